@@ -0,0 +1,117 @@
+// Command fencer-server serves geofences loaded from a GeoJSON
+// FeatureCollection over HTTP. See package server for the endpoints.
+//
+// Usage:
+//
+//	fencer-server [-addr :8080] [-id-property name] [-resp :9851 [-collection fences]] [features.geojson]
+//
+// Without a file, the server starts with an empty index. Index and query
+// metrics are served in the Prometheus text format at /metrics. With -resp, it also
+// speaks the Redis protocol with Tile38-style commands (see package resp),
+// serving the loaded features as a collection shared with the HTTP endpoints.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/metrics"
+	"github.com/bilus/fencer/resp"
+	"github.com/bilus/fencer/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "HTTP listen address")
+	idProperty := flag.String("id-property", "", `property holding feature ids; the "id" member if empty`)
+	respAddr := flag.String("resp", "", "RESP listen address; disabled if empty")
+	collection := flag.String("collection", "fences", "RESP collection serving the loaded features")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [features.geojson]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	idx, err := load(flag.Arg(0), geojson.Options{IDProperty: *idProperty})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Loaded %d features", idx.Size())
+
+	m := metrics.New(metrics.Options{})
+	idx.SetObserver(m)
+	handler := server.New(idx, server.Options{IDProperty: *idProperty})
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.Handle("/", handler)
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var respSrv *resp.Server
+	if *respAddr != "" {
+		respSrv = resp.NewServer(resp.Options{})
+		respSrv.Attach(*collection, handler)
+		go func() {
+			log.Printf("RESP listening on %s", *respAddr)
+			if err := respSrv.ListenAndServe(*respAddr); err != nil && !errors.Is(err, resp.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		if respSrv != nil {
+			respSrv.Close()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}()
+	log.Printf("Listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// load reads features from a GeoJSON file, logging invalid ones, or returns an
+// empty index if path is empty.
+func load(path string, options geojson.Options) (*server.Index, error) {
+	if path == "" {
+		return index.New[geometry.ID]([]*geometry.Feature{})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, invalid, err := geojson.Load(f, options)
+	if err != nil {
+		return nil, fmt.Errorf("Loading %s: %w", path, err)
+	}
+	for _, featureErr := range invalid {
+		log.Printf("Skipping: %v", featureErr)
+	}
+	return idx, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bilus/fencer/flatgeobuf"
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/geometry/wkt"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/shapefile"
+)
+
+type fileIndex = index.Index[geometry.ID, *geometry.Feature]
+
+// formatOf returns the format of a file based on its extension.
+func formatOf(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".geojson", ".json":
+		return "geojson", nil
+	case ".wkt":
+		return "wkt", nil
+	case ".csv":
+		return "csv", nil
+	case ".shp":
+		return "shp", nil
+	case ".fgb":
+		return "fgb", nil
+	default:
+		return "", fmt.Errorf("Unknown format of %s; use -format", path)
+	}
+}
+
+// load reads a file into an index. idField names the property, column or
+// field holding feature ids; defaults depend on the format. Invalid GeoJSON
+// features are reported to warn and skipped.
+func load(path, format, idField string, warn io.Writer) (*fileIndex, error) {
+	if format == "" || format == "auto" {
+		var err error
+		if format, err = formatOf(path); err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case "shp":
+		return shapefile.LoadFile(path, shapefile.Options{IDField: idField})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch format {
+	case "geojson":
+		idx, invalid, err := geojson.Load(f, geojson.Options{IDProperty: idField})
+		for _, featureErr := range invalid {
+			fmt.Fprintf(warn, "Skipping: %v\n", featureErr)
+		}
+		return idx, err
+	case "fgb":
+		return flatgeobuf.Load(f, flatgeobuf.Options{IDColumn: idField})
+	case "wkt":
+		return loadWKT(f)
+	case "csv":
+		return loadCSV(f, idField)
+	default:
+		return nil, fmt.Errorf("Unsupported format %q", format)
+	}
+}
+
+// loadWKT reads a geometry per line, optionally preceded by an id and a tab.
+// Features without ids are identified by line numbers. Blank lines and lines
+// starting with # are skipped.
+func loadWKT(r io.Reader) (*fileIndex, error) {
+	var features []*geometry.Feature
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id := strconv.Itoa(line)
+		if i := strings.IndexByte(text, '\t'); i >= 0 {
+			id, text = strings.TrimSpace(text[:i]), text[i+1:]
+		}
+		g, err := wkt.Unmarshal(text)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", line, err)
+		}
+		features = append(features, geometry.NewFeature(geometry.ID(id), g, nil))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index.New[geometry.ID](features)
+}
+
+// loadCSV reads a CSV file with a header row. Geometries are read from a WKT
+// column named "wkt" or "geometry" or from longitude and latitude columns
+// ("lon", "lng" or "longitude" and "lat" or "latitude"). Ids are read from
+// idColumn, "id" by default, or are row numbers if there's no such column.
+// Remaining columns become properties, with numbers parsed as float64.
+func loadCSV(r io.Reader, idColumn string) (*fileIndex, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("Missing CSV header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	find := func(names ...string) int {
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	if idColumn == "" {
+		idColumn = "id"
+	}
+	idCol := find(strings.ToLower(idColumn))
+	wktCol := find("wkt", "geometry")
+	lonCol, latCol := find("lon", "lng", "longitude"), find("lat", "latitude")
+	if wktCol < 0 && (lonCol < 0 || latCol < 0) {
+		return nil, errors.New("Expected a wkt or geometry column or lon and lat columns")
+	}
+
+	var features []*geometry.Feature
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var g geometry.Geometry
+		if wktCol >= 0 {
+			g, err = wkt.Unmarshal(record[wktCol])
+		} else {
+			var p primitives.Point
+			p, err = parsePoint(record[lonCol], record[latCol])
+			g = geometry.Point(p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Row %d: %w", row, err)
+		}
+		id := strconv.Itoa(row)
+		if idCol >= 0 {
+			id = record[idCol]
+		}
+		properties := make(map[string]any)
+		for i, value := range record {
+			if i == idCol || i == wktCol || (wktCol < 0 && (i == lonCol || i == latCol)) {
+				continue
+			}
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				properties[header[i]] = f
+			} else {
+				properties[header[i]] = value
+			}
+		}
+		features = append(features, geometry.NewFeature(geometry.ID(id), g, properties))
+	}
+	return index.New[geometry.ID](features)
+}
+
+func parsePoint(lon, lat string) (primitives.Point, error) {
+	x, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil {
+		return primitives.Point{}, fmt.Errorf("Invalid longitude %q", lon)
+	}
+	y, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil {
+		return primitives.Point{}, fmt.Errorf("Invalid latitude %q", lat)
+	}
+	return primitives.Point{x, y}, nil
+}
@@ -0,0 +1,306 @@
+// Command fencer queries geospatial files from the command line, for ad hoc
+// debugging.
+//
+// Usage:
+//
+//	fencer contains [flags] FILE [LON LAT]
+//	fencer intersect [flags] FILE [MINX MINY MAXX MAXY]
+//	fencer nearest [flags] FILE [LON LAT]
+//	fencer stats [flags] FILE
+//
+// Flags may be given anywhere after the command:
+//
+//	-format auto|geojson|wkt|csv|shp|fgb   file format; by extension if auto
+//	-id name                               property, column or field holding ids
+//	-o table|json                          output format
+//	-k n                                   number of nearest features (nearest only)
+//
+// Without coordinates, queries are read from stdin, one per line, with
+// numbers separated by spaces or commas, so fencer can be used for batch
+// lookups in shell pipelines. JSON output has one object per query.
+//
+// WKT files contain a geometry per line, optionally preceded by an id and a
+// tab. CSV files need a header row and either a "wkt" or "geometry" column or
+// "lon" and "lat" columns.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+const usage = `Usage:
+  fencer contains [flags] FILE [LON LAT]
+  fencer intersect [flags] FILE [MINX MINY MAXX MAXY]
+  fencer nearest [flags] FILE [LON LAT]
+  fencer stats [flags] FILE
+
+Without coordinates, queries are read from stdin, one per line.
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// config holds the parsed command line.
+type config struct {
+	command string
+	file    string
+	args    []string
+	format  string
+	id      string
+	output  string
+	k       int
+}
+
+// run executes a command and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, err := parse(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "fencer: %v\n", err)
+		return 2
+	}
+	idx, err := load(cfg.file, cfg.format, cfg.id, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "fencer: Loading %s: %v\n", cfg.file, err)
+		return 1
+	}
+	out := newOutput(stdout, cfg.output)
+	if cfg.command == "stats" {
+		err = stats(idx, out)
+	} else {
+		err = queries(cfg, idx, stdin, out)
+	}
+	if flushErr := out.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "fencer: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parse parses the command line.
+func parse(args []string, stderr io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("fencer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.format, "format", "auto", "file `format`: auto, geojson, wkt, csv, shp or fgb")
+	fs.StringVar(&cfg.id, "id", "", "property, column or field holding feature ids")
+	fs.StringVar(&cfg.output, "o", "table", "output `format`: table or json")
+	fs.IntVar(&cfg.k, "k", 1, "number of nearest features")
+
+	if len(args) == 0 {
+		fs.Usage()
+		return nil, errors.New("Missing command")
+	}
+	cfg.command = args[0]
+	if cfg.command == "-h" || cfg.command == "-help" || cfg.command == "help" {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) == 0 {
+		return nil, errors.New("Missing FILE")
+	}
+	cfg.file, cfg.args = positional[0], positional[1:]
+
+	var arity int
+	switch cfg.command {
+	case "contains", "nearest":
+		arity = 2
+	case "intersect":
+		arity = 4
+	case "stats":
+	default:
+		return nil, fmt.Errorf("Unknown command %q", cfg.command)
+	}
+	if len(cfg.args) != 0 && len(cfg.args) != arity {
+		return nil, fmt.Errorf("Expected %d coordinates, got %d", arity, len(cfg.args))
+	}
+	if cfg.output != "table" && cfg.output != "json" {
+		return nil, fmt.Errorf("Unknown output format %q", cfg.output)
+	}
+	if cfg.k < 1 {
+		return nil, errors.New("Expected k to be positive")
+	}
+	return cfg, nil
+}
+
+// parseInterspersed parses flags mixed with positional arguments, which the
+// flag package stops at. Negative numbers are positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		for len(args) > 0 && !isFlag(args[0]) {
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+	}
+}
+
+func isFlag(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err != nil
+}
+
+// queries answers a query given on the command line or one per line of
+// stdin.
+func queries(cfg *config, idx *fileIndex, stdin io.Reader, out output) error {
+	if len(cfg.args) > 0 {
+		return query(cfg, idx, cfg.args, out)
+	}
+	scanner := bufio.NewScanner(stdin)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		if err := query(cfg, idx, fields, out); err != nil {
+			return fmt.Errorf("Line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func query(cfg *config, idx *fileIndex, args []string, out output) error {
+	coords, err := parseCoords(args, cfg.command)
+	if err != nil {
+		return err
+	}
+	switch cfg.command {
+	case "contains":
+		point := primitives.Point{coords[0], coords[1]}
+		features, err := idx.FindContaining(point)
+		if err != nil {
+			return err
+		}
+		sortByID(features)
+		return out.matches(coords, matchesOf(features))
+	case "intersect":
+		if coords[0] > coords[2] || coords[1] > coords[3] {
+			return errors.New("Expected the minimum not to exceed the maximum")
+		}
+		bounds := primitives.Rect{Min: primitives.Point{coords[0], coords[1]}, Max: primitives.Point{coords[2], coords[3]}}
+		candidates, err := idx.Intersect(&bounds)
+		if err != nil {
+			return err
+		}
+		var features []*geometry.Feature
+		for _, f := range candidates {
+			if geometry.IntersectsRect(f.Geometry(), bounds) {
+				features = append(features, f)
+			}
+		}
+		sortByID(features)
+		return out.matches(coords, matchesOf(features))
+	default:
+		point := primitives.Point{coords[0], coords[1]}
+		neighbours := idx.Nearest(point, cfg.k, math.Inf(1))
+		matches := make([]match, len(neighbours))
+		for i, neighbour := range neighbours {
+			distance := neighbour.Distance
+			matches[i] = match{Feature: neighbour.Feature, Distance: &distance}
+		}
+		return out.matches(coords, matches)
+	}
+}
+
+func parseCoords(args []string, command string) ([]float64, error) {
+	arity := 2
+	if command == "intersect" {
+		arity = 4
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("Expected %d coordinates, got %d", arity, len(args))
+	}
+	coords := make([]float64, arity)
+	for i, arg := range args {
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("Invalid coordinate %q", arg)
+		}
+		coords[i] = f
+	}
+	return coords, nil
+}
+
+func sortByID(features []*geometry.Feature) {
+	sort.Slice(features, func(i, j int) bool { return features[i].ID < features[j].ID })
+}
+
+func matchesOf(features []*geometry.Feature) []match {
+	matches := make([]match, len(features))
+	for i, f := range features {
+		matches[i] = match{Feature: f}
+	}
+	return matches
+}
+
+// summary describes the contents of a file.
+type summary struct {
+	Features int            `json:"features"`
+	Bounds   *[4]float64    `json:"bounds"`
+	Types    map[string]int `json:"types"`
+}
+
+func stats(idx *fileIndex, out output) error {
+	s := summary{Features: idx.Size(), Types: make(map[string]int)}
+	bounds := primitives.Rect{
+		Min: primitives.Point{math.Inf(1), math.Inf(1)},
+		Max: primitives.Point{math.Inf(-1), math.Inf(-1)},
+	}
+	err := idx.Each(func(f *geometry.Feature) error {
+		s.Types[strings.TrimPrefix(fmt.Sprintf("%T", f.Geometry()), "geometry.")]++
+		b := f.Bounds()
+		bounds.Min = primitives.Point{math.Min(bounds.Min[0], b.Min[0]), math.Min(bounds.Min[1], b.Min[1])}
+		bounds.Max = primitives.Point{math.Max(bounds.Max[0], b.Max[0]), math.Max(bounds.Max[1], b.Max[1])}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.Features > 0 {
+		s.Bounds = &[4]float64{bounds.Min[0], bounds.Min[1], bounds.Max[0], bounds.Max[1]}
+	}
+	return out.summary(s)
+}
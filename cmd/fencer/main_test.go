@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const collection = `{"type":"FeatureCollection","features":[
+{"type":"Feature","id":"square","properties":{"name":"Square"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}},
+{"type":"Feature","id":"small","properties":{"name":"Small"},"geometry":{"type":"Polygon","coordinates":[[[1,1],[3,1],[3,3],[1,3],[1,1]]]}},
+{"type":"Feature","id":"far","properties":{"name":"Far"},"geometry":{"type":"Point","coordinates":[20,20]}}
+]}`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runCommand(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if code != 0 {
+		return stderr.String(), code
+	}
+	return stdout.String(), code
+}
+
+func TestRun(t *testing.T) {
+	geojsonFile := writeFile(t, "fences.geojson", collection)
+	wktFile := writeFile(t, "fences.wkt", "square\tPOLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))\n\n# comment\nPOINT (20 20)\n")
+	csvFile := writeFile(t, "places.csv", "id,name,lon,lat,population\nwaw,Warsaw,21.01,52.23,1800000\nkrk,Kraków,19.94,50.06,800000\n")
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  string
+	}{
+		{
+			"contains",
+			[]string{"contains", geojsonFile, "2", "2"},
+			"",
+			"QUERY  ID      DISTANCE  PROPERTIES\n2,2    small   -         {\"name\":\"Small\"}\n2,2    square  -         {\"name\":\"Square\"}\n",
+		},
+		{
+			"contains json",
+			[]string{"contains", "-o", "json", geojsonFile, "5", "5"},
+			"",
+			`{"point":[5,5],"features":[{"id":"square","properties":{"name":"Square"}}]}` + "\n",
+		},
+		{
+			"intersect",
+			[]string{"intersect", geojsonFile, "-o", "json", "15", "15", "25", "25"},
+			"",
+			`{"bbox":[15,15,25,25],"features":[{"id":"far","properties":{"name":"Far"}}]}` + "\n",
+		},
+		{
+			"nearest with flags after coordinates",
+			[]string{"nearest", geojsonFile, "20", "19", "-k", "2", "-o", "json"},
+			"",
+			`{"point":[20,19],"features":[{"id":"far","distance":111319.49079327357,"properties":{"name":"Far"}},{"id":"square","distance":1470447.1474772152,"properties":{"name":"Square"}}]}` + "\n",
+		},
+		{
+			"negative coordinates",
+			[]string{"contains", "-o", "json", geojsonFile, "-1", "-1"},
+			"",
+			`{"point":[-1,-1],"features":[]}` + "\n",
+		},
+		{
+			"stdin",
+			[]string{"contains", "-o", "json", geojsonFile},
+			"5 5\n\n30,30\n",
+			`{"point":[5,5],"features":[{"id":"square","properties":{"name":"Square"}}]}` + "\n" +
+				`{"point":[30,30],"features":[]}` + "\n",
+		},
+		{
+			"wkt",
+			[]string{"contains", "-o", "json", wktFile, "5", "5"},
+			"",
+			`{"point":[5,5],"features":[{"id":"square","properties":null}]}` + "\n",
+		},
+		{
+			"wkt line numbers",
+			[]string{"nearest", "-o", "json", wktFile, "20", "20"},
+			"",
+			`{"point":[20,20],"features":[{"id":"4","distance":0,"properties":null}]}` + "\n",
+		},
+		{
+			"csv",
+			[]string{"nearest", csvFile, "21", "52"},
+			"",
+			"QUERY  ID   DISTANCE  PROPERTIES\n21,52  waw  25612.6   {\"name\":\"Warsaw\",\"population\":1800000}\n",
+		},
+		{
+			"stats",
+			[]string{"stats", geojsonFile},
+			"",
+			"Features:  3\nBounds:    0,0,20,20\nPoint:     1\nPolygon:   2\n",
+		},
+		{
+			"stats json",
+			[]string{"stats", "-o", "json", geojsonFile},
+			"",
+			`{"features":3,"bounds":[0,0,20,20],"types":{"Point":1,"Polygon":2}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, code := runCommand(t, tt.stdin, tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, got)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	geojsonFile := writeFile(t, "fences.geojson", collection)
+	tests := []struct {
+		args  []string
+		stdin string
+		code  int
+	}{
+		{nil, "", 2},
+		{[]string{"foo", geojsonFile}, "", 2},
+		{[]string{"contains"}, "", 2},
+		{[]string{"contains", geojsonFile, "1"}, "", 2},
+		{[]string{"nearest", "-k", "0", geojsonFile, "1", "1"}, "", 2},
+		{[]string{"contains", "-o", "xml", geojsonFile, "1", "1"}, "", 2},
+		{[]string{"contains", "-bogus", geojsonFile}, "", 2},
+		{[]string{"contains", "missing.geojson", "1", "1"}, "", 1},
+		{[]string{"contains", "fences.txt", "1", "1"}, "", 1},
+		{[]string{"contains", geojsonFile, "x", "1"}, "", 1},
+		{[]string{"intersect", geojsonFile, "2", "2", "1", "1"}, "", 1},
+		{[]string{"contains", geojsonFile}, "1 2 3\n", 1},
+	}
+	for _, tt := range tests {
+		if _, code := runCommand(t, tt.stdin, tt.args...); code != tt.code {
+			t.Errorf("%v: got exit code %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bilus/fencer/geometry"
+)
+
+// match is a feature found by a query.
+type match struct {
+	Feature *geometry.Feature
+	// Distance in meters, for nearest queries.
+	Distance *float64
+}
+
+// output writes query results.
+type output interface {
+	// matches writes the results of a query given by its coordinates.
+	matches(coords []float64, matches []match) error
+	summary(s summary) error
+	flush() error
+}
+
+func newOutput(w io.Writer, format string) output {
+	if format == "json" {
+		bw := bufio.NewWriter(w)
+		return &jsonOutput{w: bw, enc: json.NewEncoder(bw)}
+	}
+	return &tableOutput{w: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)}
+}
+
+// jsonOutput writes a JSON object per query.
+type jsonOutput struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+type jsonFeature struct {
+	ID         geometry.ID    `json:"id"`
+	Distance   *float64       `json:"distance,omitempty"`
+	Properties map[string]any `json:"properties"`
+}
+
+type jsonResult struct {
+	Point    []float64     `json:"point,omitempty"`
+	BBox     []float64     `json:"bbox,omitempty"`
+	Features []jsonFeature `json:"features"`
+}
+
+func (o *jsonOutput) matches(coords []float64, matches []match) error {
+	result := jsonResult{Features: make([]jsonFeature, len(matches))}
+	if len(coords) == 4 {
+		result.BBox = coords
+	} else {
+		result.Point = coords
+	}
+	for i, m := range matches {
+		result.Features[i] = jsonFeature{ID: m.Feature.ID, Distance: m.Distance, Properties: m.Feature.Properties}
+	}
+	return o.enc.Encode(result)
+}
+
+func (o *jsonOutput) summary(s summary) error {
+	return o.enc.Encode(s)
+}
+
+func (o *jsonOutput) flush() error {
+	return o.w.Flush()
+}
+
+// tableOutput writes aligned columns with a row per match.
+type tableOutput struct {
+	w      *tabwriter.Writer
+	header bool
+}
+
+func (o *tableOutput) matches(coords []float64, matches []match) error {
+	if !o.header {
+		o.header = true
+		if _, err := fmt.Fprintln(o.w, "QUERY\tID\tDISTANCE\tPROPERTIES"); err != nil {
+			return err
+		}
+	}
+	parts := make([]string, len(coords))
+	for i, c := range coords {
+		parts[i] = strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	q := strings.Join(parts, ",")
+	for _, m := range matches {
+		distance := "-"
+		if m.Distance != nil {
+			distance = strconv.FormatFloat(*m.Distance, 'f', 1, 64)
+		}
+		properties, err := json.Marshal(m.Feature.Properties)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(o.w, "%s\t%s\t%s\t%s\n", q, m.Feature.ID, distance, properties); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *tableOutput) summary(s summary) error {
+	fmt.Fprintf(o.w, "Features:\t%d\n", s.Features)
+	if s.Bounds != nil {
+		fmt.Fprintf(o.w, "Bounds:\t%g,%g,%g,%g\n", s.Bounds[0], s.Bounds[1], s.Bounds[2], s.Bounds[3])
+	}
+	types := make([]string, 0, len(s.Types))
+	for t := range s.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(o.w, "%s:\t%d\n", t, s.Types[t])
+	}
+	return nil
+}
+
+func (o *tableOutput) flush() error {
+	return o.w.Flush()
+}
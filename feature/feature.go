@@ -18,3 +18,10 @@ type Feature[K Key] interface {
 	Contains(point primitives.Point) (bool, error)
 	Key() K
 }
+
+// ClosestPointer is implemented by features that can find the point of their
+// shape closest to a given point. Features not implementing it are treated as
+// their bounding rectangles when measuring distances.
+type ClosestPointer interface {
+	ClosestPoint(point primitives.Point) primitives.Point
+}
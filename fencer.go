@@ -3,7 +3,23 @@ package fencer // import "github.com/bilus/fencer"
 // Import packages so they are pulled in using `go get github.com/bilus/fencer`
 import (
 	_ "github.com/bilus/fencer/feature"
+	_ "github.com/bilus/fencer/flatgeobuf"
 	_ "github.com/bilus/fencer/geo"
+	_ "github.com/bilus/fencer/geojson"
+	_ "github.com/bilus/fencer/geometry"
+	_ "github.com/bilus/fencer/geometry/wkb"
+	_ "github.com/bilus/fencer/geometry/wkt"
 	_ "github.com/bilus/fencer/index"
+	_ "github.com/bilus/fencer/metrics"
+	_ "github.com/bilus/fencer/notify"
 	_ "github.com/bilus/fencer/query"
+	_ "github.com/bilus/fencer/query/expr"
+	_ "github.com/bilus/fencer/query/spec"
+	_ "github.com/bilus/fencer/replication"
+	_ "github.com/bilus/fencer/resp"
+	_ "github.com/bilus/fencer/server"
+	_ "github.com/bilus/fencer/shapefile"
+	_ "github.com/bilus/fencer/tiles"
+	_ "github.com/bilus/fencer/tracing"
+	_ "github.com/bilus/fencer/wal"
 )
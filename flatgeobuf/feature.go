@@ -0,0 +1,364 @@
+package flatgeobuf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+// Feature and Geometry table slots.
+const (
+	featureGeometry   = 0
+	featureProperties = 1
+
+	geometryEnds  = 0
+	geometryXY    = 1
+	geometryType  = 6
+	geometryParts = 7
+)
+
+func encodeFeature(g geometry.Geometry, properties []byte) ([]byte, GeometryType, error) {
+	geometryFields, t, err := encodeGeometry(g)
+	if err != nil {
+		return nil, 0, err
+	}
+	fields := []field{
+		refField(featureGeometry, func(b *builder) int { return b.table(geometryFields) }),
+	}
+	if len(properties) > 0 {
+		fields = append(fields, refField(featureProperties, func(b *builder) int { return b.bytes(properties) }))
+	}
+	return finish(fields), t, nil
+}
+
+func encodeGeometry(g geometry.Geometry) ([]field, GeometryType, error) {
+	var t GeometryType
+	var xy []float64
+	var ends []uint32
+	var parts [][]field
+	switch g := g.(type) {
+	case geometry.Point:
+		t, xy = GeometryPoint, []float64{g[0], g[1]}
+	case geometry.MultiPoint:
+		t, xy = GeometryMultiPoint, appendXY(nil, g)
+	case geometry.LineString:
+		t, xy = GeometryLineString, appendXY(nil, g)
+	case geometry.MultiLineString:
+		t = GeometryMultiLineString
+		for _, line := range g {
+			xy = appendXY(xy, line)
+			ends = append(ends, uint32(len(xy)/2))
+		}
+	case geometry.Polygon:
+		t = GeometryPolygon
+		for _, ring := range g {
+			xy = appendXY(xy, ring)
+			// Rings must be closed.
+			if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+				xy = append(xy, ring[0][0], ring[0][1])
+			}
+			ends = append(ends, uint32(len(xy)/2))
+		}
+	case geometry.MultiPolygon:
+		t = GeometryMultiPolygon
+		for _, polygon := range g {
+			part, _, err := encodeGeometry(polygon)
+			if err != nil {
+				return nil, 0, err
+			}
+			parts = append(parts, part)
+		}
+	default:
+		return nil, 0, fmt.Errorf("Unsupported geometry %T", g)
+	}
+	fields := []field{uint8Field(geometryType, uint8(t))}
+	if len(ends) > 1 {
+		fields = append(fields, refField(geometryEnds, func(b *builder) int { return b.uint32s(ends) }))
+	}
+	if len(xy) > 0 {
+		fields = append(fields, refField(geometryXY, func(b *builder) int { return b.float64s(xy) }))
+	}
+	if len(parts) > 0 {
+		fields = append(fields, refField(geometryParts, func(b *builder) int { return b.tables(parts) }))
+	}
+	return fields, t, nil
+}
+
+func appendXY(xy []float64, points []primitives.Point) []float64 {
+	for _, p := range points {
+		xy = append(xy, p[0], p[1])
+	}
+	return xy
+}
+
+// decodeFeature decodes a feature buffer without its size prefix.
+func decodeFeature(buf []byte, h *Header) (g geometry.Geometry, properties map[string]any, err error) {
+	defer catch(&err)
+	t := root(buf)
+	geometryTable, ok := t.table(featureGeometry)
+	if !ok {
+		return nil, nil, errors.New("Feature without geometry")
+	}
+	if g, err = decodeGeometry(geometryTable, h.GeometryType); err != nil {
+		return nil, nil, err
+	}
+	if properties, err = decodeProperties(t.bytes(featureProperties), h.Columns); err != nil {
+		return nil, nil, err
+	}
+	return g, properties, nil
+}
+
+func decodeGeometry(t table, geomType GeometryType) (geometry.Geometry, error) {
+	if geomType == GeometryUnknown {
+		geomType = GeometryType(t.uint8(geometryType, 0))
+	}
+	xy := t.float64s(geometryXY)
+	points := make([]primitives.Point, len(xy)/2)
+	for i := range points {
+		points[i] = primitives.Point{xy[2*i], xy[2*i+1]}
+	}
+	switch geomType {
+	case GeometryPoint:
+		if len(points) != 1 {
+			return nil, fmt.Errorf("Point with %d coordinates", len(xy))
+		}
+		return geometry.Point(points[0]), nil
+	case GeometryMultiPoint:
+		return geometry.MultiPoint(points), nil
+	case GeometryLineString:
+		return geometry.LineString(points), nil
+	case GeometryMultiLineString:
+		lines, err := split(points, t.uint32s(geometryEnds))
+		if err != nil {
+			return nil, err
+		}
+		multi := make(geometry.MultiLineString, len(lines))
+		for i, line := range lines {
+			multi[i] = line
+		}
+		return multi, nil
+	case GeometryPolygon:
+		rings, err := split(points, t.uint32s(geometryEnds))
+		if err != nil {
+			return nil, err
+		}
+		polygon := make(geometry.Polygon, len(rings))
+		for i, ring := range rings {
+			polygon[i] = ring
+		}
+		return polygon, nil
+	case GeometryMultiPolygon:
+		parts := t.tables(geometryParts)
+		multi := make(geometry.MultiPolygon, len(parts))
+		for i, part := range parts {
+			polygon, err := decodeGeometry(part, GeometryPolygon)
+			if err != nil {
+				return nil, err
+			}
+			multi[i] = polygon.(geometry.Polygon)
+		}
+		return multi, nil
+	default:
+		return nil, fmt.Errorf("Unsupported geometry type %v", geomType)
+	}
+}
+
+// split splits points at the given end indexes; no ends means a single part.
+func split(points []primitives.Point, ends []uint32) ([][]primitives.Point, error) {
+	if len(ends) == 0 {
+		return [][]primitives.Point{points}, nil
+	}
+	parts := make([][]primitives.Point, len(ends))
+	start := 0
+	for i, end := range ends {
+		if int(end) < start || int(end) > len(points) {
+			return nil, fmt.Errorf("Invalid end index %d of part %d", end, i)
+		}
+		parts[i] = points[start:end:end]
+		start = int(end)
+	}
+	return parts, nil
+}
+
+// columnsFor infers columns from property values. Integers and floats mix as
+// doubles; other mixed or non-scalar values are stored as JSON.
+func columnsFor(properties []map[string]any) []Column {
+	types := make(map[string]ColumnType)
+	for _, props := range properties {
+		for name, value := range props {
+			if value == nil {
+				continue
+			}
+			t := columnTypeOf(value)
+			previous, ok := types[name]
+			switch {
+			case !ok || previous == t:
+				types[name] = t
+			case (previous == ColumnLong || previous == ColumnDouble) && (t == ColumnLong || t == ColumnDouble):
+				types[name] = ColumnDouble
+			default:
+				types[name] = ColumnJSON
+			}
+		}
+	}
+	columns := make([]Column, 0, len(types))
+	for name, t := range types {
+		columns = append(columns, Column{Name: name, Type: t})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+func columnTypeOf(value any) ColumnType {
+	switch value.(type) {
+	case bool:
+		return ColumnBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return ColumnLong
+	case float32, float64:
+		return ColumnDouble
+	case string:
+		return ColumnString
+	default:
+		return ColumnJSON
+	}
+}
+
+func encodeProperties(columns []Column, properties map[string]any) ([]byte, error) {
+	var buf []byte
+	for i, c := range columns {
+		value, ok := properties[c.Name]
+		if !ok || value == nil {
+			continue
+		}
+		buf = appendUint16(buf, uint16(i))
+		switch c.Type {
+		case ColumnBool:
+			if value.(bool) {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case ColumnLong:
+			buf = appendUint64(buf, uint64(toInt64(value)))
+		case ColumnDouble:
+			buf = appendUint64(buf, math.Float64bits(toFloat64(value)))
+		case ColumnString:
+			s := value.(string)
+			buf = appendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("Property %q: %w", c.Name, err)
+			}
+			buf = appendUint32(buf, uint32(len(data)))
+			buf = append(buf, data...)
+		}
+	}
+	return buf, nil
+}
+
+func toInt64(value any) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	}
+	return 0
+}
+
+func toFloat64(value any) float64 {
+	switch v := value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return float64(toInt64(value))
+}
+
+// decodeProperties decodes properties; integers become int64 (uint64 for
+// ULong), floats float64, text and dates string, JSON its decoded value and
+// binary []byte.
+func decodeProperties(data []byte, columns []Column) (map[string]any, error) {
+	properties := make(map[string]any, len(columns))
+	for pos := 0; pos < len(data); {
+		i := int(readUint16(data, pos))
+		pos += 2
+		if i >= len(columns) {
+			return nil, fmt.Errorf("Invalid column index %d", i)
+		}
+		c := columns[i]
+		var value any
+		switch c.Type {
+		case ColumnByte:
+			check(data, pos, 1)
+			value, pos = int64(int8(data[pos])), pos+1
+		case ColumnUByte:
+			check(data, pos, 1)
+			value, pos = int64(data[pos]), pos+1
+		case ColumnBool:
+			check(data, pos, 1)
+			value, pos = data[pos] != 0, pos+1
+		case ColumnShort:
+			value, pos = int64(int16(readUint16(data, pos))), pos+2
+		case ColumnUShort:
+			value, pos = int64(readUint16(data, pos)), pos+2
+		case ColumnInt:
+			value, pos = int64(int32(readUint32(data, pos))), pos+4
+		case ColumnUInt:
+			value, pos = int64(readUint32(data, pos)), pos+4
+		case ColumnLong:
+			value, pos = int64(readUint64(data, pos)), pos+8
+		case ColumnULong:
+			value, pos = readUint64(data, pos), pos+8
+		case ColumnFloat:
+			value, pos = float64(math.Float32frombits(readUint32(data, pos))), pos+4
+		case ColumnDouble:
+			value, pos = math.Float64frombits(readUint64(data, pos)), pos+8
+		case ColumnString, ColumnDateTime, ColumnJSON, ColumnBinary:
+			n := int(readUint32(data, pos))
+			pos += 4
+			check(data, pos, n)
+			raw := data[pos : pos+n]
+			pos += n
+			switch c.Type {
+			case ColumnJSON:
+				if err := json.Unmarshal(raw, &value); err != nil {
+					return nil, fmt.Errorf("Property %q: %w", c.Name, err)
+				}
+			case ColumnBinary:
+				value = append([]byte(nil), raw...)
+			default:
+				value = string(raw)
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported column type %d", c.Type)
+		}
+		properties[c.Name] = value
+	}
+	return properties, nil
+}
@@ -0,0 +1,333 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Minimal FlatBuffers support for the FlatGeobuf schemas. Buffers are built
+// front to back: a table's vtable precedes it and everything the table
+// references follows it, so all unsigned offsets point forward as the format
+// requires.
+
+var errMalformed = errors.New("Malformed FlatBuffer")
+
+// field is a table field: either an inline scalar or a reference to an object
+// written after the table by ref, which returns the object's position.
+type field struct {
+	slot int
+	size int    // Inline size: 1, 2, 4 or 8 bytes.
+	bits uint64 // Scalar value.
+	ref  func(b *builder) int
+}
+
+func uint8Field(slot int, v uint8) field {
+	return field{slot: slot, size: 1, bits: uint64(v)}
+}
+
+func uint16Field(slot int, v uint16) field {
+	return field{slot: slot, size: 2, bits: uint64(v)}
+}
+
+func uint64Field(slot int, v uint64) field {
+	return field{slot: slot, size: 8, bits: v}
+}
+
+func refField(slot int, ref func(b *builder) int) field {
+	return field{slot: slot, size: 4, ref: ref}
+}
+
+type builder struct {
+	buf []byte
+}
+
+// finish builds a size-prefixed buffer with the root table.
+func finish(root []field) []byte {
+	b := builder{buf: make([]byte, 8)} // Size prefix and root offset.
+	pos := b.table(root)
+	binary.LittleEndian.PutUint32(b.buf[4:], uint32(pos-4))
+	binary.LittleEndian.PutUint32(b.buf, uint32(len(b.buf)-4))
+	return b.buf
+}
+
+func (b *builder) padTo(pos int) {
+	for len(b.buf) < pos {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func align(pos, alignment int) int {
+	return (pos + alignment - 1) / alignment * alignment
+}
+
+func (b *builder) table(fields []field) int {
+	// Largest fields first to minimize padding.
+	sorted := make([]field, len(fields))
+	copy(sorted, fields)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].size > sorted[j].size })
+	numSlots, tableAlign := 0, 4
+	for _, f := range sorted {
+		if f.slot >= numSlots {
+			numSlots = f.slot + 1
+		}
+		if f.size > tableAlign {
+			tableAlign = f.size
+		}
+	}
+	vtablePos := align(len(b.buf), 2)
+	vtableSize := 4 + 2*numSlots
+	tablePos := align(vtablePos+vtableSize, tableAlign)
+	slots := make([]uint16, numSlots)
+	positions := make([]int, len(sorted))
+	pos := tablePos + 4
+	for i, f := range sorted {
+		pos = align(pos, f.size)
+		positions[i] = pos
+		slots[f.slot] = uint16(pos - tablePos)
+		pos += f.size
+	}
+
+	b.padTo(vtablePos)
+	b.buf = appendUint16(b.buf, uint16(vtableSize))
+	b.buf = appendUint16(b.buf, uint16(pos-tablePos))
+	for _, slot := range slots {
+		b.buf = appendUint16(b.buf, slot)
+	}
+	b.padTo(pos)
+	binary.LittleEndian.PutUint32(b.buf[tablePos:], uint32(tablePos-vtablePos))
+	for i, f := range sorted {
+		if f.ref != nil {
+			continue
+		}
+		switch f.size {
+		case 1:
+			b.buf[positions[i]] = byte(f.bits)
+		case 2:
+			binary.LittleEndian.PutUint16(b.buf[positions[i]:], uint16(f.bits))
+		case 4:
+			binary.LittleEndian.PutUint32(b.buf[positions[i]:], uint32(f.bits))
+		case 8:
+			binary.LittleEndian.PutUint64(b.buf[positions[i]:], f.bits)
+		}
+	}
+	for i, f := range sorted {
+		if f.ref != nil {
+			target := f.ref(b)
+			binary.LittleEndian.PutUint32(b.buf[positions[i]:], uint32(target-positions[i]))
+		}
+	}
+	return tablePos
+}
+
+// vector starts a vector so that its elements are aligned to their size.
+func (b *builder) vector(elemSize, n int) int {
+	alignment := elemSize
+	if alignment < 4 {
+		alignment = 4
+	}
+	pos := align(len(b.buf)+4, alignment) - 4
+	b.padTo(pos)
+	b.buf = appendUint32(b.buf, uint32(n))
+	return pos
+}
+
+func (b *builder) float64s(values []float64) int {
+	pos := b.vector(8, len(values))
+	for _, v := range values {
+		b.buf = appendUint64(b.buf, math.Float64bits(v))
+	}
+	return pos
+}
+
+func (b *builder) uint32s(values []uint32) int {
+	pos := b.vector(4, len(values))
+	for _, v := range values {
+		b.buf = appendUint32(b.buf, v)
+	}
+	return pos
+}
+
+func (b *builder) bytes(values []byte) int {
+	pos := b.vector(1, len(values))
+	b.buf = append(b.buf, values...)
+	return pos
+}
+
+func (b *builder) string(s string) int {
+	pos := b.vector(1, len(s))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+func (b *builder) tables(tables [][]field) int {
+	pos := b.vector(4, len(tables))
+	b.padTo(pos + 4 + 4*len(tables))
+	for i, fields := range tables {
+		slot := pos + 4 + 4*i
+		target := b.table(fields)
+		binary.LittleEndian.PutUint32(b.buf[slot:], uint32(target-slot))
+	}
+	return pos
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+// table reads a FlatBuffers table. Accessors panic with errMalformed on
+// out-of-bounds data; decoding functions recover it using catch.
+type table struct {
+	buf []byte
+	pos int
+}
+
+// catch turns an errMalformed panic into an error.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		if r != errMalformed {
+			panic(r)
+		}
+		*err = errMalformed
+	}
+}
+
+func check(buf []byte, pos, size int) {
+	if pos < 0 || size < 0 || pos > len(buf)-size {
+		panic(errMalformed)
+	}
+}
+
+func readUint16(buf []byte, pos int) uint16 {
+	check(buf, pos, 2)
+	return binary.LittleEndian.Uint16(buf[pos:])
+}
+
+func readUint32(buf []byte, pos int) uint32 {
+	check(buf, pos, 4)
+	return binary.LittleEndian.Uint32(buf[pos:])
+}
+
+func readUint64(buf []byte, pos int) uint64 {
+	check(buf, pos, 8)
+	return binary.LittleEndian.Uint64(buf[pos:])
+}
+
+// root returns the root table of a buffer without its size prefix.
+func root(buf []byte) table {
+	return table{buf: buf, pos: int(readUint32(buf, 0))}
+}
+
+// field returns the position of a field or 0 if it's absent.
+func (t table) field(slot int) int {
+	vtable := t.pos - int(int32(readUint32(t.buf, t.pos)))
+	vtableSize := int(readUint16(t.buf, vtable))
+	if 4+2*slot+2 > vtableSize {
+		return 0
+	}
+	offset := int(readUint16(t.buf, vtable+4+2*slot))
+	if offset == 0 {
+		return 0
+	}
+	return t.pos + offset
+}
+
+func (t table) uint8(slot int, def uint8) uint8 {
+	pos := t.field(slot)
+	if pos == 0 {
+		return def
+	}
+	check(t.buf, pos, 1)
+	return t.buf[pos]
+}
+
+func (t table) uint16(slot int, def uint16) uint16 {
+	pos := t.field(slot)
+	if pos == 0 {
+		return def
+	}
+	return readUint16(t.buf, pos)
+}
+
+func (t table) uint64(slot int, def uint64) uint64 {
+	pos := t.field(slot)
+	if pos == 0 {
+		return def
+	}
+	return readUint64(t.buf, pos)
+}
+
+// ref returns the position of a referenced object or 0 if it's absent.
+func (t table) ref(slot int) int {
+	pos := t.field(slot)
+	if pos == 0 {
+		return 0
+	}
+	return pos + int(readUint32(t.buf, pos))
+}
+
+func (t table) table(slot int) (table, bool) {
+	pos := t.ref(slot)
+	return table{buf: t.buf, pos: pos}, pos != 0
+}
+
+// vector returns the position of the first element and the number of
+// elements of a vector.
+func (t table) vector(slot int, elemSize int) (int, int) {
+	pos := t.ref(slot)
+	if pos == 0 {
+		return 0, 0
+	}
+	n := int(readUint32(t.buf, pos))
+	if n > (len(t.buf)-pos-4)/elemSize {
+		panic(errMalformed)
+	}
+	return pos + 4, n
+}
+
+func (t table) bytes(slot int) []byte {
+	pos, n := t.vector(slot, 1)
+	return t.buf[pos : pos+n]
+}
+
+func (t table) string(slot int) string {
+	return string(t.bytes(slot))
+}
+
+func (t table) float64s(slot int) []float64 {
+	pos, n := t.vector(slot, 8)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(t.buf[pos+8*i:]))
+	}
+	return values
+}
+
+func (t table) uint32s(slot int) []uint32 {
+	pos, n := t.vector(slot, 4)
+	values := make([]uint32, n)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(t.buf[pos+4*i:])
+	}
+	return values
+}
+
+func (t table) tables(slot int) []table {
+	pos, n := t.vector(slot, 4)
+	tables := make([]table, n)
+	for i := range tables {
+		elem := pos + 4*i
+		tables[i] = table{buf: t.buf, pos: elem + int(readUint32(t.buf, elem))}
+	}
+	return tables
+}
@@ -0,0 +1,403 @@
+// Package flatgeobuf reads and writes FlatGeobuf files.
+//
+// Files are loaded into an index of geometry.Feature values or searched by
+// bounding rectangle using their packed Hilbert R-tree, reading only the index
+// nodes and features a search needs. Z, M and time ordinates are discarded;
+// geometry collections and curves are not supported.
+package flatgeobuf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+var magic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+const maxHeaderSize = 10 << 20
+
+// maxFeatureSize limits the size read from a feature's prefix, so that a
+// corrupted size doesn't allocate gigabytes.
+const maxFeatureSize = 256 << 20
+
+// Options configure reading features.
+type Options struct {
+	// IDColumn is the name of the column holding feature keys. If empty, the
+	// position of a feature in the file, starting at 0, is used.
+	IDColumn string
+}
+
+// readHeader reads the magic bytes and the header, returning the header and
+// the number of bytes read.
+func readHeader(r io.Reader, options Options) (*Header, int64, error) {
+	var prefix [12]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, 0, fmt.Errorf("Invalid FlatGeobuf header: %w", err)
+	}
+	if !bytes.Equal(prefix[:3], magic[:3]) || !bytes.Equal(prefix[4:7], magic[4:7]) {
+		return nil, 0, errors.New("Not a FlatGeobuf file")
+	}
+	if prefix[3] != magic[3] {
+		return nil, 0, fmt.Errorf("Unsupported FlatGeobuf version %d", prefix[3])
+	}
+	size := binary.LittleEndian.Uint32(prefix[8:])
+	if size > maxHeaderSize {
+		return nil, 0, fmt.Errorf("FlatGeobuf header too large: %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, fmt.Errorf("Invalid FlatGeobuf header: %w", err)
+	}
+	header, err := decodeHeader(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Invalid FlatGeobuf header: %w", err)
+	}
+	if header.IndexNodeSize == 1 {
+		return nil, 0, errInvalidIndex
+	}
+	if options.IDColumn != "" && header.column(options.IDColumn) < 0 {
+		return nil, 0, fmt.Errorf("No column %q", options.IDColumn)
+	}
+	return &header, int64(len(prefix)) + int64(size), nil
+}
+
+// newFeature decodes the n-th feature from a buffer without its size prefix.
+func newFeature(buf []byte, n uint64, header *Header, options Options) (*geometry.Feature, error) {
+	g, properties, err := decodeFeature(buf, header)
+	if err != nil {
+		return nil, fmt.Errorf("Feature %d: %w", n, err)
+	}
+	id := geometry.ID(strconv.FormatUint(n, 10))
+	if options.IDColumn != "" {
+		value, ok := properties[options.IDColumn]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("Feature %d: empty %q column", n, options.IDColumn)
+		}
+		id = geometry.ID(fmt.Sprint(value))
+	}
+	return geometry.NewFeature(id, g, properties), nil
+}
+
+// Reader reads features sequentially.
+type Reader struct {
+	Header  *Header
+	r       *bufio.Reader
+	options Options
+	n       uint64
+}
+
+// NewReader creates a reader, skipping the spatial index.
+func NewReader(r io.Reader, options Options) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header, _, err := readHeader(br, options)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, br, treeSize(header.FeaturesCount, header.IndexNodeSize)); err != nil {
+		return nil, fmt.Errorf("Invalid spatial index: %w", err)
+	}
+	return &Reader{Header: header, r: br, options: options}, nil
+}
+
+// Next returns the next feature or io.EOF after the last one.
+func (r *Reader) Next() (*geometry.Feature, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("Feature %d: truncated", r.n)
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(prefix[:])
+	if size > maxFeatureSize {
+		return nil, fmt.Errorf("Feature %d: invalid size %d", r.n, size)
+	}
+	// Memory is allocated as data arrives, in case the size is wrong.
+	buf, err := io.ReadAll(io.LimitReader(r.r, int64(size)))
+	if err != nil || len(buf) < int(size) {
+		return nil, fmt.Errorf("Feature %d: truncated", r.n)
+	}
+	n := r.n
+	r.n++
+	return newFeature(buf, n, r.Header, r.options)
+}
+
+// Load reads all features into a new index.
+func Load(r io.Reader, options Options) (*index.Index[geometry.ID, *geometry.Feature], error) {
+	reader, err := NewReader(r, options)
+	if err != nil {
+		return nil, err
+	}
+	var features []*geometry.Feature
+	for {
+		f, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, f)
+	}
+	return index.New[geometry.ID](features)
+}
+
+// File searches a FlatGeobuf file without loading it into memory.
+type File struct {
+	Header         *Header
+	r              io.ReaderAt
+	options        Options
+	treeOffset     int64
+	featuresOffset int64
+	size           int64 // Of the file, -1 if unknown.
+	closer         io.Closer
+}
+
+// NewFile reads the header of a file.
+func NewFile(r io.ReaderAt, options Options) (*File, error) {
+	header, size, err := readHeader(io.NewSectionReader(r, 0, math.MaxInt64), options)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		Header:         header,
+		r:              r,
+		options:        options,
+		treeOffset:     size,
+		featuresOffset: size + treeSize(header.FeaturesCount, header.IndexNodeSize),
+		size:           -1,
+	}
+	if sized, ok := r.(interface{ Size() int64 }); ok {
+		f.size = sized.Size()
+	}
+	return f, nil
+}
+
+// Open opens a file for searching. It must be closed after use.
+func Open(path string, options Options) (*File, error) {
+	osFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFile(osFile, options)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = osFile.Stat(); err == nil {
+			f.size = info.Size()
+		}
+	}
+	if err != nil {
+		osFile.Close()
+		return nil, err
+	}
+	f.closer = osFile
+	return f, nil
+}
+
+// Close closes a file opened by Open.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// Search returns features whose bounding rectangles intersect bounds, in file
+// order. Files without a spatial index are scanned.
+func (f *File) Search(bounds primitives.Rect) ([]*geometry.Feature, error) {
+	if f.featuresOffset == f.treeOffset {
+		return f.scan(bounds)
+	}
+	matches, err := searchTree(f.r, f.treeOffset, int(f.Header.FeaturesCount), int(f.Header.IndexNodeSize), bounds)
+	if err != nil {
+		return nil, err
+	}
+	features := make([]*geometry.Feature, len(matches))
+	for i, match := range matches {
+		buf, err := f.readAt(f.featuresOffset + int64(match.offset))
+		if err != nil {
+			return nil, fmt.Errorf("Feature %d: %w", match.index, err)
+		}
+		if features[i], err = newFeature(buf, uint64(match.index), f.Header, f.options); err != nil {
+			return nil, err
+		}
+	}
+	return features, nil
+}
+
+func (f *File) scan(bounds primitives.Rect) ([]*geometry.Feature, error) {
+	var features []*geometry.Feature
+	offset := f.featuresOffset
+	for n := uint64(0); ; n++ {
+		buf, err := f.readAt(offset)
+		if err == io.EOF {
+			return features, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Feature %d: %w", n, err)
+		}
+		offset += 4 + int64(len(buf))
+		feature, err := newFeature(buf, n, f.Header, f.options)
+		if err != nil {
+			return nil, err
+		}
+		if intersects(*feature.Bounds(), bounds) {
+			features = append(features, feature)
+		}
+	}
+}
+
+// readAt reads a size-prefixed feature buffer; it returns io.EOF at the end of
+// the file. Sizes over maxFeatureSize or past the end of the file, if its
+// size is known, are invalid.
+func (f *File) readAt(offset int64) ([]byte, error) {
+	var prefix [4]byte
+	if n, err := f.r.ReadAt(prefix[:], offset); n < len(prefix) {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(prefix[:])
+	if size > maxFeatureSize || f.size >= 0 && offset+4+int64(size) > f.size {
+		return nil, fmt.Errorf("Invalid size %d", size)
+	}
+	buf := make([]byte, size)
+	if n, err := f.r.ReadAt(buf, offset+4); n < len(buf) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteOptions configure writing features.
+type WriteOptions[F any] struct {
+	// Name is the dataset name; optional.
+	Name string
+	// IDColumn, if set, is the name of a column feature keys are written to.
+	IDColumn string
+	// Properties returns properties to write for a feature; optional. Column
+	// types are inferred from the values.
+	Properties func(f F) map[string]any
+	// IndexNodeSize is the number of children per index node; 0 means
+	// DefaultIndexNodeSize.
+	IndexNodeSize uint16
+}
+
+type item struct {
+	geometry   geometry.Geometry
+	bounds     primitives.Rect
+	properties map[string]any
+}
+
+// Write writes features to a FlatGeobuf file with a spatial index, sorting
+// them along the Hilbert curve. Features implementing geometry.Geometer are
+// written with their geometries, others as their bounding rectangles.
+func Write[K feature.Key, F feature.Feature[K]](w io.Writer, features []F, options WriteOptions[F]) error {
+	branching := options.IndexNodeSize
+	if branching == 0 {
+		branching = DefaultIndexNodeSize
+	}
+	if branching < 2 {
+		return errors.New("Index node size must be at least 2")
+	}
+	items := make([]item, len(features))
+	properties := make([]map[string]any, len(features))
+	extent := primitives.Rect{}
+	for i, f := range features {
+		it := item{bounds: *f.Bounds()}
+		if geometer, ok := any(f).(geometry.Geometer); ok {
+			it.geometry = geometer.Geometry()
+		} else {
+			it.geometry = geometry.FromRect(it.bounds)
+		}
+		if options.Properties != nil || options.IDColumn != "" {
+			it.properties = make(map[string]any)
+		}
+		if options.Properties != nil {
+			for name, value := range options.Properties(f) {
+				it.properties[name] = value
+			}
+		}
+		if options.IDColumn != "" {
+			it.properties[options.IDColumn] = f.Key().String()
+		}
+		items[i], properties[i] = it, it.properties
+		if i == 0 {
+			extent = it.bounds
+		} else {
+			extent = union(extent, it.bounds)
+		}
+	}
+	hilbertSort(items, extent)
+
+	header := &Header{
+		Name:          options.Name,
+		Bounds:        extent,
+		Columns:       columnsFor(properties),
+		FeaturesCount: uint64(len(items)),
+		IndexNodeSize: branching,
+	}
+	encoded := make([][]byte, len(items))
+	leaves := make([]node, len(items))
+	var offset uint64
+	for i, it := range items {
+		props, err := encodeProperties(header.Columns, it.properties)
+		if err != nil {
+			return err
+		}
+		buf, t, err := encodeFeature(it.geometry, props)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			header.GeometryType = t
+		} else if header.GeometryType != t {
+			header.GeometryType = GeometryUnknown
+		}
+		encoded[i] = buf
+		leaves[i] = node{bounds: it.bounds, offset: offset}
+		offset += uint64(len(buf))
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(magic)
+	bw.Write(encodeHeader(header))
+	if len(leaves) > 0 {
+		var buf []byte
+		for _, n := range buildTree(leaves, int(branching)) {
+			buf = appendNode(buf[:0], n)
+			bw.Write(buf)
+		}
+	}
+	for _, buf := range encoded {
+		bw.Write(buf)
+	}
+	return bw.Flush()
+}
+
+// Export writes all features in an index to a FlatGeobuf file.
+func Export[K feature.Key, F feature.Feature[K]](w io.Writer, idx *index.Index[K, F], options WriteOptions[F]) error {
+	var features []F
+	err := idx.Each(func(f F) error {
+		features = append(features, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return Write[K](w, features, options)
+}
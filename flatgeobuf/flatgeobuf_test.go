@@ -0,0 +1,311 @@
+package flatgeobuf_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bilus/fencer/flatgeobuf"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+func square(id string, x, y, size float64, properties map[string]any) *geometry.Feature {
+	return geometry.NewFeature(geometry.ID(id), geometry.Polygon{
+		{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}},
+	}, properties)
+}
+
+func writeOptions() flatgeobuf.WriteOptions[*geometry.Feature] {
+	return flatgeobuf.WriteOptions[*geometry.Feature]{
+		Name:       "zones",
+		IDColumn:   "id",
+		Properties: func(f *geometry.Feature) map[string]any { return f.Properties },
+	}
+}
+
+func Example() {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		square("a", 0, 0, 10, map[string]any{"name": "A", "level": 1}),
+		square("b", 20, 0, 10, map[string]any{"name": "B", "level": 2.5}),
+		square("c", 0, 20, 10, map[string]any{"name": "C"}),
+	})
+	var buf bytes.Buffer
+	if err := flatgeobuf.Export(&buf, idx, writeOptions()); err != nil {
+		panic(err)
+	}
+
+	f, err := flatgeobuf.NewFile(bytes.NewReader(buf.Bytes()), flatgeobuf.Options{IDColumn: "id"})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(f.Header.Name, f.Header.GeometryType, f.Header.FeaturesCount, f.Header.Bounds)
+	matches, err := f.Search(primitives.Rect{Min: primitives.Point{5, 5}, Max: primitives.Point{25, 8}})
+	if err != nil {
+		panic(err)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	for _, m := range matches {
+		fmt.Println(m.ID, m.Properties["name"], m.Properties["level"])
+	}
+	// Output:
+	// zones Polygon 3 {[0 0] [30 30]}
+	// a A 1
+	// b B 2.5
+}
+
+func TestRoundTrip(t *testing.T) {
+	features := []*geometry.Feature{
+		geometry.NewFeature("point", geometry.Point{1, 2}, map[string]any{"ok": true}),
+		geometry.NewFeature("line", geometry.LineString{{0, 0}, {1, 1}}, map[string]any{"tags": []any{"a", "b"}}),
+		geometry.NewFeature("multipoint", geometry.MultiPoint{{1, 2}, {3, 4}}, nil),
+		geometry.NewFeature("multiline", geometry.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}}, nil),
+		geometry.NewFeature("holed", geometry.Polygon{
+			{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+			{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+		}, map[string]any{"count": int64(7)}),
+		geometry.NewFeature("islands", geometry.MultiPolygon{
+			{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+			{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}},
+		}, nil),
+	}
+	var buf bytes.Buffer
+	if err := flatgeobuf.Write[geometry.ID](&buf, features, writeOptions()); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := flatgeobuf.Load(bytes.NewReader(buf.Bytes()), flatgeobuf.Options{IDColumn: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Size() != len(features) {
+		t.Fatalf("got %d features", idx.Size())
+	}
+	for _, want := range features {
+		found, err := idx.Lookup(want.ID)
+		if err != nil {
+			t.Fatalf("%v: %v", want.ID, err)
+		}
+		got := found[0]
+		if !reflect.DeepEqual(got.Geometry(), want.Geometry()) {
+			t.Errorf("%v: got %v, want %v", want.ID, got.Geometry(), want.Geometry())
+		}
+		for name, value := range want.Properties {
+			if !reflect.DeepEqual(got.Properties[name], value) {
+				t.Errorf("%v: got %v = %#v, want %#v", want.ID, name, got.Properties[name], value)
+			}
+		}
+	}
+}
+
+// countingReaderAt counts bytes read.
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	atomic.AddInt64(&r.read, int64(n))
+	return n, err
+}
+
+func TestFile_Search(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var features []*geometry.Feature
+	for i := 0; i < 2000; i++ {
+		x, y := rnd.Float64()*1000, rnd.Float64()*1000
+		features = append(features, square(fmt.Sprint(i), x, y, rnd.Float64()*5, nil))
+	}
+	path := filepath.Join(t.TempDir(), "squares.fgb")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flatgeobuf.Write[geometry.ID](out, features, writeOptions()); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	osFile, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFile.Close()
+	info, _ := osFile.Stat()
+	counter := &countingReaderAt{r: osFile}
+	f, err := flatgeobuf.NewFile(counter, flatgeobuf.Options{IDColumn: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, _ := index.New[geometry.ID](features)
+	for i := 0; i < 20; i++ {
+		x, y := rnd.Float64()*1000, rnd.Float64()*1000
+		bounds := primitives.Rect{Min: primitives.Point{x, y}, Max: primitives.Point{x + 30, y + 30}}
+		got, err := f.Search(bounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := idx.Intersect(&bounds)
+		if ids(got) != ids(want) {
+			t.Errorf("%v: got %v, want %v", bounds, ids(got), ids(want))
+		}
+	}
+	if counter.read > info.Size() {
+		t.Errorf("20 searches read %d bytes of a %d byte file", counter.read, info.Size())
+	}
+}
+
+func ids(features []*geometry.Feature) string {
+	keys := make([]string, len(features))
+	for i, f := range features {
+		keys[i] = string(f.ID)
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+func TestOpen_empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.fgb")
+	out, _ := os.Create(path)
+	if err := flatgeobuf.Write[geometry.ID](out, nil, writeOptions()); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	f, err := flatgeobuf.Open(path, flatgeobuf.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	matches, err := f.Search(primitives.Rect{Max: primitives.Point{1, 1}})
+	if err != nil || len(matches) != 0 {
+		t.Errorf("got %v, %v", matches, err)
+	}
+}
+
+func TestNewReader_notFlatGeobuf(t *testing.T) {
+	_, err := flatgeobuf.NewReader(bytes.NewReader([]byte("{\"type\":\"FeatureCollection\"}")), flatgeobuf.Options{})
+	if err == nil || err.Error() != "Not a FlatGeobuf file" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// testdata/cells.fgb is laid out the way GDAL writes files: 42 8x8 squares
+// on a 10 unit grid, one of them holed, with name, code and area columns,
+// EPSG:4326, per-feature geometry types and single-ring ends omitted, the
+// default index node size left out of the header and features in descending
+// Hilbert order. See testdata/README.
+
+func TestLoad_fixture(t *testing.T) {
+	in, err := os.Open("testdata/cells.fgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	idx, err := flatgeobuf.Load(in, flatgeobuf.Options{IDColumn: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Size() != 42 {
+		t.Fatalf("got %d features, want 42", idx.Size())
+	}
+	found, err := idx.Lookup("cell 2-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := geometry.Polygon{
+		{{30, 20}, {38, 20}, {38, 28}, {30, 28}, {30, 20}},
+		{{32, 22}, {32, 26}, {36, 26}, {36, 22}, {32, 22}},
+	}
+	if got := found[0]; !reflect.DeepEqual(got.Geometry(), want) {
+		t.Errorf("got %v, want %v", got.Geometry(), want)
+	}
+	wantProperties := map[string]any{"name": "cell 2-3", "code": int64(23), "area": 48.0}
+	if got := found[0].Properties; !reflect.DeepEqual(got, wantProperties) {
+		t.Errorf("got %v, want %v", got, wantProperties)
+	}
+}
+
+func TestFile_Search_fixture(t *testing.T) {
+	f, err := flatgeobuf.Open("testdata/cells.fgb", flatgeobuf.Options{IDColumn: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := f.Header
+	if h.Name != "cells" || h.GeometryType != flatgeobuf.GeometryPolygon || h.FeaturesCount != 42 ||
+		h.IndexNodeSize != flatgeobuf.DefaultIndexNodeSize || len(h.Columns) != 3 {
+		t.Errorf("got %+v", h)
+	}
+	wantBounds := primitives.Rect{Max: primitives.Point{68, 58}}
+	if h.Bounds != wantBounds {
+		t.Errorf("got bounds %v, want %v", h.Bounds, wantBounds)
+	}
+	for _, tt := range []struct {
+		bounds primitives.Rect
+		want   string
+	}{
+		{primitives.Rect{Min: primitives.Point{9, 9}, Max: primitives.Point{9.5, 9.5}}, "[]"},
+		{primitives.Rect{Min: primitives.Point{5, 5}, Max: primitives.Point{15, 15}}, "[cell 0-0 cell 0-1 cell 1-0 cell 1-1]"},
+		{primitives.Rect{Min: primitives.Point{34, 24}, Max: primitives.Point{34, 24}}, "[cell 2-3]"},
+		{primitives.Rect{Min: primitives.Point{60, 0}, Max: primitives.Point{70, 60}}, "[cell 0-6 cell 1-6 cell 2-6 cell 3-6 cell 4-6 cell 5-6]"},
+	} {
+		got, err := f.Search(tt.bounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids(got) != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.bounds, ids(got), tt.want)
+		}
+	}
+	all, err := f.Search(wantBounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 42 {
+		t.Errorf("got %d features, want 42", len(all))
+	}
+}
+
+func TestReader_invalidFeatureSize(t *testing.T) {
+	data, err := os.ReadFile("testdata/cells.fgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The magic bytes and the header, then 42 + 3 + 1 index nodes.
+	first := 12 + int(binary.LittleEndian.Uint32(data[8:])) + 46*40
+	for _, tt := range []struct {
+		size       uint32
+		wantNext   string
+		wantSearch string
+	}{
+		{0xffffff00, "Feature 0: invalid size 4294967040", "Invalid size 4294967040"},
+		{1 << 20, "Feature 0: truncated", "Invalid size 1048576"},
+	} {
+		corrupted := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(corrupted[first:], tt.size)
+		r, err := flatgeobuf.NewReader(bytes.NewReader(corrupted), flatgeobuf.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Next(); err == nil || err.Error() != tt.wantNext {
+			t.Errorf("Expected %q, got %v", tt.wantNext, err)
+		}
+		f, err := flatgeobuf.NewFile(bytes.NewReader(corrupted), flatgeobuf.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Search(f.Header.Bounds); err == nil || !strings.Contains(err.Error(), tt.wantSearch) {
+			t.Errorf("Expected %q, got %v", tt.wantSearch, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package flatgeobuf
+
+import (
+	"fmt"
+
+	"github.com/bilus/fencer/primitives"
+)
+
+// GeometryType is the type of geometries in a file or a feature.
+type GeometryType uint8
+
+const (
+	GeometryUnknown GeometryType = iota // Mixed; each feature declares its type.
+	GeometryPoint
+	GeometryLineString
+	GeometryPolygon
+	GeometryMultiPoint
+	GeometryMultiLineString
+	GeometryMultiPolygon
+	GeometryCollection
+)
+
+var geometryTypeNames = []string{
+	"Unknown", "Point", "LineString", "Polygon",
+	"MultiPoint", "MultiLineString", "MultiPolygon", "GeometryCollection",
+}
+
+func (t GeometryType) String() string {
+	if int(t) < len(geometryTypeNames) {
+		return geometryTypeNames[t]
+	}
+	return fmt.Sprintf("GeometryType(%d)", uint8(t))
+}
+
+// ColumnType is the type of an attribute column.
+type ColumnType uint8
+
+const (
+	ColumnByte ColumnType = iota
+	ColumnUByte
+	ColumnBool
+	ColumnShort
+	ColumnUShort
+	ColumnInt
+	ColumnUInt
+	ColumnLong
+	ColumnULong
+	ColumnFloat
+	ColumnDouble
+	ColumnString
+	ColumnJSON
+	ColumnDateTime
+	ColumnBinary
+)
+
+// Column describes a feature attribute.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Header describes a FlatGeobuf file.
+type Header struct {
+	Name          string
+	Bounds        primitives.Rect // Envelope of all features.
+	GeometryType  GeometryType
+	Columns       []Column
+	FeaturesCount uint64 // 0 if unknown.
+	IndexNodeSize uint16 // 0 if the file has no spatial index.
+}
+
+// Header and Column table slots.
+const (
+	headerName          = 0
+	headerEnvelope      = 1
+	headerGeometryType  = 2
+	headerColumns       = 7
+	headerFeaturesCount = 8
+	headerIndexNodeSize = 9
+
+	columnName = 0
+	columnType = 1
+)
+
+func (h *Header) column(name string) int {
+	for i, c := range h.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func encodeHeader(h *Header) []byte {
+	fields := []field{
+		uint8Field(headerGeometryType, uint8(h.GeometryType)),
+		uint64Field(headerFeaturesCount, h.FeaturesCount),
+		uint16Field(headerIndexNodeSize, h.IndexNodeSize),
+	}
+	if h.Name != "" {
+		fields = append(fields, refField(headerName, func(b *builder) int { return b.string(h.Name) }))
+	}
+	if h.FeaturesCount > 0 {
+		fields = append(fields, refField(headerEnvelope, func(b *builder) int {
+			return b.float64s([]float64{h.Bounds.Min[0], h.Bounds.Min[1], h.Bounds.Max[0], h.Bounds.Max[1]})
+		}))
+	}
+	if len(h.Columns) > 0 {
+		columns := make([][]field, len(h.Columns))
+		for i, c := range h.Columns {
+			name := c.Name
+			columns[i] = []field{
+				refField(columnName, func(b *builder) int { return b.string(name) }),
+				uint8Field(columnType, uint8(c.Type)),
+			}
+		}
+		fields = append(fields, refField(headerColumns, func(b *builder) int { return b.tables(columns) }))
+	}
+	return finish(fields)
+}
+
+func decodeHeader(buf []byte) (h Header, err error) {
+	defer catch(&err)
+	t := root(buf)
+	h.Name = t.string(headerName)
+	if envelope := t.float64s(headerEnvelope); len(envelope) >= 4 {
+		h.Bounds = primitives.Rect{
+			Min: primitives.Point{envelope[0], envelope[1]},
+			Max: primitives.Point{envelope[2], envelope[3]},
+		}
+	}
+	h.GeometryType = GeometryType(t.uint8(headerGeometryType, 0))
+	for _, c := range t.tables(headerColumns) {
+		h.Columns = append(h.Columns, Column{Name: c.string(columnName), Type: ColumnType(c.uint8(columnType, 0))})
+	}
+	h.FeaturesCount = t.uint64(headerFeaturesCount, 0)
+	h.IndexNodeSize = t.uint16(headerIndexNodeSize, DefaultIndexNodeSize)
+	return h, nil
+}
@@ -0,0 +1,230 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/bilus/fencer/primitives"
+)
+
+// DefaultIndexNodeSize is the number of children per node of the spatial
+// index.
+const DefaultIndexNodeSize = 16
+
+// nodeSize is the size of an encoded node: bounds and an offset, which is the
+// byte offset of a feature, relative to the first one, for leaves and the index
+// of the first child for other nodes.
+const nodeSize = 40
+
+type node struct {
+	bounds primitives.Rect
+	offset uint64
+}
+
+// levelBounds returns the [start, end) node ranges of each level of a packed
+// R-tree, leaves first. The root is node 0 and leaves come last.
+func levelBounds(numItems, branching int) [][2]int {
+	n, numNodes := numItems, numItems
+	levelNumNodes := []int{n}
+	for {
+		n = (n + branching - 1) / branching
+		numNodes += n
+		levelNumNodes = append(levelNumNodes, n)
+		if n == 1 {
+			break
+		}
+	}
+	bounds := make([][2]int, len(levelNumNodes))
+	end := numNodes
+	for i, size := range levelNumNodes {
+		bounds[i] = [2]int{end - size, end}
+		end -= size
+	}
+	return bounds
+}
+
+// treeSize returns the size of an encoded index in bytes.
+func treeSize(numItems uint64, branching uint16) int64 {
+	if numItems == 0 || branching == 0 {
+		return 0
+	}
+	levels := levelBounds(int(numItems), int(branching))
+	return int64(levels[0][1]) * nodeSize
+}
+
+// buildTree builds a packed R-tree on top of leaves sorted along the Hilbert
+// curve.
+func buildTree(leaves []node, branching int) []node {
+	levels := levelBounds(len(leaves), branching)
+	nodes := make([]node, levels[0][1])
+	copy(nodes[levels[0][0]:], leaves)
+	for level := 0; level < len(levels)-1; level++ {
+		parent := levels[level+1][0]
+		for i := levels[level][0]; i < levels[level][1]; i += branching {
+			n := node{bounds: nodes[i].bounds, offset: uint64(i)}
+			for j := i + 1; j < i+branching && j < levels[level][1]; j++ {
+				n.bounds = union(n.bounds, nodes[j].bounds)
+			}
+			nodes[parent] = n
+			parent++
+		}
+	}
+	return nodes
+}
+
+// hilbertSort sorts items by the Hilbert value of their centers.
+func hilbertSort(items []item, extent primitives.Rect) {
+	const hilbertMax = 1<<16 - 1
+	width, height := extent.Max[0]-extent.Min[0], extent.Max[1]-extent.Min[1]
+	scale := func(v, start, size float64) uint32 {
+		if size == 0 {
+			return 0
+		}
+		return uint32(math.Floor(hilbertMax * (v - start) / size))
+	}
+	values := make([]uint32, len(items))
+	for i, it := range items {
+		x := scale((it.bounds.Min[0]+it.bounds.Max[0])/2, extent.Min[0], width)
+		y := scale((it.bounds.Min[1]+it.bounds.Max[1])/2, extent.Min[1], height)
+		values[i] = hilbert(x, y)
+	}
+	sort.Stable(byHilbert{items, values})
+}
+
+type byHilbert struct {
+	items  []item
+	values []uint32
+}
+
+func (s byHilbert) Len() int           { return len(s.items) }
+func (s byHilbert) Less(i, j int) bool { return s.values[i] < s.values[j] }
+func (s byHilbert) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+// hilbert returns the position of (x, y) along a 16-bit Hilbert curve.
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+
+	return (i1 << 1) | i0
+}
+
+func appendNode(buf []byte, n node) []byte {
+	buf = appendUint64(buf, math.Float64bits(n.bounds.Min[0]))
+	buf = appendUint64(buf, math.Float64bits(n.bounds.Min[1]))
+	buf = appendUint64(buf, math.Float64bits(n.bounds.Max[0]))
+	buf = appendUint64(buf, math.Float64bits(n.bounds.Max[1]))
+	return appendUint64(buf, n.offset)
+}
+
+func decodeNode(data []byte) node {
+	f := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])) }
+	return node{
+		bounds: primitives.Rect{Min: primitives.Point{f(0), f(1)}, Max: primitives.Point{f(2), f(3)}},
+		offset: binary.LittleEndian.Uint64(data[32:]),
+	}
+}
+
+// leafMatch is a feature found in the index.
+type leafMatch struct {
+	index  int    // Position of the feature in the file.
+	offset uint64 // Byte offset of the feature relative to the first one.
+}
+
+var errInvalidIndex = errors.New("Invalid spatial index")
+
+// searchTree finds leaves intersecting bounds, reading only the nodes it
+// visits from r, where the tree starts at treeOffset. Matches are returned
+// in file order.
+func searchTree(r io.ReaderAt, treeOffset int64, numItems, branching int, bounds primitives.Rect) ([]leafMatch, error) {
+	levels := levelBounds(numItems, branching)
+	leavesStart := levels[0][0]
+	type entry struct{ index, level int }
+	queue := []entry{{0, len(levels) - 1}}
+	var matches []leafMatch
+	buf := make([]byte, branching*nodeSize)
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		end := e.index + branching
+		if levelEnd := levels[e.level][1]; end > levelEnd {
+			end = levelEnd
+		}
+		if e.index < levels[e.level][0] || e.index >= end {
+			return nil, errInvalidIndex
+		}
+		data := buf[:(end-e.index)*nodeSize]
+		if n, err := r.ReadAt(data, treeOffset+int64(e.index)*nodeSize); n < len(data) {
+			return nil, err
+		}
+		for i := e.index; i < end; i++ {
+			n := decodeNode(data[(i-e.index)*nodeSize:])
+			if !intersects(n.bounds, bounds) {
+				continue
+			}
+			if e.level == 0 {
+				matches = append(matches, leafMatch{index: i - leavesStart, offset: n.offset})
+			} else {
+				queue = append(queue, entry{int(n.offset), e.level - 1})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].index < matches[j].index })
+	return matches, nil
+}
+
+func intersects(r1, r2 primitives.Rect) bool {
+	return r1.Min[0] <= r2.Max[0] && r1.Max[0] >= r2.Min[0] &&
+		r1.Min[1] <= r2.Max[1] && r1.Max[1] >= r2.Min[1]
+}
+
+func union(r1, r2 primitives.Rect) primitives.Rect {
+	return primitives.Rect{
+		Min: primitives.Point{math.Min(r1.Min[0], r2.Min[0]), math.Min(r1.Min[1], r2.Min[1])},
+		Max: primitives.Point{math.Max(r1.Max[0], r2.Max[0]), math.Max(r1.Max[1], r2.Max[1])},
+	}
+}
@@ -0,0 +1,276 @@
+// Command fgbgen writes a FlatGeobuf fixture laid out the way GDAL's driver
+// writes files, using the FlatBuffers reference runtime.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+const nodeSize = 16
+
+type rect struct{ minX, minY, maxX, maxY float64 }
+
+func (r *rect) expand(o rect) {
+	r.minX = math.Min(r.minX, o.minX)
+	r.minY = math.Min(r.minY, o.minY)
+	r.maxX = math.Max(r.maxX, o.maxX)
+	r.maxY = math.Max(r.maxY, o.maxY)
+}
+
+func empty() rect { return rect{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)} }
+
+type feat struct {
+	name  string
+	code  int32
+	area  float64
+	rings [][][2]float64
+	box   rect
+	buf   []byte
+}
+
+// hilbert is the reference implementation's xy2d.
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+	return (i1 << 1) | i0
+}
+
+func hilbertOf(r, extent rect) uint32 {
+	const max = (1 << 16) - 1
+	var x, y uint32
+	if w := extent.maxX - extent.minX; w != 0 {
+		x = uint32(math.Floor(max * ((r.minX+r.maxX)/2 - extent.minX) / w))
+	}
+	if h := extent.maxY - extent.minY; h != 0 {
+		y = uint32(math.Floor(max * ((r.minY+r.maxY)/2 - extent.minY) / h))
+	}
+	return hilbert(x, y)
+}
+
+func encodeFeature(f *feat) []byte {
+	b := flatbuffers.NewBuilder(0)
+
+	var props []byte
+	props = binary.LittleEndian.AppendUint16(props, 0)
+	props = binary.LittleEndian.AppendUint32(props, uint32(len(f.name)))
+	props = append(props, f.name...)
+	props = binary.LittleEndian.AppendUint16(props, 1)
+	props = binary.LittleEndian.AppendUint32(props, uint32(f.code))
+	props = binary.LittleEndian.AppendUint16(props, 2)
+	props = binary.LittleEndian.AppendUint64(props, math.Float64bits(f.area))
+	b.StartVector(1, len(props), 1)
+	for i := len(props) - 1; i >= 0; i-- {
+		b.PrependByte(props[i])
+	}
+	propsOff := b.EndVector(len(props))
+
+	var xy []float64
+	var ends []uint32
+	for _, ring := range f.rings {
+		for _, p := range ring {
+			xy = append(xy, p[0], p[1])
+		}
+		ends = append(ends, uint32(len(xy)/2))
+	}
+	b.StartVector(8, len(xy), 8)
+	for i := len(xy) - 1; i >= 0; i-- {
+		b.PrependFloat64(xy[i])
+	}
+	xyOff := b.EndVector(len(xy))
+	var endsOff flatbuffers.UOffsetT
+	if len(ends) > 1 { // GDAL omits ends of single-ring polygons.
+		b.StartVector(4, len(ends), 4)
+		for i := len(ends) - 1; i >= 0; i-- {
+			b.PrependUint32(ends[i])
+		}
+		endsOff = b.EndVector(len(ends))
+	}
+	b.StartObject(8)
+	if endsOff != 0 {
+		b.PrependUOffsetTSlot(0, endsOff, 0)
+	}
+	b.PrependUOffsetTSlot(1, xyOff, 0)
+	geomOff := b.EndObject()
+
+	b.StartObject(3)
+	b.PrependUOffsetTSlot(0, geomOff, 0)
+	b.PrependUOffsetTSlot(1, propsOff, 0)
+	b.FinishSizePrefixed(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func encodeHeader(name string, extent rect, count int) []byte {
+	b := flatbuffers.NewBuilder(0)
+	type column struct {
+		name string
+		typ  byte
+	}
+	columns := []column{{"name", 11}, {"code", 5}, {"area", 10}}
+	offs := make([]flatbuffers.UOffsetT, len(columns))
+	for i, c := range columns {
+		n := b.CreateString(c.name)
+		b.StartObject(11)
+		b.PrependUOffsetTSlot(0, n, 0)
+		b.PrependByteSlot(1, c.typ, 0)
+		b.PrependBoolSlot(7, true, true)
+		offs[i] = b.EndObject()
+	}
+	b.StartVector(4, len(offs), 4)
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offs[i])
+	}
+	columnsOff := b.EndVector(len(offs))
+
+	org := b.CreateString("EPSG")
+	b.StartObject(6)
+	b.PrependUOffsetTSlot(0, org, 0)
+	b.PrependInt32Slot(1, 4326, 0)
+	crsOff := b.EndObject()
+
+	env := []float64{extent.minX, extent.minY, extent.maxX, extent.maxY}
+	b.StartVector(8, len(env), 8)
+	for i := len(env) - 1; i >= 0; i-- {
+		b.PrependFloat64(env[i])
+	}
+	envOff := b.EndVector(len(env))
+	nameOff := b.CreateString(name)
+
+	b.StartObject(14)
+	b.PrependUOffsetTSlot(0, nameOff, 0)
+	b.PrependUOffsetTSlot(1, envOff, 0)
+	b.PrependByteSlot(2, 3, 0) // Polygon
+	b.PrependUOffsetTSlot(7, columnsOff, 0)
+	b.PrependUint64Slot(8, uint64(count), 0)
+	b.PrependUint16Slot(9, nodeSize, 16) // Default, so omitted as GDAL does.
+	b.PrependUOffsetTSlot(10, crsOff, 0)
+	b.FinishSizePrefixed(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func levelBounds(numItems, nodeSize int) [][2]int {
+	n := numItems
+	numNodes := n
+	levelNumNodes := []int{n}
+	for n != 1 {
+		n = (n + nodeSize - 1) / nodeSize
+		numNodes += n
+		levelNumNodes = append(levelNumNodes, n)
+	}
+	levelOffsets := make([]int, len(levelNumNodes))
+	n = numNodes
+	for i, size := range levelNumNodes {
+		levelOffsets[i] = n - size
+		n -= size
+	}
+	bounds := make([][2]int, len(levelNumNodes))
+	for i := range levelNumNodes {
+		bounds[i] = [2]int{levelOffsets[i], levelOffsets[i] + levelNumNodes[i]}
+	}
+	return bounds
+}
+
+func main() {
+	var features []*feat
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 7; c++ {
+			x, y := float64(c)*10, float64(r)*10
+			f := &feat{
+				name:  fmt.Sprintf("cell %d-%d", r, c),
+				code:  int32(r*10 + c),
+				area:  64,
+				rings: [][][2]float64{{{x, y}, {x + 8, y}, {x + 8, y + 8}, {x, y + 8}, {x, y}}},
+				box:   rect{x, y, x + 8, y + 8},
+			}
+			if r == 2 && c == 3 {
+				f.rings = append(f.rings, [][2]float64{{x + 2, y + 2}, {x + 2, y + 6}, {x + 6, y + 6}, {x + 6, y + 2}, {x + 2, y + 2}})
+				f.area = 48
+			}
+			features = append(features, f)
+		}
+	}
+	extent := empty()
+	for _, f := range features {
+		extent.expand(f.box)
+	}
+	sort.SliceStable(features, func(i, j int) bool {
+		return hilbertOf(features[i].box, extent) > hilbertOf(features[j].box, extent)
+	})
+
+	bounds := levelBounds(len(features), nodeSize)
+	numNodes := bounds[0][1]
+	type node struct {
+		rect
+		offset uint64
+	}
+	nodes := make([]node, numNodes)
+	var offset uint64
+	for i, f := range features {
+		f.buf = encodeFeature(f)
+		nodes[bounds[0][0]+i] = node{f.box, offset}
+		offset += uint64(len(f.buf))
+	}
+	for i := 0; i < len(bounds)-1; i++ {
+		pos, end, newpos := bounds[i][0], bounds[i][1], bounds[i+1][0]
+		for pos < end {
+			n := node{empty(), uint64(pos)}
+			for j := 0; j < nodeSize && pos < end; j++ {
+				n.expand(nodes[pos].rect)
+				pos++
+			}
+			nodes[newpos] = n
+			newpos++
+		}
+	}
+
+	out := []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+	out = append(out, encodeHeader("cells", extent, len(features))...)
+	for _, n := range nodes {
+		for _, v := range []float64{n.minX, n.minY, n.maxX, n.maxY} {
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+		}
+		out = binary.LittleEndian.AppendUint64(out, n.offset)
+	}
+	for _, f := range features {
+		out = append(out, f.buf...)
+	}
+	if err := os.WriteFile(os.Args[1], out, 0o644); err != nil {
+		panic(err)
+	}
+}
@@ -16,3 +16,8 @@ func NewBoundsAround(point primitives.Point, radius float64) (*primitives.Rect,
 		bound.Height(),
 	)
 }
+
+// Distance returns the geodesic distance between two points in meters.
+func Distance(from, to primitives.Point) float64 {
+	return geo.NewPoint(from[0], from[1]).GeoDistanceFrom(geo.NewPoint(to[0], to[1]), true)
+}
@@ -0,0 +1,249 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+const (
+	geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+	// MaxGeohashPrecision is the longest supported geohash.
+	MaxGeohashPrecision = 12
+)
+
+// EncodeGeohash returns the geohash of a point (longitude, latitude). The
+// precision is clamped to 1..MaxGeohashPrecision.
+func EncodeGeohash(point primitives.Point, precision int) string {
+	precision = clampPrecision(precision)
+	lon := math.Max(-180, math.Min(180, point[0]))
+	lat := math.Max(-90, math.Min(90, point[1]))
+	bounds := primitives.Rect{Min: primitives.Point{-180, -90}, Max: primitives.Point{180, 90}}
+	hash := make([]byte, precision)
+	even := true
+	for i := range hash {
+		var ch int
+		for bit := 4; bit >= 0; bit-- {
+			axis, value := 1, lat
+			if even {
+				axis, value = 0, lon
+			}
+			mid := (bounds.Min[axis] + bounds.Max[axis]) / 2
+			if value >= mid {
+				ch |= 1 << bit
+				bounds.Min[axis] = mid
+			} else {
+				bounds.Max[axis] = mid
+			}
+			even = !even
+		}
+		hash[i] = geohashAlphabet[ch]
+	}
+	return string(hash)
+}
+
+// GeohashBounds returns the cell a geohash stands for.
+func GeohashBounds(hash string) (primitives.Rect, error) {
+	if hash == "" || len(hash) > MaxGeohashPrecision {
+		return primitives.Rect{}, fmt.Errorf("Invalid geohash %q", hash)
+	}
+	bounds := primitives.Rect{Min: primitives.Point{-180, -90}, Max: primitives.Point{180, 90}}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		ch := strings.IndexByte(geohashAlphabet, toLower(hash[i]))
+		if ch < 0 {
+			return primitives.Rect{}, fmt.Errorf("Invalid geohash %q", hash)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			axis := 1
+			if even {
+				axis = 0
+			}
+			mid := (bounds.Min[axis] + bounds.Max[axis]) / 2
+			if ch&(1<<bit) != 0 {
+				bounds.Min[axis] = mid
+			} else {
+				bounds.Max[axis] = mid
+			}
+			even = !even
+		}
+	}
+	return bounds, nil
+}
+
+// DecodeGeohash returns the center of a geohash cell.
+func DecodeGeohash(hash string) (primitives.Point, error) {
+	bounds, err := GeohashBounds(hash)
+	if err != nil {
+		return primitives.Point{}, err
+	}
+	return center(bounds), nil
+}
+
+// Direction indexes the result of GeohashNeighbours.
+type Direction int
+
+const (
+	North Direction = iota
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+)
+
+// GeohashNeighbours returns the eight cells of the same precision around a
+// geohash, indexed by Direction. Neighbours wrap around the antimeridian;
+// there are none beyond the poles, where empty strings are returned.
+func GeohashNeighbours(hash string) ([8]string, error) {
+	var neighbours [8]string
+	bounds, err := GeohashBounds(hash)
+	if err != nil {
+		return neighbours, err
+	}
+	c := center(bounds)
+	width, height := bounds.Max[0]-bounds.Min[0], bounds.Max[1]-bounds.Min[1]
+	offsets := [8][2]float64{{0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}, {-1, -1}, {-1, 0}, {-1, 1}}
+	for i, offset := range offsets {
+		lat := c[1] + offset[1]*height
+		if lat < -90 || lat > 90 {
+			continue
+		}
+		lon := c[0] + offset[0]*width
+		if lon > 180 {
+			lon -= 360
+		} else if lon < -180 {
+			lon += 360
+		}
+		neighbours[i] = EncodeGeohash(primitives.Point{lon, lat}, len(hash))
+	}
+	return neighbours, nil
+}
+
+// ErrTooManyGeohashCells is returned when a covering would have more cells
+// than allowed.
+var ErrTooManyGeohashCells = errors.New("Covering exceeds the maximum number of cells")
+
+// GeohashCell is a cell of a covering.
+type GeohashCell struct {
+	Hash string
+	// Interior is true for cells lying entirely within the covered shape and
+	// false for cells on its boundary.
+	Interior bool
+}
+
+// GeohashCover returns geohash cells covering a rectangle, sorted by hash,
+// or ErrTooManyGeohashCells if there would be more than maxCells. Cells have
+// mixed precisions: boundary cells have the given precision, while interior
+// cells are merged into the largest cells fitting inside, so the result is
+// the smallest such set.
+func GeohashCover(rect primitives.Rect, precision, maxCells int) ([]GeohashCell, error) {
+	return cover(precision, maxCells, func(cell primitives.Rect) (bool, bool) {
+		intersects := overlaps(cell.Min[0], cell.Max[0], rect.Min[0], rect.Max[0], 180) &&
+			overlaps(cell.Min[1], cell.Max[1], rect.Min[1], rect.Max[1], 90)
+		contains := cell.Min[0] >= rect.Min[0] && cell.Max[0] <= rect.Max[0] &&
+			cell.Min[1] >= rect.Min[1] && cell.Max[1] <= rect.Max[1]
+		return intersects, contains
+	})
+}
+
+// overlaps returns true if a cell's range [cellMin, cellMax) overlaps [lo, hi];
+// cells at the edge of the world include their maximum.
+func overlaps(cellMin, cellMax, lo, hi, edge float64) bool {
+	return cellMin <= hi && (cellMax > lo || cellMax == edge && lo == edge)
+}
+
+// GeohashCoverGeometry returns geohash cells covering a geometry, like
+// GeohashCover. Cells the geometry's edges pass through or touch are labelled
+// as boundary cells.
+func GeohashCoverGeometry(g geometry.Geometry, precision, maxCells int) ([]GeohashCell, error) {
+	bounds := g.Bounds()
+	return cover(precision, maxCells, func(cell primitives.Rect) (bool, bool) {
+		// Cells don't include their northern and eastern edges, except at the
+		// edge of the world.
+		open := cell
+		if open.Max[0] < 180 {
+			open.Max[0] = math.Nextafter(open.Max[0], math.Inf(-1))
+		}
+		if open.Max[1] < 90 {
+			open.Max[1] = math.Nextafter(open.Max[1], math.Inf(-1))
+		}
+		if open.Min[0] > bounds.Max[0] || open.Max[0] < bounds.Min[0] ||
+			open.Min[1] > bounds.Max[1] || open.Max[1] < bounds.Min[1] {
+			return false, false
+		}
+		if !geometry.IntersectsRect(g, open) {
+			return false, false
+		}
+		return true, geometry.ContainsRect(g, cell)
+	})
+}
+
+// GeohashCoverFeature covers a feature's geometry if it implements
+// geometry.Geometer and its bounding rectangle otherwise.
+func GeohashCoverFeature[K feature.Key](f feature.Feature[K], precision, maxCells int) ([]GeohashCell, error) {
+	if geometer, ok := f.(geometry.Geometer); ok {
+		return GeohashCoverGeometry(geometer.Geometry(), precision, maxCells)
+	}
+	return GeohashCover(*f.Bounds(), precision, maxCells)
+}
+
+// cover subdivides cells starting with the whole world. classify reports
+// whether a cell intersects the shape and whether it lies within it. It
+// stops once there are more than maxCells cells.
+func cover(precision, maxCells int, classify func(cell primitives.Rect) (intersects, contains bool)) ([]GeohashCell, error) {
+	precision = clampPrecision(precision)
+	var cells []GeohashCell
+	var visit func(hash string)
+	visit = func(hash string) {
+		for i := 0; i < len(geohashAlphabet) && len(cells) <= maxCells; i++ {
+			child := hash + geohashAlphabet[i:i+1]
+			bounds, _ := GeohashBounds(child)
+			intersects, contains := classify(bounds)
+			switch {
+			case !intersects:
+			case contains:
+				cells = append(cells, GeohashCell{Hash: child, Interior: true})
+			case len(child) == precision:
+				cells = append(cells, GeohashCell{Hash: child})
+			default:
+				visit(child)
+			}
+		}
+	}
+	visit("")
+	if len(cells) > maxCells {
+		return nil, ErrTooManyGeohashCells
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].Hash < cells[j].Hash })
+	return cells, nil
+}
+
+func clampPrecision(precision int) int {
+	if precision < 1 {
+		return 1
+	}
+	if precision > MaxGeohashPrecision {
+		return MaxGeohashPrecision
+	}
+	return precision
+}
+
+func center(rect primitives.Rect) primitives.Point {
+	return primitives.Point{(rect.Min[0] + rect.Max[0]) / 2, (rect.Min[1] + rect.Max[1]) / 2}
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,128 @@
+package geo_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/bilus/fencer/geo"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+func ExampleEncodeGeohash() {
+	fmt.Println(geo.EncodeGeohash(primitives.Point{-5.6, 42.6}, 5))
+	// Output: ezs42
+}
+
+func ExampleGeohashNeighbours() {
+	neighbours, _ := geo.GeohashNeighbours("dqcjq")
+	fmt.Println(neighbours[geo.North], neighbours[geo.East], neighbours[geo.South], neighbours[geo.West])
+	// Output: dqcjw dqcjr dqcjn dqcjm
+}
+
+func ExampleGeohashCoverGeometry() {
+	// An L-shaped polygon fully covering cell "s0" (0..11.25, 0..5.625).
+	shape := geometry.Polygon{{{-1, -1}, {24, -1}, {24, 3}, {12, 3}, {12, 7}, {-1, 7}, {-1, -1}}}
+	cells, err := geo.GeohashCoverGeometry(shape, 2, 100)
+	if err != nil {
+		panic(err)
+	}
+	var boundary []string
+	for _, cell := range cells {
+		if cell.Interior {
+			fmt.Println("interior:", cell.Hash)
+		} else {
+			boundary = append(boundary, cell.Hash)
+		}
+	}
+	fmt.Println("boundary:", boundary)
+	// Output:
+	// interior: s0
+	// boundary: [7z eb ec kp kr kx s1 s2 s3 s8]
+}
+
+func TestDecodeGeohash(t *testing.T) {
+	point, err := geo.DecodeGeohash("EZS42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo.EncodeGeohash(point, 5) != "ezs42" {
+		t.Errorf("got %v", point)
+	}
+	if _, err := geo.DecodeGeohash("ezs4a"); err == nil {
+		t.Error("expected an error for an invalid character")
+	}
+}
+
+func TestGeohashNeighbours_edges(t *testing.T) {
+	neighbours, err := geo.GeohashNeighbours("b") // North-west corner of the world.
+	if err != nil {
+		t.Fatal(err)
+	}
+	if neighbours[geo.North] != "" || neighbours[geo.NorthWest] != "" {
+		t.Errorf("expected no neighbours beyond the pole, got %v", neighbours)
+	}
+	if neighbours[geo.West] != "z" || neighbours[geo.South] != "8" {
+		t.Errorf("got %v", neighbours)
+	}
+}
+
+func TestGeohashCover(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		x, y := rnd.Float64()*300-150, rnd.Float64()*140-70
+		rect := primitives.Rect{Min: primitives.Point{x, y}, Max: primitives.Point{x + rnd.Float64()*20, y + rnd.Float64()*10}}
+		cells, err := geo.GeohashCover(rect, 4, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds := make([]primitives.Rect, len(cells))
+		for j, cell := range cells {
+			bounds[j], _ = geo.GeohashBounds(cell.Hash)
+			if cell.Interior && !within(bounds[j], rect) {
+				t.Fatalf("%v: interior cell %v not within", rect, cell.Hash)
+			}
+			if !cell.Interior && len(cell.Hash) != 4 {
+				t.Fatalf("%v: boundary cell %v has wrong precision", rect, cell.Hash)
+			}
+		}
+		// Every point of the rectangle must be covered.
+		for k := 0; k < 200; k++ {
+			p := primitives.Point{
+				rect.Min[0] + rnd.Float64()*(rect.Max[0]-rect.Min[0]),
+				rect.Min[1] + rnd.Float64()*(rect.Max[1]-rect.Min[1]),
+			}
+			covered := false
+			for _, b := range bounds {
+				if p[0] >= b.Min[0] && p[0] <= b.Max[0] && p[1] >= b.Min[1] && p[1] <= b.Max[1] {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				t.Fatalf("%v: point %v not covered", rect, p)
+			}
+		}
+	}
+}
+
+func within(inner, outer primitives.Rect) bool {
+	return inner.Min[0] >= outer.Min[0] && inner.Max[0] <= outer.Max[0] &&
+		inner.Min[1] >= outer.Min[1] && inner.Max[1] <= outer.Max[1]
+}
+
+func TestGeohashCover_maxCells(t *testing.T) {
+	world := primitives.Rect{Min: primitives.Point{-180, -90}, Max: primitives.Point{180, 90}}
+	// The whole world is 32 interior cells of precision 1, whatever the
+	// precision asked for.
+	cells, err := geo.GeohashCover(world, geo.MaxGeohashPrecision, 32)
+	if err != nil || len(cells) != 32 {
+		t.Errorf("Expected 32 cells, got %d, %v", len(cells), err)
+	}
+	// A thin strip has boundary cells of the full precision along its length.
+	strip := primitives.Rect{Min: primitives.Point{-170, 0}, Max: primitives.Point{170, 0.001}}
+	if _, err := geo.GeohashCover(strip, geo.MaxGeohashPrecision, 1000); err != geo.ErrTooManyGeohashCells {
+		t.Errorf("Expected ErrTooManyGeohashCells, got %v", err)
+	}
+}
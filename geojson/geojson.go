@@ -0,0 +1,295 @@
+// Package geojson loads GeoJSON FeatureCollections into an index of
+// geometry.Feature values and exports indexes and query results as GeoJSON.
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+)
+
+// Options configure reading features.
+type Options struct {
+	// IDProperty is the name of the property holding feature keys. If empty,
+	// the feature's "id" member is used.
+	IDProperty string
+}
+
+// FeatureError describes an invalid feature.
+type FeatureError struct {
+	Index  int   // Position of the feature in the collection, starting at 0.
+	Offset int64 // Byte offset of the feature in the input.
+	Err    error
+}
+
+func (err *FeatureError) Error() string {
+	return fmt.Sprintf("Invalid feature #%d at offset %d: %v", err.Index, err.Offset, err.Err)
+}
+
+func (err *FeatureError) Unwrap() error {
+	return err.Err
+}
+
+// Reader reads features from a FeatureCollection one at a time without
+// loading the whole document into memory.
+type Reader struct {
+	dec     *json.Decoder
+	options Options
+	n       int
+	inArray bool
+	done    bool
+}
+
+type rawFeature struct {
+	Type       string         `json:"type"`
+	ID         any            `json:"id"`
+	Geometry   *rawGeometry   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// NewReader creates a reader of a FeatureCollection.
+func NewReader(r io.Reader, options Options) *Reader {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Reader{dec: dec, options: options}
+}
+
+// Next returns the next feature. It returns io.EOF after the last feature and
+// a *FeatureError for an invalid feature, in which case reading may continue.
+// Any other error means the input is not a valid FeatureCollection.
+func (r *Reader) Next() (*geometry.Feature, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	if !r.inArray {
+		if err := r.seekFeatures(); err != nil {
+			return nil, err
+		}
+	}
+	if !r.dec.More() {
+		if err := r.finish(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	offset := r.dec.InputOffset() - int64(len(raw))
+	index := r.n
+	r.n++
+	f, err := r.decodeFeature(raw)
+	if err != nil {
+		return nil, &FeatureError{Index: index, Offset: offset, Err: err}
+	}
+	return f, nil
+}
+
+// Load reads all features into a new index. Invalid features are skipped and
+// returned along with the index.
+func Load(r io.Reader, options Options) (*index.Index[geometry.ID, *geometry.Feature], []*FeatureError, error) {
+	reader := NewReader(r, options)
+	var features []*geometry.Feature
+	var invalid []*FeatureError
+	for {
+		f, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var featureErr *FeatureError
+		if errors.As(err, &featureErr) {
+			invalid = append(invalid, featureErr)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		features = append(features, f)
+	}
+	idx, err := index.New[geometry.ID](features)
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx, invalid, nil
+}
+
+// seekFeatures positions the decoder at the first element of the features
+// array.
+func (r *Reader) seekFeatures() error {
+	if err := r.expectDelim('{'); err != nil {
+		return err
+	}
+	for r.dec.More() {
+		name, err := r.key()
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "features":
+			if err := r.expectDelim('['); err != nil {
+				return err
+			}
+			r.inArray = true
+			return nil
+		case "type":
+			var t string
+			if err := r.dec.Decode(&t); err != nil {
+				return err
+			}
+			if t != "FeatureCollection" {
+				return fmt.Errorf("Expected a FeatureCollection, got %q", t)
+			}
+		default:
+			if err := r.skip(); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.New("FeatureCollection without features")
+}
+
+// finish consumes the rest of the document after the features array,
+// checking its type if it wasn't seen yet.
+func (r *Reader) finish() error {
+	r.done = true
+	if err := r.expectDelim(']'); err != nil {
+		return err
+	}
+	for r.dec.More() {
+		name, err := r.key()
+		if err != nil {
+			return err
+		}
+		if name == "type" {
+			var t string
+			if err := r.dec.Decode(&t); err != nil {
+				return err
+			}
+			if t != "FeatureCollection" {
+				return fmt.Errorf("Expected a FeatureCollection, got %q", t)
+			}
+			continue
+		}
+		if err := r.skip(); err != nil {
+			return err
+		}
+	}
+	return r.expectDelim('}')
+}
+
+func (r *Reader) key() (string, error) {
+	token, err := r.dec.Token()
+	if err != nil {
+		return "", err
+	}
+	name, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("Expected an object key at offset %d", r.dec.InputOffset())
+	}
+	return name, nil
+}
+
+func (r *Reader) skip() error {
+	var value json.RawMessage
+	return r.dec.Decode(&value)
+}
+
+func (r *Reader) expectDelim(delim json.Delim) error {
+	token, err := r.dec.Token()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Expected %v at offset %d, got %v", delim, r.dec.InputOffset(), token)
+	}
+	return nil
+}
+
+func (r *Reader) decodeFeature(data []byte) (*geometry.Feature, error) {
+	return DecodeFeature(data, r.options)
+}
+
+// DecodeFeature decodes a single GeoJSON Feature.
+func DecodeFeature(data []byte, options Options) (*geometry.Feature, error) {
+	var raw rawFeature
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if raw.Type != "Feature" {
+		return nil, fmt.Errorf("Expected a Feature, got %q", raw.Type)
+	}
+	id, err := featureID(&raw, options)
+	if err != nil {
+		return nil, err
+	}
+	g, err := decodeGeometry(raw.Geometry)
+	if err != nil {
+		return nil, err
+	}
+	return geometry.NewFeature(id, g, normalize(raw.Properties)), nil
+}
+
+func featureID(raw *rawFeature, options Options) (geometry.ID, error) {
+	if options.IDProperty == "" {
+		if raw.ID == nil {
+			return "", errors.New("Missing id")
+		}
+		return toID(raw.ID, "id")
+	}
+	value, ok := raw.Properties[options.IDProperty]
+	if !ok || value == nil {
+		return "", fmt.Errorf("Missing %q property", options.IDProperty)
+	}
+	return toID(value, options.IDProperty)
+}
+
+func toID(value any, name string) (geometry.ID, error) {
+	switch v := value.(type) {
+	case string:
+		return geometry.ID(v), nil
+	case json.Number:
+		return geometry.ID(v.String()), nil
+	default:
+		return "", fmt.Errorf("Expected %v to be a string or a number, got %T", name, value)
+	}
+}
+
+// normalize converts json.Number property values to float64, or int64 for
+// integers.
+func normalize(properties map[string]any) map[string]any {
+	for k, v := range properties {
+		properties[k] = normalizeValue(v)
+	}
+	return properties
+}
+
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		return normalize(v)
+	case []any:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,106 @@
+package geojson_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/primitives"
+)
+
+const cities = `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "id": "doughnut",
+      "properties": {"name": "Doughnut", "population": 1000},
+      "geometry": {
+        "type": "Polygon",
+        "coordinates": [
+          [[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+          [[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]
+        ]
+      }
+    },
+    {
+      "type": "Feature",
+      "id": 42,
+      "properties": {"name": "Archipelago", "population": 12.5},
+      "geometry": {
+        "type": "MultiPolygon",
+        "coordinates": [
+          [[[5, 5], [5.5, 5], [5.5, 5.5], [5, 5.5], [5, 5]]],
+          [[[20, 20], [30, 20], [30, 30], [20, 20]]]
+        ]
+      }
+    }
+  ]
+}`
+
+func ExampleLoad() {
+	index, invalid, _ := geojson.Load(strings.NewReader(cities), geojson.Options{})
+	results, _ := index.FindContaining(primitives.Point{5.2, 5.2})
+	fmt.Println(len(invalid), "invalid,", len(results), "result:", results[0].Properties["name"])
+	// Output: 0 invalid, 1 result: Archipelago
+}
+
+func TestLoad_idProperty(t *testing.T) {
+	index, invalid, err := geojson.Load(strings.NewReader(cities), geojson.Options{IDProperty: "name"})
+	if err != nil || len(invalid) != 0 {
+		t.Fatal(err, invalid)
+	}
+	results, _ := index.Lookup("Doughnut")
+	if len(results) != 1 || results[0].Properties["population"] != int64(1000) {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+}
+
+func TestLoad_invalidFeatures(t *testing.T) {
+	input := `{"features": [
+{"type": "Feature", "id": "ok", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}},
+{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}},
+{"type": "Feature", "id": "open", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}},
+{"type": "Feature", "id": "line", "geometry": {"type": "Curve", "coordinates": []}}
+], "type": "FeatureCollection"}`
+	index, invalid, err := geojson.Load(strings.NewReader(input), geojson.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Size() != 1 {
+		t.Fatalf("Expected 1 valid feature, got %d", index.Size())
+	}
+	expected := []struct {
+		index int
+		err   string
+	}{
+		{1, "Missing id"},
+		{2, "Ring 0: Linear ring is not closed"},
+		{3, `Unsupported geometry type "Curve"`},
+	}
+	if len(invalid) != len(expected) {
+		t.Fatalf("Expected %d invalid features, got %v", len(expected), invalid)
+	}
+	for i, e := range expected {
+		if invalid[i].Index != e.index || invalid[i].Err.Error() != e.err {
+			t.Errorf("Expected feature #%d to fail with %q, got %v", e.index, e.err, invalid[i])
+		}
+		if !strings.HasPrefix(input[invalid[i].Offset:], `{"type": "Feature"`) {
+			t.Errorf("Offset %d doesn't point at feature #%d", invalid[i].Offset, e.index)
+		}
+	}
+}
+
+func TestLoad_notFeatureCollection(t *testing.T) {
+	for _, input := range []string{
+		`{"type": "Feature", "features": []}`,
+		`{"features": [], "type": "Feature"}`,
+		`{"features": [`,
+		`[]`,
+	} {
+		if _, _, err := geojson.Load(strings.NewReader(input), geojson.Options{}); err == nil {
+			t.Errorf("Expected an error for %v", input)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package geojson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+type rawGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// UnmarshalGeometry decodes a GeoJSON geometry object.
+func UnmarshalGeometry(data []byte) (geometry.Geometry, error) {
+	var raw rawGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return decodeGeometry(&raw)
+}
+
+// MarshalGeometry encodes a geometry as a GeoJSON geometry object.
+func MarshalGeometry(g geometry.Geometry) ([]byte, error) {
+	out, err := encodeGeometry(g)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// decodeGeometry converts a GeoJSON geometry object to a geometry.
+func decodeGeometry(raw *rawGeometry) (geometry.Geometry, error) {
+	if raw == nil {
+		return nil, errors.New("Missing geometry")
+	}
+	switch raw.Type {
+	case "Point":
+		var coords []float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid Point coordinates: %w", err)
+		}
+		point, err := decodePosition(coords)
+		return geometry.Point(point), err
+	case "MultiPoint":
+		var coords [][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid MultiPoint coordinates: %w", err)
+		}
+		if len(coords) == 0 {
+			return nil, errors.New("MultiPoint without points")
+		}
+		points, err := decodePositions(coords)
+		return geometry.MultiPoint(points), err
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid LineString coordinates: %w", err)
+		}
+		return decodeLineString(coords)
+	case "MultiLineString":
+		var coords [][][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid MultiLineString coordinates: %w", err)
+		}
+		if len(coords) == 0 {
+			return nil, errors.New("MultiLineString without line strings")
+		}
+		multi := make(geometry.MultiLineString, len(coords))
+		for i, line := range coords {
+			var err error
+			if multi[i], err = decodeLineString(line); err != nil {
+				return nil, fmt.Errorf("LineString %d: %w", i, err)
+			}
+		}
+		return multi, nil
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid Polygon coordinates: %w", err)
+		}
+		return decodePolygon(coords)
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(raw.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Invalid MultiPolygon coordinates: %w", err)
+		}
+		if len(coords) == 0 {
+			return nil, errors.New("MultiPolygon without polygons")
+		}
+		multi := make(geometry.MultiPolygon, len(coords))
+		for i, polygon := range coords {
+			var err error
+			if multi[i], err = decodePolygon(polygon); err != nil {
+				return nil, fmt.Errorf("Polygon %d: %w", i, err)
+			}
+		}
+		return multi, nil
+	case "":
+		return nil, errors.New("Missing geometry type")
+	default:
+		return nil, fmt.Errorf("Unsupported geometry type %q", raw.Type)
+	}
+}
+
+func decodeLineString(coords [][]float64) (geometry.LineString, error) {
+	if len(coords) < 2 {
+		return nil, fmt.Errorf("LineString needs at least 2 positions, got %d", len(coords))
+	}
+	points, err := decodePositions(coords)
+	return geometry.LineString(points), err
+}
+
+func decodePolygon(coords [][][]float64) (geometry.Polygon, error) {
+	if len(coords) == 0 {
+		return nil, errors.New("Polygon without rings")
+	}
+	polygon := make(geometry.Polygon, len(coords))
+	for i, ring := range coords {
+		var err error
+		if polygon[i], err = decodeRing(ring); err != nil {
+			return nil, fmt.Errorf("Ring %d: %w", i, err)
+		}
+	}
+	return polygon, nil
+}
+
+func decodeRing(coords [][]float64) (geometry.Ring, error) {
+	if len(coords) < 4 {
+		return nil, fmt.Errorf("Linear ring needs at least 4 positions, got %d", len(coords))
+	}
+	points, err := decodePositions(coords)
+	if err != nil {
+		return nil, err
+	}
+	if points[0] != points[len(points)-1] {
+		return nil, errors.New("Linear ring is not closed")
+	}
+	return points, nil
+}
+
+func decodePositions(coords [][]float64) ([]primitives.Point, error) {
+	points := make([]primitives.Point, len(coords))
+	for i, position := range coords {
+		var err error
+		if points[i], err = decodePosition(position); err != nil {
+			return nil, fmt.Errorf("Position %d: %w", i, err)
+		}
+	}
+	return points, nil
+}
+
+func decodePosition(position []float64) (primitives.Point, error) {
+	if len(position) < 2 {
+		return primitives.Point{}, fmt.Errorf("Expected at least 2 coordinates, got %d", len(position))
+	}
+	for _, c := range position[:2] {
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			return primitives.Point{}, errors.New("Coordinate is not a finite number")
+		}
+	}
+	return primitives.Point{position[0], position[1]}, nil
+}
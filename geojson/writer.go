@@ -0,0 +1,195 @@
+package geojson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+)
+
+// Geometer is implemented by features exposing their geometry. Features not
+// implementing it are exported as their bounding rectangles.
+type Geometer = geometry.Geometer
+
+// WriteOptions configure exporting features.
+type WriteOptions[F any] struct {
+	// Properties returns properties to export for a feature; optional.
+	Properties func(f F) map[string]any
+}
+
+// AllProperties exports all properties of a geometry.Feature.
+func AllProperties(f *geometry.Feature) map[string]any {
+	return f.Properties
+}
+
+// SelectProperties returns a function exporting only the named properties of
+// a geometry.Feature.
+func SelectProperties(names ...string) func(f *geometry.Feature) map[string]any {
+	return func(f *geometry.Feature) map[string]any {
+		selected := make(map[string]any, len(names))
+		for _, name := range names {
+			if value, ok := f.Properties[name]; ok {
+				selected[name] = value
+			}
+		}
+		return selected
+	}
+}
+
+// ErrWriterClosed is returned when writing to a closed Writer.
+var ErrWriterClosed = errors.New("Writer closed")
+
+// Writer streams features as a FeatureCollection.
+type Writer[K feature.Key, F feature.Feature[K]] struct {
+	w       *bufio.Writer
+	options WriteOptions[F]
+	n       int
+	closed  bool
+}
+
+type outFeature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	BBox       []float64      `json:"bbox"`
+	Geometry   any            `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type outGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// NewWriter creates a writer. Close must be called to complete the
+// FeatureCollection.
+func NewWriter[K feature.Key, F feature.Feature[K]](w io.Writer, options WriteOptions[F]) *Writer[K, F] {
+	return &Writer[K, F]{w: bufio.NewWriter(w), options: options}
+}
+
+// Write appends a feature to the collection.
+func (writer *Writer[K, F]) Write(f F) error {
+	if writer.closed {
+		return ErrWriterClosed
+	}
+	bounds := f.Bounds()
+	out := outFeature{
+		Type: "Feature",
+		ID:   f.Key().String(),
+		BBox: []float64{bounds.Min[0], bounds.Min[1], bounds.Max[0], bounds.Max[1]},
+	}
+	var err error
+	if geometer, ok := any(f).(Geometer); ok {
+		out.Geometry, err = encodeGeometry(geometer.Geometry())
+	} else {
+		out.Geometry, err = encodeGeometry(geometry.FromRect(*bounds))
+	}
+	if err != nil {
+		return fmt.Errorf("Feature %q: %w", out.ID, err)
+	}
+	if writer.options.Properties != nil {
+		out.Properties = writer.options.Properties(f)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if writer.n == 0 {
+		_, err = writer.w.WriteString(`{"type":"FeatureCollection","features":[` + "\n")
+	} else {
+		_, err = writer.w.WriteString(",\n")
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := writer.w.Write(data); err != nil {
+		return err
+	}
+	writer.n++
+	return nil
+}
+
+// Close completes the collection and flushes it to the underlying writer. It
+// doesn't close the underlying writer.
+func (writer *Writer[K, F]) Close() error {
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+	var err error
+	if writer.n == 0 {
+		_, err = writer.w.WriteString(`{"type":"FeatureCollection","features":[]}` + "\n")
+	} else {
+		_, err = writer.w.WriteString("\n]}\n")
+	}
+	if err != nil {
+		return err
+	}
+	return writer.w.Flush()
+}
+
+// Export writes all features in an index as a FeatureCollection.
+func Export[K feature.Key, F feature.Feature[K]](w io.Writer, idx *index.Index[K, F], options WriteOptions[F]) error {
+	writer := NewWriter[K](w, options)
+	if err := idx.Each(writer.Write); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// ExportFeatures writes features, e.g. results of Index.Query, as a
+// FeatureCollection.
+func ExportFeatures[K feature.Key, F feature.Feature[K]](w io.Writer, features []F, options WriteOptions[F]) error {
+	writer := NewWriter[K](w, options)
+	for _, f := range features {
+		if err := writer.Write(f); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func encodeGeometry(g geometry.Geometry) (*outGeometry, error) {
+	switch g := g.(type) {
+	case geometry.Point:
+		return &outGeometry{"Point", g}, nil
+	case geometry.MultiPoint:
+		return &outGeometry{"MultiPoint", g}, nil
+	case geometry.LineString:
+		return &outGeometry{"LineString", g}, nil
+	case geometry.MultiLineString:
+		return &outGeometry{"MultiLineString", g}, nil
+	case geometry.Polygon:
+		return &outGeometry{"Polygon", encodePolygon(g)}, nil
+	case geometry.MultiPolygon:
+		coords := make([][][][2]float64, len(g))
+		for i, polygon := range g {
+			coords[i] = encodePolygon(polygon)
+		}
+		return &outGeometry{"MultiPolygon", coords}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported geometry %T", g)
+	}
+}
+
+func encodePolygon(polygon geometry.Polygon) [][][2]float64 {
+	coords := make([][][2]float64, len(polygon))
+	for i, ring := range polygon {
+		coords[i] = encodeRing(ring)
+	}
+	return coords
+}
+
+// encodeRing closes the ring as GeoJSON requires.
+func encodeRing(ring geometry.Ring) [][2]float64 {
+	coords := make([][2]float64, len(ring), len(ring)+1)
+	copy(coords, ring)
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		coords = append(coords, ring[0])
+	}
+	return coords
+}
@@ -0,0 +1,97 @@
+package geojson_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/testutil"
+)
+
+// ParkingID uniquely identifies a parking lot.
+type ParkingID string
+
+func (id ParkingID) String() string {
+	return string(id)
+}
+
+// Parking is an example feature without a geometry.
+type Parking struct {
+	ID       ParkingID
+	Capacity int
+	Area     primitives.Rect
+}
+
+func (p *Parking) Bounds() *primitives.Rect {
+	return &p.Area
+}
+
+func (p *Parking) Contains(point primitives.Point) (bool, error) {
+	return testutil.Contains(p.Area, point), nil
+}
+
+func (p *Parking) Key() ParkingID {
+	return p.ID
+}
+
+func ExampleExportFeatures() {
+	parkings := []*Parking{
+		{"p1", 100, primitives.Rect{Min: primitives.Point{0, 0}, Max: primitives.Point{1, 2}}},
+	}
+	_ = geojson.ExportFeatures(os.Stdout, parkings, geojson.WriteOptions[*Parking]{
+		Properties: func(p *Parking) map[string]any {
+			return map[string]any{"capacity": p.Capacity}
+		},
+	})
+	// Output:
+	// {"type":"FeatureCollection","features":[
+	// {"type":"Feature","id":"p1","bbox":[0,0,1,2],"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,2],[0,2],[0,0]]]},"properties":{"capacity":100}}
+	// ]}
+}
+
+func TestExport_roundTrip(t *testing.T) {
+	idx, _, err := geojson.Load(bytes.NewBufferString(cities), geojson.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = geojson.Export(&buf, idx, geojson.WriteOptions[*geometry.Feature]{
+		Properties: geojson.SelectProperties("name"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, invalid, err := geojson.Load(&buf, geojson.Options{})
+	if err != nil || len(invalid) != 0 {
+		t.Fatal(err, invalid)
+	}
+	if exported.Size() != 2 {
+		t.Fatalf("Expected 2 features, got %d", exported.Size())
+	}
+	for _, point := range []primitives.Point{{1, 1}, {5, 5}, {5.2, 5.2}, {25, 22}, {25, 28}} {
+		expected, _ := idx.FindContaining(point)
+		actual, _ := exported.FindContaining(point)
+		if len(expected) != len(actual) || (len(actual) > 0 && actual[0].ID != expected[0].ID) {
+			t.Errorf("Results for %v differ: %v vs %v", point, expected, actual)
+		}
+	}
+	doughnut, _ := exported.Lookup("doughnut")
+	if len(doughnut[0].Properties) != 1 || doughnut[0].Properties["name"] != "Doughnut" {
+		t.Errorf("Unexpected properties: %v", doughnut[0].Properties)
+	}
+}
+
+func TestExport_empty(t *testing.T) {
+	idx, _ := index.New[ParkingID]([]*Parking{})
+	var buf bytes.Buffer
+	if err := geojson.Export(&buf, idx, geojson.WriteOptions[*Parking]{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != `{"type":"FeatureCollection","features":[]}`+"\n" {
+		t.Fatalf("Unexpected output: %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package geometry
+
+import (
+	"github.com/bilus/fencer/primitives"
+)
+
+// ID uniquely identifies a Feature.
+type ID string
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// Feature is a general-purpose spatial feature with a geometry and a bag of
+// properties. It implements feature.Feature[ID].
+type Feature struct {
+	ID         ID
+	Properties map[string]any
+	geometry   Geometry
+	bounds     primitives.Rect
+}
+
+// NewFeature creates a feature. The geometry must not be modified afterwards.
+func NewFeature(id ID, geometry Geometry, properties map[string]any) *Feature {
+	return &Feature{
+		ID:         id,
+		Properties: properties,
+		geometry:   geometry,
+		bounds:     geometry.Bounds(),
+	}
+}
+
+// Geometry returns the feature's geometry.
+func (f *Feature) Geometry() Geometry {
+	return f.geometry
+}
+
+// Bounds returns the bounding rectangle of the feature's geometry.
+func (f *Feature) Bounds() *primitives.Rect {
+	return &f.bounds
+}
+
+// Contains returns true if the point lies within the feature's geometry.
+func (f *Feature) Contains(point primitives.Point) (bool, error) {
+	return f.geometry.Contains(point), nil
+}
+
+// ClosestPoint returns the point of the feature's geometry closest to point.
+func (f *Feature) ClosestPoint(point primitives.Point) primitives.Point {
+	return ClosestPoint(f.geometry, point)
+}
+
+func (f *Feature) Key() ID {
+	return f.ID
+}
@@ -0,0 +1,221 @@
+// Package geometry contains a geometry model for spatial features and a
+// ready-made feature type built on top of it.
+package geometry
+
+import (
+	"math"
+
+	"github.com/bilus/fencer/primitives"
+)
+
+// Geometry is a shape with a bounding rectangle and a point-in-shape test.
+type Geometry interface {
+	Bounds() primitives.Rect
+	Contains(point primitives.Point) bool
+}
+
+// Geometer is implemented by features exposing their geometry.
+type Geometer interface {
+	Geometry() Geometry
+}
+
+// Point is a single location.
+type Point primitives.Point
+
+// LineString is a sequence of points connected by straight segments.
+type LineString []primitives.Point
+
+// MultiPoint is a list of points.
+type MultiPoint []primitives.Point
+
+// MultiLineString is a list of line strings.
+type MultiLineString []LineString
+
+// Ring is a closed sequence of points. The last point may, but doesn't have
+// to, repeat the first one.
+type Ring []primitives.Point
+
+// Polygon is a list of rings; the first ring is the exterior, the remaining
+// ones are holes.
+type Polygon []Ring
+
+// MultiPolygon is a list of polygons.
+type MultiPolygon []Polygon
+
+// Bounds returns a degenerate rectangle at the point.
+func (p Point) Bounds() primitives.Rect {
+	return primitives.Rect{Min: primitives.Point(p), Max: primitives.Point(p)}
+}
+
+// Contains returns true if the points are equal.
+func (p Point) Contains(point primitives.Point) bool {
+	return primitives.Point(p) == point
+}
+
+// Bounds returns the bounding rectangle of the line string.
+func (line LineString) Bounds() primitives.Rect {
+	return boundsOf(line)
+}
+
+// Contains returns true if the point lies on the line string.
+func (line LineString) Contains(point primitives.Point) bool {
+	if len(line) == 1 {
+		return line[0] == point
+	}
+	for i := 1; i < len(line); i++ {
+		if onSegment(line[i-1], line[i], point) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the bounding rectangle of all points.
+func (multi MultiPoint) Bounds() primitives.Rect {
+	return boundsOf(multi)
+}
+
+// Contains returns true if the point is one of the points.
+func (multi MultiPoint) Contains(point primitives.Point) bool {
+	for _, p := range multi {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the bounding rectangle of all line strings.
+func (multi MultiLineString) Bounds() primitives.Rect {
+	bounds := emptyBounds()
+	for _, line := range multi {
+		if len(line) > 0 {
+			bounds = union(bounds, line.Bounds())
+		}
+	}
+	return orZero(bounds)
+}
+
+// Contains returns true if the point lies on any of the line strings.
+func (multi MultiLineString) Contains(point primitives.Point) bool {
+	for _, line := range multi {
+		if line.Contains(point) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the bounding rectangle of the ring.
+func (ring Ring) Bounds() primitives.Rect {
+	return boundsOf(ring)
+}
+
+// Contains returns true if the point lies inside the ring.
+func (ring Ring) Contains(point primitives.Point) bool {
+	inside := false
+	n := len(ring)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		pi, pj := ring[i], ring[j]
+		if (pi[1] > point[1]) != (pj[1] > point[1]) &&
+			point[0] < (pj[0]-pi[0])*(point[1]-pi[1])/(pj[1]-pi[1])+pi[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Bounds returns the bounding rectangle of the polygon's exterior.
+func (polygon Polygon) Bounds() primitives.Rect {
+	if len(polygon) == 0 {
+		return primitives.Rect{}
+	}
+	return polygon[0].Bounds()
+}
+
+// Contains returns true if the point lies inside the exterior and outside all
+// holes.
+func (polygon Polygon) Contains(point primitives.Point) bool {
+	if len(polygon) == 0 || !polygon[0].Contains(point) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if hole.Contains(point) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds returns the bounding rectangle of all polygons.
+func (multi MultiPolygon) Bounds() primitives.Rect {
+	bounds := emptyBounds()
+	for _, polygon := range multi {
+		if len(polygon) > 0 {
+			bounds = union(bounds, polygon.Bounds())
+		}
+	}
+	return orZero(bounds)
+}
+
+// Contains returns true if the point lies inside any of the polygons.
+func (multi MultiPolygon) Contains(point primitives.Point) bool {
+	for _, polygon := range multi {
+		if polygon.Contains(point) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRect returns a polygon covering a rectangle.
+func FromRect(rect primitives.Rect) Polygon {
+	return Polygon{{
+		rect.Min,
+		{rect.Max[0], rect.Min[1]},
+		rect.Max,
+		{rect.Min[0], rect.Max[1]},
+		rect.Min,
+	}}
+}
+
+// onSegment returns true if p lies on the segment between a and b, allowing
+// for floating point error.
+func onSegment(a, b, p primitives.Point) bool {
+	if p[0] < math.Min(a[0], b[0]) || p[0] > math.Max(a[0], b[0]) ||
+		p[1] < math.Min(a[1], b[1]) || p[1] > math.Max(a[1], b[1]) {
+		return false
+	}
+	cross := (b[0]-a[0])*(p[1]-a[1]) - (b[1]-a[1])*(p[0]-a[0])
+	length := math.Hypot(b[0]-a[0], b[1]-a[1])
+	return math.Abs(cross) <= 1e-9*math.Max(length, 1)
+}
+
+func boundsOf(points []primitives.Point) primitives.Rect {
+	bounds := emptyBounds()
+	for _, p := range points {
+		bounds = union(bounds, primitives.Rect{Min: p, Max: p})
+	}
+	return orZero(bounds)
+}
+
+func emptyBounds() primitives.Rect {
+	return primitives.Rect{
+		Min: primitives.Point{math.Inf(1), math.Inf(1)},
+		Max: primitives.Point{math.Inf(-1), math.Inf(-1)},
+	}
+}
+
+func orZero(bounds primitives.Rect) primitives.Rect {
+	if bounds.Min[0] > bounds.Max[0] {
+		return primitives.Rect{}
+	}
+	return bounds
+}
+
+func union(r1, r2 primitives.Rect) primitives.Rect {
+	return primitives.Rect{
+		Min: primitives.Point{math.Min(r1.Min[0], r2.Min[0]), math.Min(r1.Min[1], r2.Min[1])},
+		Max: primitives.Point{math.Max(r1.Max[0], r2.Max[0]), math.Max(r1.Max[1], r2.Max[1])},
+	}
+}
@@ -0,0 +1,92 @@
+package geometry_test
+
+import (
+	"fmt"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+func ExamplePolygon_Contains() {
+	square := geometry.Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		// A hole.
+		{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+	}
+	fmt.Println(square.Contains(primitives.Point{2, 2}))
+	fmt.Println(square.Contains(primitives.Point{5, 5}))
+	fmt.Println(square.Contains(primitives.Point{12, 5}))
+	// Output:
+	// true
+	// false
+	// false
+}
+
+func ExampleMultiPolygon_Bounds() {
+	islands := geometry.MultiPolygon{
+		{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+		{{{5, 5}, {6, 5}, {6, 7}, {5, 5}}},
+	}
+	fmt.Println(islands.Bounds())
+	// Output: {[0 0] [6 7]}
+}
+
+func ExampleFeature() {
+	f := geometry.NewFeature("square", geometry.Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+	}, map[string]any{"name": "Square"})
+	contains, _ := f.Contains(primitives.Point{5, 5})
+	fmt.Println(f.Key(), *f.Bounds(), contains)
+	// Output: square {[0 0] [10 10]} true
+}
+
+func ExampleContainsRect() {
+	square := geometry.Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+	}
+	inside := primitives.Rect{Min: primitives.Point{1, 1}, Max: primitives.Point{3, 3}}
+	overHole := primitives.Rect{Min: primitives.Point{3, 3}, Max: primitives.Point{7, 7}}
+	inHole := primitives.Rect{Min: primitives.Point{4.5, 4.5}, Max: primitives.Point{5.5, 5.5}}
+	fmt.Println(geometry.ContainsRect(square, inside), geometry.IntersectsRect(square, inside))
+	fmt.Println(geometry.ContainsRect(square, overHole), geometry.IntersectsRect(square, overHole))
+	fmt.Println(geometry.ContainsRect(square, inHole), geometry.IntersectsRect(square, inHole))
+	// Output:
+	// true true
+	// false true
+	// false false
+}
+
+func ExampleIntersectsRect() {
+	line := geometry.LineString{{0, 0}, {10, 10}}
+	fmt.Println(geometry.IntersectsRect(line, primitives.Rect{Min: primitives.Point{4, 5}, Max: primitives.Point{6, 6}}))
+	fmt.Println(geometry.IntersectsRect(line, primitives.Rect{Min: primitives.Point{6, 0}, Max: primitives.Point{9, 3}}))
+	// Output:
+	// true
+	// false
+}
+
+func ExampleIntersects() {
+	square := geometry.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}
+	// Crosses the square without a vertex inside.
+	fmt.Println(geometry.Intersects(geometry.LineString{{-1, 5}, {11, 5}}, square))
+	fmt.Println(geometry.Intersects(geometry.LineString{{-1, 11}, {11, 11}}, square))
+	fmt.Println(geometry.Intersects(geometry.Point{5, 5}, square))
+	// Output:
+	// true
+	// false
+	// true
+}
+
+func ExampleWithin() {
+	// A U-shaped polygon.
+	u := geometry.Polygon{{{0, 0}, {10, 0}, {10, 10}, {7, 10}, {7, 3}, {3, 3}, {3, 10}, {0, 10}, {0, 0}}}
+	fmt.Println(geometry.Within(geometry.Point{1, 8}, u))
+	fmt.Println(geometry.Within(geometry.LineString{{1, 1}, {9, 1}}, u))
+	// Both ends lie in the U's arms, but the line crosses the gap between them.
+	fmt.Println(geometry.Within(geometry.LineString{{1, 8}, {9, 8}}, u))
+	// Output:
+	// true
+	// true
+	// false
+}
@@ -0,0 +1,174 @@
+package geometry
+
+import (
+	"github.com/bilus/fencer/primitives"
+)
+
+// IntersectsRect returns true if a geometry and a rectangle share any point.
+// Geometries of other types than those in this package are approximated by
+// their bounding rectangles.
+func IntersectsRect(g Geometry, rect primitives.Rect) bool {
+	switch g := g.(type) {
+	case Point:
+		return inRect(primitives.Point(g), rect)
+	case MultiPoint:
+		for _, p := range g {
+			if inRect(p, rect) {
+				return true
+			}
+		}
+		return false
+	case LineString:
+		return pathIntersectsRect(g, rect)
+	case MultiLineString:
+		for _, line := range g {
+			if pathIntersectsRect(line, rect) {
+				return true
+			}
+		}
+		return false
+	case Ring:
+		return Polygon{g}.intersectsRect(rect)
+	case Polygon:
+		return g.intersectsRect(rect)
+	case MultiPolygon:
+		for _, polygon := range g {
+			if polygon.intersectsRect(rect) {
+				return true
+			}
+		}
+		return false
+	default:
+		return rectsIntersect(g.Bounds(), rect)
+	}
+}
+
+// ContainsRect returns true if a rectangle lies entirely within a geometry.
+// The test is conservative: it may return false for rectangles touching the
+// geometry's edges from inside or spanning several polygons of a
+// MultiPolygon. Points and lines never contain a rectangle; geometries of
+// other types than those in this package are tested at the rectangle's
+// corners.
+func ContainsRect(g Geometry, rect primitives.Rect) bool {
+	switch g := g.(type) {
+	case Point, MultiPoint, LineString, MultiLineString:
+		return false
+	case Ring:
+		return Polygon{g}.containsRect(rect)
+	case Polygon:
+		return g.containsRect(rect)
+	case MultiPolygon:
+		for _, polygon := range g {
+			if polygon.containsRect(rect) {
+				return true
+			}
+		}
+		return false
+	default:
+		for _, corner := range corners(rect) {
+			if !g.Contains(corner) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (polygon Polygon) intersectsRect(rect primitives.Rect) bool {
+	if len(polygon) == 0 || !rectsIntersect(polygon.Bounds(), rect) {
+		return false
+	}
+	// Either an edge crosses the rectangle or one contains the other.
+	if polygon.edgeIntersectsRect(rect) {
+		return true
+	}
+	return polygon.Contains(rect.Min)
+}
+
+func (polygon Polygon) containsRect(rect primitives.Rect) bool {
+	for _, corner := range corners(rect) {
+		if !polygon.Contains(corner) {
+			return false
+		}
+	}
+	return !polygon.edgeIntersectsRect(rect)
+}
+
+func (polygon Polygon) edgeIntersectsRect(rect primitives.Rect) bool {
+	for _, ring := range polygon {
+		n := len(ring)
+		for i := 0; i < n; i++ {
+			if segmentIntersectsRect(ring[i], ring[(i+1)%n], rect) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pathIntersectsRect(path []primitives.Point, rect primitives.Rect) bool {
+	if len(path) == 1 {
+		return inRect(path[0], rect)
+	}
+	for i := 1; i < len(path); i++ {
+		if segmentIntersectsRect(path[i-1], path[i], rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentIntersectsRect clips segment ab to the rectangle (Liang-Barsky).
+func segmentIntersectsRect(a, b primitives.Point, rect primitives.Rect) bool {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, edge := range [4][2]float64{
+		{-dx, a[0] - rect.Min[0]},
+		{dx, rect.Max[0] - a[0]},
+		{-dy, a[1] - rect.Min[1]},
+		{dy, rect.Max[1] - a[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			if t > t0 {
+				t0 = t
+			}
+		} else {
+			if t < t0 {
+				return false
+			}
+			if t < t1 {
+				t1 = t
+			}
+		}
+	}
+	return true
+}
+
+func inRect(p primitives.Point, rect primitives.Rect) bool {
+	return p[0] >= rect.Min[0] && p[0] <= rect.Max[0] && p[1] >= rect.Min[1] && p[1] <= rect.Max[1]
+}
+
+func rectsIntersect(r1, r2 primitives.Rect) bool {
+	return r1.Min[0] <= r2.Max[0] && r1.Max[0] >= r2.Min[0] &&
+		r1.Min[1] <= r2.Max[1] && r1.Max[1] >= r2.Min[1]
+}
+
+func corners(rect primitives.Rect) [4]primitives.Point {
+	return [4]primitives.Point{
+		rect.Min,
+		{rect.Max[0], rect.Min[1]},
+		rect.Max,
+		{rect.Min[0], rect.Max[1]},
+	}
+}
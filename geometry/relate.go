@@ -0,0 +1,225 @@
+package geometry
+
+import (
+	"math"
+
+	"github.com/bilus/fencer/primitives"
+)
+
+// Intersects returns true if two geometries share any point. Geometries of
+// other types than those in this package are approximated by their bounding
+// rectangles.
+func Intersects(a, b Geometry) bool {
+	if !rectsIntersect(a.Bounds(), b.Bounds()) {
+		return false
+	}
+	pathsA, okA := paths(a)
+	pathsB, okB := paths(b)
+	if !okA || !okB {
+		if !okA && !okB {
+			return true
+		}
+		if !okA {
+			return IntersectsRect(b, a.Bounds())
+		}
+		return IntersectsRect(a, b.Bounds())
+	}
+	for _, path := range pathsA {
+		for _, p := range path.points {
+			if b.Contains(p) {
+				return true
+			}
+		}
+	}
+	for _, path := range pathsB {
+		for _, p := range path.points {
+			if a.Contains(p) {
+				return true
+			}
+		}
+	}
+	return pathsCross(pathsA, pathsB, segmentsIntersect)
+}
+
+// Within returns true if a lies entirely within b: all of a's vertices lie in
+// b and none of a's edges crosses b's boundary. A polygon enclosing a hole of
+// b without touching it is reported as within b. Geometries of other types
+// than those in this package are approximated by their bounding rectangles.
+func Within(a, b Geometry) bool {
+	bounds, outer := a.Bounds(), b.Bounds()
+	if bounds.Min[0] < outer.Min[0] || bounds.Min[1] < outer.Min[1] ||
+		bounds.Max[0] > outer.Max[0] || bounds.Max[1] > outer.Max[1] {
+		return false
+	}
+	pathsA, okA := paths(a)
+	if !okA {
+		return ContainsRect(b, bounds)
+	}
+	for _, path := range pathsA {
+		for _, p := range path.points {
+			if !b.Contains(p) {
+				return false
+			}
+		}
+	}
+	pathsB, okB := paths(b)
+	if !okB {
+		return true
+	}
+	return !pathsCross(pathsA, pathsB, segmentsCross)
+}
+
+// ClosestPoint returns the point of a geometry closest to p in the plane, p
+// itself if the geometry contains it. Geometries of other types than those in
+// this package are approximated by their bounding rectangles.
+func ClosestPoint(g Geometry, p primitives.Point) primitives.Point {
+	if g.Contains(p) {
+		return p
+	}
+	ps, ok := paths(g)
+	if !ok {
+		bounds := g.Bounds()
+		return primitives.Point{
+			math.Max(bounds.Min[0], math.Min(bounds.Max[0], p[0])),
+			math.Max(bounds.Min[1], math.Min(bounds.Max[1], p[1])),
+		}
+	}
+	closest, best := p, math.Inf(1)
+	consider := func(c primitives.Point) {
+		if d := math.Hypot(c[0]-p[0], c[1]-p[1]); d < best {
+			closest, best = c, d
+		}
+	}
+	for _, path := range ps {
+		if len(path.points) == 1 {
+			consider(path.points[0])
+			continue
+		}
+		path.segments(func(a, b primitives.Point) bool {
+			consider(closestOnSegment(a, b, p))
+			return false
+		})
+	}
+	return closest
+}
+
+func closestOnSegment(a, b, p primitives.Point) primitives.Point {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return a
+	}
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return primitives.Point{a[0] + t*dx, a[1] + t*dy}
+}
+
+// path is a sequence of vertices; closed paths have an edge from the last
+// vertex back to the first.
+type path struct {
+	points []primitives.Point
+	closed bool
+}
+
+func (p path) segments(fn func(a, b primitives.Point) bool) bool {
+	n := len(p.points)
+	for i := 1; i < n; i++ {
+		if fn(p.points[i-1], p.points[i]) {
+			return true
+		}
+	}
+	if p.closed && n > 2 {
+		return fn(p.points[n-1], p.points[0])
+	}
+	return false
+}
+
+// paths returns the vertices and edges of a geometry or false if its type is
+// unknown.
+func paths(g Geometry) ([]path, bool) {
+	switch g := g.(type) {
+	case Point:
+		return []path{{points: []primitives.Point{primitives.Point(g)}}}, true
+	case MultiPoint:
+		out := make([]path, len(g))
+		for i, p := range g {
+			out[i] = path{points: []primitives.Point{p}}
+		}
+		return out, true
+	case LineString:
+		return []path{{points: g}}, true
+	case MultiLineString:
+		out := make([]path, len(g))
+		for i, line := range g {
+			out[i] = path{points: line}
+		}
+		return out, true
+	case Ring:
+		return []path{{points: g, closed: true}}, true
+	case Polygon:
+		return polygonPaths(nil, g), true
+	case MultiPolygon:
+		var out []path
+		for _, polygon := range g {
+			out = polygonPaths(out, polygon)
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+func polygonPaths(out []path, polygon Polygon) []path {
+	for _, ring := range polygon {
+		out = append(out, path{points: ring, closed: true})
+	}
+	return out
+}
+
+// pathsCross returns true if test holds for any pair of edges.
+func pathsCross(pathsA, pathsB []path, test func(a1, a2, b1, b2 primitives.Point) bool) bool {
+	for _, pa := range pathsA {
+		crossed := pa.segments(func(a1, a2 primitives.Point) bool {
+			for _, pb := range pathsB {
+				if pb.segments(func(b1, b2 primitives.Point) bool { return test(a1, a2, b1, b2) }) {
+					return true
+				}
+			}
+			return false
+		})
+		if crossed {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentsIntersect returns true if segments a and b share any point.
+func segmentsIntersect(a1, a2, b1, b2 primitives.Point) bool {
+	d1, d2 := orientation(b1, b2, a1), orientation(b1, b2, a2)
+	d3, d4 := orientation(a1, a2, b1), orientation(a1, a2, b2)
+	if d1*d2 < 0 && d3*d4 < 0 {
+		return true
+	}
+	return d1 == 0 && onSegment(b1, b2, a1) || d2 == 0 && onSegment(b1, b2, a2) ||
+		d3 == 0 && onSegment(a1, a2, b1) || d4 == 0 && onSegment(a1, a2, b2)
+}
+
+// segmentsCross returns true if segments a and b intersect at a single point
+// inside both of them.
+func segmentsCross(a1, a2, b1, b2 primitives.Point) bool {
+	return orientation(b1, b2, a1)*orientation(b1, b2, a2) < 0 &&
+		orientation(a1, a2, b1)*orientation(a1, a2, b2) < 0
+}
+
+// orientation returns the sign of the cross product of ab and ac.
+func orientation(a, b, c primitives.Point) float64 {
+	cross := (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	switch {
+	case cross > 0:
+		return 1
+	case cross < 0:
+		return -1
+	default:
+		return 0
+	}
+}
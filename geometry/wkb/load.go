@@ -0,0 +1,47 @@
+package wkb
+
+import (
+	"fmt"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+)
+
+// Rows is a cursor over (id, wkb) rows; *sql.Rows satisfies it.
+type Rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// NewFeature decodes a WKB or EWKB geometry and wraps it in a feature.
+func NewFeature(id geometry.ID, data []byte, properties map[string]any) (*geometry.Feature, error) {
+	g, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return geometry.NewFeature(id, g, properties), nil
+}
+
+// LoadRows builds an index from rows of two columns: a string id and a WKB or
+// EWKB geometry. It stops at the first invalid row. The caller remains
+// responsible for closing the rows.
+func LoadRows(rows Rows) (*index.Index[geometry.ID, *geometry.Feature], error) {
+	var features []*geometry.Feature
+	for n := 0; rows.Next(); n++ {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("Row %d: %w", n, err)
+		}
+		f, err := NewFeature(geometry.ID(id), data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Row %d (id %q): %w", n, id, err)
+		}
+		features = append(features, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return index.New[geometry.ID](features)
+}
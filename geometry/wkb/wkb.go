@@ -0,0 +1,317 @@
+// Package wkb encodes and decodes geometries in the Well-Known Binary format
+// and its PostGIS extension, EWKB, which can carry an SRID.
+//
+// Z and M ordinates, in both ISO and EWKB flavours, are accepted but discarded
+// when decoding. Empty geometries and geometry collections are not supported.
+package wkb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+const (
+	wkbPoint           = 1
+	wkbLineString      = 2
+	wkbPolygon         = 3
+	wkbMultiPoint      = 4
+	wkbMultiLineString = 5
+	wkbMultiPolygon    = 6
+
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+
+	xdr = 0 // Big endian.
+	ndr = 1 // Little endian.
+)
+
+// ErrTruncated is returned when the input ends prematurely.
+var ErrTruncated = errors.New("WKB truncated")
+
+// Marshal returns the little-endian WKB representation of a geometry.
+func Marshal(g geometry.Geometry) ([]byte, error) {
+	return marshal(nil, g, 0, binary.LittleEndian)
+}
+
+// MarshalEWKB returns the little-endian EWKB representation of a geometry
+// with an SRID. SRID 0 means no SRID.
+func MarshalEWKB(g geometry.Geometry, srid int) ([]byte, error) {
+	return marshal(nil, g, srid, binary.LittleEndian)
+}
+
+// Unmarshal decodes a WKB or EWKB geometry, ignoring its SRID.
+func Unmarshal(data []byte) (geometry.Geometry, error) {
+	g, _, err := UnmarshalEWKB(data)
+	return g, err
+}
+
+// UnmarshalEWKB decodes a WKB or EWKB geometry, returning its SRID or 0 if it
+// has none.
+func UnmarshalEWKB(data []byte) (geometry.Geometry, int, error) {
+	d := decoder{data: data}
+	g, srid, err := d.geometry(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.pos != len(data) {
+		return nil, 0, fmt.Errorf("Unexpected %d bytes after geometry", len(data)-d.pos)
+	}
+	return g, srid, nil
+}
+
+func marshal(buf []byte, g geometry.Geometry, srid int, order binary.ByteOrder) ([]byte, error) {
+	header := func(geomType uint32) {
+		buf = append(buf, ndr)
+		if srid != 0 {
+			buf = appendUint32(buf, order, geomType|ewkbSRID)
+			buf = appendUint32(buf, order, uint32(srid))
+		} else {
+			buf = appendUint32(buf, order, geomType)
+		}
+	}
+	switch g := g.(type) {
+	case geometry.Point:
+		header(wkbPoint)
+		buf = appendPoint(buf, order, primitives.Point(g))
+	case geometry.LineString:
+		header(wkbLineString)
+		buf = appendPoints(buf, order, g)
+	case geometry.Polygon:
+		header(wkbPolygon)
+		buf = appendPolygon(buf, order, g)
+	case geometry.MultiPoint:
+		header(wkbMultiPoint)
+		buf = appendUint32(buf, order, uint32(len(g)))
+		for _, p := range g {
+			buf, _ = marshal(buf, geometry.Point(p), 0, order)
+		}
+	case geometry.MultiLineString:
+		header(wkbMultiLineString)
+		buf = appendUint32(buf, order, uint32(len(g)))
+		for _, line := range g {
+			buf, _ = marshal(buf, line, 0, order)
+		}
+	case geometry.MultiPolygon:
+		header(wkbMultiPolygon)
+		buf = appendUint32(buf, order, uint32(len(g)))
+		for _, polygon := range g {
+			buf, _ = marshal(buf, polygon, 0, order)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported geometry %T", g)
+	}
+	return buf, nil
+}
+
+func appendPolygon(buf []byte, order binary.ByteOrder, polygon geometry.Polygon) []byte {
+	buf = appendUint32(buf, order, uint32(len(polygon)))
+	for _, ring := range polygon {
+		// WKB rings must be closed.
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			ring = append(ring[:len(ring):len(ring)], ring[0])
+		}
+		buf = appendPoints(buf, order, ring)
+	}
+	return buf
+}
+
+func appendPoints(buf []byte, order binary.ByteOrder, points []primitives.Point) []byte {
+	buf = appendUint32(buf, order, uint32(len(points)))
+	for _, p := range points {
+		buf = appendPoint(buf, order, p)
+	}
+	return buf
+}
+
+func appendPoint(buf []byte, order binary.ByteOrder, p primitives.Point) []byte {
+	buf = appendUint64(buf, order, math.Float64bits(p[0]))
+	return appendUint64(buf, order, math.Float64bits(p[1]))
+}
+
+func appendUint32(buf []byte, order binary.ByteOrder, v uint32) []byte {
+	var b [4]byte
+	order.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, order binary.ByteOrder, v uint64) []byte {
+	var b [8]byte
+	order.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	dims  int
+}
+
+// geometry decodes a geometry; expected is the type required for members of
+// multi-geometries or 0.
+func (d *decoder) geometry(expected uint32) (geometry.Geometry, int, error) {
+	start := d.pos
+	if d.pos >= len(d.data) {
+		return nil, 0, ErrTruncated
+	}
+	switch d.data[d.pos] {
+	case xdr:
+		d.order = binary.BigEndian
+	case ndr:
+		d.order = binary.LittleEndian
+	default:
+		return nil, 0, fmt.Errorf("Invalid byte order %d at offset %d", d.data[d.pos], d.pos)
+	}
+	d.pos++
+	geomType, err := d.uint32()
+	if err != nil {
+		return nil, 0, err
+	}
+	var srid int
+	if geomType&ewkbSRID != 0 {
+		s, err := d.uint32()
+		if err != nil {
+			return nil, 0, err
+		}
+		srid = int(int32(s))
+	}
+	d.dims = 2
+	if geomType&ewkbZ != 0 {
+		d.dims++
+	}
+	if geomType&ewkbM != 0 {
+		d.dims++
+	}
+	geomType &^= ewkbZ | ewkbM | ewkbSRID
+	// ISO WKB: 1000s for Z, 2000s for M, 3000s for ZM.
+	switch geomType / 1000 {
+	case 1, 2:
+		d.dims = 3
+	case 3:
+		d.dims = 4
+	}
+	geomType %= 1000
+	if expected != 0 && geomType != expected {
+		return nil, 0, fmt.Errorf("Expected geometry type %d at offset %d, got %d", expected, start, geomType)
+	}
+
+	switch geomType {
+	case wkbPoint:
+		p, err := d.point()
+		if err == nil && (math.IsNaN(p[0]) || math.IsNaN(p[1])) {
+			err = errors.New("Empty points are not supported")
+		}
+		return geometry.Point(p), srid, err
+	case wkbLineString:
+		points, err := d.points()
+		return geometry.LineString(points), srid, err
+	case wkbPolygon:
+		polygon, err := d.polygon()
+		return polygon, srid, err
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon:
+		n, err := d.count(5)
+		if err != nil {
+			return nil, 0, err
+		}
+		members := make([]geometry.Geometry, n)
+		for i := range members {
+			if members[i], _, err = d.geometry(geomType - 3); err != nil {
+				return nil, 0, err
+			}
+		}
+		return collect(geomType, members), srid, nil
+	default:
+		return nil, 0, fmt.Errorf("Unsupported geometry type %d at offset %d", geomType, start)
+	}
+}
+
+func collect(geomType uint32, members []geometry.Geometry) geometry.Geometry {
+	switch geomType {
+	case wkbMultiPoint:
+		multi := make(geometry.MultiPoint, len(members))
+		for i, m := range members {
+			multi[i] = primitives.Point(m.(geometry.Point))
+		}
+		return multi
+	case wkbMultiLineString:
+		multi := make(geometry.MultiLineString, len(members))
+		for i, m := range members {
+			multi[i] = m.(geometry.LineString)
+		}
+		return multi
+	default:
+		multi := make(geometry.MultiPolygon, len(members))
+		for i, m := range members {
+			multi[i] = m.(geometry.Polygon)
+		}
+		return multi
+	}
+}
+
+func (d *decoder) polygon() (geometry.Polygon, error) {
+	n, err := d.count(4)
+	if err != nil {
+		return nil, err
+	}
+	polygon := make(geometry.Polygon, n)
+	for i := range polygon {
+		if polygon[i], err = d.points(); err != nil {
+			return nil, err
+		}
+	}
+	return polygon, nil
+}
+
+func (d *decoder) points() ([]primitives.Point, error) {
+	n, err := d.count(8 * d.dims)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]primitives.Point, n)
+	for i := range points {
+		if points[i], err = d.point(); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+func (d *decoder) point() (primitives.Point, error) {
+	if len(d.data)-d.pos < 8*d.dims {
+		return primitives.Point{}, ErrTruncated
+	}
+	p := primitives.Point{
+		math.Float64frombits(d.order.Uint64(d.data[d.pos:])),
+		math.Float64frombits(d.order.Uint64(d.data[d.pos+8:])),
+	}
+	d.pos += 8 * d.dims
+	return p, nil
+}
+
+// count reads a number of elements, checking it against the remaining input
+// given each element takes at least minSize bytes.
+func (d *decoder) count(minSize int) (int, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(n)*uint64(minSize) > uint64(len(d.data)-d.pos) {
+		return 0, ErrTruncated
+	}
+	return int(n), nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if len(d.data)-d.pos < 4 {
+		return 0, ErrTruncated
+	}
+	v := d.order.Uint32(d.data[d.pos:])
+	d.pos += 4
+	return v, nil
+}
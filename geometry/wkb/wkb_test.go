@@ -0,0 +1,135 @@
+package wkb_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/geometry/wkb"
+	"github.com/bilus/fencer/primitives"
+)
+
+// rows mimics *sql.Rows.
+type rows struct {
+	ids  []string
+	data [][]byte
+	n    int
+}
+
+func (r *rows) Next() bool {
+	r.n++
+	return r.n <= len(r.ids)
+}
+
+func (r *rows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.ids[r.n-1]
+	*dest[1].(*[]byte) = r.data[r.n-1]
+	return nil
+}
+
+func (r *rows) Err() error {
+	return nil
+}
+
+func mustMarshal(g geometry.Geometry) []byte {
+	data, err := wkb.Marshal(g)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func ExampleLoadRows() {
+	idx, err := wkb.LoadRows(&rows{
+		ids: []string{"north", "south"},
+		data: [][]byte{
+			mustMarshal(geometry.Polygon{{{0, 10}, {10, 10}, {10, 20}, {0, 20}, {0, 10}}}),
+			mustMarshal(geometry.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	matches, err := idx.FindContaining(primitives.Point{5, 15})
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range matches {
+		fmt.Println(f.Key())
+	}
+	// Output: north
+}
+
+func ExampleUnmarshalEWKB() {
+	// SRID=4326;POINT(1 2), as returned by PostGIS.
+	data, _ := hex.DecodeString("0101000020E6100000000000000000F03F0000000000000040")
+	g, srid, err := wkb.UnmarshalEWKB(data)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(g, srid)
+	// Output: [1 2] 4326
+}
+
+func TestRoundTrip(t *testing.T) {
+	geometries := []geometry.Geometry{
+		geometry.Point{1, 2},
+		geometry.LineString{{0, 0}, {1, 1}, {2, 0}},
+		geometry.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}},
+		geometry.MultiPoint{{1, 2}, {3, 4}},
+		geometry.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}},
+		geometry.MultiPolygon{
+			{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}},
+			{{{10, 10}, {14, 10}, {14, 14}, {10, 10}}, {{11, 11}, {12, 11}, {12, 12}, {11, 11}}},
+		},
+	}
+	for _, want := range geometries {
+		for _, srid := range []int{0, 3857} {
+			data, err := wkb.MarshalEWKB(want, srid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, gotSRID, err := wkb.UnmarshalEWKB(data)
+			if err != nil {
+				t.Fatalf("%v: %v", want, err)
+			}
+			if !reflect.DeepEqual(got, want) || gotSRID != srid {
+				t.Errorf("got %v (SRID %d), want %v (SRID %d)", got, gotSRID, want, srid)
+			}
+		}
+	}
+}
+
+func TestUnmarshal_bigEndianZ(t *testing.T) {
+	// ISO WKB POINT Z (1 2 3) in big-endian byte order.
+	data, _ := hex.DecodeString("00000003E93FF000000000000040000000000000004008000000000000")
+	g, err := wkb.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != (geometry.Point{1, 2}) {
+		t.Errorf("got %v", g)
+	}
+}
+
+func TestUnmarshal_truncated(t *testing.T) {
+	data := mustMarshal(geometry.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}})
+	for n := 0; n < len(data); n++ {
+		if _, err := wkb.Unmarshal(data[:n]); !errors.Is(err, wkb.ErrTruncated) {
+			t.Errorf("%d bytes: got %v", n, err)
+		}
+	}
+}
+
+func TestLoadRows_invalidRow(t *testing.T) {
+	_, err := wkb.LoadRows(&rows{
+		ids:  []string{"ok", "bad"},
+		data: [][]byte{mustMarshal(geometry.Point{1, 2}), {0x01, 0x07}},
+	})
+	if err == nil || err.Error() != `Row 1 (id "bad"): WKB truncated` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Package wkt encodes and decodes geometries in the Well-Known Text format.
+//
+// Z and M ordinates are accepted but discarded when decoding. An EWKT SRID
+// prefix (e.g. "SRID=4326;POINT(1 2)") is accepted and ignored. Empty
+// geometries and geometry collections are not supported.
+package wkt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+// Marshal returns the WKT representation of a geometry.
+func Marshal(g geometry.Geometry) (string, error) {
+	var b strings.Builder
+	switch g := g.(type) {
+	case geometry.Point:
+		b.WriteString("POINT ")
+		writePoints(&b, []primitives.Point{primitives.Point(g)})
+	case geometry.LineString:
+		b.WriteString("LINESTRING ")
+		writePoints(&b, g)
+	case geometry.Polygon:
+		b.WriteString("POLYGON ")
+		writePolygon(&b, g)
+	case geometry.MultiPoint:
+		b.WriteString("MULTIPOINT (")
+		for i, p := range g {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePoints(&b, []primitives.Point{p})
+		}
+		b.WriteString(")")
+	case geometry.MultiLineString:
+		b.WriteString("MULTILINESTRING (")
+		for i, line := range g {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePoints(&b, line)
+		}
+		b.WriteString(")")
+	case geometry.MultiPolygon:
+		b.WriteString("MULTIPOLYGON (")
+		for i, polygon := range g {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePolygon(&b, polygon)
+		}
+		b.WriteString(")")
+	default:
+		return "", fmt.Errorf("Unsupported geometry %T", g)
+	}
+	return b.String(), nil
+}
+
+func writePolygon(b *strings.Builder, polygon geometry.Polygon) {
+	b.WriteString("(")
+	for i, ring := range polygon {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		// WKT rings must be closed.
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			ring = append(ring[:len(ring):len(ring)], ring[0])
+		}
+		writePoints(b, ring)
+	}
+	b.WriteString(")")
+}
+
+func writePoints(b *strings.Builder, points []primitives.Point) {
+	b.WriteString("(")
+	for i, p := range points {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+	}
+	b.WriteString(")")
+}
+
+// SyntaxError describes invalid WKT input.
+type SyntaxError struct {
+	Offset int // Byte offset of the error in the input.
+	Msg    string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("WKT syntax error at offset %d: %v", err.Offset, err.Msg)
+}
+
+// Unmarshal parses a WKT geometry.
+func Unmarshal(text string) (geometry.Geometry, error) {
+	p := parser{input: text}
+	if i := strings.IndexByte(text, ';'); i >= 0 && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(text)), "SRID=") {
+		p.pos = i + 1
+	}
+	g, err := p.geometry()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("Unexpected %q", p.input[p.pos:])
+	}
+	return g, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+	dims  int // Number of ordinates per point.
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *parser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			break
+		}
+		p.pos++
+	}
+	return strings.ToUpper(p.input[start:p.pos])
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != c {
+		return p.errorf("Expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) peek(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.input) && p.input[p.pos] == c
+}
+
+func (p *parser) geometry() (geometry.Geometry, error) {
+	start := p.pos
+	tag := p.word()
+	p.dims = 2
+	save := p.pos
+	switch p.word() {
+	case "Z", "M":
+		p.dims = 3
+	case "ZM":
+		p.dims = 4
+	case "EMPTY":
+		p.pos = start
+		return nil, p.errorf("Empty geometries are not supported")
+	default:
+		p.pos = save
+	}
+	// Old-style dimension suffixes, e.g. POINTZ or POINTM.
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		if tag != suffix && strings.HasSuffix(tag, suffix) && isGeometryTag(strings.TrimSuffix(tag, suffix)) {
+			tag = strings.TrimSuffix(tag, suffix)
+			p.dims = 2 + len(suffix)
+		}
+	}
+	switch tag {
+	case "POINT":
+		points, err := p.points()
+		if err != nil {
+			return nil, err
+		}
+		if len(points) != 1 {
+			return nil, p.errorf("Expected a single point")
+		}
+		return geometry.Point(points[0]), nil
+	case "LINESTRING":
+		points, err := p.points()
+		return geometry.LineString(points), err
+	case "POLYGON":
+		return p.polygon()
+	case "MULTIPOINT":
+		return p.multiPoint()
+	case "MULTILINESTRING":
+		var multi geometry.MultiLineString
+		err := p.list(func() error {
+			points, err := p.points()
+			multi = append(multi, points)
+			return err
+		})
+		return multi, err
+	case "MULTIPOLYGON":
+		var multi geometry.MultiPolygon
+		err := p.list(func() error {
+			polygon, err := p.polygon()
+			multi = append(multi, polygon)
+			return err
+		})
+		return multi, err
+	case "":
+		p.pos = start
+		return nil, p.errorf("Expected a geometry type")
+	default:
+		p.pos = start
+		return nil, p.errorf("Unsupported geometry type %v", tag)
+	}
+}
+
+func isGeometryTag(tag string) bool {
+	switch tag {
+	case "POINT", "LINESTRING", "POLYGON", "MULTIPOINT", "MULTILINESTRING", "MULTIPOLYGON":
+		return true
+	}
+	return false
+}
+
+// list parses a parenthesized, comma-separated list.
+func (p *parser) list(item func() error) error {
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if p.peek(',') {
+			p.pos++
+			continue
+		}
+		return p.expect(')')
+	}
+}
+
+func (p *parser) points() ([]primitives.Point, error) {
+	var points []primitives.Point
+	err := p.list(func() error {
+		point, err := p.point()
+		points = append(points, point)
+		return err
+	})
+	return points, err
+}
+
+// multiPoint accepts both MULTIPOINT ((1 2), (3 4)) and MULTIPOINT (1 2, 3 4).
+func (p *parser) multiPoint() (geometry.MultiPoint, error) {
+	var multi geometry.MultiPoint
+	err := p.list(func() error {
+		if p.peek('(') {
+			points, err := p.points()
+			if err == nil && len(points) != 1 {
+				err = p.errorf("Expected a single point")
+			}
+			multi = append(multi, points...)
+			return err
+		}
+		point, err := p.point()
+		multi = append(multi, point)
+		return err
+	})
+	return multi, err
+}
+
+func (p *parser) polygon() (geometry.Polygon, error) {
+	var polygon geometry.Polygon
+	err := p.list(func() error {
+		start := p.pos
+		points, err := p.points()
+		if err != nil {
+			return err
+		}
+		if len(points) < 4 || points[0] != points[len(points)-1] {
+			p.pos = start
+			return p.errorf("Expected a closed ring with at least 4 points")
+		}
+		polygon = append(polygon, points)
+		return nil
+	})
+	return polygon, err
+}
+
+func (p *parser) point() (primitives.Point, error) {
+	var point primitives.Point
+	for i := 0; i < p.dims; i++ {
+		value, err := p.number()
+		if err != nil {
+			return point, err
+		}
+		if i < 2 {
+			point[i] = value
+		}
+	}
+	return point, nil
+}
+
+func (p *parser) number() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && strings.IndexByte("0123456789+-.eE", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("Expected a number")
+	}
+	return value, nil
+}
@@ -0,0 +1,99 @@
+package wkt_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/geometry/wkt"
+	"github.com/bilus/fencer/primitives"
+)
+
+func ExampleUnmarshal() {
+	g, err := wkt.Unmarshal("SRID=4326;POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(g.Bounds())
+	fmt.Println(g.Contains(primitives.Point{2, 2}), g.Contains(primitives.Point{5, 5}))
+	// Output:
+	// {[0 0] [10 10]}
+	// true false
+}
+
+func ExampleMarshal() {
+	text, err := wkt.Marshal(geometry.MultiPoint{{1, 2}, {3.5, -4}})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(text)
+	// Output: MULTIPOINT ((1 2), (3.5 -4))
+}
+
+func TestRoundTrip(t *testing.T) {
+	geometries := []geometry.Geometry{
+		geometry.Point{1, 2},
+		geometry.LineString{{0, 0}, {1, 1}, {2, 0}},
+		geometry.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}},
+		geometry.MultiPoint{{1, 2}, {3, 4}},
+		geometry.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}},
+		geometry.MultiPolygon{
+			{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}},
+			{{{10, 10}, {14, 10}, {14, 14}, {10, 10}}, {{11, 11}, {12, 11}, {12, 12}, {11, 11}}},
+		},
+	}
+	for _, want := range geometries {
+		text, err := wkt.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := wkt.Unmarshal(text)
+		if err != nil {
+			t.Fatalf("%v: %v", text, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestUnmarshal_dimensions(t *testing.T) {
+	for _, text := range []string{
+		"POINT Z (1 2 3)",
+		"POINT M (1 2 3)",
+		"point zm (1 2 3 4)",
+		"POINTZ(1 2 3)",
+		"MULTIPOINT Z (1 2 3)",
+	} {
+		g, err := wkt.Unmarshal(text)
+		if err != nil {
+			t.Errorf("%v: %v", text, err)
+			continue
+		}
+		if g.Bounds().Min != (primitives.Point{1, 2}) {
+			t.Errorf("%v: got %v", text, g)
+		}
+	}
+}
+
+func TestUnmarshal_invalid(t *testing.T) {
+	for text, offset := range map[string]int{
+		"POINT EMPTY":                    0,
+		"CIRCULARSTRING (0 0, 1 1, 2 0)": 0,
+		"POINT (1 x)":                    9,
+		"POLYGON ((0 0, 1 0, 1 1, 0 1))": 9,
+		"LINESTRING (0 0, 1 1) junk":     22,
+	} {
+		_, err := wkt.Unmarshal(text)
+		var syntaxErr *wkt.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%v: expected a syntax error, got %v", text, err)
+			continue
+		}
+		if syntaxErr.Offset != offset {
+			t.Errorf("%v: got offset %d, want %d (%v)", text, syntaxErr.Offset, offset, err)
+		}
+	}
+}
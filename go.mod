@@ -4,6 +4,7 @@ require (
 	github.com/JamesMilnerUK/pip-go v0.0.0-20180711171552-99c4cbbc7deb
 	github.com/bilus/rtreego v0.0.0-20180128165634-4bb50c85dc20
 	github.com/paulmach/go.geo v0.0.0-20180829195134-22b514266d33
+	github.com/tidwall/rtree v1.9.2
 	github.com/twpayne/go-geom v1.0.5
 	github.com/zyedidia/generic v1.1.0
 )
@@ -12,7 +13,6 @@ require (
 	github.com/paulmach/go.geojson v1.4.0 // indirect
 	github.com/segmentio/fasthash v1.0.3 // indirect
 	github.com/tidwall/geoindex v1.7.0 // indirect
-	github.com/tidwall/rtree v1.9.2 // indirect
 	golang.org/x/exp v0.0.0-20220218215828-6cf2b201936e // indirect
 )
 
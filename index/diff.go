@@ -0,0 +1,163 @@
+package index
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// ChangeSet holds differences between two snapshots of an index.
+type ChangeSet[K feature.Key, F feature.Feature[K]] struct {
+	Added    []K // Keys present only in the new index.
+	Removed  []K // Keys present only in the old index.
+	Modified []K // Keys present in both indexes with different features.
+	features featuresByKey[K, F]
+}
+
+// Features returns new features for an added or modified key.
+func (changes *ChangeSet[K, F]) Features(key K) []F {
+	return changes.features[key]
+}
+
+// IsEmpty returns true if there are no differences.
+func (changes *ChangeSet[K, F]) IsEmpty() bool {
+	return len(changes.Added) == 0 && len(changes.Removed) == 0 && len(changes.Modified) == 0
+}
+
+// Diff compares two indexes, using equal to compare features stored under the
+// same key. Keys in the change set are sorted by their string representation.
+func Diff[K feature.Key, F feature.Feature[K]](old, new *Index[K, F], equal func(old F, new F) bool) (*ChangeSet[K, F], error) {
+	changes := ChangeSet[K, F]{features: make(featuresByKey[K, F])}
+	oldKeys := old.Keys()
+	newKeys := new.Keys()
+	var err error
+	oldKeys.Each(func(key K) {
+		if !newKeys.Has(key) {
+			changes.Removed = append(changes.Removed, key)
+		}
+	})
+	newKeys.Each(func(key K) {
+		if err != nil {
+			return
+		}
+		var newFeatures []F
+		newFeatures, err = new.Lookup(key)
+		if err != nil {
+			return
+		}
+		if !oldKeys.Has(key) {
+			changes.Added = append(changes.Added, key)
+			changes.features[key] = newFeatures
+			return
+		}
+		var oldFeatures []F
+		oldFeatures, err = old.Lookup(key)
+		if err != nil {
+			return
+		}
+		if !allEqual(oldFeatures, newFeatures, equal) {
+			changes.Modified = append(changes.Modified, key)
+			changes.features[key] = newFeatures
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortKeys(changes.Added)
+	sortKeys(changes.Removed)
+	sortKeys(changes.Modified)
+	return &changes, nil
+}
+
+// Apply applies a change set as a single batch: either all changes are
+// applied or, if any removed or modified key is missing or recording them
+// fails, none are. Features already stored under added keys are replaced.
+//
+// All changes are recorded by the attached journal before any is applied, at
+// once if it implements BatchJournal. Other journals record them one by one,
+// so if recording fails part-way, the journal holds changes the index
+// doesn't.
+func (index *Index[K, F]) Apply(changes *ChangeSet[K, F]) (err error) {
+	for _, keys := range [][]K{changes.Removed, changes.Modified} {
+		for _, key := range keys {
+			if _, ok := index.featuresByKey[key]; !ok {
+				return ErrFeatureNotFound[K]{Key: key}
+			}
+		}
+	}
+	var mutations []Mutation[K, F]
+	for _, keys := range [][]K{changes.Removed, changes.Modified, changes.Added} {
+		for _, key := range keys {
+			if _, ok := index.featuresByKey[key]; ok {
+				mutations = append(mutations, Mutation[K, F]{Op: OpDelete, Key: key})
+			}
+		}
+	}
+	for _, keys := range [][]K{changes.Added, changes.Modified} {
+		for _, key := range keys {
+			for _, f := range changes.features[key] {
+				mutations = append(mutations, Mutation[K, F]{Op: OpInsert, Key: key, Feature: f})
+			}
+		}
+	}
+
+	if len(mutations) == 0 {
+		return nil
+	}
+	if index.observer != nil {
+		start := time.Now()
+		defer func() {
+			for _, mutation := range mutations {
+				index.mutated(mutation.Op, start, &err)
+			}
+		}()
+	}
+	if err := index.recordAll(mutations); err != nil {
+		return err
+	}
+	for _, mutation := range mutations {
+		if mutation.Op == OpDelete {
+			index.delete(mutation.Key)
+		} else {
+			index.insert(mutation.Feature)
+		}
+	}
+	return nil
+}
+
+// recordAll records mutations in the attached journal, as a batch if it
+// implements BatchJournal.
+func (index *Index[K, F]) recordAll(mutations []Mutation[K, F]) error {
+	switch journal := index.journal.(type) {
+	case nil:
+		return nil
+	case BatchJournal[K, F]:
+		return journal.RecordBatch(mutations)
+	default:
+		for _, mutation := range mutations {
+			if err := journal.Record(mutation); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func allEqual[F any](old, new []F, equal func(F, F) bool) bool {
+	if len(old) != len(new) {
+		return false
+	}
+	for i := range old {
+		if !equal(old[i], new[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortKeys[K feature.Key](keys []K) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+}
@@ -0,0 +1,167 @@
+package index_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/JamesMilnerUK/pip-go"
+	"github.com/bilus/fencer/index"
+)
+
+func samePopulation(old, new *City) bool {
+	return old.Population == new.Population
+}
+
+// This example uses an example spatial feature implementation.
+// See https://github.com/bilus/fencer/blob/master/index/index_test.go for more details.
+func ExampleDiff() {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	old, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+
+	szczecin2, _ := NewCity("szczecin", "Szczecin", 400000, pip.Polygon{Points: szczecinBoundaries})
+	farAway, _ := NewCity("far-away", "Far Away", 1, pip.Polygon{Points: farAwayLandBoundaries})
+	new, _ := index.New[CityID]([]*City{&szczecin2, &farAway})
+
+	changes, _ := index.Diff(old, new, samePopulation)
+	fmt.Println("Added:", changes.Added)
+	fmt.Println("Removed:", changes.Removed)
+	fmt.Println("Modified:", changes.Modified)
+	// Output:
+	// Added: [far-away]
+	// Removed: [wrocław]
+	// Modified: [szczecin]
+}
+
+// This example uses an example spatial feature implementation.
+// See https://github.com/bilus/fencer/blob/master/index/index_test.go for more details.
+func ExampleIndex_Apply() {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	live, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	old, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+
+	szczecin2, _ := NewCity("szczecin", "Szczecin", 400000, pip.Polygon{Points: szczecinBoundaries})
+	new, _ := index.New[CityID]([]*City{&szczecin2})
+
+	changes, _ := index.Diff(old, new, samePopulation)
+	_ = live.Apply(changes)
+	results, _ := live.Lookup(CityID("szczecin"))
+	fmt.Println(live.Size(), "city, population:", results[0].Population)
+	// Output: 1 city, population: 400000
+}
+
+func TestIndex_Apply_isAtomic(t *testing.T) {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	old, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	new, _ := index.New[CityID]([]*City{})
+	changes, err := index.Diff(old, new, samePopulation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := index.New[CityID]([]*City{&szczecin})
+	err = live.Apply(changes)
+	if _, ok := err.(index.ErrFeatureNotFound[CityID]); !ok {
+		t.Fatalf("Expected ErrFeatureNotFound, got %v", err)
+	}
+	if live.Size() != 1 {
+		t.Fatalf("Expected the index to be left intact, got %d features", live.Size())
+	}
+}
+
+// batchJournal records batches of mutations, failing if err is set.
+type batchJournal struct {
+	batches [][]index.Mutation[CityID, *City]
+	err     error
+}
+
+func (journal *batchJournal) Record(mutation index.Mutation[CityID, *City]) error {
+	return journal.RecordBatch([]index.Mutation[CityID, *City]{mutation})
+}
+
+func (journal *batchJournal) RecordBatch(mutations []index.Mutation[CityID, *City]) error {
+	if journal.err != nil {
+		return journal.err
+	}
+	journal.batches = append(journal.batches, mutations)
+	return nil
+}
+
+func TestIndex_Apply_batchJournal(t *testing.T) {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	szczecin2, _ := NewCity("szczecin", "Szczecin", 400000, pip.Polygon{Points: szczecinBoundaries})
+	old, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	new, _ := index.New[CityID]([]*City{&szczecin2})
+	changes, err := index.Diff(old, new, samePopulation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	journal := &batchJournal{err: fmt.Errorf("Disk full")}
+	live.SetJournal(journal)
+	if err := live.Apply(changes); err != journal.err {
+		t.Fatalf("Expected the journal's error, got %v", err)
+	}
+	if cities, _ := live.Lookup("szczecin"); live.Size() != 2 || cities[0].Population != 407811 {
+		t.Fatalf("Expected the index to be left intact")
+	}
+
+	journal.err = nil
+	if err := live.Apply(changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.batches) != 1 || len(journal.batches[0]) != 3 {
+		t.Errorf("Expected a single batch of 3 mutations, got %d batches", len(journal.batches))
+	}
+	if cities, _ := live.Lookup("szczecin"); live.Size() != 1 || cities[0].Population != 400000 {
+		t.Errorf("Expected the changes to be applied")
+	}
+}
+
+// failingJournal records mutations until it has recorded n of them.
+type failingJournal struct {
+	n        int
+	recorded []index.Mutation[CityID, *City]
+}
+
+func (journal *failingJournal) Record(mutation index.Mutation[CityID, *City]) error {
+	if len(journal.recorded) >= journal.n {
+		return fmt.Errorf("Disk full")
+	}
+	journal.recorded = append(journal.recorded, mutation)
+	return nil
+}
+
+func TestIndex_Apply_journal(t *testing.T) {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	szczecin2, _ := NewCity("szczecin", "Szczecin", 400000, pip.Polygon{Points: szczecinBoundaries})
+	old, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	new, _ := index.New[CityID]([]*City{&szczecin2})
+	changes, err := index.Diff(old, new, samePopulation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	journal := &failingJournal{n: 2}
+	live.SetJournal(journal)
+	if err := live.Apply(changes); err == nil {
+		t.Fatal("Expected the journal's error")
+	}
+	if cities, _ := live.Lookup("szczecin"); live.Size() != 2 || cities[0].Population != 407811 {
+		t.Fatalf("Expected the index to be left intact")
+	}
+
+	journal.n = 5
+	if err := live.Apply(changes); err != nil {
+		t.Fatal(err)
+	}
+	if cities, _ := live.Lookup("szczecin"); live.Size() != 1 || cities[0].Population != 400000 {
+		t.Errorf("Expected the changes to be applied")
+	}
+}
@@ -0,0 +1,148 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/query"
+)
+
+// Explanation reports how a query was executed; see Explain.
+type Explanation struct {
+	Bounds      primitives.Rect
+	Candidates  int           // Features with bounding boxes intersecting Bounds.
+	Search      time.Duration // Time spent searching the R-tree.
+	Conditions  []ConditionReport
+	Aggregators []AggregatorReport
+	Matches     int // Distinct features matched.
+	Groups      int // Result groups; see query.Query.Len.
+	Duration    time.Duration
+}
+
+// ConditionReport describes the execution of a condition. Conditions are
+// evaluated in order until one rejects a feature.
+type ConditionReport struct {
+	Name      string // See query.ConditionName.
+	Evaluated int
+	Rejected  int
+	Duration  time.Duration
+}
+
+// AggregatorReport describes the execution of an aggregator: matches it kept
+// or rejected by returning no result keys from Map.
+type AggregatorReport struct {
+	Name     string // See query.ConditionName.
+	Kept     int
+	Rejected int
+	Duration time.Duration // Time spent in Map and Reduce.
+}
+
+// Explain runs a query like Query does but returns a report on its
+// execution instead of the results. On error, the report covers features
+// scanned so far.
+func (index *Index[K, F]) Explain(bounds *primitives.Rect, q query.Query[K, F]) (*Explanation, error) {
+	start := time.Now()
+	explanation := &Explanation{
+		Bounds:      *bounds,
+		Conditions:  make([]ConditionReport, len(q.Conditions)),
+		Aggregators: make([]AggregatorReport, len(q.Aggregators)),
+	}
+	defer func() {
+		explanation.Duration = time.Since(start)
+	}()
+
+	conditions := make([]query.Condition[K, F], len(q.Conditions))
+	for i, condition := range q.Conditions {
+		explanation.Conditions[i].Name = query.ConditionName(condition)
+		conditions[i] = &explainedCondition[K, F]{condition, &explanation.Conditions[i]}
+	}
+	aggregators := make([]query.Aggregator[K, F], len(q.Aggregators))
+	for i, aggregator := range q.Aggregators {
+		explanation.Aggregators[i].Name = query.ConditionName(aggregator)
+		aggregators[i] = &explainedAggregator[K, F]{aggregator, &explanation.Aggregators[i]}
+	}
+	q.Conditions, q.Aggregators = conditions, aggregators
+	q.Observer, q.Tracer = nil, nil
+	q.Reset()
+
+	var candidates []F
+	index.rtree.Search(bounds.Min, bounds.Max, func(min, max primitives.Point, f F) bool {
+		candidates = append(candidates, f)
+		return true
+	})
+	explanation.Search = time.Since(start)
+	explanation.Candidates = len(candidates)
+	for _, feature := range candidates {
+		if err := q.Scan(feature); err != nil {
+			return explanation, err
+		}
+	}
+	explanation.Matches = len(q.Distinct())
+	explanation.Groups = q.Len()
+	return explanation, nil
+}
+
+// String formats the explanation as a table.
+func (explanation *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bounds: [%g %g %g %g]\n", explanation.Bounds.Min[0], explanation.Bounds.Min[1], explanation.Bounds.Max[0], explanation.Bounds.Max[1])
+	fmt.Fprintf(&b, "Candidates: %d (%v)\n", explanation.Candidates, explanation.Search)
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONDITION\tEVALUATED\tREJECTED\tTIME")
+	for _, c := range explanation.Conditions {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", c.Name, c.Evaluated, c.Rejected, c.Duration)
+	}
+	fmt.Fprintln(w, "AGGREGATOR\tKEPT\tREJECTED\tTIME")
+	for _, a := range explanation.Aggregators {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", a.Name, a.Kept, a.Rejected, a.Duration)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "Matches: %d in %d groups (%v)\n", explanation.Matches, explanation.Groups, explanation.Duration)
+	return b.String()
+}
+
+type explainedCondition[K feature.Key, F feature.Feature[K]] struct {
+	query.Condition[K, F]
+	report *ConditionReport
+}
+
+func (c *explainedCondition[K, F]) IsMatch(f F) (bool, error) {
+	start := time.Now()
+	match, err := c.Condition.IsMatch(f)
+	c.report.Duration += time.Since(start)
+	c.report.Evaluated++
+	if err == nil && !match {
+		c.report.Rejected++
+	}
+	return match, err
+}
+
+type explainedAggregator[K feature.Key, F feature.Feature[K]] struct {
+	query.Aggregator[K, F]
+	report *AggregatorReport
+}
+
+func (a *explainedAggregator[K, F]) Map(match *query.Match[K, F]) (*query.Match[K, F], error) {
+	start := time.Now()
+	match, err := a.Aggregator.Map(match)
+	a.report.Duration += time.Since(start)
+	if err == nil && match != nil {
+		if len(match.ResultKeys) == 0 {
+			a.report.Rejected++
+		} else {
+			a.report.Kept++
+		}
+	}
+	return match, err
+}
+
+func (a *explainedAggregator[K, F]) Reduce(result *query.Result[K, F], match *query.Match[K, F]) error {
+	start := time.Now()
+	err := a.Aggregator.Reduce(result, match)
+	a.report.Duration += time.Since(start)
+	return err
+}
@@ -3,6 +3,7 @@ package index
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/bilus/fencer/feature"
 	"github.com/bilus/fencer/primitives"
@@ -22,54 +23,162 @@ func (err ErrFeatureNotFound[K]) Error() string {
 
 type featuresByKey[K feature.Key, F feature.Feature[K]] map[K][]F
 
+// Op is a kind of index mutation.
+type Op int
+
+const (
+	OpInsert Op = iota + 1
+	OpDelete
+	OpUpdate
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpInsert:
+		return "insert"
+	case OpDelete:
+		return "delete"
+	case OpUpdate:
+		return "update"
+	default:
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+}
+
+// Mutation describes a single change to an index. Feature is not set for
+// OpDelete.
+type Mutation[K feature.Key, F feature.Feature[K]] struct {
+	Op      Op
+	Key     K
+	Feature F
+}
+
+// Journal records index mutations. Mutations are recorded before they're
+// applied; if Record returns an error, the mutation is not applied.
+type Journal[K feature.Key, F feature.Feature[K]] interface {
+	Record(mutation Mutation[K, F]) error
+}
+
+// BatchJournal is a journal able to record several mutations at once, e.g.
+// as a single log record, so that either all or none of them are recorded.
+// It's used by Index.Apply.
+type BatchJournal[K feature.Key, F feature.Feature[K]] interface {
+	Journal[K, F]
+	RecordBatch(mutations []Mutation[K, F]) error
+}
+
 // Index allows finding features by bounding box and custom queries.
 // It is NOT thread-safe.
 type Index[K feature.Key, F feature.Feature[K]] struct {
 	rtree rtree.RTreeG[F]
 	featuresByKey[K, F]
+	journal  Journal[K, F]
+	observer Observer
+	tracer   query.Tracer
 }
 
 // Creates a new index containing features.
 func New[K feature.Key, F feature.Feature[K]](features []F) (*Index[K, F], error) {
 	index := Index[K, F]{featuresByKey: make(featuresByKey[K, F])}
 	for _, f := range features {
-		if err := index.Insert(f); err != nil {
-			return nil, err
-		}
+		index.insert(f)
 	}
 	return &index, nil
 }
 
+// SetJournal attaches a journal recording all subsequent mutations. Passing
+// nil detaches the current journal.
+func (index *Index[K, F]) SetJournal(journal Journal[K, F]) {
+	index.journal = journal
+}
+
+// Journal returns the attached journal or nil.
+func (index *Index[K, F]) Journal() Journal[K, F] {
+	return index.journal
+}
+
 // Insert adds a feature to the index.
-func (index *Index[K, F]) Insert(f F) error {
-	bounds := f.Bounds()
-	index.rtree.Insert(bounds.Min, bounds.Max, f)
-	key := f.Key()
-	index.featuresByKey[key] = append(index.featuresByKey[key], f)
+func (index *Index[K, F]) Insert(f F) (err error) {
+	if index.observer != nil {
+		defer index.mutated(OpInsert, time.Now(), &err)
+	}
+	if err := index.record(OpInsert, f.Key(), f); err != nil {
+		return err
+	}
+	index.insert(f)
 	return nil
 }
 
 // Delete removes a feature by its key.
-func (index *Index[K, F]) Delete(key K) error {
-	features, ok := index.featuresByKey[key]
-	if !ok {
+func (index *Index[K, F]) Delete(key K) (err error) {
+	if index.observer != nil {
+		defer index.mutated(OpDelete, time.Now(), &err)
+	}
+	if _, ok := index.featuresByKey[key]; !ok {
 		return ErrFeatureNotFound[K]{Key: key}
 	}
-	delete(index.featuresByKey, key)
-
-	for _, feature := range features {
-		bounds := feature.Bounds()
-		index.rtree.Delete(bounds.Min, bounds.Max, feature)
+	var none F
+	if err := index.record(OpDelete, key, none); err != nil {
+		return err
 	}
+	index.delete(key)
 	return nil
 }
 
 // Update updates a feature (either its bounding rectangle or properties).
-func (index *Index[K, F]) Update(f F) error {
-	if err := index.Delete(f.Key()); err != nil {
+func (index *Index[K, F]) Update(f F) (err error) {
+	if index.observer != nil {
+		defer index.mutated(OpUpdate, time.Now(), &err)
+	}
+	key := f.Key()
+	if _, ok := index.featuresByKey[key]; !ok {
+		return ErrFeatureNotFound[K]{Key: key}
+	}
+	if err := index.record(OpUpdate, key, f); err != nil {
 		return err
 	}
-	return index.Insert(f)
+	index.delete(key)
+	index.insert(f)
+	return nil
+}
+
+// Replay applies a mutation, e.g. one read from a log or received from
+// another index.
+func (index *Index[K, F]) Replay(mutation Mutation[K, F]) error {
+	switch mutation.Op {
+	case OpInsert:
+		return index.Insert(mutation.Feature)
+	case OpDelete:
+		return index.Delete(mutation.Key)
+	case OpUpdate:
+		return index.Update(mutation.Feature)
+	default:
+		return fmt.Errorf("Unsupported operation %v", mutation.Op)
+	}
+}
+
+func (index *Index[K, F]) record(op Op, key K, f F) error {
+	if index.journal == nil {
+		return nil
+	}
+	return index.journal.Record(Mutation[K, F]{Op: op, Key: key, Feature: f})
+}
+
+func (index *Index[K, F]) insert(f F) {
+	bounds := f.Bounds()
+	index.rtree.Insert(bounds.Min, bounds.Max, f)
+	key := f.Key()
+	index.featuresByKey[key] = append(index.featuresByKey[key], f)
+}
+
+func (index *Index[K, F]) delete(key K) {
+	features := index.featuresByKey[key]
+	delete(index.featuresByKey, key)
+
+	for _, feature := range features {
+		bounds := feature.Bounds()
+		index.rtree.Delete(bounds.Min, bounds.Max, feature)
+	}
 }
 
 // FindContaining returns features containing the given point.
@@ -93,21 +202,97 @@ func (index *Index[K, F]) Intersect(bounds *primitives.Rect) ([]F, error) {
 }
 
 // Query returns features with bounding boxes intersecting the specified bounding box and matching the provided query.
-func (index *Index[K, F]) Query(bounds *primitives.Rect, query query.Query[K, F]) ([]F, error) {
+// Results of previous runs of the query are discarded, see Execute.
+func (index *Index[K, F]) Query(bounds *primitives.Rect, q query.Query[K, F]) ([]F, error) {
+	executed, candidates, err := index.execute(bounds, q)
+	if err != nil || candidates == 0 {
+		return nil, err
+	}
+	return executed.Distinct(), nil
+}
+
+// Execute runs a query like Query does, starting with no results, and
+// returns it to give access to its results. The query passed in is not
+// modified, so it can be executed again, even concurrently.
+func (index *Index[K, F]) Execute(bounds *primitives.Rect, q query.Query[K, F]) (query.Query[K, F], error) {
+	executed, _, err := index.execute(bounds, q)
+	return executed, err
+}
+
+// execute implements Execute, also returning the number of candidates found
+// in the R-tree.
+func (index *Index[K, F]) execute(bounds *primitives.Rect, q query.Query[K, F]) (executed query.Query[K, F], numCandidates int, err error) {
+	q.Reset()
 	candidates := make([]F, 0)
+	matches := 0
+	if index.observer != nil {
+		if observer, ok := index.observer.(query.Observer); ok && q.Observer == nil {
+			q.Observer = observer
+		}
+		defer func(start time.Time) {
+			index.observer.Queried(time.Since(start), len(candidates), matches, err)
+		}(time.Now())
+	}
+	if q.Tracer == nil {
+		q.Tracer = index.tracer
+	}
+	if q.Tracer != nil {
+		span := q.Tracer.Start(q.Span, "index.Query")
+		span.SetAttribute("bounds", [4]float64{bounds.Min[0], bounds.Min[1], bounds.Max[0], bounds.Max[1]})
+		defer func(parent query.Span) {
+			span.SetAttribute("matches", matches)
+			span.End(err)
+			executed.Span = parent
+		}(q.Span)
+		q.Span = span
+	}
+
+	var search query.Span
+	if q.Tracer != nil {
+		search = q.Tracer.Start(q.Span, "rtree.Search")
+	}
 	index.rtree.Search(bounds.Min, bounds.Max, func(min, max primitives.Point, f F) bool {
 		candidates = append(candidates, f)
 		return true
 	})
-	if len(candidates) == 0 {
-		return nil, nil
+	if search != nil {
+		search.SetAttribute("candidates", len(candidates))
+		search.End(nil)
 	}
 	for _, feature := range candidates {
-		if err := query.Scan(feature); err != nil {
-			return nil, err
+		if err := q.Scan(feature); err != nil {
+			return q, len(candidates), err
+		}
+	}
+	if index.observer != nil || q.Tracer != nil {
+		matches = q.Count()
+	}
+	return q, len(candidates), nil
+}
+
+// Nearby calls fn for features in order of increasing distance from point
+// until fn returns false. The distance to a feature is the distance to the
+// closest point of its bounding rectangle, measured by dist, which must not
+// decrease as points get farther apart; if dist is nil, the Euclidean
+// distance is used.
+func (index *Index[K, F]) Nearby(point primitives.Point, dist func(from, to primitives.Point) float64, fn func(f F, distance float64) bool) {
+	if dist == nil {
+		dist = func(from, to primitives.Point) float64 {
+			return math.Hypot(to[0]-from[0], to[1]-from[1])
 		}
 	}
-	return query.Distinct(), nil
+	index.rtree.Nearby(
+		func(min, max primitives.Point, f F, item bool) float64 {
+			closest := primitives.Point{
+				math.Max(min[0], math.Min(max[0], point[0])),
+				math.Max(min[1], math.Min(max[1], point[1])),
+			}
+			return dist(point, closest)
+		},
+		func(min, max primitives.Point, f F, distance float64) bool {
+			return fn(f, distance)
+		},
+	)
 }
 
 // Lookup returns a feature based on its key. It returns a slice containing one
@@ -133,6 +318,18 @@ func (index *Index[K, F]) Keys() *hashset.Set[K] {
 	return keys
 }
 
+// Each calls fn for every feature in the index, stopping at the first error.
+func (index *Index[K, F]) Each(fn func(f F) error) error {
+	for _, features := range index.featuresByKey {
+		for _, f := range features {
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Size returns the number of features in the index.
 func (index *Index[K, F]) Size() int {
 	return len(index.featuresByKey)
@@ -2,11 +2,15 @@ package index_test
 
 import (
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/JamesMilnerUK/pip-go"
 	"github.com/bilus/fencer/feature"
 	"github.com/bilus/fencer/geo"
+	"github.com/bilus/fencer/geometry"
 	"github.com/bilus/fencer/index"
 	"github.com/bilus/fencer/primitives"
 	"github.com/bilus/fencer/query"
@@ -112,6 +116,23 @@ func ExampleIndex_Intersect() {
 	// Output: 2 results
 }
 
+// This example uses an example spatial feature implementation.
+// See https://github.com/bilus/fencer/blob/master/index/index_test.go for more details.
+func ExampleIndex_Nearby() {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	index, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	// Somewhere near Poznań, closer to Wrocław.
+	location := primitives.Point{16.9, 52.0}
+	index.Nearby(location, geo.Distance, func(city *City, distance float64) bool {
+		fmt.Printf("%s: %.0f km\n", city.Name, distance/1000)
+		return true
+	})
+	// Output:
+	// Wrocław: 87 km
+	// Szczecin: 202 km
+}
+
 // This example uses an example spatial feature implementation.
 // See https://github.com/bilus/fencer/blob/master/index/index_test.go for more details.
 func ExampleIndex_Delete_lookup() {
@@ -554,3 +575,146 @@ var (
 		{X: 14.43, Y: 53.487},
 	}
 )
+
+func TestIndex_Nearest(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		// The bounding rectangle of the diagonal line is nearer than the point,
+		// the line itself is not.
+		geometry.NewFeature("line", geometry.LineString{{0, 0}, {10, 10}}, nil),
+		geometry.NewFeature("point", geometry.Point{9, 2}, nil),
+		geometry.NewFeature("far", geometry.Point{40, 40}, nil),
+	})
+	location := primitives.Point{9.5, 1}
+	neighbours := idx.Nearest(location, 2, math.Inf(1))
+	if len(neighbours) != 2 || neighbours[0].Feature.Key() != "point" || neighbours[1].Feature.Key() != "line" {
+		t.Fatalf("Expected the point, then the line, got %v", neighbours)
+	}
+	want := geo.Distance(location, primitives.Point{9, 2})
+	if d := neighbours[0].Distance; math.Abs(d-want) > 1e-6 {
+		t.Errorf("Expected distance %v, got %v", want, d)
+	}
+	if got := idx.Nearest(location, 5, 200000); len(got) != 1 {
+		t.Errorf("Expected only the point within 200km, got %v", got)
+	}
+	if got := idx.Nearest(location, 0, math.Inf(1)); len(got) != 0 {
+		t.Errorf("Expected no neighbours for k = 0, got %v", got)
+	}
+}
+
+// byKindExceptB groups features by kind, rejecting b.
+type byKindExceptB struct{}
+
+func (byKindExceptB) Map(match *query.Match[geometry.ID, *geometry.Feature]) (*query.Match[geometry.ID, *geometry.Feature], error) {
+	if match.Feature.ID != "b" {
+		match.AddKey(match.Feature.Properties["kind"])
+	}
+	return match, nil
+}
+
+func (byKindExceptB) Reduce(result *query.Result[geometry.ID, *geometry.Feature], match *query.Match[geometry.ID, *geometry.Feature]) error {
+	for _, key := range match.ResultKeys {
+		err := result.Update(key, func(entry *query.ResultEntry[geometry.ID, *geometry.Feature]) error {
+			entry.Features = append(entry.Features, match.Feature)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestIndex_Explain(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{"kind": "shop"}),
+		geometry.NewFeature("b", geometry.Point{2, 2}, map[string]any{"kind": "shop"}),
+		geometry.NewFeature("c", geometry.Point{3, 3}, map[string]any{"kind": "park"}),
+		geometry.NewFeature("d", geometry.Point{50, 50}, map[string]any{"kind": "shop"}),
+	})
+	isShop := query.Pred[geometry.ID, *geometry.Feature](func(f *geometry.Feature) (bool, error) {
+		return f.Properties["kind"] == "shop", nil
+	})
+	q := query.Build[geometry.ID, *geometry.Feature]().Where(isShop).Aggregate(byKindExceptB{}).Query()
+	bounds := primitives.Rect{Max: primitives.Point{10, 10}}
+	explanation, err := idx.Explain(&bounds, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Candidates != 3 || explanation.Matches != 1 || explanation.Groups != 1 {
+		t.Errorf("got %+v", explanation)
+	}
+	conditions := explanation.Conditions
+	if len(conditions) != 1 || conditions[0].Name != "query.Pred" || conditions[0].Evaluated != 3 || conditions[0].Rejected != 1 {
+		t.Errorf("got %+v", conditions)
+	}
+	aggregators := explanation.Aggregators
+	if len(aggregators) != 1 || aggregators[0].Name != "index_test.byKindExceptB" || aggregators[0].Kept != 1 || aggregators[0].Rejected != 1 {
+		t.Errorf("got %+v", aggregators)
+	}
+	if s := explanation.String(); !strings.Contains(s, "Candidates: 3") || !strings.Contains(s, "Matches: 1 in 1 groups") {
+		t.Errorf("got %s", s)
+	}
+
+	failing := query.Pred[geometry.ID, *geometry.Feature](func(f *geometry.Feature) (bool, error) {
+		return false, fmt.Errorf("Failed on %v", f.ID)
+	})
+	explanation, err = idx.Explain(&bounds, query.Build[geometry.ID, *geometry.Feature]().Where(failing).Query())
+	if err == nil || explanation.Conditions[0].Evaluated != 1 {
+		t.Errorf("got %+v, %v", explanation, err)
+	}
+}
+
+func TestIndex_Query_reusesQuery(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{"kind": "shop"}),
+		geometry.NewFeature("c", geometry.Point{3, 3}, map[string]any{"kind": "park"}),
+		geometry.NewFeature("d", geometry.Point{4, 4}, map[string]any{"kind": "shop"}),
+	})
+	q := query.Build[geometry.ID, *geometry.Feature]().Aggregate(byKindExceptB{}).Query()
+	bounds := primitives.Rect{Max: primitives.Point{10, 10}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			executed, err := idx.Execute(&bounds, q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// Results of other runs aren't accumulated.
+			if executed.Len() != 2 || len(executed.Distinct()) != 3 {
+				t.Errorf("got %v groups, %v features", executed.Len(), executed.Distinct())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(q.Distinct()) != 0 {
+		t.Errorf("Expected the query passed in not to be modified")
+	}
+	features, err := idx.Query(&bounds, q)
+	if err != nil || len(features) != 3 {
+		t.Errorf("got %v, %v", features, err)
+	}
+}
+
+func TestIndex_Query_noMatches(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{"kind": "shop"}),
+	})
+	q := query.Build[geometry.ID, *geometry.Feature]().Where(query.Pred[geometry.ID, *geometry.Feature](func(*geometry.Feature) (bool, error) {
+		return false, nil
+	})).Query()
+	// Candidates but no matches.
+	features, err := idx.Query(&primitives.Rect{Max: primitives.Point{10, 10}}, q)
+	if err != nil || features == nil || len(features) != 0 {
+		t.Errorf("Expected an empty slice, got %#v, %v", features, err)
+	}
+	// No candidates.
+	features, err = idx.Query(&primitives.Rect{Min: primitives.Point{5, 5}, Max: primitives.Point{10, 10}}, q)
+	if err != nil || features != nil {
+		t.Errorf("Expected nil, got %#v, %v", features, err)
+	}
+}
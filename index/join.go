@@ -0,0 +1,356 @@
+package index
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geo"
+	"github.com/bilus/fencer/primitives"
+)
+
+// joinNodeSize is the maximum number of entries of a node of the trees
+// Join builds.
+const joinNodeSize = 16
+
+// JoinPredicate decides which pairs of features from two indexes are joined.
+type JoinPredicate[FA, FB any] interface {
+	// Window returns the bounding box the matching features of the right index
+	// must intersect. Returning nil skips the feature.
+	Window(a FA) (*primitives.Rect, error)
+	// Match is the exact predicate applied to pairs whose bounding boxes passed
+	// the Window test.
+	Match(a FA, b FB) (bool, error)
+}
+
+type bounded interface {
+	Bounds() *primitives.Rect
+}
+
+type containing interface {
+	bounded
+	Contains(point primitives.Point) (bool, error)
+}
+
+type intersects[FA, FB bounded] struct{}
+
+// Intersects returns a predicate joining features whose bounding boxes
+// intersect. It's a bounding box test only; geometries aren't compared.
+func Intersects[FA, FB bounded]() JoinPredicate[FA, FB] {
+	return intersects[FA, FB]{}
+}
+
+func (intersects[FA, FB]) Window(a FA) (*primitives.Rect, error) {
+	return a.Bounds(), nil
+}
+
+func (intersects[FA, FB]) Match(a FA, b FB) (bool, error) {
+	return true, nil
+}
+
+type contains[FA containing, FB any] struct {
+	point func(FB) primitives.Point
+}
+
+// Contains returns a predicate joining features of the left index with the
+// features of the right index whose location, as returned by point, they
+// contain.
+func Contains[FA containing, FB any](point func(b FB) primitives.Point) JoinPredicate[FA, FB] {
+	return contains[FA, FB]{point}
+}
+
+func (c contains[FA, FB]) Window(a FA) (*primitives.Rect, error) {
+	return a.Bounds(), nil
+}
+
+func (c contains[FA, FB]) Match(a FA, b FB) (bool, error) {
+	return a.Contains(c.point(b))
+}
+
+type withinDistance[FA, FB any] struct {
+	distance float64
+	pointA   func(FA) primitives.Point
+	pointB   func(FB) primitives.Point
+}
+
+// WithinDistance returns a predicate joining features whose locations are at
+// most distance meters apart.
+func WithinDistance[FA, FB any](distance float64, pointA func(a FA) primitives.Point, pointB func(b FB) primitives.Point) JoinPredicate[FA, FB] {
+	return withinDistance[FA, FB]{distance, pointA, pointB}
+}
+
+func (w withinDistance[FA, FB]) Window(a FA) (*primitives.Rect, error) {
+	return geo.NewBoundsAround(w.pointA(a), w.distance)
+}
+
+func (w withinDistance[FA, FB]) Match(a FA, b FB) (bool, error) {
+	return geo.Distance(w.pointA(a), w.pointB(b)) <= w.distance, nil
+}
+
+type joinPair[FA, FB any] struct {
+	a FA
+	b FB
+}
+
+// Join performs a spatial join of two indexes, calling fn for each pair of
+// features matching the predicate.
+//
+// Join packs the features of each index into a temporary R-tree, keyed by
+// their predicate windows on the left and by their bounding boxes on the
+// right, and descends both trees in step, following only pairs of nodes
+// whose rectangles intersect. Building the trees takes O(n log n) time and
+// O(n) memory for n features of both indexes. The descent skips pairs of
+// nodes that can't contain matches, so for spread out features its cost
+// grows with the number of pairs with intersecting rectangles rather than
+// with the product of index sizes. Pairs of leaves reached by the descent
+// are matched in parallel but fn is always called from the calling goroutine
+// so it doesn't need to be thread-safe. Pairs are passed in no particular
+// order. Join stops at the first error returned by the predicate or fn.
+//
+// Neither index may be modified while Join is running.
+func Join[KA feature.Key, FA feature.Feature[KA], KB feature.Key, FB feature.Feature[KB]](
+	a *Index[KA, FA], b *Index[KB, FB], predicate JoinPredicate[FA, FB], fn func(a FA, b FB) error,
+) error {
+	leaves := make(chan joinLeaves[FA, FB])
+	pairs := make(chan joinPair[FA, FB])
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(done) })
+	}
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	var workers sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for l := range leaves {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := l.join(predicate, pairs, done); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(leaves)
+		leftEntries, err := joinEntries(a, predicate.Window)
+		if err != nil {
+			fail(err)
+			return
+		}
+		left := packJoinTree(leftEntries)
+		rightEntries, _ := joinEntries(b, func(f FB) (*primitives.Rect, error) {
+			return f.Bounds(), nil
+		})
+		right := packJoinTree(rightEntries)
+		if left != nil && right != nil {
+			descend(left, right, leaves, done)
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(pairs)
+	}()
+
+	for pair := range pairs {
+		if err := fn(pair.a, pair.b); err != nil {
+			fail(err)
+			break
+		}
+	}
+	cancel()
+	for range pairs {
+		// Drain so workers can exit.
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// joinEntry is a feature with the rectangle it's joined by.
+type joinEntry[F any] struct {
+	rect    primitives.Rect
+	feature F
+}
+
+// joinNode is a node of a tree built by Join. Leaves hold entries, other
+// nodes hold children.
+type joinNode[F any] struct {
+	rect     primitives.Rect
+	height   int // 0 for leaves.
+	entries  []joinEntry[F]
+	children []*joinNode[F]
+}
+
+// joinEntries returns entries for features of an index, skipping features
+// whose rectangle is nil.
+func joinEntries[K feature.Key, F feature.Feature[K]](
+	index *Index[K, F], rectOf func(F) (*primitives.Rect, error),
+) ([]joinEntry[F], error) {
+	entries := make([]joinEntry[F], 0, index.rtree.Len())
+	var err error
+	index.rtree.Scan(func(min, max primitives.Point, f F) bool {
+		var rect *primitives.Rect
+		rect, err = rectOf(f)
+		if err != nil {
+			return false
+		}
+		if rect != nil {
+			entries = append(entries, joinEntry[F]{*rect, f})
+		}
+		return true
+	})
+	return entries, err
+}
+
+// packJoinTree builds a tree of entries using Sort-Tile-Recursive packing.
+// It returns nil if there are no entries.
+func packJoinTree[F any](entries []joinEntry[F]) *joinNode[F] {
+	if len(entries) == 0 {
+		return nil
+	}
+	var nodes []*joinNode[F]
+	for _, tile := range packTiles(entries, func(e joinEntry[F]) primitives.Rect { return e.rect }) {
+		node := &joinNode[F]{rect: tile[0].rect, entries: tile}
+		for _, e := range tile[1:] {
+			node.rect = union(node.rect, e.rect)
+		}
+		nodes = append(nodes, node)
+	}
+	for len(nodes) > 1 {
+		var parents []*joinNode[F]
+		for _, tile := range packTiles(nodes, func(n *joinNode[F]) primitives.Rect { return n.rect }) {
+			parent := &joinNode[F]{rect: tile[0].rect, height: tile[0].height + 1, children: tile}
+			for _, child := range tile[1:] {
+				parent.rect = union(parent.rect, child.rect)
+			}
+			parents = append(parents, parent)
+		}
+		nodes = parents
+	}
+	return nodes[0]
+}
+
+// packTiles groups items into tiles of up to joinNodeSize items close to
+// each other: items are sorted into vertical slices by the x coordinate of
+// their centers, then each slice is cut into tiles by the y coordinate.
+func packTiles[T any](items []T, rectOf func(T) primitives.Rect) [][]T {
+	center := func(item T, axis int) float64 {
+		rect := rectOf(item)
+		return rect.Min[axis] + (rect.Max[axis]-rect.Min[axis])/2
+	}
+	numTiles := (len(items) + joinNodeSize - 1) / joinNodeSize
+	sliceSize := int(math.Ceil(math.Sqrt(float64(numTiles)))) * joinNodeSize
+	sort.Slice(items, func(i, j int) bool { return center(items[i], 0) < center(items[j], 0) })
+	tiles := make([][]T, 0, numTiles)
+	for start := 0; start < len(items); start += sliceSize {
+		slice := items[start:minInt(start+sliceSize, len(items))]
+		sort.Slice(slice, func(i, j int) bool { return center(slice[i], 1) < center(slice[j], 1) })
+		for i := 0; i < len(slice); i += joinNodeSize {
+			tiles = append(tiles, slice[i:minInt(i+joinNodeSize, len(slice))])
+		}
+	}
+	return tiles
+}
+
+// joinLeaves is a pair of leaves whose rectangles intersect.
+type joinLeaves[FA, FB any] struct {
+	a *joinNode[FA]
+	b *joinNode[FB]
+}
+
+// descend sends pairs of intersecting leaves of two trees, descending into
+// the children of the higher of two intersecting nodes. It returns false if
+// cancelled.
+func descend[FA, FB any](a *joinNode[FA], b *joinNode[FB], leaves chan<- joinLeaves[FA, FB], done <-chan struct{}) bool {
+	if !intersect(a.rect, b.rect) {
+		return true
+	}
+	switch {
+	case a.height == 0 && b.height == 0:
+		select {
+		case leaves <- joinLeaves[FA, FB]{a, b}:
+			return true
+		case <-done:
+			return false
+		}
+	case a.height >= b.height:
+		for _, child := range a.children {
+			if !descend(child, b, leaves, done) {
+				return false
+			}
+		}
+	default:
+		for _, child := range b.children {
+			if !descend(a, child, leaves, done) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// join sends pairs of entries of the leaves matching a predicate.
+func (l joinLeaves[FA, FB]) join(predicate JoinPredicate[FA, FB], pairs chan<- joinPair[FA, FB], done <-chan struct{}) error {
+	for _, a := range l.a.entries {
+		if !intersect(a.rect, l.b.rect) {
+			continue
+		}
+		for _, b := range l.b.entries {
+			if !intersect(a.rect, b.rect) {
+				continue
+			}
+			isMatch, err := predicate.Match(a.feature, b.feature)
+			if err != nil {
+				return err
+			}
+			if !isMatch {
+				continue
+			}
+			select {
+			case pairs <- joinPair[FA, FB]{a.feature, b.feature}:
+			case <-done:
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func intersect(r1, r2 primitives.Rect) bool {
+	return r1.Min[0] <= r2.Max[0] && r1.Max[0] >= r2.Min[0] &&
+		r1.Min[1] <= r2.Max[1] && r1.Max[1] >= r2.Min[1]
+}
+
+func union(r1, r2 primitives.Rect) primitives.Rect {
+	return primitives.Rect{
+		Min: primitives.Point{math.Min(r1.Min[0], r2.Min[0]), math.Min(r1.Min[1], r2.Min[1])},
+		Max: primitives.Point{math.Max(r1.Max[0], r2.Max[0]), math.Max(r1.Max[1], r2.Max[1])},
+	}
+}
@@ -0,0 +1,170 @@
+package index_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/JamesMilnerUK/pip-go"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+// StoreID uniquely identifies a store.
+type StoreID string
+
+func (id StoreID) String() string {
+	return string(id)
+}
+
+// Store is a point feature.
+type Store struct {
+	ID       StoreID
+	Location primitives.Point
+}
+
+func (s *Store) Bounds() *primitives.Rect {
+	return &primitives.Rect{Min: s.Location, Max: s.Location}
+}
+
+func (s *Store) Contains(point primitives.Point) (bool, error) {
+	return s.Location == point, nil
+}
+
+func (s *Store) Key() StoreID {
+	return s.ID
+}
+
+func storeLocation(s *Store) primitives.Point {
+	return s.Location
+}
+
+// This example uses an example spatial feature implementation.
+// See https://github.com/bilus/fencer/blob/master/index/index_test.go for more details.
+func ExampleJoin() {
+	wroclaw, _ := NewCity("wrocław", "Wrocław", 638384, pip.Polygon{Points: wroclawBoundaries})
+	szczecin, _ := NewCity("szczecin", "Szczecin", 407811, pip.Polygon{Points: szczecinBoundaries})
+	cities, _ := index.New[CityID]([]*City{&wroclaw, &szczecin})
+	stores, _ := index.New[StoreID]([]*Store{
+		{"rynek", primitives.Point{17.032, 51.110}},
+		{"waly-chrobrego", primitives.Point{14.565, 53.429}},
+		{"berlin", primitives.Point{13.404, 52.520}},
+	})
+	matches := make([]string, 0)
+	_ = index.Join(cities, stores, index.Contains[*City](storeLocation), func(city *City, store *Store) error {
+		matches = append(matches, fmt.Sprintf("%v in %v", store.ID, city.Name))
+		return nil
+	})
+	sort.Strings(matches)
+	for _, match := range matches {
+		fmt.Println(match)
+	}
+	// Output:
+	// rynek in Wrocław
+	// waly-chrobrego in Szczecin
+}
+
+func TestJoin_withinDistance(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomStores := func(prefix string, n int) []*Store {
+		stores := make([]*Store, n)
+		for i := range stores {
+			stores[i] = &Store{
+				ID:       StoreID(fmt.Sprintf("%v%d", prefix, i)),
+				Location: primitives.Point{16.9 + rnd.Float64()*0.2, 51.0 + rnd.Float64()*0.2},
+			}
+		}
+		return stores
+	}
+	as, bs := randomStores("a", 500), randomStores("b", 500)
+	a, _ := index.New[StoreID](as)
+	b, _ := index.New[StoreID](bs)
+	distance := 1000.0
+	predicate := index.WithinDistance[*Store, *Store](distance, storeLocation, storeLocation)
+
+	expected := make(map[string]struct{})
+	for _, sa := range as {
+		for _, sb := range bs {
+			if ok, _ := predicate.Match(sa, sb); ok {
+				expected[string(sa.ID+"-"+sb.ID)] = struct{}{}
+			}
+		}
+	}
+	actual := make(map[string]struct{})
+	err := index.Join(a, b, predicate, func(sa *Store, sb *Store) error {
+		pair := string(sa.ID + "-" + sb.ID)
+		if _, ok := actual[pair]; ok {
+			t.Errorf("Duplicate pair %v", pair)
+		}
+		actual[pair] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) == 0 || len(actual) != len(expected) {
+		t.Fatalf("Expected %d pairs, got %d", len(expected), len(actual))
+	}
+	for pair := range expected {
+		if _, ok := actual[pair]; !ok {
+			t.Errorf("Missing pair %v", pair)
+		}
+	}
+}
+
+func TestJoin_unevenIndexes(t *testing.T) {
+	grid := func(prefix string, n int, step float64) []*Store {
+		stores := make([]*Store, n)
+		for i := range stores {
+			stores[i] = &Store{StoreID(fmt.Sprintf("%v%d", prefix, i)), primitives.Point{float64(i%30) * step, float64(i/30) * step}}
+		}
+		return stores
+	}
+	// Trees of different heights.
+	for _, sizes := range [][2]int{{1, 900}, {900, 3}, {40, 600}} {
+		as, bs := grid("a", sizes[0], 1), grid("b", sizes[1], 0.5)
+		a, _ := index.New[StoreID](as)
+		b, _ := index.New[StoreID](bs)
+		expected := 0
+		for _, sa := range as {
+			for _, sb := range bs {
+				if sa.Location == sb.Location {
+					expected++
+				}
+			}
+		}
+		actual := 0
+		err := index.Join(a, b, index.Intersects[*Store, *Store](), func(sa, sb *Store) error {
+			if sa.Location != sb.Location {
+				t.Errorf("Unexpected pair %v, %v", sa.ID, sb.ID)
+			}
+			actual++
+			return nil
+		})
+		if err != nil || expected == 0 || actual != expected {
+			t.Errorf("%v: Expected %d pairs, got %d (%v)", sizes, expected, actual, err)
+		}
+	}
+}
+
+func TestJoin_stopsOnError(t *testing.T) {
+	stores := make([]*Store, 1000)
+	for i := range stores {
+		stores[i] = &Store{StoreID(fmt.Sprint(i)), primitives.Point{float64(i % 10), float64(i / 10)}}
+	}
+	a, _ := index.New[StoreID](stores)
+	b, _ := index.New[StoreID](stores)
+	calls := 0
+	stop := fmt.Errorf("stop")
+	err := index.Join(a, b, index.Intersects[*Store, *Store](), func(*Store, *Store) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("Expected error %v, got %v", stop, err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected fn to be called once, got %d", calls)
+	}
+}
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geo"
+	"github.com/bilus/fencer/primitives"
+)
+
+// Neighbour is a feature found by Nearest.
+type Neighbour[F any] struct {
+	Feature F
+	// Distance is the geodesic distance in meters.
+	Distance float64
+}
+
+// Nearest returns up to k features nearest to a point (longitude, latitude),
+// closest first, that are at most maxDistance meters away. The distance to a
+// feature implementing feature.ClosestPointer is measured to the point it
+// returns, to other features to the closest point of their bounding
+// rectangles.
+func (index *Index[K, F]) Nearest(point primitives.Point, k int, maxDistance float64) []Neighbour[F] {
+	var neighbours []Neighbour[F]
+	if k <= 0 {
+		return neighbours
+	}
+	// Bounding rectangles are visited nearest first; a feature's distance is
+	// at least that of its rectangle, so the search stops once rectangles are
+	// farther than the k-th nearest feature found.
+	index.Nearby(point, geo.Distance, func(f F, boxDistance float64) bool {
+		if boxDistance > maxDistance || len(neighbours) >= k && boxDistance > neighbours[k-1].Distance {
+			return false
+		}
+		distance := boxDistance
+		if closest, ok := any(f).(feature.ClosestPointer); ok {
+			distance = geo.Distance(point, closest.ClosestPoint(point))
+		}
+		if distance > maxDistance {
+			return true
+		}
+		i := sort.Search(len(neighbours), func(i int) bool { return neighbours[i].Distance > distance })
+		neighbours = append(neighbours, Neighbour[F]{})
+		copy(neighbours[i+1:], neighbours[i:])
+		neighbours[i] = Neighbour[F]{f, distance}
+		return true
+	})
+	if len(neighbours) > k {
+		neighbours = neighbours[:k]
+	}
+	return neighbours
+}
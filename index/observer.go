@@ -0,0 +1,45 @@
+package index
+
+import (
+	"time"
+
+	"github.com/bilus/fencer/query"
+)
+
+// Observer is notified of index operations, e.g. to collect metrics. An
+// observer also implementing query.Observer observes queries that don't have
+// their own observer. Observers of indexes read concurrently must be safe for
+// concurrent use.
+type Observer interface {
+	// Mutated is called after Insert, Delete or Update.
+	Mutated(op Op, duration time.Duration, err error)
+	// Queried is called after Query (also used by FindContaining and
+	// Intersect) with the number of features whose bounding rectangles
+	// intersect the query's and the number of features returned.
+	Queried(duration time.Duration, candidates, matches int, err error)
+}
+
+// SetObserver attaches an observer; nil detaches the current one.
+func (index *Index[K, F]) SetObserver(observer Observer) {
+	index.observer = observer
+}
+
+// Observer returns the attached observer or nil.
+func (index *Index[K, F]) Observer() Observer {
+	return index.observer
+}
+
+func (index *Index[K, F]) mutated(op Op, start time.Time, err *error) {
+	index.observer.Mutated(op, time.Since(start), *err)
+}
+
+// SetTracer attaches a tracer used by queries without their own; nil
+// detaches the current one.
+func (index *Index[K, F]) SetTracer(tracer query.Tracer) {
+	index.tracer = tracer
+}
+
+// Tracer returns the attached tracer or nil.
+func (index *Index[K, F]) Tracer() query.Tracer {
+	return index.tracer
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// histogram counts observations in buckets with the given upper bounds and
+// an implicit +Inf one.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Non-cumulative.
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// labels holds alternating names and values.
+type labels []string
+
+// exposition writes the Prometheus text format, remembering the first error.
+type exposition struct {
+	w         *bufio.Writer
+	namespace string
+	err       error
+}
+
+func (e *exposition) write(parts ...string) {
+	for _, part := range parts {
+		if e.err != nil {
+			return
+		}
+		_, e.err = e.w.WriteString(part)
+	}
+}
+
+func (e *exposition) header(name, kind, help string) {
+	name = e.namespace + "_" + name
+	e.write("# HELP ", name, " ", help, "\n# TYPE ", name, " ", kind, "\n")
+}
+
+func (e *exposition) sample(name string, l labels, value float64) {
+	e.write(e.namespace, "_", name)
+	if len(l) > 0 {
+		e.write("{")
+		for i := 0; i < len(l); i += 2 {
+			if i > 0 {
+				e.write(",")
+			}
+			e.write(l[i], `="`, escape(l[i+1]), `"`)
+		}
+		e.write("}")
+	}
+	e.write(" ", formatFloat(value), "\n")
+}
+
+func (e *exposition) histogram(name string, l labels, h *histogram) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		e.sample(name+"_bucket", append(l[:len(l):len(l)], "le", formatFloat(bound)), float64(cumulative))
+	}
+	e.sample(name+"_bucket", append(l[:len(l):len(l)], "le", "+Inf"), float64(h.count))
+	e.sample(name+"_sum", l, h.sum)
+	e.sample(name+"_count", l, float64(h.count))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
@@ -0,0 +1,192 @@
+// Package metrics collects index and query metrics and exports them in the
+// Prometheus text format, without depending on a metrics library.
+//
+// Attach Metrics to an index with Index.SetObserver and serve it, e.g. at
+// /metrics:
+//
+//	m := metrics.New(metrics.Options{})
+//	idx.SetObserver(m)
+//	http.Handle("/metrics", m)
+//
+// Exported metrics, prefixed with the namespace:
+//
+//	index_mutations_total{op, result}            counter
+//	index_mutation_duration_seconds{op}          histogram
+//	index_queries_total{result}                  counter
+//	index_query_duration_seconds                 histogram
+//	index_query_candidates                       histogram of R-tree candidates
+//	index_query_matches                          histogram of returned features
+//	query_condition_rejections_total{condition}  counter
+//
+// where result is "ok" or "error".
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bilus/fencer/index"
+)
+
+// Default bucket upper bounds.
+var (
+	// DefaultDurationBuckets are in seconds, from 10µs to 1s.
+	DefaultDurationBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+	// DefaultSizeBuckets count features.
+	DefaultSizeBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+// Options configure metrics.
+type Options struct {
+	Namespace       string    // Metric name prefix; defaults to "fencer".
+	DurationBuckets []float64 // Defaults to DefaultDurationBuckets.
+	SizeBuckets     []float64 // Defaults to DefaultSizeBuckets.
+}
+
+// Metrics implements index.Observer and query.Observer, and serves collected
+// metrics over HTTP. It's safe for concurrent use.
+type Metrics struct {
+	options Options
+
+	mu                sync.Mutex
+	mutations         map[[2]string]uint64 // By op and result.
+	mutationDurations map[string]*histogram
+	queries           map[string]uint64 // By result.
+	queryDuration     *histogram
+	candidates        *histogram
+	matches           *histogram
+	rejections        map[string]uint64 // By condition.
+}
+
+var _ index.Observer = (*Metrics)(nil)
+
+// New creates metrics.
+func New(options Options) *Metrics {
+	if options.Namespace == "" {
+		options.Namespace = "fencer"
+	}
+	if len(options.DurationBuckets) == 0 {
+		options.DurationBuckets = DefaultDurationBuckets
+	}
+	if len(options.SizeBuckets) == 0 {
+		options.SizeBuckets = DefaultSizeBuckets
+	}
+	return &Metrics{
+		options:           options,
+		mutations:         make(map[[2]string]uint64),
+		mutationDurations: make(map[string]*histogram),
+		queries:           make(map[string]uint64),
+		queryDuration:     newHistogram(options.DurationBuckets),
+		candidates:        newHistogram(options.SizeBuckets),
+		matches:           newHistogram(options.SizeBuckets),
+		rejections:        make(map[string]uint64),
+	}
+}
+
+// Mutated implements index.Observer.
+func (m *Metrics) Mutated(op index.Op, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mutations[[2]string{op.String(), result(err)}]++
+	h := m.mutationDurations[op.String()]
+	if h == nil {
+		h = newHistogram(m.options.DurationBuckets)
+		m.mutationDurations[op.String()] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// Queried implements index.Observer.
+func (m *Metrics) Queried(duration time.Duration, candidates, matches int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[result(err)]++
+	m.queryDuration.observe(duration.Seconds())
+	m.candidates.observe(float64(candidates))
+	if err == nil {
+		m.matches.observe(float64(matches))
+	}
+}
+
+// Rejected implements query.Observer.
+func (m *Metrics) Rejected(condition string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejections[condition]++
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ServeHTTP writes metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo writes metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	e := &exposition{w: bw, namespace: m.options.Namespace}
+
+	m.mu.Lock()
+	e.header("index_mutations_total", "counter", "Index mutations.")
+	for _, key := range sortedKeys(m.mutations, func(a, b [2]string) bool {
+		return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+	}) {
+		e.sample("index_mutations_total", labels{"op", key[0], "result", key[1]}, float64(m.mutations[key]))
+	}
+	e.header("index_mutation_duration_seconds", "histogram", "Index mutation latency.")
+	for _, op := range sortedKeys(m.mutationDurations, func(a, b string) bool { return a < b }) {
+		e.histogram("index_mutation_duration_seconds", labels{"op", op}, m.mutationDurations[op])
+	}
+	e.header("index_queries_total", "counter", "Index queries.")
+	for _, r := range sortedKeys(m.queries, func(a, b string) bool { return a < b }) {
+		e.sample("index_queries_total", labels{"result", r}, float64(m.queries[r]))
+	}
+	e.header("index_query_duration_seconds", "histogram", "Index query latency.")
+	e.histogram("index_query_duration_seconds", nil, m.queryDuration)
+	e.header("index_query_candidates", "histogram", "Features with bounding rectangles intersecting the query's.")
+	e.histogram("index_query_candidates", nil, m.candidates)
+	e.header("index_query_matches", "histogram", "Features returned by queries.")
+	e.histogram("index_query_matches", nil, m.matches)
+	e.header("query_condition_rejections_total", "counter", "Features rejected by query conditions.")
+	for _, condition := range sortedKeys(m.rejections, func(a, b string) bool { return a < b }) {
+		e.sample("query_condition_rejections_total", labels{"condition", condition}, float64(m.rejections[condition]))
+	}
+	m.mu.Unlock()
+
+	if e.err == nil {
+		e.err = bw.Flush()
+	}
+	return cw.n, e.err
+}
+
+func sortedKeys[K comparable, V any](m map[K]V, less func(a, b K) bool) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,124 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/metrics"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/query"
+)
+
+type large struct{}
+
+func (large) IsMatch(f *geometry.Feature) (bool, error) {
+	return f.Properties["size"] == "large", nil
+}
+
+func (large) String() string {
+	return "large"
+}
+
+func Example() {
+	m := metrics.New(metrics.Options{})
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{})
+	idx.SetObserver(m)
+	_ = idx.Insert(geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{"size": "large"}))
+	_ = idx.Insert(geometry.NewFeature("b", geometry.Point{2, 2}, map[string]any{"size": "small"}))
+	_ = idx.Delete("c")
+	bounds := primitives.Rect{Max: primitives.Point{3, 3}}
+	_, _ = idx.Query(&bounds, query.Build[geometry.ID, *geometry.Feature]().Where(large{}).Query())
+	_, _ = idx.FindContaining(primitives.Point{1, 1})
+
+	var b strings.Builder
+	_, _ = m.WriteTo(&b)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.HasSuffix(line, "_total counter") || strings.Contains(line, "_total{") ||
+			strings.HasPrefix(line, "fencer_index_query_candidates_sum") ||
+			strings.HasPrefix(line, "fencer_index_query_matches_sum") {
+			fmt.Println(line)
+		}
+	}
+	// Output:
+	// # TYPE fencer_index_mutations_total counter
+	// fencer_index_mutations_total{op="delete",result="error"} 1
+	// fencer_index_mutations_total{op="insert",result="ok"} 2
+	// # TYPE fencer_index_queries_total counter
+	// fencer_index_queries_total{result="ok"} 2
+	// fencer_index_query_candidates_sum 3
+	// fencer_index_query_matches_sum 2
+	// # TYPE fencer_query_condition_rejections_total counter
+	// fencer_query_condition_rejections_total{condition="large"} 1
+}
+
+func ExampleMetrics_WriteTo() {
+	m := metrics.New(metrics.Options{
+		Namespace:       "geo",
+		DurationBuckets: []float64{0.001, 0.01},
+		SizeBuckets:     []float64{10},
+	})
+	m.Queried(5*time.Millisecond, 20, 3, nil)
+	m.Queried(50*time.Millisecond, 1, 0, errors.New("Oops"))
+	m.Rejected(`say "hi"`)
+	_, _ = m.WriteTo(os.Stdout)
+	// Output:
+	// # HELP geo_index_mutations_total Index mutations.
+	// # TYPE geo_index_mutations_total counter
+	// # HELP geo_index_mutation_duration_seconds Index mutation latency.
+	// # TYPE geo_index_mutation_duration_seconds histogram
+	// # HELP geo_index_queries_total Index queries.
+	// # TYPE geo_index_queries_total counter
+	// geo_index_queries_total{result="error"} 1
+	// geo_index_queries_total{result="ok"} 1
+	// # HELP geo_index_query_duration_seconds Index query latency.
+	// # TYPE geo_index_query_duration_seconds histogram
+	// geo_index_query_duration_seconds_bucket{le="0.001"} 0
+	// geo_index_query_duration_seconds_bucket{le="0.01"} 1
+	// geo_index_query_duration_seconds_bucket{le="+Inf"} 2
+	// geo_index_query_duration_seconds_sum 0.055
+	// geo_index_query_duration_seconds_count 2
+	// # HELP geo_index_query_candidates Features with bounding rectangles intersecting the query's.
+	// # TYPE geo_index_query_candidates histogram
+	// geo_index_query_candidates_bucket{le="10"} 1
+	// geo_index_query_candidates_bucket{le="+Inf"} 2
+	// geo_index_query_candidates_sum 21
+	// geo_index_query_candidates_count 2
+	// # HELP geo_index_query_matches Features returned by queries.
+	// # TYPE geo_index_query_matches histogram
+	// geo_index_query_matches_bucket{le="10"} 1
+	// geo_index_query_matches_bucket{le="+Inf"} 1
+	// geo_index_query_matches_sum 3
+	// geo_index_query_matches_count 1
+	// # HELP geo_query_condition_rejections_total Features rejected by query conditions.
+	// # TYPE geo_query_condition_rejections_total counter
+	// geo_query_condition_rejections_total{condition="say \"hi\""} 1
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := metrics.New(metrics.Options{})
+	m.Mutated(index.OpUpdate, time.Millisecond, nil)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`fencer_index_mutations_total{op="update",result="ok"} 1`,
+		`fencer_index_mutation_duration_seconds_bucket{op="update",le="0.001"} 1`,
+		`fencer_index_mutation_duration_seconds_bucket{op="update",le="0.00025"} 0`,
+		`fencer_index_mutation_duration_seconds_count{op="update"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers sent with each webhook.
+const (
+	SignatureHeader = "X-Fencer-Signature"
+	EventHeader     = "X-Fencer-Event"
+	DeliveryHeader  = "X-Fencer-Delivery"
+)
+
+// Sign returns the signature of a payload: "sha256=" followed by the
+// hex-encoded HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature returned by Sign in constant time.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// statusError is a non-2xx response.
+type statusError struct {
+	status int
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("Unexpected status %d", err.status)
+}
+
+// retryable reports whether a delivery failing with err may succeed later.
+func retryable(err error) bool {
+	statusErr, ok := err.(*statusError)
+	if !ok {
+		return true
+	}
+	return statusErr.status == http.StatusRequestTimeout ||
+		statusErr.status == http.StatusTooManyRequests ||
+		statusErr.status >= 500
+}
+
+// deliver posts an event, retrying with backoff, and moves it to the
+// dead-letter store if all attempts fail.
+func (n *Notifier[K, F]) deliver(ctx context.Context, d *delivery) {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		n.deadLetter(d, 0, err)
+		return
+	}
+	backoff := n.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(ctx, d, body)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil || attempt >= n.options.MaxAttempts || !retryable(err) {
+			n.deadLetter(d, attempt, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			n.deadLetter(d, attempt, err)
+			return
+		}
+		if backoff *= 2; backoff > n.options.MaxBackoff {
+			backoff = n.options.MaxBackoff
+		}
+	}
+}
+
+func (n *Notifier[K, F]) post(ctx context.Context, d *delivery, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(d.event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(d.id, 10))
+	if d.endpoint.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.endpoint.Secret, body))
+	}
+	resp, err := n.options.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{resp.StatusCode}
+	}
+	return nil
+}
+
+// DeadLetter is an event that couldn't be delivered.
+type DeadLetter struct {
+	Endpoint string // The endpoint URL.
+	Event    Event
+	Attempts int
+	Err      string // The last error.
+	Time     time.Time
+}
+
+// DeadLetterStore keeps undelivered events.
+type DeadLetterStore interface {
+	Add(letter DeadLetter) error
+}
+
+// MemoryDeadLetters keeps dead letters in memory. The zero value is ready to
+// use.
+type MemoryDeadLetters struct {
+	// Limit caps the number of letters kept, dropping the oldest; unlimited
+	// if zero.
+	Limit int
+
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// Add stores a letter.
+func (store *MemoryDeadLetters) Add(letter DeadLetter) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.letters = append(store.letters, letter)
+	if store.Limit > 0 && len(store.letters) > store.Limit {
+		store.letters = append(store.letters[:0:0], store.letters[len(store.letters)-store.Limit:]...)
+	}
+	return nil
+}
+
+// List returns stored letters, oldest first.
+func (store *MemoryDeadLetters) List() []DeadLetter {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return append([]DeadLetter(nil), store.letters...)
+}
+
+// Take removes and returns stored letters, e.g. to redeliver them.
+func (store *MemoryDeadLetters) Take() []DeadLetter {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	letters := store.letters
+	store.letters = nil
+	return letters
+}
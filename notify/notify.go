@@ -0,0 +1,350 @@
+// Package notify sends webhooks when objects enter or leave geofences.
+//
+// A Notifier compares successive FindContaining results for each object and
+// POSTs an Event to every endpoint configured for the fence, either by its id
+// or by one of its tags. Deliveries are queued, retried with exponential
+// backoff and signed with HMAC-SHA256; those that can't be delivered end up
+// in a dead-letter store. Events about one object are delivered in the order
+// they happened.
+package notify
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+// Defaults for Options.
+const (
+	DefaultQueueSize   = 1000
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = time.Minute
+)
+
+// ErrQueueFull is returned by Update when some deliveries didn't fit in the
+// queue; they are moved to the dead-letter store.
+var ErrQueueFull = errors.New("Notification queue full")
+
+// Transition is the type of an event.
+type Transition string
+
+// Transitions.
+const (
+	Enter Transition = "enter"
+	Exit  Transition = "exit"
+)
+
+// Event is the payload of a webhook.
+type Event struct {
+	Type   Transition       `json:"type"`
+	Object string           `json:"object"`
+	Fence  string           `json:"fence"`
+	Tags   []string         `json:"tags,omitempty"`
+	Point  primitives.Point `json:"point"` // The location causing the transition.
+	Time   time.Time        `json:"time"`
+}
+
+// Endpoint receives events for selected fences.
+type Endpoint struct {
+	URL string
+	// Secret signs payloads if set; see Sign.
+	Secret string
+	// Fences and Tags select fences by id or tag; events for all fences are
+	// sent if both are empty.
+	Fences []string
+	Tags   []string
+}
+
+func (endpoint *Endpoint) matches(event *Event) bool {
+	if len(endpoint.Fences) == 0 && len(endpoint.Tags) == 0 {
+		return true
+	}
+	for _, fence := range endpoint.Fences {
+		if fence == event.Fence {
+			return true
+		}
+	}
+	for _, tag := range endpoint.Tags {
+		for _, t := range event.Tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Options configure a notifier.
+type Options[F any] struct {
+	Endpoints []Endpoint
+	// Tags returns the tags of a fence; optional. See PropertyTags.
+	Tags func(fence F) []string
+
+	QueueSize   int           // Pending deliveries, split among workers; defaults to DefaultQueueSize.
+	Workers     int           // Concurrent deliveries, each for its own objects; defaults to 1.
+	MaxAttempts int           // Defaults to DefaultMaxAttempts.
+	Backoff     time.Duration // Delay before the first retry, doubled for each next one.
+	MaxBackoff  time.Duration // Upper bound of the delay.
+
+	Client      *http.Client    // Defaults to http.DefaultClient.
+	DeadLetters DeadLetterStore // Defaults to a MemoryDeadLetters.
+	// OnError is called with errors storing dead letters; optional.
+	OnError func(err error)
+}
+
+// PropertyTags returns a function reading tags of features from a property
+// holding a string or an array of strings.
+func PropertyTags(property string) func(f *geometry.Feature) []string {
+	return func(f *geometry.Feature) []string {
+		switch value := f.Properties[property].(type) {
+		case string:
+			return []string{value}
+		case []string:
+			return value
+		case []any:
+			tags := make([]string, 0, len(value))
+			for _, v := range value {
+				if s, ok := v.(string); ok {
+					tags = append(tags, s)
+				}
+			}
+			return tags
+		default:
+			return nil
+		}
+	}
+}
+
+// Notifier tracks objects and delivers events about them.
+type Notifier[K feature.Key, F feature.Feature[K]] struct {
+	options Options[F]
+	queues  []chan *delivery // One per worker, by object.
+	ids     uint64           // Last delivery id.
+
+	mu     sync.Mutex
+	inside map[string]map[K]F // Fences containing each object.
+}
+
+type delivery struct {
+	id       uint64
+	endpoint *Endpoint
+	event    Event
+}
+
+// New creates a notifier. Events are queued by Update and delivered while Run
+// is running.
+func New[K feature.Key, F feature.Feature[K]](options Options[F]) *Notifier[K, F] {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.DeadLetters == nil {
+		options.DeadLetters = &MemoryDeadLetters{}
+	}
+	queues := make([]chan *delivery, options.Workers)
+	for i := range queues {
+		queues[i] = make(chan *delivery, (options.QueueSize+options.Workers-1)/options.Workers)
+	}
+	return &Notifier[K, F]{
+		options: options,
+		queues:  queues,
+		inside:  make(map[string]map[K]F),
+	}
+}
+
+// queue returns the queue of the worker delivering events about an object.
+func (n *Notifier[K, F]) queue(object string) chan *delivery {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(object))
+	return n.queues[h.Sum32()%uint32(len(n.queues))]
+}
+
+// DeadLetters returns the dead-letter store.
+func (n *Notifier[K, F]) DeadLetters() DeadLetterStore {
+	return n.options.DeadLetters
+}
+
+// Locate finds the fences containing an object's new location and calls
+// Update.
+func (n *Notifier[K, F]) Locate(idx *index.Index[K, F], object string, point primitives.Point) ([]Event, error) {
+	containing, err := idx.FindContaining(point)
+	if err != nil {
+		return nil, err
+	}
+	return n.Update(object, point, containing)
+}
+
+// Update records the fences containing an object at a point and queues
+// events for fences it left or entered since the previous update. Exits come
+// before entries, each ordered by fence id, and events of successive updates
+// of an object are delivered in the order of the updates.
+func (n *Notifier[K, F]) Update(object string, point primitives.Point, containing []F) ([]Event, error) {
+	now := time.Now().UTC()
+	current := make(map[K]F, len(containing))
+	for _, fence := range containing {
+		current[fence.Key()] = fence
+	}
+
+	// Events are queued before unlocking so that concurrent updates of an
+	// object queue them in the order the changes are recorded.
+	n.mu.Lock()
+	previous := n.inside[object]
+	if len(current) == 0 {
+		delete(n.inside, object)
+	} else {
+		n.inside[object] = current
+	}
+	var exits, entries []Event
+	for key, fence := range previous {
+		if _, ok := current[key]; !ok {
+			exits = append(exits, n.event(Exit, object, fence, point, now))
+		}
+	}
+	for key, fence := range current {
+		if _, ok := previous[key]; !ok {
+			entries = append(entries, n.event(Enter, object, fence, point, now))
+		}
+	}
+	sortEvents(exits)
+	sortEvents(entries)
+	events := append(exits, entries...)
+	dropped := n.enqueue(events)
+	n.mu.Unlock()
+
+	for _, d := range dropped {
+		n.deadLetter(d, 0, ErrQueueFull)
+	}
+	if len(dropped) > 0 {
+		return events, ErrQueueFull
+	}
+	return events, nil
+}
+
+// Forget stops tracking an object without sending events.
+func (n *Notifier[K, F]) Forget(object string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.inside, object)
+}
+
+func (n *Notifier[K, F]) event(t Transition, object string, fence F, point primitives.Point, now time.Time) Event {
+	event := Event{Type: t, Object: object, Fence: fence.Key().String(), Point: point, Time: now}
+	if n.options.Tags != nil {
+		event.Tags = n.options.Tags(fence)
+	}
+	return event
+}
+
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool { return events[i].Fence < events[j].Fence })
+}
+
+// enqueue queues deliveries of events, returning those that didn't fit.
+func (n *Notifier[K, F]) enqueue(events []Event) []*delivery {
+	var dropped []*delivery
+	for _, event := range events {
+		for i := range n.options.Endpoints {
+			endpoint := &n.options.Endpoints[i]
+			if !endpoint.matches(&event) {
+				continue
+			}
+			d := &delivery{id: atomic.AddUint64(&n.ids, 1), endpoint: endpoint, event: event}
+			select {
+			case n.queue(event.Object) <- d:
+			default:
+				dropped = append(dropped, d)
+			}
+		}
+	}
+	return dropped
+}
+
+// Redeliver queues a dead letter again, if its endpoint is still configured.
+func (n *Notifier[K, F]) Redeliver(letter DeadLetter) error {
+	for i := range n.options.Endpoints {
+		endpoint := &n.options.Endpoints[i]
+		if endpoint.URL != letter.Endpoint {
+			continue
+		}
+		select {
+		case n.queue(letter.Event.Object) <- &delivery{id: atomic.AddUint64(&n.ids, 1), endpoint: endpoint, event: letter.Event}:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+	return errors.New("Unknown endpoint " + letter.Endpoint)
+}
+
+// Run delivers queued events until ctx is cancelled. Each worker delivers
+// events about its objects one at a time, retries included. Deliveries
+// interrupted or still queued then are moved to the dead-letter store. It
+// returns ctx.Err().
+func (n *Notifier[K, F]) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, queue := range n.queues {
+		wg.Add(1)
+		go func(queue chan *delivery) {
+			defer wg.Done()
+			for {
+				select {
+				case d := <-queue:
+					n.deliver(ctx, d)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(queue)
+	}
+	wg.Wait()
+	for _, queue := range n.queues {
+	drain:
+		for {
+			select {
+			case d := <-queue:
+				n.deadLetter(d, 0, ctx.Err())
+			default:
+				break drain
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (n *Notifier[K, F]) deadLetter(d *delivery, attempts int, err error) {
+	letter := DeadLetter{
+		Endpoint: d.endpoint.URL,
+		Event:    d.event,
+		Attempts: attempts,
+		Err:      err.Error(),
+		Time:     time.Now().UTC(),
+	}
+	if err := n.options.DeadLetters.Add(letter); err != nil && n.options.OnError != nil {
+		n.options.OnError(err)
+	}
+}
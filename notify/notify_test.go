@@ -0,0 +1,327 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/notify"
+	"github.com/bilus/fencer/primitives"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+	event  notify.Event
+}
+
+// receiver is a webhook endpoint responding with statuses in turn, the last
+// one repeated.
+func receiver(t testing.TB, statuses ...int) (*httptest.Server, chan received) {
+	ch := make(chan received, 100)
+	var calls int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event notify.Event
+		_ = json.Unmarshal(body, &event)
+		ch <- received{r.Header, body, event}
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			i := int(atomic.AddInt64(&calls, 1)) - 1
+			if i >= len(statuses) {
+				i = len(statuses) - 1
+			}
+			status = statuses[i]
+		}
+		w.WriteHeader(status)
+	}))
+	if t != nil {
+		t.Cleanup(srv.Close)
+	}
+	return srv, ch
+}
+
+func fences() *index.Index[geometry.ID, *geometry.Feature] {
+	idx, err := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("depot", geometry.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}, map[string]any{"tags": []any{"logistics"}}),
+		geometry.NewFeature("yard", geometry.Polygon{{{5, 5}, {15, 5}, {15, 15}, {5, 15}, {5, 5}}}, map[string]any{"tags": "storage"}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+func start(n interface{ Run(context.Context) error }) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = n.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func wait(t testing.TB, ch chan received) received {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		if t != nil {
+			t.Fatal("timed out waiting for a webhook")
+		}
+		panic("timed out waiting for a webhook")
+	}
+}
+
+func Example() {
+	srv, ch := receiver(nil)
+	defer srv.Close()
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints: []notify.Endpoint{{URL: srv.URL, Secret: "s3cret", Fences: []string{"depot"}}},
+	})
+	defer start(n)()
+
+	idx := fences()
+	for _, point := range []primitives.Point{{2, 2}, {7, 7}, {20, 20}} {
+		events, _ := n.Locate(idx, "truck1", point)
+		for _, event := range events {
+			fmt.Println(event.Type, event.Fence, event.Point)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		r := wait(nil, ch)
+		fmt.Println("Received", r.event.Type, r.event.Fence, notify.Verify("s3cret", r.body, r.header.Get(notify.SignatureHeader)))
+	}
+	// Output:
+	// enter depot [2 2]
+	// enter yard [7 7]
+	// exit depot [20 20]
+	// exit yard [20 20]
+	// Received enter depot true
+	// Received exit depot true
+}
+
+func TestNotifier_routing(t *testing.T) {
+	all, allCh := receiver(t)
+	tagged, taggedCh := receiver(t)
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints: []notify.Endpoint{{URL: all.URL}, {URL: tagged.URL, Tags: []string{"storage"}}},
+		Tags:      notify.PropertyTags("tags"),
+	})
+	defer start(n)()
+
+	idx := fences()
+	if _, err := n.Locate(idx, "truck1", primitives.Point{7, 7}); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		r := wait(t, allCh)
+		got[r.event.Fence] = true
+		if r.header.Get(notify.EventHeader) != "enter" || r.header.Get(notify.SignatureHeader) != "" {
+			t.Errorf("unexpected headers %v", r.header)
+		}
+	}
+	if !got["depot"] || !got["yard"] {
+		t.Errorf("got %v", got)
+	}
+	r := wait(t, taggedCh)
+	if r.event.Fence != "yard" || len(r.event.Tags) != 1 || r.event.Tags[0] != "storage" {
+		t.Errorf("got %+v", r.event)
+	}
+	// Staying inside doesn't send anything.
+	events, err := n.Locate(idx, "truck1", primitives.Point{8, 8})
+	if err != nil || len(events) != 0 {
+		t.Errorf("got %v, %v", events, err)
+	}
+}
+
+func TestNotifier_retries(t *testing.T) {
+	srv, ch := receiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	dead := &notify.MemoryDeadLetters{}
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints:   []notify.Endpoint{{URL: srv.URL}},
+		Backoff:     time.Millisecond,
+		DeadLetters: dead,
+	})
+	defer start(n)()
+
+	if _, err := n.Locate(fences(), "truck1", primitives.Point{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []string
+	for i := 0; i < 3; i++ {
+		deliveries = append(deliveries, wait(t, ch).header.Get(notify.DeliveryHeader))
+	}
+	if deliveries[0] != deliveries[1] || deliveries[1] != deliveries[2] {
+		t.Errorf("expected retries of the same delivery, got %v", deliveries)
+	}
+	if letters := dead.List(); len(letters) != 0 {
+		t.Errorf("got dead letters %v", letters)
+	}
+}
+
+func TestNotifier_deadLetters(t *testing.T) {
+	failing, failingCh := receiver(t, http.StatusInternalServerError)
+	rejecting, rejectingCh := receiver(t, http.StatusBadRequest)
+	dead := &notify.MemoryDeadLetters{}
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints:   []notify.Endpoint{{URL: failing.URL}, {URL: rejecting.URL}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		DeadLetters: dead,
+	})
+	stop := start(n)
+
+	if _, err := n.Locate(fences(), "truck1", primitives.Point{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		wait(t, failingCh)
+	}
+	wait(t, rejectingCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dead.List()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	attempts := map[string]int{}
+	for _, letter := range dead.List() {
+		attempts[letter.Endpoint] = letter.Attempts
+		if letter.Event.Fence != "depot" || letter.Err == "" {
+			t.Errorf("got %+v", letter)
+		}
+	}
+	if attempts[failing.URL] != 3 || attempts[rejecting.URL] != 1 {
+		t.Errorf("got attempts %v", attempts)
+	}
+}
+
+func TestNotifier_queueFull(t *testing.T) {
+	srv, ch := receiver(t)
+	dead := &notify.MemoryDeadLetters{}
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints:   []notify.Endpoint{{URL: srv.URL}},
+		QueueSize:   1,
+		DeadLetters: dead,
+	})
+	// Without Run, deliveries stay queued.
+	events, err := n.Locate(fences(), "truck1", primitives.Point{7, 7})
+	if len(events) != 2 || err != notify.ErrQueueFull {
+		t.Fatalf("got %v, %v", events, err)
+	}
+	letters := dead.Take()
+	if len(letters) != 1 || letters[0].Event.Fence != "yard" {
+		t.Fatalf("got %v", letters)
+	}
+	defer start(n)()
+	if r := wait(t, ch); r.event.Fence != "depot" {
+		t.Errorf("got %+v", r.event)
+	}
+	if err := n.Redeliver(letters[0]); err != nil {
+		t.Fatal(err)
+	}
+	if r := wait(t, ch); r.event.Fence != "yard" {
+		t.Errorf("got %+v", r.event)
+	}
+}
+
+func TestNotifier_ordering(t *testing.T) {
+	srv, ch := receiver(t)
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints: []notify.Endpoint{{URL: srv.URL, Fences: []string{"depot"}}},
+		Workers:   4,
+	})
+	defer start(n)()
+
+	// Concurrent updates of each object move it in or out of the depot;
+	// whatever their order, its events must alternate.
+	idx := fences()
+	var sent int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		object := fmt.Sprint("truck", i/2)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				point := primitives.Point{2, 2}
+				if j%2 == 1 {
+					point = primitives.Point{20, 20}
+				}
+				events, err := n.Locate(idx, object, point)
+				if err != nil {
+					t.Error(err)
+				}
+				atomic.AddInt64(&sent, int64(len(events)))
+			}
+		}()
+	}
+	wg.Wait()
+	last := map[string]notify.Transition{}
+	for i := int64(0); i < atomic.LoadInt64(&sent); i++ {
+		r := wait(t, ch)
+		want := notify.Enter
+		if last[r.event.Object] == notify.Enter {
+			want = notify.Exit
+		}
+		if r.event.Type != want {
+			t.Fatalf("Expected %s of %s, got %s", want, r.event.Object, r.event.Type)
+		}
+		last[r.event.Object] = r.event.Type
+	}
+}
+
+func TestNotifier_stop(t *testing.T) {
+	dead := &notify.MemoryDeadLetters{}
+	n := notify.New[geometry.ID](notify.Options[*geometry.Feature]{
+		Endpoints:   []notify.Endpoint{{URL: "http://127.0.0.1:0"}},
+		DeadLetters: dead,
+	})
+	if _, err := n.Locate(fences(), "truck1", primitives.Point{7, 7}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := n.Run(ctx); err != context.Canceled {
+		t.Errorf("got %v", err)
+	}
+	if letters := dead.List(); len(letters) != 2 {
+		t.Errorf("got %v", letters)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"enter"}`)
+	signature := notify.Sign("secret", body)
+	if !notify.Verify("secret", body, signature) {
+		t.Error("expected a valid signature")
+	}
+	if notify.Verify("other", body, signature) || notify.Verify("secret", []byte("{}"), signature) {
+		t.Error("expected an invalid signature")
+	}
+}
+
+func TestMemoryDeadLetters_limit(t *testing.T) {
+	store := &notify.MemoryDeadLetters{Limit: 2}
+	for _, object := range []string{"a", "b", "c"} {
+		_ = store.Add(notify.DeadLetter{Event: notify.Event{Object: object}})
+	}
+	letters := store.List()
+	if len(letters) != 2 || letters[0].Event.Object != "b" || letters[1].Event.Object != "c" {
+		t.Errorf("got %v", letters)
+	}
+}
@@ -0,0 +1,168 @@
+package query
+
+import (
+	"sort"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// GroupBy returns a mapper replacing result keys of a match with the key
+// returned by fn. A nil key rejects the match.
+func GroupBy[K feature.Key, F feature.Feature[K]](fn func(feature F) ResultKey) Mapper[K, F] {
+	return groupBy[K, F](fn)
+}
+
+type groupBy[K feature.Key, F feature.Feature[K]] func(feature F) ResultKey
+
+func (fn groupBy[K, F]) Map(match *Match[K, F]) (*Match[K, F], error) {
+	key := fn(match.Feature)
+	if key == nil {
+		match.ReplaceKeys()
+	} else {
+		match.ReplaceKeys(key)
+	}
+	return match, nil
+}
+
+// Count returns a reducer counting matches for each result key. The count
+// is stored in ResultEntry.Meta as an int.
+func Count[K feature.Key, F feature.Feature[K]]() Reducer[K, F] {
+	return count[K, F]{}
+}
+
+type count[K feature.Key, F feature.Feature[K]] struct{}
+
+func (count[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	return reduceEach(result, match, func(entry *ResultEntry[K, F]) {
+		n, _ := entry.Meta.(int)
+		entry.Meta = n + 1
+	})
+}
+
+// Sum returns a reducer summing values of matches for each result key. The
+// sum is stored in ResultEntry.Meta as a float64.
+func Sum[K feature.Key, F feature.Feature[K]](value func(feature F) float64) Reducer[K, F] {
+	return sum[K, F](value)
+}
+
+type sum[K feature.Key, F feature.Feature[K]] func(feature F) float64
+
+func (value sum[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	v := value(match.Feature)
+	return reduceEach(result, match, func(entry *ResultEntry[K, F]) {
+		total, _ := entry.Meta.(float64)
+		entry.Meta = total + v
+	})
+}
+
+// Mean is a running average.
+type Mean struct {
+	Sum   float64
+	Count int
+}
+
+// Value returns the average or 0 if there are no values.
+func (mean Mean) Value() float64 {
+	if mean.Count == 0 {
+		return 0
+	}
+	return mean.Sum / float64(mean.Count)
+}
+
+// Avg returns a reducer averaging values of matches for each result key.
+// The average is stored in ResultEntry.Meta as a Mean.
+func Avg[K feature.Key, F feature.Feature[K]](value func(feature F) float64) Reducer[K, F] {
+	return avg[K, F](value)
+}
+
+type avg[K feature.Key, F feature.Feature[K]] func(feature F) float64
+
+func (value avg[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	v := value(match.Feature)
+	return reduceEach(result, match, func(entry *ResultEntry[K, F]) {
+		mean, _ := entry.Meta.(Mean)
+		entry.Meta = Mean{Sum: mean.Sum + v, Count: mean.Count + 1}
+	})
+}
+
+// MinBy returns a reducer keeping the feature ordered first by less for each
+// result key. Of equal features, the first one is kept.
+func MinBy[K feature.Key, F feature.Feature[K]](less func(a, b F) bool) Reducer[K, F] {
+	return TopK[K](1, less)
+}
+
+// MaxBy returns a reducer keeping the feature ordered last by less for each
+// result key. Of equal features, the first one is kept.
+func MaxBy[K feature.Key, F feature.Feature[K]](less func(a, b F) bool) Reducer[K, F] {
+	return TopK[K](1, func(a, b F) bool { return less(b, a) })
+}
+
+// TopK returns a reducer keeping up to k features ordered first by less for
+// each result key, in this order. Of equal features, the earlier ones are
+// kept.
+func TopK[K feature.Key, F feature.Feature[K]](k int, less func(a, b F) bool) Reducer[K, F] {
+	return topK[K, F]{k: k, less: less}
+}
+
+type topK[K feature.Key, F feature.Feature[K]] struct {
+	k    int
+	less func(a, b F) bool
+}
+
+func (top topK[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	f := match.Feature
+	return reduceEach(result, match, func(entry *ResultEntry[K, F]) {
+		features := entry.Features
+		i := sort.Search(len(features), func(i int) bool { return top.less(f, features[i]) })
+		if i >= top.k {
+			return
+		}
+		if len(features) < top.k {
+			features = append(features, f)
+		}
+		copy(features[i+1:], features[i:])
+		features[i] = f
+		entry.Features = features
+	})
+}
+
+// Collect returns a reducer keeping all matching features for each result
+// key.
+func Collect[K feature.Key, F feature.Feature[K]]() Reducer[K, F] {
+	return collect[K, F]{}
+}
+
+type collect[K feature.Key, F feature.Feature[K]] struct{}
+
+func (collect[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	return reduceEach(result, match, func(entry *ResultEntry[K, F]) {
+		entry.Features = append(entry.Features, match.Feature)
+	})
+}
+
+// reduceEach updates the entry of each result key of a match.
+func reduceEach[K feature.Key, F feature.Feature[K]](result *Result[K, F], match *Match[K, F], update func(entry *ResultEntry[K, F])) error {
+	for _, key := range match.ResultKeys {
+		err := result.Update(key, func(entry *ResultEntry[K, F]) error {
+			update(entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Values returns values of type V stored in ResultEntry.Meta by reducers
+// such as Count, Sum and Avg, by result key. Entries with metadata of other
+// types are skipped.
+func Values[V any, K feature.Key, F feature.Feature[K]](q Query[K, F]) map[ResultKey]V {
+	values := make(map[ResultKey]V)
+	for key, entry := range q.results.entries {
+		if value, ok := Meta[V](entry); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
@@ -3,7 +3,8 @@ package query
 import "github.com/bilus/fencer/feature"
 
 type QueryBuilder[K feature.Key, F feature.Feature[K]] struct {
-	query Query[K, F]
+	plan  Plan[K, F]
+	order *order[K, F]
 }
 
 // Build returns a new query builder.
@@ -11,29 +12,124 @@ func Build[K feature.Key, F feature.Feature[K]]() *QueryBuilder[K, F] {
 	return &QueryBuilder[K, F]{}
 }
 
-// Query returns a complete constructed query.
-func (builder *QueryBuilder[K, F]) Query() Query[K, F] {
-	if len(builder.query.Conditions) == 0 {
-		builder.Where(defaultFilter[K, F]{})
+// Plan returns a complete constructed plan. Conditions are normalized (see
+// Normalize), so cheap conditions are evaluated first. Later changes to the
+// builder don't affect the plan.
+func (builder *QueryBuilder[K, F]) Plan() Plan[K, F] {
+	plan := builder.plan
+	plan.Conditions = normalizeGroup(builder.plan.Conditions, func(condition Condition[K, F]) ([]Condition[K, F], bool) {
+		nested, ok := condition.(AllOf[K, F])
+		return nested, ok
+	})
+	if len(plan.Conditions) == 0 {
+		plan.Conditions = []Condition[K, F]{defaultFilter[K, F]{}}
+	}
+	if builder.order != nil {
+		order := *builder.order
+		plan.order = &order
 	}
-	if len(builder.query.Aggregators) == 0 {
-		builder.Aggregate(defaultAggregator[K, F]{})
+	plan.Aggregators = make([]Aggregator[K, F], len(builder.plan.Aggregators))
+	for i, aggregator := range builder.plan.Aggregators {
+		if stream, ok := aggregator.(*StreamAggregator[K, F]); ok {
+			// Streams are changed by their builders.
+			aggregator = &StreamAggregator[K, F]{
+				Mappers: append([]Mapper[K, F](nil), stream.Mappers...),
+				Reducer: stream.Reducer,
+			}
+		}
+		plan.Aggregators[i] = aggregator
 	}
-	builder.query.results = &Result[K, F]{
-		entries: make(map[ResultKey]*ResultEntry[K, F]),
+	if len(plan.Aggregators) == 0 {
+		if plan.order.capacity() > 0 {
+			// Only keep features needed for the page while scanning.
+			plan.Aggregators = []Aggregator[K, F]{boundedAggregator[K, F]{}}
+		} else {
+			plan.Aggregators = []Aggregator[K, F]{defaultAggregator[K, F]{}}
+		}
 	}
-	return builder.query
+	return plan
+}
+
+// Query returns a new query executing a complete constructed plan; see Plan.
+func (builder *QueryBuilder[K, F]) Query() Query[K, F] {
+	return builder.Plan().Query()
+}
+
+// OrderBy sorts results, where less reports whether a sorts before b.
+// Features ordered neither way are sorted by key.
+func (builder *QueryBuilder[K, F]) OrderBy(less func(a, b F) bool) *QueryBuilder[K, F] {
+	builder.ordering().less = less
+	return builder
+}
+
+// Limit sets the maximum number of features returned. Unless the query has
+// aggregators, only features needed for the page are kept while scanning.
+// Zero or a negative n means no limit.
+func (builder *QueryBuilder[K, F]) Limit(n int) *QueryBuilder[K, F] {
+	builder.ordering().limit = nonNegative(n)
+	return builder
+}
+
+// Offset skips the first n features. A negative n is treated as 0.
+func (builder *QueryBuilder[K, F]) Offset(n int) *QueryBuilder[K, F] {
+	builder.ordering().offset = nonNegative(n)
+	return builder
+}
+
+// After starts results after a cursor returned by Query.Next, ignoring
+// Offset. Use the same ordering and limit as for the previous page. A
+// negative cursor offset is treated as 0.
+func (builder *QueryBuilder[K, F]) After(cursor Cursor) *QueryBuilder[K, F] {
+	cursor.Offset = nonNegative(cursor.Offset)
+	builder.ordering().after = &cursor
+	return builder
+}
+
+func nonNegative(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (builder *QueryBuilder[K, F]) ordering() *order[K, F] {
+	if builder.order == nil {
+		builder.order = &order[K, F]{}
+	}
+	return builder.order
 }
 
 // Where adds a filter to the query. Multiple filters act as a logical AND.
 func (builder *QueryBuilder[K, F]) Where(condition Condition[K, F]) *QueryBuilder[K, F] {
-	builder.query.Conditions = append(builder.query.Conditions, condition)
+	builder.plan.Conditions = append(builder.plan.Conditions, condition)
+	return builder
+}
+
+// WhereAny adds a filter matching features matching any of the conditions.
+func (builder *QueryBuilder[K, F]) WhereAny(conditions ...Condition[K, F]) *QueryBuilder[K, F] {
+	return builder.Where(Or(conditions...))
+}
+
+// WhereNot adds a filter matching features not matching the condition.
+func (builder *QueryBuilder[K, F]) WhereNot(condition Condition[K, F]) *QueryBuilder[K, F] {
+	return builder.Where(Not(condition))
+}
+
+// Observe sets an observer notified of rejected features.
+func (builder *QueryBuilder[K, F]) Observe(observer Observer) *QueryBuilder[K, F] {
+	builder.plan.Observer = observer
+	return builder
+}
+
+// Trace sets a tracer timing pipeline stages.
+func (builder *QueryBuilder[K, F]) Trace(tracer Tracer) *QueryBuilder[K, F] {
+	builder.plan.Tracer = tracer
 	return builder
 }
 
 // Aggregate adds a new aggregator.
 func (builder *QueryBuilder[K, F]) Aggregate(aggregator Aggregator[K, F]) *QueryBuilder[K, F] {
-	builder.query.Aggregators = append(builder.query.Aggregators, aggregator)
+	builder.plan.Aggregators = append(builder.plan.Aggregators, aggregator)
 	return builder
 }
 
@@ -42,7 +138,7 @@ func (builder *QueryBuilder[K, F]) StreamTo(reducer Reducer[K, F]) *streamBuilde
 	stream := &StreamAggregator[K, F]{
 		Reducer: reducer,
 	}
-	builder.query.Aggregators = append(builder.query.Aggregators, stream)
+	builder.plan.Aggregators = append(builder.plan.Aggregators, stream)
 	return &streamBuilder[K, F]{stream}
 }
 
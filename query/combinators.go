@@ -0,0 +1,177 @@
+package query
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// DefaultCost is the cost of conditions not implementing Coster.
+const DefaultCost = 1
+
+// Coster is implemented by conditions declaring the relative cost of
+// evaluating them, so that Normalize can evaluate cheap conditions first.
+type Coster interface {
+	Cost() int
+}
+
+// AllOf matches features matching all conditions (AND). It stops at the
+// first condition not matching.
+type AllOf[K feature.Key, F feature.Feature[K]] []Condition[K, F]
+
+// And returns a conjunction of conditions.
+func And[K feature.Key, F feature.Feature[K]](conditions ...Condition[K, F]) AllOf[K, F] {
+	return AllOf[K, F](conditions)
+}
+
+func (all AllOf[K, F]) IsMatch(feature F) (bool, error) {
+	for _, condition := range all {
+		match, err := condition.IsMatch(feature)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Cost returns the total cost of the conditions.
+func (all AllOf[K, F]) Cost() int {
+	return totalCost[K](all)
+}
+
+func (all AllOf[K, F]) String() string {
+	return group[K]("AND", all)
+}
+
+// AnyOf matches features matching any of the conditions (OR). It stops at
+// the first condition matching.
+type AnyOf[K feature.Key, F feature.Feature[K]] []Condition[K, F]
+
+// Or returns a disjunction of conditions.
+func Or[K feature.Key, F feature.Feature[K]](conditions ...Condition[K, F]) AnyOf[K, F] {
+	return AnyOf[K, F](conditions)
+}
+
+func (anyOf AnyOf[K, F]) IsMatch(feature F) (bool, error) {
+	for _, condition := range anyOf {
+		match, err := condition.IsMatch(feature)
+		if err != nil || match {
+			return match, err
+		}
+	}
+	return false, nil
+}
+
+// Cost returns the total cost of the conditions.
+func (anyOf AnyOf[K, F]) Cost() int {
+	return totalCost[K](anyOf)
+}
+
+func (anyOf AnyOf[K, F]) String() string {
+	return group[K]("OR", anyOf)
+}
+
+// Negation matches features not matching a condition.
+type Negation[K feature.Key, F feature.Feature[K]] struct {
+	Condition Condition[K, F]
+}
+
+// Not returns a negation of a condition.
+func Not[K feature.Key, F feature.Feature[K]](condition Condition[K, F]) Negation[K, F] {
+	return Negation[K, F]{condition}
+}
+
+func (not Negation[K, F]) IsMatch(feature F) (bool, error) {
+	match, err := not.Condition.IsMatch(feature)
+	if err != nil {
+		return false, err
+	}
+	return !match, nil
+}
+
+// Cost returns the cost of the negated condition.
+func (not Negation[K, F]) Cost() int {
+	return CostOf(not.Condition)
+}
+
+func (not Negation[K, F]) String() string {
+	return "NOT " + ConditionName(not.Condition)
+}
+
+// CostOf returns the cost of a condition or DefaultCost if it doesn't
+// declare one.
+func CostOf(condition any) int {
+	if coster, ok := condition.(Coster); ok {
+		return coster.Cost()
+	}
+	return DefaultCost
+}
+
+func totalCost[K feature.Key, F feature.Feature[K]](conditions []Condition[K, F]) int {
+	cost := 0
+	for _, condition := range conditions {
+		cost += CostOf(condition)
+	}
+	return cost
+}
+
+func group[K feature.Key, F feature.Feature[K]](op string, conditions []Condition[K, F]) string {
+	names := make([]string, len(conditions))
+	for i, condition := range conditions {
+		names[i] = ConditionName(condition)
+	}
+	return op + "(" + strings.Join(names, ", ") + ")"
+}
+
+// Normalize simplifies a condition, flattening nested groups of the same
+// kind (e.g. AND inside AND), removing double negations and groups of one
+// condition, and ordering conditions within groups by increasing cost.
+// Conditions of equal cost keep their order.
+func Normalize[K feature.Key, F feature.Feature[K]](condition Condition[K, F]) Condition[K, F] {
+	switch c := condition.(type) {
+	case AllOf[K, F]:
+		conditions := normalizeGroup(c, func(condition Condition[K, F]) ([]Condition[K, F], bool) {
+			nested, ok := condition.(AllOf[K, F])
+			return nested, ok
+		})
+		if len(conditions) == 1 {
+			return conditions[0]
+		}
+		return AllOf[K, F](conditions)
+	case AnyOf[K, F]:
+		conditions := normalizeGroup(c, func(condition Condition[K, F]) ([]Condition[K, F], bool) {
+			nested, ok := condition.(AnyOf[K, F])
+			return nested, ok
+		})
+		if len(conditions) == 1 {
+			return conditions[0]
+		}
+		return AnyOf[K, F](conditions)
+	case Negation[K, F]:
+		if inner, ok := c.Condition.(Negation[K, F]); ok {
+			return Normalize(inner.Condition)
+		}
+		return Not(Normalize(c.Condition))
+	default:
+		return condition
+	}
+}
+
+// normalizeGroup normalizes conditions of a group, inlining nested groups
+// of the same kind, and orders them by cost.
+func normalizeGroup[K feature.Key, F feature.Feature[K]](conditions []Condition[K, F], nested func(Condition[K, F]) ([]Condition[K, F], bool)) []Condition[K, F] {
+	var normalized []Condition[K, F]
+	for _, condition := range conditions {
+		condition = Normalize(condition)
+		if inner, ok := nested(condition); ok {
+			normalized = append(normalized, inner...)
+		} else {
+			normalized = append(normalized, condition)
+		}
+	}
+	sort.SliceStable(normalized, func(i, j int) bool {
+		return CostOf(normalized[i]) < CostOf(normalized[j])
+	})
+	return normalized
+}
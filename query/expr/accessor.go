@@ -0,0 +1,195 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bilus/fencer/geometry"
+)
+
+// Kind is the type of a value in an expression.
+type Kind int
+
+const (
+	Any Kind = iota // Unknown until evaluated.
+	Number
+	String
+	Bool
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Bool:
+		return "boolean"
+	default:
+		return "any"
+	}
+}
+
+// Getter returns the value of a field of a feature. Values of numeric,
+// string and boolean types (including named ones and pointers to them) can be
+// compared; other values can only be tested with IS NULL.
+type Getter[F any] func(f F) (any, error)
+
+// Accessor resolves field names when expressions are compiled.
+type Accessor[F any] interface {
+	// Field returns a getter for a field and its kind, which is Any if not
+	// known in advance, or an error if there's no such field.
+	Field(name string) (Getter[F], Kind, error)
+}
+
+// AccessorFunc is a function implementing Accessor.
+type AccessorFunc[F any] func(name string) (Getter[F], Kind, error)
+
+// Field implements Accessor.
+func (fn AccessorFunc[F]) Field(name string) (Getter[F], Kind, error) {
+	return fn(name)
+}
+
+// Properties returns an accessor reading values from a property bag. Dotted
+// names, unless present in the bag, read nested maps. Missing properties are
+// NULL.
+func Properties[F any](properties func(f F) map[string]any) Accessor[F] {
+	return AccessorFunc[F](func(name string) (Getter[F], Kind, error) {
+		path := strings.Split(name, ".")
+		return func(f F) (any, error) {
+			bag := properties(f)
+			if value, ok := bag[name]; ok || len(path) == 1 {
+				return value, nil
+			}
+			var value any = bag
+			for _, key := range path {
+				m, ok := value.(map[string]any)
+				if !ok {
+					return nil, nil
+				}
+				value = m[key]
+			}
+			return value, nil
+		}, Any, nil
+	})
+}
+
+// FeatureProperties reads properties of geometry features.
+var FeatureProperties = Properties(func(f *geometry.Feature) map[string]any {
+	return f.Properties
+})
+
+// Struct returns an accessor reading exported fields of F, a struct or a
+// pointer to one, including fields of embedded structs. A field is named by
+// its "expr" tag, its "json" tag or its Go name, in this order, and is
+// matched case-insensitively if there's no exact match. Fields tagged
+// `expr:"-"` are not accessible.
+func Struct[F any]() Accessor[F] {
+	t := reflect.TypeOf((*F)(nil)).Elem()
+	pointer := t.Kind() == reflect.Pointer
+	if pointer {
+		t = t.Elem()
+	}
+	fields := make(map[string]reflect.StructField)
+	if t.Kind() == reflect.Struct {
+		for _, field := range reflect.VisibleFields(t) {
+			if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+				continue
+			}
+			name := field.Name
+			if tag, ok := field.Tag.Lookup("expr"); ok {
+				if tag == "-" {
+					continue
+				}
+				name = tag
+			} else if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+				name = tag
+			}
+			fields[name] = field
+		}
+	}
+	return AccessorFunc[F](func(name string) (Getter[F], Kind, error) {
+		if t.Kind() != reflect.Struct {
+			return nil, Any, fmt.Errorf("%v is not a struct", t)
+		}
+		field, ok := fields[name]
+		if !ok {
+			for fieldName, f := range fields {
+				if strings.EqualFold(fieldName, name) {
+					field, ok = f, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, Any, fmt.Errorf("Unknown field %s", name)
+		}
+		index := field.Index
+		return func(f F) (any, error) {
+			v := reflect.ValueOf(&f).Elem()
+			if pointer {
+				if v.IsNil() {
+					return nil, nil
+				}
+				v = v.Elem()
+			}
+			value, err := v.FieldByIndexErr(index)
+			if err != nil {
+				// A nil embedded pointer.
+				return nil, nil
+			}
+			return value.Interface(), nil
+		}, kindOf(field.Type), nil
+	})
+}
+
+func kindOf(t reflect.Type) Kind {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return Number
+	case reflect.String:
+		return String
+	case reflect.Bool:
+		return Bool
+	default:
+		return Any
+	}
+}
+
+// normalize converts values to float64, string, bool or nil if possible.
+func normalize(value any) any {
+	switch value.(type) {
+	case nil, float64, string, bool:
+		return value
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return value
+}
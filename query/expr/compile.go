@@ -0,0 +1,393 @@
+// Package expr compiles textual filters such as
+//
+//	population > 10000 AND region IN ('Europe', 'Oceania') AND NOT name LIKE 'T%'
+//
+// into query conditions. Fields are read through an Accessor, e.g. one using
+// reflection over struct fields (Struct) or one reading a property bag
+// (Properties). See Parse for the syntax.
+//
+// As in SQL, comparisons involving NULL, e.g. a missing property, are
+// neither true nor false, and features are only matched if the whole
+// expression is true.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// Condition is a compiled expression. It implements query.Condition.
+type Condition[K feature.Key, F feature.Feature[K]] struct {
+	text string
+	eval evaluator[F]
+}
+
+// evaluator returns a value of an expression for a feature: a float64,
+// string, bool, nil or, for fields, another value.
+type evaluator[F any] func(f F) (any, error)
+
+// Compile parses an expression and compiles it using an accessor to resolve
+// fields. Parse errors and type errors are returned as *Error.
+func Compile[K feature.Key, F feature.Feature[K]](text string, accessor Accessor[F]) (*Condition[K, F], error) {
+	node, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler[F]{text: text, accessor: accessor}
+	eval, kind, err := c.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	if kind != Bool && kind != Any {
+		return nil, c.errorf(node.Offset(), "Expected a boolean expression, got %v", kind)
+	}
+	return &Condition[K, F]{text: text, eval: eval}, nil
+}
+
+// IsMatch implements query.Condition.
+func (c *Condition[K, F]) IsMatch(f F) (bool, error) {
+	value, err := c.eval(f)
+	if err != nil {
+		return false, err
+	}
+	switch value := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return value, nil
+	default:
+		return false, fmt.Errorf("Expected a boolean, got %v", value)
+	}
+}
+
+// String returns the source of the expression.
+func (c *Condition[K, F]) String() string {
+	return c.text
+}
+
+type compiler[F any] struct {
+	text     string
+	accessor Accessor[F]
+}
+
+func (c *compiler[F]) errorf(offset int, format string, args ...any) error {
+	return &Error{Pos: positionOf(c.text, offset), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *compiler[F]) compile(node Node) (evaluator[F], Kind, error) {
+	switch node := node.(type) {
+	case *Literal:
+		value := node.Value
+		return func(F) (any, error) { return value, nil }, literalKind(value), nil
+	case *Field:
+		getter, kind, err := c.accessor.Field(node.Name)
+		if err != nil {
+			return nil, Any, c.errorf(node.Pos, "%v", err)
+		}
+		return func(f F) (any, error) {
+			value, err := getter(f)
+			return normalize(value), err
+		}, kind, nil
+	case *Not:
+		x, err := c.boolean(node.X)
+		if err != nil {
+			return nil, Any, err
+		}
+		return func(f F) (any, error) {
+			value, err := c.evalBool(x, f, node.X)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			return !*value, nil
+		}, Bool, nil
+	case *Binary:
+		if node.Op == "AND" || node.Op == "OR" {
+			return c.logical(node)
+		}
+		return c.comparison(node.Pos, node.Op, node.X, node.Y)
+	case *In:
+		return c.in(node)
+	case *Like:
+		return c.like(node)
+	case *Between:
+		lo, _, err := c.comparison(node.Pos, ">=", node.X, node.Lo)
+		if err != nil {
+			return nil, Any, err
+		}
+		hi, _, err := c.comparison(node.Pos, "<=", node.X, node.Hi)
+		if err != nil {
+			return nil, Any, err
+		}
+		return negate(and(lo, hi), node.Not), Bool, nil
+	case *IsNull:
+		x, _, err := c.compile(node.X)
+		if err != nil {
+			return nil, Any, err
+		}
+		return func(f F) (any, error) {
+			value, err := x(f)
+			if err != nil {
+				return nil, err
+			}
+			return (value == nil) != node.Not, nil
+		}, Bool, nil
+	default:
+		return nil, Any, c.errorf(node.Offset(), "Unsupported expression %v", node)
+	}
+}
+
+func literalKind(value any) Kind {
+	switch value.(type) {
+	case float64:
+		return Number
+	case string:
+		return String
+	case bool:
+		return Bool
+	default:
+		return Any
+	}
+}
+
+// boolean compiles an operand of a logical operator.
+func (c *compiler[F]) boolean(node Node) (evaluator[F], error) {
+	eval, kind, err := c.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	if kind != Bool && kind != Any {
+		return nil, c.errorf(node.Offset(), "Expected a boolean, got %v", kind)
+	}
+	return eval, nil
+}
+
+// evalBool evaluates a boolean operand, returning nil for NULL.
+func (c *compiler[F]) evalBool(eval evaluator[F], f F, node Node) (*bool, error) {
+	value, err := eval(f)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return nil, c.errorf(node.Offset(), "Expected a boolean, got %v", value)
+	}
+	return &b, nil
+}
+
+func (c *compiler[F]) logical(node *Binary) (evaluator[F], Kind, error) {
+	x, err := c.boolean(node.X)
+	if err != nil {
+		return nil, Any, err
+	}
+	y, err := c.boolean(node.Y)
+	if err != nil {
+		return nil, Any, err
+	}
+	// Short-circuits on false for AND and on true for OR.
+	decisive := node.Op == "OR"
+	return func(f F) (any, error) {
+		a, err := c.evalBool(x, f, node.X)
+		if err != nil {
+			return nil, err
+		}
+		if a != nil && *a == decisive {
+			return decisive, nil
+		}
+		b, err := c.evalBool(y, f, node.Y)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil && *b == decisive {
+			return decisive, nil
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		return !decisive, nil
+	}, Bool, nil
+}
+
+func and[F any](x, y evaluator[F]) evaluator[F] {
+	return func(f F) (any, error) {
+		a, err := x(f)
+		if err != nil || a == false {
+			return a, err
+		}
+		b, err := y(f)
+		if err != nil || b == false {
+			return b, err
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		return true, nil
+	}
+}
+
+func negate[F any](eval evaluator[F], not bool) evaluator[F] {
+	if !not {
+		return eval
+	}
+	return func(f F) (any, error) {
+		value, err := eval(f)
+		if b, ok := value.(bool); ok {
+			return !b, err
+		}
+		return value, err
+	}
+}
+
+func (c *compiler[F]) comparison(pos int, op string, xNode, yNode Node) (evaluator[F], Kind, error) {
+	x, xKind, err := c.compile(xNode)
+	if err != nil {
+		return nil, Any, err
+	}
+	y, yKind, err := c.compile(yNode)
+	if err != nil {
+		return nil, Any, err
+	}
+	if xKind != Any && yKind != Any && xKind != yKind {
+		return nil, Any, c.errorf(pos, "Cannot compare %v with %v", xKind, yKind)
+	}
+	if op != "=" && op != "!=" && (xKind == Bool || yKind == Bool) {
+		return nil, Any, c.errorf(pos, "Cannot order booleans with %s", op)
+	}
+	return func(f F) (any, error) {
+		a, err := x(f)
+		if err != nil {
+			return nil, err
+		}
+		b, err := y(f)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		cmp, err := compare(a, b, op != "=" && op != "!=")
+		if err != nil {
+			return nil, c.errorf(pos, "%v", err)
+		}
+		switch op {
+		case "=":
+			return cmp == 0, nil
+		case "!=":
+			return cmp != 0, nil
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	}, Bool, nil
+}
+
+// compare compares non-nil values of the same kind.
+func compare(a, b any, ordered bool) (int, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok && !ordered {
+			if a == b {
+				return 0, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("Cannot compare %v with %v", describeValue(a), describeValue(b))
+}
+
+func describeValue(value any) string {
+	switch value.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func (c *compiler[F]) in(node *In) (evaluator[F], Kind, error) {
+	items := make([]evaluator[F], len(node.List))
+	for i, item := range node.List {
+		eq, _, err := c.comparison(node.Pos, "=", node.X, item)
+		if err != nil {
+			return nil, Any, err
+		}
+		items[i] = eq
+	}
+	return negate(func(f F) (any, error) {
+		var result any = false
+		for _, eq := range items {
+			value, err := eq(f)
+			if err != nil {
+				return nil, err
+			}
+			if value == true {
+				return true, nil
+			}
+			if value == nil {
+				result = nil
+			}
+		}
+		return result, nil
+	}, node.Not), Bool, nil
+}
+
+func (c *compiler[F]) like(node *Like) (evaluator[F], Kind, error) {
+	x, kind, err := c.compile(node.X)
+	if err != nil {
+		return nil, Any, err
+	}
+	if kind != String && kind != Any {
+		return nil, Any, c.errorf(node.Pos, "Cannot match %v with LIKE", kind)
+	}
+	var pattern strings.Builder
+	pattern.WriteString("(?s)^")
+	for _, r := range node.Pattern.Value.(string) {
+		switch r {
+		case '%':
+			pattern.WriteString(".*")
+		case '_':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern.WriteString("$")
+	re := regexp.MustCompile(pattern.String())
+	return negate(func(f F) (any, error) {
+		value, err := x(f)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, c.errorf(node.Pos, "Cannot match %v with LIKE", describeValue(value))
+		}
+		return re.MatchString(s), nil
+	}, node.Not), Bool, nil
+}
@@ -0,0 +1,250 @@
+package expr_test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/query"
+	"github.com/bilus/fencer/query/expr"
+)
+
+type CountryID int
+
+func (id CountryID) String() string {
+	return strconv.Itoa(int(id))
+}
+
+type Details struct {
+	Capital string
+}
+
+type Country struct {
+	*Details
+	ID         CountryID
+	Name       string
+	Population int
+	Region     string  `json:"region"`
+	Change     float64 `expr:"growth"`
+	Landlocked bool
+	Motto      *string
+	Secret     string `expr:"-"`
+}
+
+func (c Country) Key() CountryID {
+	return c.ID
+}
+
+func (c Country) Contains(p primitives.Point) (bool, error) {
+	return false, nil
+}
+
+func (c Country) Bounds() *primitives.Rect {
+	return &primitives.Rect{}
+}
+
+var motto = "Unity"
+
+var countries = []Country{
+	{&Details{"Vatican City"}, 1, "Vatican City", 800, "Europe", -0.011, true, nil, ""},
+	{nil, 2, "Tokelau", 1300, "Polynesia", 0.014, false, nil, ""},
+	{nil, 4, "Tuvalu", 11200, "Oceania", 0.009, false, nil, ""},
+	{nil, 5, "Nauru", 11300, "Oceania", 0.001, false, &motto, ""},
+	{&Details{"Warsaw"}, 6, "Poland", 38224, "Europe", -0.001, false, nil, ""},
+	{&Details{"Kyiv"}, 7, "Ukraine", 44400, "Europe", 0, false, nil, ""},
+}
+
+func Example() {
+	condition, err := expr.Compile[CountryID](
+		"population > 10000 AND region IN ('Europe','Oceania') AND NOT name LIKE 'T%'",
+		expr.Struct[Country](),
+	)
+	if err != nil {
+		panic(err)
+	}
+	q := query.Build[CountryID, Country]().Where(condition).Query()
+	for _, country := range countries {
+		_ = q.Scan(country)
+	}
+	fmt.Println(len(q.Distinct()))
+	// Output: 3
+}
+
+func ExampleCompile_errors() {
+	for _, text := range []string{
+		"population > 'many'",
+		"name LIKE 'T%' AND\n  region IN ('Europe'",
+		"nme = 'Poland'",
+	} {
+		_, err := expr.Compile[CountryID](text, expr.Struct[Country]())
+		fmt.Println(err)
+	}
+	// Output:
+	// 1:12: Cannot compare number with string
+	// 2:22: Expected ), got end of expression
+	// 1:1: Unknown field nme
+}
+
+func ExampleFeatureProperties() {
+	condition, _ := expr.Compile[geometry.ID]("kind = 'park' AND address.city = 'Kraków'", expr.FeatureProperties)
+	park := geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{
+		"kind":    "park",
+		"address": map[string]any{"city": "Kraków"},
+	})
+	fmt.Println(condition.IsMatch(park))
+	// Output: true <nil>
+}
+
+func TestCompile_struct(t *testing.T) {
+	tests := []struct {
+		text string
+		want []CountryID
+	}{
+		{"population >= 11300", []CountryID{5, 6, 7}},
+		{"Population < 1300 OR id = 7", []CountryID{1, 7}},
+		{"region = \"Europe\" AND NOT (name = 'Poland' OR name == 'Ukraine')", []CountryID{1}},
+		{"region NOT IN ('Europe', 'Oceania')", []CountryID{2}},
+		{"name NOT LIKE '%an%'", []CountryID{2, 4, 5, 7}},
+		{"name LIKE 'T_valu'", []CountryID{4}},
+		{"growth BETWEEN -0.001 AND 0.001", []CountryID{5, 6, 7}},
+		{"growth NOT BETWEEN -0.001 AND 0.001", []CountryID{1, 2, 4}},
+		{"landlocked", []CountryID{1}},
+		{"landlocked = false AND growth > 0", []CountryID{2, 4, 5}},
+		{"motto IS NOT NULL", []CountryID{5}},
+		{"motto = 'Unity'", []CountryID{5}},
+		{"NOT motto = 'Unity'", nil},
+		{"motto = 'Unity' OR population < 1000", []CountryID{1, 5}},
+		{"capital = 'Warsaw'", []CountryID{6}},
+		{"capital IS NULL", []CountryID{2, 4, 5}},
+	}
+	for _, tt := range tests {
+		condition, err := expr.Compile[CountryID](tt.text, expr.Struct[Country]())
+		if err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+			continue
+		}
+		var got []CountryID
+		for _, country := range countries {
+			match, err := condition.IsMatch(country)
+			if err != nil {
+				t.Errorf("%s: %v", tt.text, err)
+			}
+			if match {
+				got = append(got, country.ID)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_pointers(t *testing.T) {
+	condition, err := expr.Compile[CountryID, *Country]("name = 'Poland'", expr.Struct[*Country]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, err := condition.IsMatch(&countries[4]); !match || err != nil {
+		t.Errorf("got %v, %v", match, err)
+	}
+	if match, err := condition.IsMatch(nil); match || err != nil {
+		t.Errorf("got %v, %v", match, err)
+	}
+}
+
+func TestCompile_properties(t *testing.T) {
+	feature := geometry.NewFeature("a", geometry.Point{1, 1}, map[string]any{
+		"name":  "Park",
+		"area":  12,
+		"open":  true,
+		"a.b":   "dotted",
+		"level": "high",
+	})
+	for text, want := range map[string]bool{
+		"area > 10 AND open":          true,
+		"area = 12.0":                 true,
+		"missing = 1":                 false,
+		"NOT missing = 1":             false,
+		"missing IS NULL":             true,
+		"missing = 1 OR area > 1":     true,
+		"a.b = 'dotted'":              true,
+		"name IN ('Park', missing)":   true,
+		"name NOT IN ('Lake', NULL)":  false,
+		"NOT (name NOT LIKE 'P%')":    true,
+		"area BETWEEN 12 AND missing": false,
+	} {
+		condition, err := expr.Compile[geometry.ID](text, expr.FeatureProperties)
+		if err != nil {
+			t.Errorf("%s: %v", text, err)
+			continue
+		}
+		if got, err := condition.IsMatch(feature); got != want || err != nil {
+			t.Errorf("%s: got %v, %v", text, got, err)
+		}
+	}
+
+	// Types of properties are only known at runtime.
+	for text, want := range map[string]string{
+		"name > 1":                           "1:6: Cannot compare string with number",
+		"level LIKE 'h%' AND area LIKE '1%'": "1:26: Cannot match number with LIKE",
+		"name":                               "Expected a boolean, got Park",
+		"NOT name":                           "1:5: Expected a boolean, got Park",
+	} {
+		condition, err := expr.Compile[geometry.ID](text, expr.FeatureProperties)
+		if err != nil {
+			t.Errorf("%s: %v", text, err)
+			continue
+		}
+		if _, err := condition.IsMatch(feature); err == nil || err.Error() != want {
+			t.Errorf("%s: got %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestCompile_errors(t *testing.T) {
+	for text, want := range map[string]string{
+		"":                          "1:1: Expected an operand, got end of expression",
+		"population >":              "1:13: Expected an operand, got end of expression",
+		"population > 1 population": `1:16: Unexpected "population"`,
+		"name = 'Poland":            "1:8: Unterminated string",
+		"name # 1":                  `1:6: Unexpected character '#'`,
+		"name NOT = 'x'":            `1:6: Unexpected "NOT"`,
+		"name LIKE 1":               `1:11: Expected a pattern string, got "1"`,
+		"population LIKE '1%'":      "1:12: Cannot match number with LIKE",
+		"landlocked < true":         "1:12: Cannot order booleans with <",
+		"population":                "1:1: Expected a boolean expression, got number",
+		"NOT name":                  "1:5: Expected a boolean, got string",
+		"population > 1 AND name":   "1:20: Expected a boolean, got string",
+		"region IN ('Europe', 1)":   "1:8: Cannot compare string with number",
+		"secret = 'x'":              "1:1: Unknown field secret",
+		"name IS 'x'":               `1:9: Expected NULL, got "'x'"`,
+		"1.2.3 = 1":                 `1:1: Invalid number "1.2.3"`,
+	} {
+		_, err := expr.Compile[CountryID](text, expr.Struct[Country]())
+		var exprErr *expr.Error
+		if !errors.As(err, &exprErr) || err.Error() != want {
+			t.Errorf("%q: got %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	node, err := expr.Parse("a = 1 or not b like 'x''s' and c is not null and d between -1 and 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "((a = 1) OR ((NOT b LIKE 'x''s' AND c IS NOT NULL) AND d BETWEEN -1 AND 2))"
+	if got := node.String(); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestCondition_String(t *testing.T) {
+	condition, _ := expr.Compile[CountryID]("population > 1", expr.Struct[Country]())
+	if got := query.ConditionName(condition); got != "population > 1" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Position is a location in an expression.
+type Position struct {
+	Offset int // Byte offset, starting at 0.
+	Line   int // Starting at 1.
+	Column int // Characters, starting at 1.
+}
+
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// positionOf converts a byte offset to a position.
+func positionOf(text string, offset int) Position {
+	pos := Position{Offset: offset, Line: 1, Column: 1}
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+// Error is a parse or type error.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (err *Error) Error() string {
+	return err.Pos.String() + ": " + err.Msg
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenKeyword
+	tokenNumber
+	tokenString
+	tokenOperator // Comparison operators, parentheses, commas and minus.
+)
+
+type token struct {
+	kind   tokenKind
+	text   string // Upper case for keywords.
+	value  any    // Numbers and strings.
+	offset int
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "LIKE": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true, "BETWEEN": true,
+}
+
+// lex splits an expression into tokens.
+func lex(text string) ([]token, error) {
+	var tokens []token
+	errorAt := func(offset int, format string, args ...any) error {
+		return &Error{Pos: positionOf(text, offset), Msg: fmt.Sprintf(format, args...)}
+	}
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			word := text[start:i]
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, token{kind: tokenKeyword, text: upper, offset: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: word, offset: start})
+			}
+		case r >= '0' && r <= '9' || r == '.' && i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9':
+			start := i
+			for i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.' ||
+				text[i] == 'e' || text[i] == 'E' ||
+				(text[i] == '+' || text[i] == '-') && (text[i-1] == 'e' || text[i-1] == 'E')) {
+				i++
+			}
+			f, err := strconv.ParseFloat(text[start:i], 64)
+			if err != nil {
+				return nil, errorAt(start, "Invalid number %q", text[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text[start:i], value: f, offset: start})
+		case r == '\'' || r == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(text) {
+					return nil, errorAt(start, "Unterminated string")
+				}
+				if text[i] == byte(r) {
+					// A doubled quote stands for itself.
+					if i+1 < len(text) && text[i+1] == byte(r) {
+						b.WriteByte(byte(r))
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(text[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text[start:i], value: b.String(), offset: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", ",", "-"} {
+				if strings.HasPrefix(text[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, errorAt(i, "Unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, offset: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, offset: len(text)}), nil
+}
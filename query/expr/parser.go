@@ -0,0 +1,382 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is a node of a parsed expression.
+type Node interface {
+	// Offset returns the node's byte offset in the expression.
+	Offset() int
+	String() string
+}
+
+// Literal is a number (float64), string, boolean or NULL (nil).
+type Literal struct {
+	Pos   int
+	Value any
+}
+
+// Field refers to a field of a feature.
+type Field struct {
+	Pos  int
+	Name string
+}
+
+// Not negates an expression.
+type Not struct {
+	Pos int
+	X   Node
+}
+
+// Binary is a logical operation (AND, OR) or a comparison (=, !=, <, <=, >,
+// >=).
+type Binary struct {
+	Pos  int // Of the operator.
+	Op   string
+	X, Y Node
+}
+
+// In tests membership in a list.
+type In struct {
+	Pos  int // Of IN.
+	X    Node
+	List []Node
+	Not  bool
+}
+
+// Like matches a string against a pattern, where % matches any sequence of
+// characters and _ any single character.
+type Like struct {
+	Pos     int // Of LIKE.
+	X       Node
+	Pattern *Literal
+	Not     bool
+}
+
+// Between tests if a value lies in a closed range.
+type Between struct {
+	Pos    int // Of BETWEEN.
+	X      Node
+	Lo, Hi Node
+	Not    bool
+}
+
+// IsNull tests if a value is NULL, e.g. a missing property.
+type IsNull struct {
+	Pos int // Of IS.
+	X   Node
+	Not bool
+}
+
+func (n *Literal) Offset() int { return n.Pos }
+func (n *Field) Offset() int   { return n.Pos }
+func (n *Not) Offset() int     { return n.Pos }
+func (n *Binary) Offset() int  { return n.X.Offset() }
+func (n *In) Offset() int      { return n.X.Offset() }
+func (n *Like) Offset() int    { return n.X.Offset() }
+func (n *Between) Offset() int { return n.X.Offset() }
+func (n *IsNull) Offset() int  { return n.X.Offset() }
+
+func (n *Literal) String() string {
+	switch v := n.Value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (n *Field) String() string { return n.Name }
+func (n *Not) String() string   { return "NOT " + n.X.String() }
+
+func (n *Binary) String() string {
+	return "(" + n.X.String() + " " + n.Op + " " + n.Y.String() + ")"
+}
+
+func (n *In) String() string {
+	items := make([]string, len(n.List))
+	for i, item := range n.List {
+		items[i] = item.String()
+	}
+	return n.X.String() + not(n.Not) + " IN (" + strings.Join(items, ", ") + ")"
+}
+
+func (n *Like) String() string {
+	return n.X.String() + not(n.Not) + " LIKE " + n.Pattern.String()
+}
+
+func (n *Between) String() string {
+	return n.X.String() + not(n.Not) + " BETWEEN " + n.Lo.String() + " AND " + n.Hi.String()
+}
+
+func (n *IsNull) String() string {
+	if n.Not {
+		return n.X.String() + " IS NOT NULL"
+	}
+	return n.X.String() + " IS NULL"
+}
+
+func not(negated bool) string {
+	if negated {
+		return " NOT"
+	}
+	return ""
+}
+
+// Parse parses an expression. The grammar, with case-insensitive keywords,
+// is:
+//
+//	expr       = and { OR and }
+//	and        = unary { AND unary }
+//	unary      = NOT unary | comparison
+//	comparison = operand [ op operand
+//	                     | [NOT] IN "(" operand { "," operand } ")"
+//	                     | [NOT] LIKE string
+//	                     | [NOT] BETWEEN operand AND operand
+//	                     | IS [NOT] NULL ]
+//	operand    = [-] number | string | TRUE | FALSE | NULL | field | "(" expr ")"
+//	op         = "=" | "==" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+//
+// Strings are quoted with ' or ", doubling the quote to include it. Fields
+// are identifiers, which may contain dots.
+func Parse(text string) (Node, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{text: text, tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok.offset, "Unexpected %s", describe(tok))
+	}
+	return node, nil
+}
+
+type parser struct {
+	text   string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes a keyword or operator if it's next.
+func (p *parser) accept(text string) (token, bool) {
+	tok := p.peek()
+	if (tok.kind == tokenKeyword || tok.kind == tokenOperator) && tok.text == text {
+		return p.next(), true
+	}
+	return tok, false
+}
+
+func (p *parser) expect(text string) (token, error) {
+	tok, ok := p.accept(text)
+	if !ok {
+		return tok, p.errorf(tok.offset, "Expected %s, got %s", text, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) errorf(offset int, format string, args ...any) error {
+	return &Error{Pos: positionOf(p.text, offset), Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(tok token) string {
+	if tok.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(tok.text)
+}
+
+func (p *parser) or() (Node, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("OR")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Pos: tok.offset, Op: "OR", X: x, Y: y}
+	}
+}
+
+func (p *parser) and() (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("AND")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Pos: tok.offset, Op: "AND", X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	if tok, ok := p.accept("NOT"); ok {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Pos: tok.offset, X: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Node, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind == tokenOperator {
+		switch tok.text {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			y, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			op := tok.text
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			return &Binary{Pos: tok.offset, Op: op, X: x, Y: y}, nil
+		}
+	}
+	if tok, ok := p.accept("IS"); ok {
+		_, negated := p.accept("NOT")
+		if _, err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNull{Pos: tok.offset, X: x, Not: negated}, nil
+	}
+	// NOT here may only precede IN, LIKE or BETWEEN.
+	negated := false
+	if tok := p.peek(); tok.kind == tokenKeyword && tok.text == "NOT" {
+		// NOT isn't the last token, EOF is.
+		next := p.tokens[p.i+1]
+		if next.kind == tokenKeyword && (next.text == "IN" || next.text == "LIKE" || next.text == "BETWEEN") {
+			p.next()
+			negated = true
+		}
+	}
+	tok = p.peek()
+	if tok.kind != tokenKeyword {
+		return x, nil
+	}
+	switch tok.text {
+	case "IN":
+		p.next()
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := &In{Pos: tok.offset, X: x, Not: negated}
+		for {
+			item, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			in.List = append(in.List, item)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case "LIKE":
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, p.errorf(pattern.offset, "Expected a pattern string, got %s", describe(pattern))
+		}
+		return &Like{Pos: tok.offset, X: x, Pattern: &Literal{Pos: pattern.offset, Value: pattern.value}, Not: negated}, nil
+	case "BETWEEN":
+		p.next()
+		lo, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &Between{Pos: tok.offset, X: x, Lo: lo, Hi: hi, Not: negated}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) operand() (Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return &Literal{Pos: tok.offset, Value: tok.value}, nil
+	case tokenIdent:
+		return &Field{Pos: tok.offset, Name: tok.text}, nil
+	case tokenKeyword:
+		switch tok.text {
+		case "TRUE":
+			return &Literal{Pos: tok.offset, Value: true}, nil
+		case "FALSE":
+			return &Literal{Pos: tok.offset, Value: false}, nil
+		case "NULL":
+			return &Literal{Pos: tok.offset, Value: nil}, nil
+		}
+	case tokenOperator:
+		if number := p.peek(); tok.text == "-" && number.kind == tokenNumber && number.offset == tok.offset+1 {
+			p.next()
+			return &Literal{Pos: tok.offset, Value: -number.value.(float64)}, nil
+		}
+		if tok.text == "(" {
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf(tok.offset, "Expected an operand, got %s", describe(tok))
+}
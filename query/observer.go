@@ -0,0 +1,29 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Observer is notified of features rejected by query conditions, e.g. to
+// collect metrics. It must be safe for concurrent use if queries are.
+type Observer interface {
+	// Rejected is called when a condition rejects a feature, with the
+	// condition's name (see ConditionName).
+	Rejected(condition string)
+}
+
+// ConditionName returns a name of a condition, or another pipeline stage, for
+// diagnostics: its String method's result if it implements fmt.Stringer or
+// its type name otherwise.
+func ConditionName(condition any) string {
+	if stringer, ok := condition.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	name := fmt.Sprintf("%T", condition)
+	// Type parameters make names unwieldy.
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimLeft(name, "*")
+}
@@ -0,0 +1,239 @@
+package query
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// Cursor marks the end of a page of results; pass it to QueryBuilder.After
+// to query the next page. The page starts after the feature with Key if
+// it's still among the results, otherwise at Offset, so pages stay stable
+// when up to a page of features is added or removed before the cursor.
+type Cursor struct {
+	Offset int    // Of the next page.
+	Key    string // Of the last feature.
+}
+
+// String returns an opaque token representing the cursor.
+func (cursor Cursor) String() string {
+	data, _ := json.Marshal(cursorToken{cursor.Offset, cursor.Key})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor parses a token returned by Cursor.String.
+func ParseCursor(token string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("Invalid cursor: %w", err)
+	}
+	var t cursorToken
+	if err := json.Unmarshal(data, &t); err != nil || t.Offset < 0 {
+		return Cursor{}, fmt.Errorf("Invalid cursor %q", token)
+	}
+	return Cursor{Offset: t.Offset, Key: t.Key}, nil
+}
+
+type cursorToken struct {
+	Offset int    `json:"o"`
+	Key    string `json:"k"`
+}
+
+// order configures ordering and paging of query results. Features are
+// sorted by less, if set, then by the string representations of their keys,
+// e.g. key 10 sorts before key 2. A nil order sorts by keys only.
+type order[K feature.Key, F feature.Feature[K]] struct {
+	less   func(a, b F) bool // Optional.
+	offset int
+	limit  int // 0 for no limit.
+	after  *Cursor
+}
+
+// keyed is a feature with the string representation of its key.
+type keyed[F any] struct {
+	feature F
+	key     string
+}
+
+func keyedFeature[K feature.Key, F feature.Feature[K]](f F) keyed[F] {
+	return keyed[F]{f, f.Key().String()}
+}
+
+// before reports whether a sorts before b. Ties are broken using keys so
+// the order is deterministic.
+func (o *order[K, F]) before(a, b keyed[F]) bool {
+	if o != nil && o.less != nil {
+		if o.less(a.feature, b.feature) {
+			return true
+		}
+		if o.less(b.feature, a.feature) {
+			return false
+		}
+	}
+	return a.key < b.key
+}
+
+// capacity returns how many of the first features are needed to select a
+// page and tell if there are more, or 0 if all are.
+func (o *order[K, F]) capacity() int {
+	switch {
+	case o == nil || o.limit == 0:
+		return 0
+	case o.after != nil:
+		return o.after.Offset + 2*o.limit
+	default:
+		return o.offset + o.limit + 1
+	}
+}
+
+// page selects a page of sorted features, returning the offset of the next
+// page or -1 if there are no more features.
+func (o *order[K, F]) page(sorted []F) ([]F, int) {
+	if o == nil {
+		return sorted, -1
+	}
+	start := o.offset
+	if o.after != nil {
+		start = o.after.Offset
+		for i, f := range sorted {
+			if f.Key().String() == o.after.Key {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := len(sorted)
+	if o.limit > 0 && start+o.limit < end {
+		end = start + o.limit
+	}
+	if end == len(sorted) {
+		return sorted[start:end], -1
+	}
+	return sorted[start:end], end
+}
+
+// pageLen returns the length of the page selected from features, given in
+// any order.
+func (o *order[K, F]) pageLen(features []F) int {
+	if o == nil {
+		return len(features)
+	}
+	start := o.offset
+	if o.after != nil {
+		start = o.after.Offset
+		for _, f := range features {
+			if f.Key().String() == o.after.Key {
+				// The page starts after features sorting before f and f.
+				start = 1
+				kf := keyed[F]{f, o.after.Key}
+				for _, g := range features {
+					if o.before(keyedFeature[K](g), kf) {
+						start++
+					}
+				}
+				break
+			}
+		}
+	}
+	n := len(features) - start
+	if n < 0 {
+		n = 0
+	}
+	if o.limit > 0 && o.limit < n {
+		n = o.limit
+	}
+	return n
+}
+
+// boundedAggregator is the default aggregator of queries with a limit. It
+// keeps only the first features needed to select the page, see
+// Result.keep.
+type boundedAggregator[K feature.Key, F feature.Feature[K]] struct {
+	defaultAggregator[K, F]
+}
+
+func (boundedAggregator[K, F]) Reduce(result *Result[K, F], match *Match[K, F]) error {
+	result.keep(match.Feature)
+	return nil
+}
+
+// featureHeap is a heap with the last of features on top.
+type featureHeap[K feature.Key, F feature.Feature[K]] struct {
+	features []keyed[F]
+	order    *order[K, F]
+}
+
+func (h *featureHeap[K, F]) Len() int {
+	return len(h.features)
+}
+
+func (h *featureHeap[K, F]) Less(i, j int) bool {
+	return h.order.before(h.features[j], h.features[i])
+}
+
+func (h *featureHeap[K, F]) Swap(i, j int) {
+	h.features[i], h.features[j] = h.features[j], h.features[i]
+}
+
+func (h *featureHeap[K, F]) Push(x any) {
+	h.features = append(h.features, x.(keyed[F]))
+}
+
+func (h *featureHeap[K, F]) Pop() any {
+	last := h.features[len(h.features)-1]
+	h.features = h.features[:len(h.features)-1]
+	return last
+}
+
+// keep adds a feature to the results, keyed by its key, unless there are
+// already enough features sorting before it, evicting the last feature if
+// needed.
+func (result *Result[K, F]) keep(f F) {
+	key := f.Key()
+	h := result.top
+	if entry, ok := result.entries[key]; ok {
+		entry.Features = []F{f}
+		for i, g := range h.features {
+			if g.feature.Key() == key {
+				h.features[i].feature = f
+				heap.Fix(h, i)
+				break
+			}
+		}
+		return
+	}
+	kf := keyedFeature[K](f)
+	if h.Len() >= h.order.capacity() {
+		last := h.features[0]
+		if !h.order.before(kf, last) {
+			return
+		}
+		delete(result.entries, last.feature.Key())
+		heap.Pop(h)
+	}
+	heap.Push(h, kf)
+	result.entries[key] = &ResultEntry[K, F]{Features: []F{f}}
+}
+
+// sorted returns distinct features in order.
+func (result *Result[K, F]) sorted() []F {
+	features := result.distinct()
+	sorted := make([]keyed[F], len(features))
+	for i, f := range features {
+		sorted[i] = keyedFeature[K](f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return result.order.before(sorted[i], sorted[j])
+	})
+	for i, kf := range sorted {
+		features[i] = kf.feature
+	}
+	return features
+}
@@ -24,30 +24,75 @@ func (match *Match[K, F]) ReplaceKeys(resultKeys ...ResultKey) {
 	match.ResultKeys = resultKeys
 }
 
-// Query holds configuration of a query pipeline for narrowing down spatial
-// search results.
-type Query[K feature.Key, F feature.Feature[K]] struct {
+// Plan holds configuration of a query pipeline for narrowing down spatial
+// search results. Plans are immutable once built, so one plan can be used
+// for any number of queries, including concurrent ones.
+type Plan[K feature.Key, F feature.Feature[K]] struct {
 	Conditions  []Condition[K, F]  // Logical conjunction (AND).
 	Aggregators []Aggregator[K, F] // Logical disjunction (OR).
-	results     *Result[K, F]
+	Observer    Observer           // Optional.
+	Tracer      Tracer             // Optional.
+	order       *order[K, F]
+}
+
+// Query returns a new query executing the plan.
+func (plan Plan[K, F]) Query() Query[K, F] {
+	q := Query[K, F]{Plan: plan}
+	q.Reset()
+	return q
+}
+
+// Query is an execution of a plan, accumulating results of features passed
+// to Scan. A query must not be used concurrently.
+type Query[K feature.Key, F feature.Feature[K]] struct {
+	Plan[K, F]
+	Span    Span // Parent of spans started by Scan; optional.
+	results *Result[K, F]
+}
+
+// Reset discards results so that features can be scanned again. Copies of
+// the query made before keep the old results.
+func (q *Query[K, F]) Reset() {
+	q.results = &Result[K, F]{
+		entries: make(map[ResultKey]*ResultEntry[K, F]),
+		order:   q.order,
+	}
+	if q.order.capacity() > 0 {
+		q.results.top = &featureHeap[K, F]{order: q.order}
+	}
 }
 
 // Scan sends a feature through the query pipeline, first rejecting it unless
 // all preconditions (conjunction step) match, then applying each of the filters
 // and finally performing a reduce step to update query results.
 func (q *Query[K, F]) Scan(feature F) error {
-	isMatch, err := allMatch[K, F](q.Conditions, feature)
+	if q.Tracer == nil {
+		return q.scan(nil, feature)
+	}
+	span := q.Tracer.Start(q.Span, "query.Scan")
+	span.SetAttribute("feature", feature.Key().String())
+	err := q.scan(span, feature)
+	span.End(err)
+	return err
+}
+
+// scan implements Scan, starting spans for each stage if span is not nil.
+func (q *Query[K, F]) scan(span Span, feature F) error {
+	rejectedBy, err := q.firstRejecting(span, feature)
 	if err != nil {
 		return err
 	}
-	if !isMatch {
+	if rejectedBy >= 0 {
+		if q.Observer != nil {
+			q.Observer.Rejected(ConditionName(q.Conditions[rejectedBy]))
+		}
 		return nil
 	}
 	match := &Match[K, F]{
 		Feature: feature,
 	}
 	for _, aggregator := range q.Aggregators {
-		match, err := aggregator.Map(match)
+		match, err := q.mapMatch(span, aggregator, match)
 		if err != nil {
 			return err
 		}
@@ -58,7 +103,10 @@ func (q *Query[K, F]) Scan(feature F) error {
 			// Rejected by Map.
 			continue
 		}
-		if err := aggregator.Reduce(q.results, match); err != nil {
+		stage := q.start(span, "reduce", reducerOf(aggregator))
+		err = aggregator.Reduce(q.results, match)
+		end(stage, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -66,20 +114,101 @@ func (q *Query[K, F]) Scan(feature F) error {
 	return nil
 }
 
-// Distinct returns distinct features matching the query.
+// Distinct returns distinct features matching the query, sorted as
+// configured by OrderBy and then by the string representations of keys, and
+// paged if configured using QueryBuilder.
 func (q *Query[K, F]) Distinct() []F {
-	return q.results.distinct()
+	features, _ := q.results.order.page(q.results.sorted())
+	return features
+}
+
+// Count returns the number of features Distinct returns, without sorting
+// them.
+func (q *Query[K, F]) Count() int {
+	return q.results.order.pageLen(q.results.distinct())
 }
 
-func allMatch[K feature.Key, F feature.Feature[K]](conditions []Condition[K, F], feature F) (bool, error) {
-	for _, condition := range conditions {
+// Next returns a cursor to the page following the one returned by Distinct
+// or false if it's the last page.
+func (q *Query[K, F]) Next() (Cursor, bool) {
+	features, next := q.results.order.page(q.results.sorted())
+	if next < 0 || len(features) == 0 {
+		return Cursor{}, false
+	}
+	return Cursor{Offset: next, Key: features[len(features)-1].Key().String()}, true
+}
+
+// Len returns the number of result groups, i.e. distinct result keys.
+func (q *Query[K, F]) Len() int {
+	return q.results.Len()
+}
+
+// Result returns results of the query, grouped by result key.
+func (q *Query[K, F]) Result() *Result[K, F] {
+	return q.results
+}
+
+// firstRejecting returns the index of the first condition not matching a
+// feature or -1 if all match.
+func (q *Query[K, F]) firstRejecting(span Span, feature F) (int, error) {
+	for i, condition := range q.Conditions {
+		stage := q.start(span, "condition", condition)
 		match, err := condition.IsMatch(feature)
+		if stage != nil {
+			stage.SetAttribute("match", match)
+			stage.End(err)
+		}
 		if err != nil {
-			return false, err
+			return -1, err
 		}
 		if !match {
-			return false, nil
+			return i, nil
 		}
 	}
-	return true, nil
+	return -1, nil
+}
+
+// mapMatch calls an aggregator's Map, tracing mappers of stream aggregators
+// separately.
+func (q *Query[K, F]) mapMatch(span Span, aggregator Aggregator[K, F], match *Match[K, F]) (*Match[K, F], error) {
+	if span == nil {
+		return aggregator.Map(match)
+	}
+	var mappers []Mapper[K, F]
+	switch stream := aggregator.(type) {
+	case *StreamAggregator[K, F]:
+		mappers = stream.Mappers
+	case StreamAggregator[K, F]:
+		mappers = stream.Mappers
+	default:
+		stage := q.start(span, "map", aggregator)
+		match, err := aggregator.Map(match)
+		end(stage, err)
+		return match, err
+	}
+	var err error
+	for _, mapper := range mappers {
+		stage := q.start(span, "map", mapper)
+		match, err = mapper.Map(match)
+		end(stage, err)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			return nil, fmt.Errorf("Internal error: nil match returned from mapper %T", mapper)
+		}
+	}
+	return match, nil
+}
+
+// reducerOf returns the reducer of an aggregator, for naming spans.
+func reducerOf[K feature.Key, F feature.Feature[K]](aggregator Aggregator[K, F]) any {
+	switch stream := aggregator.(type) {
+	case *StreamAggregator[K, F]:
+		return stream.Reducer
+	case StreamAggregator[K, F]:
+		return stream.Reducer
+	default:
+		return aggregator
+	}
 }
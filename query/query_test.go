@@ -9,7 +9,7 @@ import (
 	"github.com/bilus/fencer/primitives"
 	"github.com/bilus/fencer/query"
 	"github.com/bilus/fencer/testutil"
-	// "testing"
+	"testing"
 )
 
 type CountryID int
@@ -290,3 +290,542 @@ func printNamesSorted(features []Country) {
 		fmt.Println(name)
 	}
 }
+
+type rejections map[string]int
+
+func (r rejections) Rejected(condition string) {
+	r[condition]++
+}
+
+func ExampleQueryBuilder_Observe() {
+	observed := rejections{}
+	query := query.Build[CountryID, Country]().
+		Where(PopulationGreaterThan{10000}).
+		Where(query.Pred[CountryID, Country](func(country Country) (bool, error) {
+			return country.Region == "Europe", nil
+		})).
+		Observe(observed).
+		Query()
+	for _, country := range countries {
+		query.Scan(country)
+	}
+	fmt.Println(observed)
+	// Output: map[query.Pred:2 query_test.PopulationGreaterThan:3]
+}
+
+type RegionIs string
+
+func (r RegionIs) IsMatch(country Country) (bool, error) {
+	return country.Region == string(r), nil
+}
+
+func (r RegionIs) String() string {
+	return "region=" + string(r)
+}
+
+// NameMatches is an expensive condition.
+type NameMatches string
+
+func (n NameMatches) IsMatch(country Country) (bool, error) {
+	return strings.Contains(country.Name, string(n)), nil
+}
+
+func (n NameMatches) Cost() int {
+	return 10
+}
+
+func (n NameMatches) String() string {
+	return "name~" + string(n)
+}
+
+func ExampleQueryBuilder_WhereAny() {
+	// (region=Polynesia OR region=Oceania) AND NOT name~Na
+	query := query.Build[CountryID, Country]().
+		WhereAny(RegionIs("Polynesia"), RegionIs("Oceania")).
+		WhereNot(NameMatches("Na")).
+		Query()
+	for _, country := range countries {
+		query.Scan(country)
+	}
+	printNamesSorted(query.Distinct())
+	// Output:
+	// Niue
+	// Tokelau
+	// Tuvalu
+}
+
+func ExampleNormalize() {
+	condition := query.And[CountryID, Country](
+		NameMatches("a"),
+		query.And[CountryID, Country](RegionIs("Europe"), query.Or[CountryID, Country](RegionIs("Oceania"))),
+		query.Not[CountryID, Country](query.Not[CountryID, Country](PopulationGreaterThan{10000})),
+	)
+	fmt.Println(query.ConditionName(condition))
+	fmt.Println(query.ConditionName(query.Normalize[CountryID, Country](condition)))
+	// Output:
+	// AND(name~a, AND(region=Europe, OR(region=Oceania)), NOT NOT query_test.PopulationGreaterThan)
+	// AND(region=Europe, region=Oceania, query_test.PopulationGreaterThan, name~a)
+}
+
+// failing fails the test if evaluated.
+type failing struct {
+	t *testing.T
+}
+
+func (f failing) IsMatch(country Country) (bool, error) {
+	f.t.Errorf("Unexpected evaluation for %v", country.Name)
+	return false, nil
+}
+
+func TestCombinators(t *testing.T) {
+	poland := countries[5]
+	europe, oceania := RegionIs("Europe"), RegionIs("Oceania")
+	for _, tt := range []struct {
+		condition query.Condition[CountryID, Country]
+		want      bool
+	}{
+		{query.And[CountryID, Country](), true},
+		{query.Or[CountryID, Country](), false},
+		{query.And[CountryID, Country](europe, NameMatches("land")), true},
+		{query.And[CountryID, Country](oceania, failing{t}), false},
+		{query.Or[CountryID, Country](europe, failing{t}), true},
+		{query.Or[CountryID, Country](oceania, query.Not[CountryID, Country](oceania)), true},
+		{query.Not[CountryID, Country](query.Or[CountryID, Country](oceania, query.And[CountryID, Country](europe, NameMatches("x")))), true},
+	} {
+		match, err := tt.condition.IsMatch(poland)
+		if err != nil || match != tt.want {
+			t.Errorf("%v: got %v, %v", query.ConditionName(tt.condition), match, err)
+		}
+	}
+}
+
+func TestQueryBuilder_normalizesConditions(t *testing.T) {
+	q := query.Build[CountryID, Country]().
+		Where(NameMatches("a")).
+		Where(query.And[CountryID, Country](RegionIs("Europe"), PopulationGreaterThan{10000})).
+		Query()
+	names := make([]string, len(q.Conditions))
+	for i, condition := range q.Conditions {
+		names[i] = query.ConditionName(condition)
+	}
+	if got := strings.Join(names, ", "); got != "region=Europe, query_test.PopulationGreaterThan, name~a" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func byRegion(country Country) query.ResultKey {
+	return country.Region
+}
+
+func population(country Country) float64 {
+	return float64(country.Population)
+}
+
+func lessPopulated(a, b Country) bool {
+	return a.Population < b.Population
+}
+
+func ExampleCount() {
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.Count[CountryID, Country]()).Map(query.GroupBy[CountryID](byRegion))
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	fmt.Println(query.Values[int](q))
+	// Output: map[Europe:3 Oceania:2 Polynesia:2]
+}
+
+func ExampleAvg() {
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.Avg[CountryID](population)).Map(query.GroupBy[CountryID](byRegion))
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	for region, mean := range query.Values[query.Mean](q) {
+		if region == "Oceania" {
+			fmt.Println(mean.Count, mean.Value())
+		}
+	}
+	// Output: 2 11250
+}
+
+func ExampleMaxBy() {
+	// Equivalent to ExampleBuild_groupingResults.
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.MaxBy[CountryID](lessPopulated)).Map(query.GroupBy[CountryID](byRegion))
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	printNamesSorted(q.Distinct())
+	// Output:
+	// Nauru
+	// Niue
+	// Ukraine
+}
+
+func ExampleTopK() {
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.TopK[CountryID](2, lessPopulated)).Map(query.GroupBy[CountryID](func(Country) query.ResultKey {
+		return "all"
+	}))
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	printNamesSorted(q.Distinct())
+	// Output:
+	// Tokelau
+	// Vatican City
+}
+
+func TestAggregators(t *testing.T) {
+	declining := func(country Country) query.ResultKey {
+		if country.Change < 0 {
+			return "declining"
+		}
+		return nil
+	}
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.Sum[CountryID](population)).Map(query.GroupBy[CountryID](declining))
+	qb.StreamTo(query.Collect[CountryID, Country]()).Map(query.GroupBy[CountryID](byRegion))
+	qb.StreamTo(query.MinBy[CountryID](lessPopulated)).Map(query.GroupBy[CountryID](func(country Country) query.ResultKey {
+		return len(country.Name)
+	}))
+	q := qb.Query()
+	for _, country := range countries {
+		if err := q.Scan(country); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fmt.Sprint(query.Values[float64](q)); got != "map[declining:40624]" {
+		t.Errorf("Sum: got %v", got)
+	}
+	// Collect keeps all countries.
+	if got := len(q.Distinct()); got != len(countries) {
+		t.Errorf("Distinct: got %v", got)
+	}
+	// One group for Sum, 3 regions and 5 distinct name lengths.
+	if got := q.Len(); got != 1+3+5 {
+		t.Errorf("Len: got %v", got)
+	}
+}
+
+func TestTopK_ties(t *testing.T) {
+	byChange := func(a, b Country) bool {
+		return a.Change < b.Change
+	}
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.TopK[CountryID](3, byChange)).Map(query.GroupBy[CountryID](func(Country) query.ResultKey {
+		return "all"
+	}))
+	q := qb.Query()
+	for _, country := range append(countries, Country{ID: 8, Name: "Ukraine 2", Change: -0.004}) {
+		q.Scan(country)
+	}
+	var names []string
+	for _, country := range q.Distinct() {
+		names = append(names, country.Name)
+	}
+	if got := strings.Join(names, ", "); got != "Vatican City, Niue, Ukraine 2" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func ExampleQueryBuilder_OrderBy() {
+	moreDeclining := func(a, b Country) bool {
+		return a.Change < b.Change
+	}
+	q := query.Build[CountryID, Country]().OrderBy(moreDeclining).Limit(3).Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	for _, country := range q.Distinct() {
+		fmt.Println(country.Name, country.Change)
+	}
+	// Output:
+	// Vatican City -0.011
+	// Niue -0.004
+	// Poland -0.001
+}
+
+func ExampleQuery_Next() {
+	cursor := ""
+	for page := 1; ; page++ {
+		qb := query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(3)
+		if cursor != "" {
+			after, err := query.ParseCursor(cursor)
+			if err != nil {
+				panic(err)
+			}
+			qb.After(after)
+		}
+		q := qb.Query()
+		for _, country := range countries {
+			q.Scan(country)
+		}
+		fmt.Print(page, ":")
+		for _, country := range q.Distinct() {
+			fmt.Print(" ", country.Name)
+		}
+		fmt.Println()
+		next, ok := q.Next()
+		if !ok {
+			break
+		}
+		cursor = next.String()
+	}
+	// Output:
+	// 1: Vatican City Tokelau Niue
+	// 2: Tuvalu Nauru Poland
+	// 3: Ukraine
+}
+
+func scanAll(q query.Query[CountryID, Country], countries []Country) []string {
+	for _, country := range countries {
+		if err := q.Scan(country); err != nil {
+			panic(err)
+		}
+	}
+	var names []string
+	for _, country := range q.Distinct() {
+		names = append(names, country.Name)
+	}
+	return names
+}
+
+func TestQueryBuilder_Limit(t *testing.T) {
+	byRegion := func(a, b Country) bool {
+		return a.Region < b.Region
+	}
+	for _, tt := range []struct {
+		builder *query.QueryBuilder[CountryID, Country]
+		want    string
+	}{
+		// Without ordering or paging, features are sorted by key.
+		{query.Build[CountryID, Country](), "Vatican City, Tokelau, Niue, Tuvalu, Nauru, Poland, Ukraine"},
+		// Ties are broken by key.
+		{query.Build[CountryID, Country]().OrderBy(byRegion), "Vatican City, Poland, Ukraine, Tuvalu, Nauru, Tokelau, Niue"},
+		{query.Build[CountryID, Country]().OrderBy(byRegion).Offset(2).Limit(3), "Ukraine, Tuvalu, Nauru"},
+		{query.Build[CountryID, Country]().OrderBy(byRegion).Offset(6).Limit(3), "Niue"},
+		{query.Build[CountryID, Country]().OrderBy(byRegion).Offset(7), ""},
+		{query.Build[CountryID, Country]().Limit(2), "Vatican City, Tokelau"},
+		{query.Build[CountryID, Country]().Offset(5), "Poland, Ukraine"},
+		// Negative values are treated as 0.
+		{query.Build[CountryID, Country]().OrderBy(byRegion).Offset(-1).Limit(2), "Vatican City, Poland"},
+		{query.Build[CountryID, Country]().Offset(5).Limit(-1), "Poland, Ukraine"},
+		{query.Build[CountryID, Country]().Limit(2).After(query.Cursor{Offset: -3}), "Vatican City, Tokelau"},
+		// Aggregated results are ordered after scanning.
+		{query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(2).Aggregate(GroupByRegionMostPopulated{}), "Niue, Nauru"},
+	} {
+		q := tt.builder.Query()
+		// Scanning features twice doesn't duplicate results.
+		scanAll(q, countries)
+		if got := strings.Join(scanAll(q, countries), ", "); got != tt.want {
+			t.Errorf("got %v, want %v", got, tt.want)
+		}
+		if n := len(q.Distinct()); q.Count() != n {
+			t.Errorf("Expected count %v, got %v", n, q.Count())
+		}
+	}
+}
+
+// GroupByRegionMostPopulated keeps the most populated country per region.
+type GroupByRegionMostPopulated struct {
+	GroupByRegion
+	MostPopulated
+}
+
+func TestQueryBuilder_Limit_keepsOnlyPage(t *testing.T) {
+	q := query.Build[CountryID, Country]().OrderBy(lessPopulated).Offset(1).Limit(2).Query()
+	for i := len(countries) - 1; i >= 0; i-- {
+		q.Scan(countries[i])
+		// Offset, limit and one more to tell if there's a next page.
+		if q.Len() > 4 {
+			t.Fatalf("Kept %v features", q.Len())
+		}
+	}
+	if got := strings.Join(scanAll(q, nil), ", "); got != "Tokelau, Niue" {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := q.Next(); !ok {
+		t.Errorf("Expected a next page")
+	}
+}
+
+func TestQueryBuilder_After(t *testing.T) {
+	first := query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(3).Query()
+	scanAll(first, countries)
+	cursor, _ := first.Next()
+	parsed, err := query.ParseCursor(cursor.String())
+	if err != nil || parsed != cursor {
+		t.Fatalf("got %v, %v", parsed, err)
+	}
+
+	// Tokelau is gone and a less populated country has been added.
+	changed := append([]Country{{ID: 8, Name: "Pitcairn", Population: 50}}, countries[:1]...)
+	changed = append(changed, countries[2:]...)
+	next := query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(3).After(cursor).Query()
+	if got := strings.Join(scanAll(next, changed), ", "); got != "Tuvalu, Nauru, Poland" {
+		t.Errorf("got %v", got)
+	}
+	if next.Count() != 3 {
+		t.Errorf("Expected count 3, got %v", next.Count())
+	}
+
+	// Niue, the last feature of the first page, is gone too so the offset is used.
+	changed = append(changed[:2], changed[3:]...)
+	next = query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(3).After(cursor).Query()
+	if got := strings.Join(scanAll(next, changed), ", "); got != "Nauru, Poland, Ukraine" {
+		t.Errorf("got %v", got)
+	}
+
+	if _, err := query.ParseCursor("!"); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func ExampleQueryBuilder_Plan() {
+	plan := query.Build[CountryID, Country]().Where(RegionIs("Europe")).Plan()
+	// Each query executing the plan has its own results.
+	europe, all := plan.Query(), plan.Query()
+	for _, country := range countries {
+		europe.Scan(country)
+		all.Scan(country)
+		all.Scan(country)
+	}
+	fmt.Println(len(europe.Distinct()), len(all.Distinct()))
+	// Output: 3 3
+}
+
+func TestQuery_Reset(t *testing.T) {
+	qb := query.Build[CountryID, Country]().OrderBy(lessPopulated).Limit(1)
+	q := qb.Query()
+	scanAll(q, countries)
+	copied := q
+	q.Reset()
+	if got := scanAll(q, countries[1:]); fmt.Sprint(got) != "[Tokelau]" {
+		t.Errorf("got %v", got)
+	}
+	if got := scanAll(copied, nil); fmt.Sprint(got) != "[Vatican City]" {
+		t.Errorf("Copy: got %v", got)
+	}
+
+	// Changing the builder doesn't affect plans built before.
+	plan := qb.Plan()
+	qb.Where(RegionIs("Europe")).Limit(2)
+	if got := scanAll(plan.Query(), countries); fmt.Sprint(got) != "[Vatican City]" {
+		t.Errorf("Plan: got %v", got)
+	}
+	if got := scanAll(qb.Query(), countries); fmt.Sprint(got) != "[Vatican City Poland]" {
+		t.Errorf("Builder: got %v", got)
+	}
+}
+
+func TestQuery_Reset_streams(t *testing.T) {
+	qb := query.Build[CountryID, Country]()
+	stream := qb.StreamTo(MostPopulated{}).Map(GroupByRegion{})
+	plan := qb.Plan()
+	// Changing a stream doesn't affect plans built before.
+	stream.Map(query.GroupBy[CountryID](func(Country) query.ResultKey { return nil }))
+	got := scanAll(plan.Query(), countries)
+	sort.Strings(got)
+	if fmt.Sprint(got) != "[Nauru Niue Ukraine]" {
+		t.Errorf("Plan: got %v", got)
+	}
+	if got := scanAll(qb.Query(), countries); len(got) != 0 {
+		t.Errorf("Builder: got %v", got)
+	}
+}
+
+func ExampleResult_Each() {
+	// Like ExampleBuild_groupingResults but keeping regions.
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(MostPopulated{}).Map(GroupByRegion{})
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	q.Result().Each(func(region query.ResultKey, entry *query.ResultEntry[CountryID, Country]) error {
+		fmt.Println(region, entry.Features[0].Name)
+		return nil
+	})
+	// Output:
+	// Europe Ukraine
+	// Oceania Nauru
+	// Polynesia Niue
+}
+
+func ExampleMeta() {
+	qb := query.Build[CountryID, Country]()
+	qb.StreamTo(query.Count[CountryID, Country]()).Map(query.GroupBy[CountryID](byRegion))
+	q := qb.Query()
+	for _, country := range countries {
+		q.Scan(country)
+	}
+	entry, ok := q.Result().Get("Europe")
+	if !ok {
+		panic("no countries in Europe")
+	}
+	count, _ := query.Meta[int](entry)
+	fmt.Println(q.Result().Len(), "regions;", count, "countries in Europe")
+	// Output: 3 regions; 3 countries in Europe
+}
+
+func TestResult_Keys(t *testing.T) {
+	qb := query.Build[CountryID, Country]()
+	keys := []query.ResultKey{"b", 2.5, CountryID(10), true, "a", int8(-3), nil, false, uint(3), struct{ X int }{2}, struct{ X int }{1}}
+	qb.StreamTo(query.Collect[CountryID, Country]()).Map(mapperFunc(func(match *query.Match[CountryID, Country]) {
+		match.ReplaceKeys(keys...)
+	}))
+	q := qb.Query()
+	q.Scan(countries[0])
+	got := fmt.Sprint(q.Result().Keys())
+	if want := "[<nil> false true -3 2.5 3 10 a b {1} {2}]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := q.Result().Get("c"); ok {
+		t.Errorf("Unexpected entry")
+	}
+	errStop := fmt.Errorf("Stop")
+	visited := 0
+	err := q.Result().Each(func(query.ResultKey, *query.ResultEntry[CountryID, Country]) error {
+		visited++
+		return errStop
+	})
+	if err != errStop || visited != 1 {
+		t.Errorf("got %v after %v entries", err, visited)
+	}
+}
+
+func TestResult_Keys_ties(t *testing.T) {
+	qb := query.Build[CountryID, Country]()
+	keys := []query.ResultKey{uint(1), int8(1), 1.0, 1, int64(1 << 60), int64(1<<60 + 1), CountryID(1)}
+	qb.StreamTo(query.Collect[CountryID, Country]()).Map(mapperFunc(func(match *query.Match[CountryID, Country]) {
+		match.ReplaceKeys(keys...)
+	}))
+	q := qb.Query()
+	q.Scan(countries[0])
+	// Equal values are ordered by type name.
+	want := "[1 1 1 1 1 1152921504606846976 1152921504606846977]"
+	types := "[float64 int int8 uint query_test.CountryID int64 int64]"
+	for i := 0; i < 50; i++ {
+		got := q.Result().Keys()
+		gotTypes := make([]string, len(got))
+		for i, key := range got {
+			gotTypes[i] = fmt.Sprintf("%T", key)
+		}
+		if fmt.Sprint(got) != want || fmt.Sprint(gotTypes) != types {
+			t.Fatalf("Expected %v of types %v, got %v of types %v", want, types, got, gotTypes)
+		}
+	}
+}
+
+type mapperFunc func(match *query.Match[CountryID, Country])
+
+func (fn mapperFunc) Map(match *query.Match[CountryID, Country]) (*query.Match[CountryID, Country], error) {
+	fn(match)
+	return match, nil
+}
@@ -1,6 +1,12 @@
 package query
 
 import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
 	"github.com/bilus/fencer/feature"
 )
 
@@ -17,6 +23,8 @@ type ResultEntry[K feature.Key, F feature.Feature[K]] struct {
 // Result is a map of keys and the corresponding entries containing a query result.
 type Result[K feature.Key, F feature.Feature[K]] struct {
 	entries map[ResultKey]*ResultEntry[K, F]
+	order   *order[K, F]       // Nil unless ordered or paged.
+	top     *featureHeap[K, F] // Features kept by boundedAggregator.
 }
 
 // UpdateFunc is a callback passed to Result.Update.
@@ -33,6 +41,164 @@ func (result *Result[K, F]) Update(key ResultKey, f UpdateFunc[K, F]) error {
 	return f(entry)
 }
 
+// Len returns the number of entries.
+func (result *Result[K, F]) Len() int {
+	return len(result.entries)
+}
+
+// Get returns the entry for a key.
+func (result *Result[K, F]) Get(key ResultKey) (*ResultEntry[K, F], bool) {
+	entry, ok := result.entries[key]
+	return entry, ok
+}
+
+// Keys returns keys of all entries in order: nil first, then booleans,
+// numbers and strings, each by value, then other keys by type name and
+// formatted value. Keys of equal values, e.g. 1 and 1.0, are ordered by type
+// name. Pointers, keys containing them and NaNs have no stable order.
+func (result *Result[K, F]) Keys() []ResultKey {
+	keys := make([]ResultKey, 0, len(result.entries))
+	for key := range result.entries {
+		keys = append(keys, key)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return compareKeys(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// Each calls fn for each entry in order of keys (see Keys), stopping at the
+// first error.
+func (result *Result[K, F]) Each(fn func(key ResultKey, entry *ResultEntry[K, F]) error) error {
+	for _, key := range result.Keys() {
+		if err := fn(key, result.entries[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Meta returns metadata of an entry if it's of type V.
+func Meta[V any, K feature.Key, F feature.Feature[K]](entry *ResultEntry[K, F]) (V, bool) {
+	value, ok := entry.Meta.(V)
+	return value, ok
+}
+
+// compareKeys returns -1, 0 or 1 if a sorts before, the same as or after b.
+func compareKeys(a, b ResultKey) int {
+	ra, rb := keyRank(a), keyRank(b)
+	if ra != rb || ra == nilKey {
+		return compareInts(ra, rb)
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var c int
+	switch ra {
+	case boolKey:
+		c = compareInts(boolInt(va.Bool()), boolInt(vb.Bool()))
+	case numberKey:
+		c = compareNumbers(va, vb)
+	case stringKey:
+		c = strings.Compare(va.String(), vb.String())
+	}
+	if c != 0 {
+		return c
+	}
+	if c = strings.Compare(typeName(va.Type()), typeName(vb.Type())); c != 0 || ra != otherKey {
+		return c
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// typeName returns the name of a type qualified by its package path.
+func typeName(t reflect.Type) string {
+	return t.PkgPath() + " " + t.String()
+}
+
+const (
+	nilKey = iota
+	boolKey
+	numberKey
+	stringKey
+	otherKey
+)
+
+// keyRank returns the position of a kind of keys in the order of keys.
+func keyRank(key ResultKey) int {
+	if key == nil {
+		return nilKey
+	}
+	switch reflect.ValueOf(key).Kind() {
+	case reflect.Bool:
+		return boolKey
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return numberKey
+	case reflect.String:
+		return stringKey
+	default:
+		return otherKey
+	}
+}
+
+// compareNumbers compares integers exactly and other numbers as float64,
+// with NaNs first.
+func compareNumbers(a, b reflect.Value) int {
+	switch {
+	case a.CanInt() && b.CanInt():
+		return compareInts(a.Int(), b.Int())
+	case a.CanUint() && b.CanUint():
+		return compareInts(a.Uint(), b.Uint())
+	case a.CanInt() && b.CanUint():
+		if a.Int() < 0 {
+			return -1
+		}
+		return compareInts(uint64(a.Int()), b.Uint())
+	case a.CanUint() && b.CanInt():
+		return -compareNumbers(b, a)
+	}
+	fa, fb := number(a), number(b)
+	switch {
+	case math.IsNaN(fa) || math.IsNaN(fb):
+		return compareInts(boolInt(!math.IsNaN(fa)), boolInt(!math.IsNaN(fb)))
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func number(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+func compareInts[T int | int64 | uint64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (result *Result[K, F]) distinct() []F {
 	features := make([]F, 0)
 	matched := make(map[K]struct{})
@@ -0,0 +1,86 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/query"
+	"github.com/bilus/fencer/query/expr"
+)
+
+// Constructor builds a value from JSON arguments, which are nil if omitted.
+type Constructor[T any] func(args json.RawMessage) (T, error)
+
+// Typed turns a function taking decoded arguments into a constructor.
+// Arguments are decoded into A, rejecting unknown fields; omitted arguments
+// leave A zero.
+func Typed[A, T any](fn func(args A) (T, error)) Constructor[T] {
+	return func(raw json.RawMessage) (T, error) {
+		var args A
+		if len(raw) > 0 && string(raw) != "null" {
+			if err := decode(raw, &args); err != nil {
+				var zero T
+				return zero, err
+			}
+		}
+		return fn(args)
+	}
+}
+
+// Static returns a constructor of a value taking no arguments.
+func Static[T any](value T) Constructor[T] {
+	return func(raw json.RawMessage) (T, error) {
+		if len(raw) > 0 && string(raw) != "null" {
+			var zero T
+			return zero, fmt.Errorf("No arguments expected")
+		}
+		return value, nil
+	}
+}
+
+// Registry maps names used in specs to constructors.
+type Registry[K feature.Key, F feature.Feature[K]] struct {
+	// Accessor resolves fields in filters; filters are rejected if nil.
+	Accessor expr.Accessor[F]
+
+	conditions map[string]Constructor[query.Condition[K, F]]
+	mappers    map[string]Constructor[query.Mapper[K, F]]
+	reducers   map[string]Constructor[query.Reducer[K, F]]
+	orders     map[string]Constructor[func(a, b F) bool]
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry[K feature.Key, F feature.Feature[K]]() *Registry[K, F] {
+	return &Registry[K, F]{
+		conditions: make(map[string]Constructor[query.Condition[K, F]]),
+		mappers:    make(map[string]Constructor[query.Mapper[K, F]]),
+		reducers:   make(map[string]Constructor[query.Reducer[K, F]]),
+		orders:     make(map[string]Constructor[func(a, b F) bool]),
+	}
+}
+
+// Condition registers a condition constructor.
+func (registry *Registry[K, F]) Condition(name string, constructor Constructor[query.Condition[K, F]]) *Registry[K, F] {
+	registry.conditions[name] = constructor
+	return registry
+}
+
+// Mapper registers a mapper constructor.
+func (registry *Registry[K, F]) Mapper(name string, constructor Constructor[query.Mapper[K, F]]) *Registry[K, F] {
+	registry.mappers[name] = constructor
+	return registry
+}
+
+// Reducer registers a reducer constructor.
+func (registry *Registry[K, F]) Reducer(name string, constructor Constructor[query.Reducer[K, F]]) *Registry[K, F] {
+	registry.reducers[name] = constructor
+	return registry
+}
+
+// Order registers a constructor of an ordering, a function reporting
+// whether a sorts before b.
+func (registry *Registry[K, F]) Order(name string, constructor Constructor[func(a, b F) bool]) *Registry[K, F] {
+	registry.orders[name] = constructor
+	return registry
+}
@@ -0,0 +1,247 @@
+// Package spec describes queries as JSON documents, e.g. to send them from
+// clients to servers or to store saved searches:
+//
+//	{
+//	  "bounds": [14.1, 49.0, 24.2, 54.9],
+//	  "filter": "population > 10000",
+//	  "where": [{"name": "region", "args": {"in": ["Europe"]}}],
+//	  "aggregate": [{"map": [{"name": "by_region"}], "reduce": {"name": "collect"}}],
+//	  "order_by": {"name": "population"},
+//	  "desc": true,
+//	  "limit": 10
+//	}
+//
+// Conditions, mappers, reducers and orderings are referred to by names
+// resolved using a Registry when a spec is compiled.
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/query"
+	"github.com/bilus/fencer/query/expr"
+)
+
+// Spec is a query specification.
+type Spec struct {
+	// Bounds to search, as [minX, minY, maxX, maxY].
+	Bounds *[4]float64 `json:"bounds,omitempty"`
+	// Point features must contain; an alternative to bounds.
+	Point *primitives.Point `json:"point,omitempty"`
+	// Filter is an expression in the query/expr language.
+	Filter string `json:"filter,omitempty"`
+	// Where lists conditions, all of which must match.
+	Where []Call `json:"where,omitempty"`
+	// Aggregate lists aggregations, any of which may keep a feature.
+	Aggregate []Aggregation `json:"aggregate,omitempty"`
+	// OrderBy names an ordering of results.
+	OrderBy *Call `json:"order_by,omitempty"`
+	Desc    bool  `json:"desc,omitempty"`
+	// Offset and Limit select a page of results; Limit 0 means no limit.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+	// Cursor returned by Search.Next selects the next page instead of Offset.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Call refers to a registered constructor by name, with optional arguments.
+type Call struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// Aggregation is a sequence of mappers, e.g. grouping features, followed by
+// a reducer.
+type Aggregation struct {
+	Map    []Call `json:"map,omitempty"`
+	Reduce Call   `json:"reduce"`
+}
+
+// Parse decodes a spec, rejecting unknown fields.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := decode(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+func decode(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("Unexpected data after JSON value")
+	}
+	return nil
+}
+
+// Error is a validation error of a part of a spec.
+type Error struct {
+	Path string // E.g. "where[1].args".
+	Err  error
+}
+
+func (err *Error) Error() string {
+	return err.Path + ": " + err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Errors lists all validation errors of a spec.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Search is a compiled spec. It can be run any number of times, including
+// concurrently.
+type Search[K feature.Key, F feature.Feature[K]] struct {
+	Bounds *primitives.Rect
+	Plan   query.Plan[K, F]
+}
+
+// Run executes the search against an index. It returns a cursor token to
+// use in the spec of the next page, or "" if there are no more features.
+func (search *Search[K, F]) Run(idx *index.Index[K, F]) (features []F, next string, err error) {
+	q, err := idx.Execute(search.Bounds, search.Plan.Query())
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor, ok := q.Next(); ok {
+		next = cursor.String()
+	}
+	return q.Distinct(), next, nil
+}
+
+// Compile validates a spec and builds a search, resolving names using a
+// registry. It returns Errors listing all problems found.
+func Compile[K feature.Key, F feature.Feature[K]](spec *Spec, registry *Registry[K, F]) (*Search[K, F], error) {
+	var errs Errors
+	fail := func(path string, err error) {
+		errs = append(errs, &Error{Path: path, Err: err})
+	}
+	search := &Search[K, F]{}
+	builder := query.Build[K, F]()
+
+	switch {
+	case spec.Bounds != nil && spec.Point != nil:
+		fail("point", fmt.Errorf("Cannot be used with bounds"))
+	case spec.Bounds != nil:
+		b := spec.Bounds
+		if b[0] > b[2] || b[1] > b[3] {
+			fail("bounds", fmt.Errorf("Minimum exceeds maximum"))
+		}
+		search.Bounds = &primitives.Rect{Min: primitives.Point{b[0], b[1]}, Max: primitives.Point{b[2], b[3]}}
+	case spec.Point != nil:
+		point := *spec.Point
+		size := math.SmallestNonzeroFloat64
+		search.Bounds = &primitives.Rect{Min: point, Max: primitives.Point{point[0] + size, point[1] + size}}
+		builder.Where(query.Contains[K, F]{Point: point})
+	default:
+		fail("bounds", fmt.Errorf("Either bounds or point is required"))
+	}
+
+	if spec.Filter != "" {
+		if registry.Accessor == nil {
+			fail("filter", fmt.Errorf("Filters are not supported"))
+		} else if condition, err := expr.Compile[K](spec.Filter, registry.Accessor); err != nil {
+			fail("filter", err)
+		} else {
+			builder.Where(condition)
+		}
+	}
+
+	for i, call := range spec.Where {
+		path := fmt.Sprintf("where[%d]", i)
+		condition, err := resolve(registry.conditions, "condition", call)
+		if err != nil {
+			fail(path, err)
+			continue
+		}
+		builder.Where(condition)
+	}
+
+	for i, aggregation := range spec.Aggregate {
+		path := fmt.Sprintf("aggregate[%d]", i)
+		reducer, err := resolve(registry.reducers, "reducer", aggregation.Reduce)
+		if err != nil {
+			fail(path+".reduce", err)
+		}
+		stream := builder.StreamTo(reducer)
+		for j, call := range aggregation.Map {
+			mapper, err := resolve(registry.mappers, "mapper", call)
+			if err != nil {
+				fail(fmt.Sprintf("%s.map[%d]", path, j), err)
+				continue
+			}
+			stream.Map(mapper)
+		}
+	}
+
+	if spec.OrderBy != nil {
+		less, err := resolve(registry.orders, "ordering", *spec.OrderBy)
+		if err != nil {
+			fail("order_by", err)
+		} else if spec.Desc {
+			builder.OrderBy(func(a, b F) bool { return less(b, a) })
+		} else {
+			builder.OrderBy(less)
+		}
+	} else if spec.Desc {
+		fail("desc", fmt.Errorf("Requires order_by"))
+	}
+	if spec.Offset < 0 {
+		fail("offset", fmt.Errorf("Cannot be negative"))
+	}
+	if spec.Limit < 0 {
+		fail("limit", fmt.Errorf("Cannot be negative"))
+	}
+	builder.Offset(spec.Offset).Limit(spec.Limit)
+	if spec.Cursor != "" {
+		if cursor, err := query.ParseCursor(spec.Cursor); err != nil {
+			fail("cursor", err)
+		} else {
+			builder.After(cursor)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	search.Plan = builder.Plan()
+	return search, nil
+}
+
+// resolve calls the constructor registered under a name.
+func resolve[T any](constructors map[string]Constructor[T], what string, call Call) (T, error) {
+	var zero T
+	if call.Name == "" {
+		return zero, fmt.Errorf("Missing %s name", what)
+	}
+	constructor, ok := constructors[call.Name]
+	if !ok {
+		return zero, fmt.Errorf("Unknown %s %q", what, call.Name)
+	}
+	value, err := constructor(call.Args)
+	if err != nil {
+		return zero, fmt.Errorf("Bad arguments to %s: %w", call.Name, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,272 @@
+package spec_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/query"
+	"github.com/bilus/fencer/query/expr"
+	"github.com/bilus/fencer/query/spec"
+)
+
+type (
+	Feature   = *geometry.Feature
+	Condition = query.Condition[geometry.ID, Feature]
+	Mapper    = query.Mapper[geometry.ID, Feature]
+	Reducer   = query.Reducer[geometry.ID, Feature]
+	Match     = query.Match[geometry.ID, Feature]
+	Result    = query.Result[geometry.ID, Feature]
+	Entry     = query.ResultEntry[geometry.ID, Feature]
+)
+
+func city(id geometry.ID, x, y float64, region string, population int) Feature {
+	return geometry.NewFeature(id, geometry.Point{x, y}, map[string]any{
+		"region":     region,
+		"population": population,
+	})
+}
+
+var cities = []Feature{
+	city("warsaw", 21.0, 52.2, "Europe", 1790000),
+	city("krakow", 19.9, 50.0, "Europe", 780000),
+	city("gdansk", 18.6, 54.3, "Europe", 470000),
+	city("hel", 18.8, 54.6, "Europe", 3000),
+	city("lviv", 24.0, 49.8, "Europe", 720000),
+	city("wellington", 174.7, -41.2, "Oceania", 210000),
+}
+
+// byRegion groups features by region.
+type byRegion struct{}
+
+func (byRegion) Map(match *Match) (*Match, error) {
+	match.AddKey(match.Feature.Properties["region"])
+	return match, nil
+}
+
+// collect keeps features for each result key.
+type collect struct{}
+
+func (collect) Reduce(result *Result, match *Match) error {
+	for _, key := range match.ResultKeys {
+		err := result.Update(key, func(entry *Entry) error {
+			entry.Features = append(entry.Features, match.Feature)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registry() *spec.Registry[geometry.ID, Feature] {
+	registry := spec.NewRegistry[geometry.ID, Feature]()
+	registry.Accessor = expr.FeatureProperties
+	registry.Condition("region", spec.Typed(func(args struct{ In []string }) (Condition, error) {
+		if len(args.In) == 0 {
+			return nil, fmt.Errorf("Expected at least one region")
+		}
+		return query.Pred[geometry.ID, Feature](func(f Feature) (bool, error) {
+			for _, region := range args.In {
+				if f.Properties["region"] == region {
+					return true, nil
+				}
+			}
+			return false, nil
+		}), nil
+	}))
+	registry.Mapper("by_region", spec.Static[Mapper](byRegion{}))
+	registry.Reducer("collect", spec.Static[Reducer](collect{}))
+	registry.Order("population", spec.Static(func(a, b Feature) bool {
+		return a.Properties["population"].(int) < b.Properties["population"].(int)
+	}))
+	return registry
+}
+
+func Example() {
+	idx, err := index.New[geometry.ID](cities)
+	if err != nil {
+		panic(err)
+	}
+	s, err := spec.Parse([]byte(`{
+		"bounds": [14.1, 49.0, 24.2, 54.9],
+		"filter": "population > 10000",
+		"where": [{"name": "region", "args": {"in": ["Europe"]}}],
+		"aggregate": [{"map": [{"name": "by_region"}], "reduce": {"name": "collect"}}],
+		"order_by": {"name": "population"},
+		"desc": true,
+		"limit": 3
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	search, err := spec.Compile(s, registry())
+	if err != nil {
+		panic(err)
+	}
+	features, _, err := search.Run(idx)
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range features {
+		fmt.Println(f.Key())
+	}
+	// Output:
+	// warsaw
+	// krakow
+	// lviv
+}
+
+func ExampleCompile_errors() {
+	s, _ := spec.Parse([]byte(`{
+		"filter": "population >",
+		"where": [{"name": "region", "args": {"in": []}}, {"name": "capital"}],
+		"aggregate": [{"map": [{"name": "by_region", "args": 1}], "reduce": {}}],
+		"desc": true,
+		"limit": -1
+	}`))
+	_, err := spec.Compile(s, registry())
+	for _, err := range err.(spec.Errors) {
+		fmt.Println(err)
+	}
+	// Output:
+	// bounds: Either bounds or point is required
+	// filter: 1:13: Expected an operand, got end of expression
+	// where[0]: Bad arguments to region: Expected at least one region
+	// where[1]: Unknown condition "capital"
+	// aggregate[0].reduce: Missing reducer name
+	// aggregate[0].map[0]: Bad arguments to by_region: No arguments expected
+	// desc: Requires order_by
+	// limit: Cannot be negative
+}
+
+func TestCompile_point(t *testing.T) {
+	park := geometry.NewFeature("park", geometry.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}, nil)
+	idx, err := index.New[geometry.ID]([]Feature{park, cities[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	search, err := spec.Compile(&spec.Spec{Point: &[2]float64{5, 5}}, registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, _, err := search.Run(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 || features[0].Key() != "park" {
+		t.Errorf("got %v", features)
+	}
+}
+
+func TestCompile_page(t *testing.T) {
+	idx, err := index.New[geometry.ID](cities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		json string
+		want string
+	}{
+		{`{"bounds": [-180, -90, 180, 90], "order_by": {"name": "population"}}`, "[hel wellington gdansk lviv krakow warsaw]"},
+		{`{"bounds": [-180, -90, 180, 90], "order_by": {"name": "population"}, "offset": 4}`, "[krakow warsaw]"},
+		{`{"bounds": [-180, -90, 180, 90], "order_by": {"name": "population"}, "offset": 2, "limit": 2}`, "[gdansk lviv]"},
+		{`{"bounds": [-180, -90, 180, 90], "order_by": {"name": "population"}, "offset": 6}`, "[]"},
+		{`{"bounds": [-180, -90, 180, 90], "filter": "region = 'Oceania'"}`, "[wellington]"},
+	} {
+		s, err := spec.Parse([]byte(tt.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		search, err := spec.Compile(s, registry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		features, _, err := search.Run(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]geometry.ID, len(features))
+		for i, f := range features {
+			keys[i] = f.Key()
+		}
+		if got := fmt.Sprint(keys); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, json := range []string{
+		`{"bounds": [1, 2, 3]`,
+		`{"bound": [1, 2, 3, 4]}`,
+		`{"bounds": [1, 2, 3, 4]} {}`,
+	} {
+		if _, err := spec.Parse([]byte(json)); err == nil {
+			t.Errorf("%s: expected an error", json)
+		}
+	}
+}
+
+func TestCompile_validation(t *testing.T) {
+	registry := registry()
+	registry.Accessor = nil
+	for json, want := range map[string]string{
+		`{"bounds": [3, 2, 1, 4]}`:                                                     "bounds: Minimum exceeds maximum",
+		`{"bounds": [1, 2, 3, 4], "point": [1, 2]}`:                                    "point: Cannot be used with bounds",
+		`{"bounds": [1, 2, 3, 4], "filter": "region = 'Europe'"}`:                      "filter: Filters are not supported",
+		`{"point": [1, 2], "where": [{"name": "region", "args": {"on": ["Europe"]}}]}`: `where[0]: Bad arguments to region: json: unknown field "on"`,
+		`{"point": [1, 2], "order_by": {"name": "area"}}`:                              `order_by: Unknown ordering "area"`,
+		`{"point": [1, 2], "aggregate": [{"reduce": {"name": "sum"}}]}`:                `aggregate[0].reduce: Unknown reducer "sum"`,
+		`{"point": [1, 2], "offset": -1}`:                                              "offset: Cannot be negative",
+	} {
+		s, err := spec.Parse([]byte(json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = spec.Compile(s, registry)
+		var errs spec.Errors
+		if !errors.As(err, &errs) || err.Error() != want {
+			t.Errorf("%s: got %v, want %s", json, err, want)
+		}
+	}
+}
+
+func TestSearch_Run_cursor(t *testing.T) {
+	idx, err := index.New[geometry.ID](cities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &spec.Spec{Bounds: &[4]float64{-180, -90, 180, 90}, OrderBy: &spec.Call{Name: "population"}, Limit: 4}
+	var pages []string
+	for {
+		search, err := spec.Compile(s, registry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		features, cursor, err := search.Run(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]geometry.ID, len(features))
+		for i, f := range features {
+			keys[i] = f.Key()
+		}
+		pages = append(pages, fmt.Sprint(keys))
+		if cursor == "" {
+			break
+		}
+		s.Cursor = cursor
+	}
+	if got := fmt.Sprint(pages); got != "[[hel wellington gdansk lviv] [krakow warsaw]]" {
+		t.Errorf("got %v", got)
+	}
+
+	s.Cursor = "?"
+	if _, err := spec.Compile(s, registry()); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
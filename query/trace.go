@@ -0,0 +1,34 @@
+package query
+
+// Tracer starts spans timing stages of query execution: a "query.Scan" span
+// per scanned feature with a child span per condition ("condition Name"),
+// mapper ("map Name") and reducer ("reduce Name"), named using
+// ConditionName. Index.Query adds "index.Query" and "rtree.Search" spans.
+// Tracers must be safe for concurrent use if queries are.
+type Tracer interface {
+	// Start starts a span, a child of parent unless it's nil.
+	Start(parent Span, name string) Span
+}
+
+// Span is a traced stage of query execution.
+type Span interface {
+	// SetAttribute annotates the span.
+	SetAttribute(key string, value any)
+	// End ends the span, which failed if err is not nil.
+	End(err error)
+}
+
+// start starts a span for a pipeline stage if tracing, i.e. if parent is
+// not nil.
+func (q *Query[K, F]) start(parent Span, kind string, stage any) Span {
+	if parent == nil {
+		return nil
+	}
+	return q.Tracer.Start(parent, kind+" "+ConditionName(stage))
+}
+
+func end(span Span, err error) {
+	if span != nil {
+		span.End(err)
+	}
+}
@@ -0,0 +1,253 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/wal"
+)
+
+// errSnapshotRequired is returned when the leader can't resume the stream
+// from the follower's revision.
+var errSnapshotRequired = errors.New("Snapshot required")
+
+// FollowerOptions configure a follower.
+type FollowerOptions struct {
+	Client        *http.Client  // Defaults to http.DefaultClient.
+	RetryInterval time.Duration // Delay before reconnecting; defaults to 1s.
+	// OnError is called with errors causing a reconnect; optional.
+	OnError func(err error)
+}
+
+// Follower maintains a replica of a leader's index.
+type Follower[K feature.Key, F feature.Feature[K]] struct {
+	url     string
+	codec   wal.Codec[K, F]
+	options FollowerOptions
+
+	mu       sync.RWMutex
+	index    *index.Index[K, F]
+	epoch    string
+	revision uint64
+	changed  chan struct{}
+}
+
+// NewFollower creates a follower of the leader served at leaderURL.
+func NewFollower[K feature.Key, F feature.Feature[K]](leaderURL string, codec wal.Codec[K, F], options FollowerOptions) *Follower[K, F] {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = time.Second
+	}
+	return &Follower[K, F]{
+		url:     strings.TrimSuffix(leaderURL, "/"),
+		codec:   codec,
+		options: options,
+		changed: make(chan struct{}),
+	}
+}
+
+// Run replicates the leader's index until ctx is cancelled, reconnecting on
+// errors. If a mutation can't be applied, the replica is reloaded from a
+// snapshot. It returns ctx.Err().
+func (follower *Follower[K, F]) Run(ctx context.Context) error {
+	for {
+		err := follower.sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errSnapshotRequired {
+			follower.mu.Lock()
+			follower.epoch = ""
+			follower.mu.Unlock()
+			continue
+		}
+		if err != nil && follower.options.OnError != nil {
+			follower.options.OnError(err)
+		}
+		select {
+		case <-time.After(follower.options.RetryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Read calls fn with shared access to the replica. It returns ErrNotReady
+// until the first snapshot is loaded.
+func (follower *Follower[K, F]) Read(fn func(idx *index.Index[K, F]) error) error {
+	follower.mu.RLock()
+	defer follower.mu.RUnlock()
+	if follower.index == nil {
+		return ErrNotReady
+	}
+	return fn(follower.index)
+}
+
+// ErrNotReady is returned when reading from a follower that hasn't loaded a
+// snapshot yet.
+var ErrNotReady = errors.New("Replica not ready")
+
+// Revision returns the last applied revision.
+func (follower *Follower[K, F]) Revision() uint64 {
+	follower.mu.RLock()
+	defer follower.mu.RUnlock()
+	return follower.revision
+}
+
+// WaitForRevision blocks until the follower applies the given revision or ctx
+// is cancelled.
+func (follower *Follower[K, F]) WaitForRevision(ctx context.Context, revision uint64) error {
+	for {
+		follower.mu.RLock()
+		ready := follower.index != nil && follower.revision >= revision
+		changed := follower.changed
+		follower.mu.RUnlock()
+		if ready {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sync loads a snapshot if needed and applies mutations until the stream
+// ends.
+func (follower *Follower[K, F]) sync(ctx context.Context) error {
+	follower.mu.RLock()
+	epoch, revision := follower.epoch, follower.revision
+	follower.mu.RUnlock()
+	if epoch == "" {
+		if err := follower.loadSnapshot(ctx); err != nil {
+			return err
+		}
+		follower.mu.RLock()
+		epoch, revision = follower.epoch, follower.revision
+		follower.mu.RUnlock()
+	}
+
+	query := url.Values{}
+	query.Set("since", strconv.FormatUint(revision, 10))
+	query.Set("epoch", epoch)
+	body, err := follower.get(ctx, mutationsPath+"?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(bufio.NewReader(body))
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		mutation, err := decodeMutation(follower.codec, msg)
+		if err != nil {
+			return err
+		}
+		if err := follower.apply(msg.Revision, mutation); err != nil {
+			return err
+		}
+	}
+}
+
+func (follower *Follower[K, F]) loadSnapshot(ctx context.Context) error {
+	body, err := follower.get(ctx, snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(bufio.NewReader(body))
+	var header message
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("Invalid snapshot header: %w", err)
+	}
+	var features []F
+	for {
+		var msg message
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		f, err := follower.codec.DecodeFeature(msg.Feature)
+		if err != nil {
+			return err
+		}
+		features = append(features, f)
+	}
+	idx, err := index.New[K](features)
+	if err != nil {
+		return err
+	}
+
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+	follower.index = idx
+	follower.epoch = header.Epoch
+	follower.revision = header.Revision
+	follower.notify()
+	return nil
+}
+
+// apply applies a mutation received from the leader. If it can't be
+// applied, the replica has diverged, so the next sync starts over from a
+// snapshot.
+func (follower *Follower[K, F]) apply(revision uint64, mutation index.Mutation[K, F]) error {
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+	if revision != follower.revision+1 {
+		follower.epoch = ""
+		return fmt.Errorf("Expected revision %d, got %d", follower.revision+1, revision)
+	}
+	if err := follower.index.Replay(mutation); err != nil {
+		follower.epoch = ""
+		return fmt.Errorf("Replaying revision %d: %w", revision, err)
+	}
+	follower.revision = revision
+	follower.notify()
+	return nil
+}
+
+func (follower *Follower[K, F]) notify() {
+	close(follower.changed)
+	follower.changed = make(chan struct{})
+}
+
+func (follower *Follower[K, F]) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, follower.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := follower.options.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusGone:
+		resp.Body.Close()
+		return nil, errSnapshotRequired
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected response from leader: %v", resp.Status)
+	}
+}
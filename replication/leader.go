@@ -0,0 +1,262 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/wal"
+)
+
+// DefaultBacklog is the default number of recent mutations a leader keeps for
+// followers catching up.
+const DefaultBacklog = 10000
+
+// LeaderOptions configure a leader.
+type LeaderOptions struct {
+	// Backlog is the number of recent mutations kept in memory. Followers
+	// lagging further behind have to start from a snapshot.
+	Backlog int
+}
+
+// Leader exposes an index and its mutation stream to followers over HTTP.
+// It implements http.Handler and index.BatchJournal.
+type Leader[K feature.Key, F feature.Feature[K]] struct {
+	codec   wal.Codec[K, F]
+	epoch   string
+	backlog int
+	next    index.Journal[K, F]
+
+	indexMu sync.RWMutex
+	index   *index.Index[K, F]
+
+	mu       sync.Mutex
+	revision uint64
+	// Mutations with consecutive revisions ending at revision, in a ring
+	// buffer of up to backlog messages starting at oldest.
+	messages []message
+	oldest   int
+	changed  chan struct{}
+}
+
+// NewLeader creates a leader for an index and attaches it as the index's
+// journal. A journal already attached to the index, e.g. a write-ahead log,
+// keeps receiving mutations before the leader does.
+func NewLeader[K feature.Key, F feature.Feature[K]](idx *index.Index[K, F], codec wal.Codec[K, F], options LeaderOptions) (*Leader[K, F], error) {
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		return nil, err
+	}
+	backlog := options.Backlog
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	leader := &Leader[K, F]{
+		codec:   codec,
+		epoch:   hex.EncodeToString(epoch),
+		backlog: backlog,
+		next:    idx.Journal(),
+		index:   idx,
+		changed: make(chan struct{}),
+	}
+	idx.SetJournal(leader)
+	return leader, nil
+}
+
+// Update calls fn with exclusive access to the index; all mutations must be
+// made through Update.
+func (leader *Leader[K, F]) Update(fn func(idx *index.Index[K, F]) error) error {
+	leader.indexMu.Lock()
+	defer leader.indexMu.Unlock()
+	return fn(leader.index)
+}
+
+// Read calls fn with shared access to the index.
+func (leader *Leader[K, F]) Read(fn func(idx *index.Index[K, F]) error) error {
+	leader.indexMu.RLock()
+	defer leader.indexMu.RUnlock()
+	return fn(leader.index)
+}
+
+// Revision returns the revision of the last mutation.
+func (leader *Leader[K, F]) Revision() uint64 {
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	return leader.revision
+}
+
+// Record assigns the next revision to a mutation and publishes it to
+// followers.
+func (leader *Leader[K, F]) Record(mutation index.Mutation[K, F]) error {
+	msg, err := encodeMutation(leader.codec, mutation)
+	if err != nil {
+		return err
+	}
+	if leader.next != nil {
+		if err := leader.next.Record(mutation); err != nil {
+			return err
+		}
+	}
+	leader.publish(msg)
+	return nil
+}
+
+// RecordBatch assigns consecutive revisions to mutations and publishes them
+// to followers at once. The next journal records them as a batch if it
+// implements index.BatchJournal.
+func (leader *Leader[K, F]) RecordBatch(mutations []index.Mutation[K, F]) error {
+	msgs := make([]message, len(mutations))
+	for i, mutation := range mutations {
+		msg, err := encodeMutation(leader.codec, mutation)
+		if err != nil {
+			return err
+		}
+		msgs[i] = msg
+	}
+	if batch, ok := leader.next.(index.BatchJournal[K, F]); ok {
+		if err := batch.RecordBatch(mutations); err != nil {
+			return err
+		}
+	} else if leader.next != nil {
+		for _, mutation := range mutations {
+			if err := leader.next.Record(mutation); err != nil {
+				return err
+			}
+		}
+	}
+	leader.publish(msgs...)
+	return nil
+}
+
+// publish appends messages to the backlog and wakes up followers.
+func (leader *Leader[K, F]) publish(msgs ...message) {
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	for _, msg := range msgs {
+		leader.revision++
+		msg.Revision = leader.revision
+		if len(leader.messages) < leader.backlog {
+			leader.messages = append(leader.messages, msg)
+		} else {
+			leader.messages[leader.oldest] = msg
+			leader.oldest = (leader.oldest + 1) % leader.backlog
+		}
+	}
+	close(leader.changed)
+	leader.changed = make(chan struct{})
+}
+
+// ServeHTTP serves the snapshot at <prefix>/snapshot and the mutation stream
+// at <prefix>/mutations.
+func (leader *Leader[K, F]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, snapshotPath):
+		leader.serveSnapshot(w, r)
+	case strings.HasSuffix(r.URL.Path, mutationsPath):
+		leader.serveMutations(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (leader *Leader[K, F]) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	var header message
+	var features []message
+	err := leader.Read(func(idx *index.Index[K, F]) error {
+		header = message{Epoch: leader.epoch, Revision: leader.Revision()}
+		return idx.Each(func(f F) error {
+			data, err := leader.codec.EncodeFeature(f)
+			if err != nil {
+				return err
+			}
+			features = append(features, message{Feature: data})
+			return nil
+		})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return
+	}
+	for _, msg := range features {
+		if err := enc.Encode(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (leader *Leader[K, F]) serveMutations(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("epoch") != leader.epoch {
+		http.Error(w, "Snapshot required", http.StatusGone)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	started := false
+	for {
+		messages, changed, ok := leader.since(since)
+		if !ok {
+			if !started {
+				http.Error(w, "Snapshot required", http.StatusGone)
+			}
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		for _, msg := range messages {
+			if err := enc.Encode(msg); err != nil {
+				return
+			}
+			since = msg.Revision
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// since returns mutations after a revision and a channel closed when a new
+// mutation is recorded. It returns ok = false if the mutations are no longer
+// in the backlog.
+func (leader *Leader[K, F]) since(revision uint64) (messages []message, changed <-chan struct{}, ok bool) {
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	if revision > leader.revision {
+		return nil, nil, false
+	}
+	first := leader.revision - uint64(len(leader.messages)) + 1
+	if revision+1 < first {
+		return nil, nil, false
+	}
+	for i := int(revision + 1 - first); i < len(leader.messages); i++ {
+		messages = append(messages, leader.messages[(leader.oldest+i)%len(leader.messages)])
+	}
+	return messages, leader.changed, true
+}
@@ -0,0 +1,76 @@
+// Package replication keeps read replicas of an index in sync with a leader
+// over HTTP.
+//
+// The leader numbers mutations with consecutive revisions. A follower starts
+// from a snapshot of the leader's index, then tails the mutation stream. After
+// a reconnect, it resumes from the last applied revision unless the leader no
+// longer has it, in which case it starts over from a fresh snapshot.
+//
+// Both endpoints return newline-delimited JSON. The snapshot starts with a
+// header carrying the revision it reflects, followed by one line per feature.
+// The mutation stream, requested with the revision to resume after, is a
+// never-ending sequence of mutations.
+package replication
+
+import (
+	"fmt"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/wal"
+)
+
+const (
+	snapshotPath  = "/snapshot"
+	mutationsPath = "/mutations"
+	contentType   = "application/x-ndjson"
+)
+
+// message is a single line of the snapshot or the mutation stream. Keys and
+// features are serialized using a codec.
+type message struct {
+	Epoch    string `json:"epoch,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	Op       string `json:"op,omitempty"`
+	Key      []byte `json:"key,omitempty"`
+	Feature  []byte `json:"feature,omitempty"`
+}
+
+var ops = map[string]index.Op{
+	index.OpInsert.String(): index.OpInsert,
+	index.OpDelete.String(): index.OpDelete,
+	index.OpUpdate.String(): index.OpUpdate,
+}
+
+func encodeMutation[K feature.Key, F feature.Feature[K]](codec wal.Codec[K, F], mutation index.Mutation[K, F]) (message, error) {
+	key, err := codec.EncodeKey(mutation.Key)
+	if err != nil {
+		return message{}, err
+	}
+	msg := message{Op: mutation.Op.String(), Key: key}
+	if mutation.Op != index.OpDelete {
+		msg.Feature, err = codec.EncodeFeature(mutation.Feature)
+		if err != nil {
+			return message{}, err
+		}
+	}
+	return msg, nil
+}
+
+func decodeMutation[K feature.Key, F feature.Feature[K]](codec wal.Codec[K, F], msg message) (index.Mutation[K, F], error) {
+	var mutation index.Mutation[K, F]
+	op, ok := ops[msg.Op]
+	if !ok {
+		return mutation, fmt.Errorf("Unsupported operation %q", msg.Op)
+	}
+	mutation.Op = op
+	key, err := codec.DecodeKey(msg.Key)
+	if err != nil {
+		return mutation, err
+	}
+	mutation.Key = key
+	if op != index.OpDelete {
+		mutation.Feature, err = codec.DecodeFeature(msg.Feature)
+	}
+	return mutation, err
+}
@@ -0,0 +1,374 @@
+package replication_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/replication"
+	"github.com/bilus/fencer/testutil"
+	"github.com/bilus/fencer/wal"
+)
+
+// ZoneID uniquely identifies a zone.
+type ZoneID string
+
+func (id ZoneID) String() string {
+	return string(id)
+}
+
+// Zone is an example feature serialized using wal.JSONCodec.
+type Zone struct {
+	ID    ZoneID
+	Level int
+	Area  primitives.Rect
+}
+
+func (z *Zone) Bounds() *primitives.Rect {
+	return &z.Area
+}
+
+func (z *Zone) Contains(point primitives.Point) (bool, error) {
+	return testutil.Contains(z.Area, point), nil
+}
+
+func (z *Zone) Key() ZoneID {
+	return z.ID
+}
+
+func newZone(id ZoneID, level int) *Zone {
+	return &Zone{ID: id, Level: level, Area: primitives.Rect{Max: primitives.Point{1, 1}}}
+}
+
+var codec wal.Codec[ZoneID, *Zone] = wal.JSONCodec[ZoneID, *Zone]{}
+
+func newLeader(t *testing.T, options replication.LeaderOptions) *replication.Leader[ZoneID, *Zone] {
+	idx, _ := index.New[ZoneID]([]*Zone{newZone("a", 0)})
+	leader, err := replication.NewLeader(idx, codec, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leader
+}
+
+func insert(t *testing.T, leader *replication.Leader[ZoneID, *Zone], zones ...*Zone) {
+	err := leader.Update(func(idx *index.Index[ZoneID, *Zone]) error {
+		for _, zone := range zones {
+			if err := idx.Insert(zone); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func follow(t *testing.T, url string) (*replication.Follower[ZoneID, *Zone], func()) {
+	follower := replication.NewFollower(url, codec, replication.FollowerOptions{RetryInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = follower.Run(ctx)
+	}()
+	return follower, func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitFor(t *testing.T, follower *replication.Follower[ZoneID, *Zone], revision uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := follower.WaitForRevision(ctx, revision); err != nil {
+		t.Fatalf("Waiting for revision %d: %v (at %d)", revision, err, follower.Revision())
+	}
+}
+
+func keys(t *testing.T, follower *replication.Follower[ZoneID, *Zone]) string {
+	var result string
+	err := follower.Read(func(idx *index.Index[ZoneID, *Zone]) error {
+		for _, key := range []ZoneID{"a", "b", "c", "d", "e"} {
+			zones, _ := idx.Lookup(key)
+			for _, zone := range zones {
+				result += fmt.Sprintf("%v%d ", zone.ID, zone.Level)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestFollower_catchesUpAndTails(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{})
+	insert(t, leader, newZone("b", 0))
+	server := httptest.NewServer(leader)
+	defer server.Close()
+
+	follower, stop := follow(t, server.URL)
+	defer stop()
+	waitFor(t, follower, 1)
+
+	insert(t, leader, newZone("c", 0))
+	_ = leader.Update(func(idx *index.Index[ZoneID, *Zone]) error {
+		_ = idx.Update(newZone("a", 1))
+		return idx.Delete("b")
+	})
+	waitFor(t, follower, leader.Revision())
+	if got := keys(t, follower); got != "a1 c0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
+
+func TestFollower_appliesBatches(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{})
+	server := httptest.NewServer(leader)
+	defer server.Close()
+	follower, stop := follow(t, server.URL)
+	defer stop()
+	waitFor(t, follower, 0)
+
+	updated, _ := index.New[ZoneID]([]*Zone{newZone("a", 1), newZone("b", 0)})
+	err := leader.Update(func(idx *index.Index[ZoneID, *Zone]) error {
+		changes, err := index.Diff(idx, updated, func(old, new *Zone) bool { return *old == *new })
+		if err != nil {
+			return err
+		}
+		return idx.Apply(changes)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader.Revision() != 3 {
+		t.Fatalf("Expected revision 3, got %d", leader.Revision())
+	}
+	waitFor(t, follower, 3)
+	if got := keys(t, follower); got != "a1 b0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
+
+func TestFollower_resumesAfterReconnect(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{})
+	var snapshots int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/snapshot" {
+			mu.Lock()
+			snapshots++
+			mu.Unlock()
+		}
+		leader.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	follower, stop := follow(t, server.URL)
+	defer stop()
+	insert(t, leader, newZone("b", 0))
+	waitFor(t, follower, 1)
+
+	server.CloseClientConnections()
+	insert(t, leader, newZone("c", 0), newZone("d", 0))
+	waitFor(t, follower, 3)
+
+	if got := keys(t, follower); got != "a0 b0 c0 d0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if snapshots != 1 {
+		t.Fatalf("Expected follower to resume without a new snapshot, got %d snapshots", snapshots)
+	}
+}
+
+func TestFollower_resnapshotsWhenTooFarBehind(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{Backlog: 1})
+	var mu sync.Mutex
+	var handler http.Handler = leader
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		h := handler
+		mu.Unlock()
+		h.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	follower, stop := follow(t, server.URL)
+	defer stop()
+	waitFor(t, follower, 0)
+
+	// Make the leader unreachable while it moves past its backlog.
+	mu.Lock()
+	handler = http.NotFoundHandler()
+	mu.Unlock()
+	server.CloseClientConnections()
+	insert(t, leader, newZone("b", 0), newZone("c", 0), newZone("d", 0))
+	mu.Lock()
+	handler = leader
+	mu.Unlock()
+
+	waitFor(t, follower, 3)
+	if got := keys(t, follower); got != "a0 b0 c0 d0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
+
+func TestFollower_resumesFromWrappedBacklog(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{Backlog: 2})
+	server := httptest.NewServer(leader)
+	defer server.Close()
+
+	follower, stop := follow(t, server.URL)
+	defer stop()
+	insert(t, leader, newZone("b", 0), newZone("c", 0))
+	waitFor(t, follower, 2)
+
+	server.CloseClientConnections()
+	insert(t, leader, newZone("d", 0), newZone("e", 0))
+	waitFor(t, follower, 4)
+	if got := keys(t, follower); got != "a0 b0 c0 d0 e0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
+
+func TestFollower_resyncsAfterFailedReplay(t *testing.T) {
+	leader := newLeader(t, replication.LeaderOptions{})
+	server := httptest.NewServer(leader)
+	defer server.Close()
+
+	errs := make(chan error, 10)
+	follower := replication.NewFollower(server.URL, codec, replication.FollowerOptions{
+		RetryInterval: 10 * time.Millisecond,
+		OnError:       func(err error) { errs <- err },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = follower.Run(ctx) }()
+	waitFor(t, follower, 0)
+
+	// The replica diverges so updating zone a fails.
+	_ = follower.Read(func(idx *index.Index[ZoneID, *Zone]) error {
+		return idx.Delete("a")
+	})
+	_ = leader.Update(func(idx *index.Index[ZoneID, *Zone]) error {
+		return idx.Update(newZone("a", 1))
+	})
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "Replaying revision 1") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an error")
+	}
+	waitFor(t, follower, 1)
+	if got := keys(t, follower); got != "a1 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
+
+// failingCodec fails to encode zones with a negative level.
+type failingCodec struct {
+	wal.JSONCodec[ZoneID, *Zone]
+}
+
+func (codec failingCodec) EncodeFeature(zone *Zone) ([]byte, error) {
+	if zone.Level < 0 {
+		return nil, fmt.Errorf("Negative level")
+	}
+	return codec.JSONCodec.EncodeFeature(zone)
+}
+
+// journal records mutations.
+type journal struct {
+	mutations []index.Mutation[ZoneID, *Zone]
+}
+
+func (journal *journal) Record(mutation index.Mutation[ZoneID, *Zone]) error {
+	journal.mutations = append(journal.mutations, mutation)
+	return nil
+}
+
+func TestLeader_Record_encodingError(t *testing.T) {
+	idx, _ := index.New[ZoneID]([]*Zone{})
+	next := &journal{}
+	idx.SetJournal(next)
+	leader, err := replication.NewLeader[ZoneID, *Zone](idx, failingCodec{}, replication.LeaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = leader.Update(func(idx *index.Index[ZoneID, *Zone]) error {
+		return idx.Insert(newZone("a", -1))
+	})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(next.mutations) != 0 || idx.Size() != 0 || leader.Revision() != 0 {
+		t.Fatalf("Expected the mutation not to be recorded or applied")
+	}
+}
+
+func TestFollower_switchesToNewLeader(t *testing.T) {
+	leader1 := newLeader(t, replication.LeaderOptions{})
+	insert(t, leader1, newZone("b", 0), newZone("c", 0))
+	server1 := httptest.NewServer(leader1)
+	defer server1.Close()
+
+	// A restarted leader starts counting revisions from scratch.
+	leader2 := newLeader(t, replication.LeaderOptions{})
+	insert(t, leader2, newZone("e", 0), newZone("d", 0), newZone("c", 1))
+	server2 := httptest.NewServer(leader2)
+	defer server2.Close()
+
+	var mu sync.Mutex
+	target := server1.URL
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		url := target
+		mu.Unlock()
+		resp, err := http.Get(url + r.URL.RequestURI())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		buf := make([]byte, 4096)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				_, _ = w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer proxy.Close()
+
+	follower, stop := follow(t, proxy.URL)
+	defer stop()
+	waitFor(t, follower, 2)
+
+	mu.Lock()
+	target = server2.URL
+	mu.Unlock()
+	server1.CloseClientConnections()
+	waitFor(t, follower, 3)
+	if got := keys(t, follower); got != "a0 c1 d0 e0 " {
+		t.Fatalf("Unexpected replica contents: %q", got)
+	}
+}
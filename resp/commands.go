@@ -0,0 +1,788 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bilus/fencer/geo"
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/tiles"
+)
+
+// DefaultLimit is the maximum number of search results unless LIMIT is given.
+const DefaultLimit = 100
+
+var (
+	errSyntax   = errors.New("Syntax error")
+	errNotFound = errors.New("Id not found")
+	errNoKey    = errors.New("Key not found")
+)
+
+// args is a cursor over command arguments.
+type args struct {
+	list []string
+}
+
+func (a *args) empty() bool {
+	return len(a.list) == 0
+}
+
+func (a *args) next() (string, error) {
+	if len(a.list) == 0 {
+		return "", errSyntax
+	}
+	arg := a.list[0]
+	a.list = a.list[1:]
+	return arg, nil
+}
+
+// peek returns the next argument in upper case without consuming it.
+func (a *args) peek() string {
+	if len(a.list) == 0 {
+		return ""
+	}
+	return strings.ToUpper(a.list[0])
+}
+
+func (a *args) float() (float64, error) {
+	arg, err := a.next()
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("Invalid argument %q", arg)
+	}
+	return f, nil
+}
+
+func (a *args) int() (int, error) {
+	arg, err := a.next()
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("Invalid argument %q", arg)
+	}
+	return i, nil
+}
+
+// latLon reads a point given latitude first.
+func (a *args) latLon() (primitives.Point, error) {
+	lat, err := a.float()
+	if err != nil {
+		return primitives.Point{}, err
+	}
+	lon, err := a.float()
+	if err != nil {
+		return primitives.Point{}, err
+	}
+	return primitives.Point{lon, lat}, nil
+}
+
+func (a *args) bounds() (primitives.Rect, error) {
+	min, err := a.latLon()
+	if err != nil {
+		return primitives.Rect{}, err
+	}
+	max, err := a.latLon()
+	if err != nil {
+		return primitives.Rect{}, err
+	}
+	if min[0] > max[0] || min[1] > max[1] {
+		return primitives.Rect{}, errors.New("Invalid bounds")
+	}
+	return primitives.Rect{Min: min, Max: max}, nil
+}
+
+type command func(s *Server, a *args) (any, error)
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":       (*Server).ping,
+		"QUIT":       (*Server).quit,
+		"SET":        (*Server).set,
+		"GET":        (*Server).get,
+		"DEL":        (*Server).del,
+		"DROP":       (*Server).drop,
+		"KEYS":       (*Server).keys,
+		"WITHIN":     (*Server).within,
+		"INTERSECTS": (*Server).intersects,
+		"NEARBY":     (*Server).nearby,
+	}
+}
+
+// do executes a command and returns its reply.
+func (s *Server) do(list []string) any {
+	name := strings.ToUpper(list[0])
+	cmd, ok := commands[name]
+	if !ok {
+		return errorReply(fmt.Sprintf("ERR Unknown command %q", list[0]))
+	}
+	a := &args{list: list[1:]}
+	reply, err := cmd(s, a)
+	if err == nil && !a.empty() {
+		err = errSyntax
+	}
+	if err != nil {
+		return errorReply("ERR " + err.Error())
+	}
+	return reply
+}
+
+func (s *Server) ping(a *args) (any, error) {
+	if a.empty() {
+		return simpleString("PONG"), nil
+	}
+	return a.next()
+}
+
+func (s *Server) quit(a *args) (any, error) {
+	return simpleString("OK"), nil
+}
+
+func (s *Server) set(a *args) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	id, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	var nx, xx bool
+	for {
+		switch a.peek() {
+		case "FIELD":
+			_, _ = a.next()
+			name, err := a.next()
+			if err != nil {
+				return nil, err
+			}
+			value, err := a.next()
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = fieldValue(value)
+			continue
+		case "NX":
+			_, _ = a.next()
+			nx = true
+			continue
+		case "XX":
+			_, _ = a.next()
+			xx = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return nil, errSyntax
+	}
+	g, err := s.object(a)
+	if err != nil {
+		return nil, err
+	}
+	if xx {
+		// Don't create a collection just to find out the object doesn't
+		// exist.
+		if c, _ := s.collection(key, false); c == nil {
+			return nullReply{}, nil
+		}
+	}
+	c, err := s.collection(key, true)
+	if err != nil {
+		return nil, err
+	}
+	f := geometry.NewFeature(geometry.ID(id), g, fields)
+	skipped := false
+	err = c.Update(func(idx *Index) error {
+		existing, _ := idx.Lookup(f.Key())
+		switch {
+		case nx && len(existing) > 0, xx && len(existing) == 0:
+			skipped = true
+			return nil
+		case len(existing) > 0:
+			return idx.Update(f)
+		default:
+			return idx.Insert(f)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if skipped {
+		return nullReply{}, nil
+	}
+	return simpleString("OK"), nil
+}
+
+// object reads a SET object.
+func (s *Server) object(a *args) (geometry.Geometry, error) {
+	kind, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(kind) {
+	case "OBJECT":
+		return readGeoJSON(a)
+	case "POINT":
+		p, err := a.latLon()
+		return geometry.Point(p), err
+	case "BOUNDS":
+		bounds, err := a.bounds()
+		return geometry.FromRect(bounds), err
+	case "HASH":
+		hash, err := a.next()
+		if err != nil {
+			return nil, err
+		}
+		p, err := geo.DecodeGeohash(hash)
+		return geometry.Point(p), err
+	default:
+		return nil, errSyntax
+	}
+}
+
+// readGeoJSON reads a GeoJSON geometry or the geometry of a Feature.
+func readGeoJSON(a *args) (geometry.Geometry, error) {
+	data, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(data, `"Feature"`) {
+		f, err := geojson.DecodeFeature([]byte(data), geojson.Options{})
+		if err == nil {
+			return f.Geometry(), nil
+		}
+	}
+	g, err := geojson.UnmarshalGeometry([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid GeoJSON: %v", err)
+	}
+	return g, nil
+}
+
+// fieldValue stores numeric field values as numbers.
+func fieldValue(value string) any {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
+
+// lookup returns an object or nil if it doesn't exist.
+func (s *Server) lookup(key, id string) (*geometry.Feature, error) {
+	c, err := s.collection(key, false)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	var f *geometry.Feature
+	err = c.Read(func(idx *Index) error {
+		if features, _ := idx.Lookup(geometry.ID(id)); len(features) > 0 {
+			f = features[0]
+		}
+		return nil
+	})
+	return f, err
+}
+
+func (s *Server) get(a *args) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	id, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	withFields := false
+	if a.peek() == "WITHFIELDS" {
+		_, _ = a.next()
+		withFields = true
+	}
+	out := outputObjects
+	precision := 0
+	if !a.empty() {
+		kind, _ := a.next()
+		switch strings.ToUpper(kind) {
+		case "OBJECT":
+		case "POINT":
+			out = outputPoints
+		case "BOUNDS":
+			out = outputBounds
+		case "HASH":
+			out = outputHash
+			if precision, err = a.int(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+	f, err := s.lookup(key, id)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nullReply{}, nil
+	}
+	var reply any
+	switch out {
+	case outputPoints:
+		reply = pointReply(f)
+	case outputBounds:
+		reply = boundsReply(f)
+	case outputHash:
+		reply = geo.EncodeGeohash(representativePoint(f), precision)
+	default:
+		data, err := geojson.MarshalGeometry(f.Geometry())
+		if err != nil {
+			return nil, err
+		}
+		reply = string(data)
+	}
+	if withFields {
+		return []any{reply, fieldsReply(f)}, nil
+	}
+	return reply, nil
+}
+
+func (s *Server) del(a *args) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	id, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.collection(key, false)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	err = c.Update(func(idx *Index) error {
+		return idx.Delete(geometry.ID(id))
+	})
+	var notFound index.ErrFeatureNotFound[geometry.ID]
+	if errors.As(err, &notFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return 1, nil
+}
+
+func (s *Server) drop(a *args) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.collections[key]; !ok {
+		return 0, nil
+	}
+	delete(s.collections, key)
+	return 1, nil
+}
+
+func (s *Server) keys(a *args) (any, error) {
+	pattern, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid pattern %q", pattern)
+	}
+	s.mu.RLock()
+	var names []string
+	for name := range s.collections {
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	reply := make([]any, len(names))
+	for i, name := range names {
+		reply[i] = name
+	}
+	return reply, nil
+}
+
+type output int
+
+const (
+	outputObjects output = iota
+	outputIDs
+	outputCount
+	outputPoints
+	outputBounds
+	outputHash
+)
+
+// searchOptions are options common to search commands.
+type searchOptions struct {
+	limit    int
+	distance bool
+	output   output
+}
+
+func readSearchOptions(a *args, allowDistance bool) (searchOptions, error) {
+	options := searchOptions{limit: DefaultLimit}
+	for {
+		switch a.peek() {
+		case "LIMIT":
+			_, _ = a.next()
+			limit, err := a.int()
+			if err != nil {
+				return options, err
+			}
+			options.limit = limit
+			continue
+		case "DISTANCE":
+			if !allowDistance {
+				return options, errSyntax
+			}
+			_, _ = a.next()
+			options.distance = true
+			continue
+		case "IDS":
+			options.output = outputIDs
+		case "COUNT":
+			options.output = outputCount
+		case "OBJECTS":
+			options.output = outputObjects
+		case "POINTS":
+			options.output = outputPoints
+		case "BOUNDS":
+			// BOUNDS is also an area; it's an output format only if another
+			// BOUNDS follows or the area is given otherwise.
+			if len(a.list) < 2 || isArea(strings.ToUpper(a.list[1])) {
+				options.output = outputBounds
+			} else {
+				return options, nil
+			}
+		default:
+			return options, nil
+		}
+		_, _ = a.next()
+		return options, nil
+	}
+}
+
+func isArea(arg string) bool {
+	switch arg {
+	case "BOUNDS", "CIRCLE", "OBJECT", "GET", "TILE", "HASH", "POINT":
+		return true
+	}
+	return false
+}
+
+// circle is an area of a given radius in meters around a center.
+type circle struct {
+	center primitives.Point
+	radius float64
+}
+
+func (c circle) Bounds() primitives.Rect {
+	bounds, err := geo.NewBoundsAround(c.center, c.radius)
+	if err != nil {
+		return primitives.Rect{Min: c.center, Max: c.center}
+	}
+	return *bounds
+}
+
+func (c circle) Contains(point primitives.Point) bool {
+	return geo.Distance(c.center, point) <= c.radius
+}
+
+// rect is a rectangular area including its edges.
+type rect primitives.Rect
+
+func (r rect) Bounds() primitives.Rect {
+	return primitives.Rect(r)
+}
+
+func (r rect) Contains(point primitives.Point) bool {
+	return point[0] >= r.Min[0] && point[0] <= r.Max[0] && point[1] >= r.Min[1] && point[1] <= r.Max[1]
+}
+
+// area reads a search area.
+func (s *Server) area(a *args) (geometry.Geometry, error) {
+	kind, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(kind) {
+	case "BOUNDS":
+		bounds, err := a.bounds()
+		return rect(bounds), err
+	case "CIRCLE":
+		center, err := a.latLon()
+		if err != nil {
+			return nil, err
+		}
+		radius, err := a.float()
+		if err != nil {
+			return nil, err
+		}
+		return circle{center, radius}, nil
+	case "OBJECT":
+		return readGeoJSON(a)
+	case "GET":
+		key, err := a.next()
+		if err != nil {
+			return nil, err
+		}
+		id, err := a.next()
+		if err != nil {
+			return nil, err
+		}
+		f, err := s.lookup(key, id)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, errNotFound
+		}
+		return f.Geometry(), nil
+	case "TILE":
+		x, err := a.int()
+		if err != nil {
+			return nil, err
+		}
+		y, err := a.int()
+		if err != nil {
+			return nil, err
+		}
+		z, err := a.int()
+		if err != nil {
+			return nil, err
+		}
+		bounds, err := tiles.Bounds(z, x, y)
+		return rect(bounds), err
+	case "HASH":
+		hash, err := a.next()
+		if err != nil {
+			return nil, err
+		}
+		bounds, err := geo.GeohashBounds(hash)
+		return rect(bounds), err
+	default:
+		return nil, errSyntax
+	}
+}
+
+func (s *Server) within(a *args) (any, error) {
+	return s.search(a, func(g, area geometry.Geometry) bool {
+		if r, ok := area.(rect); ok {
+			bounds := g.Bounds()
+			return r.Contains(bounds.Min) && r.Contains(bounds.Max)
+		}
+		return geometry.Within(g, area)
+	})
+}
+
+func (s *Server) intersects(a *args) (any, error) {
+	return s.search(a, func(g, area geometry.Geometry) bool {
+		switch area := area.(type) {
+		case rect:
+			return geometry.IntersectsRect(g, primitives.Rect(area))
+		case circle:
+			return area.Contains(geometry.ClosestPoint(g, area.center))
+		default:
+			return geometry.Intersects(g, area)
+		}
+	})
+}
+
+// search runs WITHIN or INTERSECTS, returning matches sorted by id.
+func (s *Server) search(a *args, match func(g, area geometry.Geometry) bool) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	options, err := readSearchOptions(a, false)
+	if err != nil {
+		return nil, err
+	}
+	area, err := s.area(a)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.collection(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errNoKey
+	}
+	bounds := area.Bounds()
+	var matches []*geometry.Feature
+	err = c.Read(func(idx *Index) error {
+		candidates, err := idx.Intersect(&bounds)
+		for _, f := range candidates {
+			if match(f.Geometry(), area) {
+				matches = append(matches, f)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Key() < matches[j].Key() })
+	return searchReply(matches, nil, options)
+}
+
+func (s *Server) nearby(a *args) (any, error) {
+	key, err := a.next()
+	if err != nil {
+		return nil, err
+	}
+	options, err := readSearchOptions(a, true)
+	if err != nil {
+		return nil, err
+	}
+	if kind, err := a.next(); err != nil || strings.ToUpper(kind) != "POINT" {
+		return nil, errSyntax
+	}
+	center, err := a.latLon()
+	if err != nil {
+		return nil, err
+	}
+	radius := math.Inf(1)
+	if !a.empty() {
+		if radius, err = a.float(); err != nil {
+			return nil, err
+		}
+	}
+	c, err := s.collection(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errNoKey
+	}
+
+	var neighbours []index.Neighbour[*geometry.Feature]
+	_ = c.Read(func(idx *Index) error {
+		neighbours = idx.Nearest(center, options.limit, radius)
+		return nil
+	})
+	matches := make([]*geometry.Feature, len(neighbours))
+	var distances []float64
+	if options.distance {
+		distances = make([]float64, len(neighbours))
+	}
+	for i, n := range neighbours {
+		matches[i] = n.Feature
+		if distances != nil {
+			distances[i] = n.Distance
+		}
+	}
+	return searchReply(matches, distances, options)
+}
+
+// searchReply formats search results as [cursor, [item, ...]] or a count.
+func searchReply(matches []*geometry.Feature, distances []float64, options searchOptions) (any, error) {
+	if len(matches) > options.limit {
+		matches = matches[:options.limit]
+	}
+	if options.output == outputCount {
+		return len(matches), nil
+	}
+	items := make([]any, len(matches))
+	for i, f := range matches {
+		id := f.Key().String()
+		var item []any
+		switch options.output {
+		case outputIDs:
+			if distances == nil {
+				items[i] = id
+				continue
+			}
+			item = []any{id}
+		case outputPoints:
+			item = []any{id, pointReply(f)}
+		case outputBounds:
+			item = []any{id, boundsReply(f)}
+		default:
+			data, err := geojson.MarshalGeometry(f.Geometry())
+			if err != nil {
+				return nil, fmt.Errorf("Object %q: %w", id, err)
+			}
+			item = []any{id, string(data)}
+			if len(f.Properties) > 0 {
+				item = append(item, fieldsReply(f))
+			}
+		}
+		if distances != nil {
+			item = append(item, distances[i])
+		}
+		items[i] = item
+	}
+	return []any{0, items}, nil
+}
+
+// representativePoint returns a point object or the center of an object's
+// bounding rectangle.
+func representativePoint(f *geometry.Feature) primitives.Point {
+	if p, ok := f.Geometry().(geometry.Point); ok {
+		return primitives.Point(p)
+	}
+	bounds := f.Bounds()
+	return primitives.Point{(bounds.Min[0] + bounds.Max[0]) / 2, (bounds.Min[1] + bounds.Max[1]) / 2}
+}
+
+func pointReply(f *geometry.Feature) []any {
+	p := representativePoint(f)
+	return []any{p[1], p[0]}
+}
+
+func boundsReply(f *geometry.Feature) []any {
+	bounds := f.Bounds()
+	return []any{
+		[]any{bounds.Min[1], bounds.Min[0]},
+		[]any{bounds.Max[1], bounds.Max[0]},
+	}
+}
+
+// fieldsReply returns fields as a flat list of names and values, sorted by
+// name.
+func fieldsReply(f *geometry.Feature) []any {
+	names := make([]string, 0, len(f.Properties))
+	for name := range f.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reply := make([]any, 0, 2*len(names))
+	for _, name := range names {
+		var value any
+		switch v := f.Properties[name].(type) {
+		case float64:
+			value = v
+		case string:
+			value = v
+		default:
+			value = fmt.Sprint(v)
+		}
+		reply = append(reply, name, value)
+	}
+	return reply
+}
@@ -0,0 +1,214 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxArgs    = 1 << 20
+	maxBulkLen = 512 << 20
+	maxLineLen = 64 << 10 // Inline commands and headers.
+	// Arguments are preallocated only up to these sizes, so that claimed
+	// lengths don't allocate memory before data arrives.
+	preallocArgs = 1024
+	bulkChunk    = 64 << 10
+)
+
+// ProtocolError is an error in a client's request stream; the connection is
+// closed after reporting it.
+type ProtocolError struct {
+	Msg string
+}
+
+func (err *ProtocolError) Error() string {
+	return "Protocol error: " + err.Msg
+}
+
+// readCommand reads an array of bulk strings or an inline command. It returns
+// an empty command for blank inline lines.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		return splitInline(line)
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxArgs {
+		return nil, &ProtocolError{fmt.Sprintf("Invalid multibulk length %q", line[1:])}
+	}
+	if n <= 0 {
+		// An empty or null array.
+		return nil, nil
+	}
+	capacity := n
+	if capacity > preallocArgs {
+		capacity = preallocArgs
+	}
+	args := make([]string, 0, capacity)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, &ProtocolError{fmt.Sprintf("Expected '$', got %q", line)}
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, &ProtocolError{fmt.Sprintf("Invalid bulk length %q", line[1:])}
+		}
+		arg, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of a given size followed by CRLF, in chunks
+// so that memory is only allocated for data actually received.
+func readBulk(r *bufio.Reader, size int) (string, error) {
+	var data strings.Builder
+	for data.Len() < size {
+		chunk := size - data.Len()
+		if chunk > bulkChunk {
+			chunk = bulkChunk
+		}
+		data.Grow(chunk)
+		if _, err := io.CopyN(&data, r, int64(chunk)); err != nil {
+			return "", unexpectedEOF(err)
+		}
+	}
+	var crlf [2]byte
+	if _, err := io.ReadFull(r, crlf[:]); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if crlf != [2]byte{'\r', '\n'} {
+		return "", &ProtocolError{"Bulk string not terminated by CRLF"}
+	}
+	return data.String(), nil
+}
+
+// readLine reads a line of at most maxLineLen bytes.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLen+2 {
+			return "", &ProtocolError{"Line too long"}
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", unexpectedEOF(err)
+		}
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// splitInline splits an inline command on whitespace. Arguments may be
+// enclosed in double quotes, with backslash escaping the next character, or
+// in single quotes.
+func splitInline(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case (c == '"' || c == '\'') && !inArg:
+			quote := c
+			inArg = true
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, &ProtocolError{"Unbalanced quotes in request"}
+				}
+				if line[i] == quote {
+					break
+				}
+				if line[i] == '\\' && quote == '"' && i+1 < len(line) {
+					i++
+				}
+				arg.WriteByte(line[i])
+			}
+		default:
+			inArg = true
+			arg.WriteByte(c)
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Reply values written by writeReply.
+type (
+	simpleString string
+	errorReply   string
+	nullReply    struct{}
+)
+
+// writeReply writes a reply: simpleString, errorReply, nullReply, int, string
+// (a bulk string), float64 (a bulk string) or []any (an array). Values of
+// other types are written as errors.
+func writeReply(w *bufio.Writer, reply any) error {
+	var err error
+	switch v := reply.(type) {
+	case simpleString:
+		_, err = w.WriteString("+" + string(v) + "\r\n")
+	case errorReply:
+		_, err = w.WriteString("-" + strings.ReplaceAll(string(v), "\r\n", " ") + "\r\n")
+	case nullReply:
+		_, err = w.WriteString("$-1\r\n")
+	case int:
+		_, err = w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		_, err = w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case float64:
+		return writeReply(w, strconv.FormatFloat(v, 'f', -1, 64))
+	case []any:
+		if _, err = w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n"); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := writeReply(w, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return writeReply(w, errorReply(fmt.Sprintf("ERR Unsupported reply type %T", reply)))
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+package resp_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/resp"
+)
+
+// client is a minimal RESP client.
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) do(args ...string) any {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		panic(err)
+	}
+	return c.read()
+}
+
+// read returns replies as strings, ints, nil, errors or []any.
+func (c *client) read() any {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errors.New(line[1:])
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return err
+		}
+		return string(data[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	default:
+		return fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+func start(t testing.TB) (*resp.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := resp.NewServer(resp.Options{})
+	go func() { _ = s.Serve(l) }()
+	if t != nil {
+		t.Cleanup(func() { s.Close() })
+	}
+	return s, l.Addr().String()
+}
+
+func Example() {
+	s, addr := start(nil)
+	defer s.Close()
+	c := dial(addr)
+	fmt.Println(c.do("SET", "fleet", "truck1", "FIELD", "speed", "90", "POINT", "33.5123", "-112.2693"))
+	fmt.Println(c.do("SET", "fleet", "truck2", "POINT", "33.4626", "-112.1695"))
+	fmt.Println(c.do("GET", "fleet", "truck1"))
+	fmt.Println(c.do("NEARBY", "fleet", "LIMIT", "1", "IDS", "POINT", "33.46", "-112.17"))
+	fmt.Println(c.do("WITHIN", "fleet", "COUNT", "BOUNDS", "33.4", "-112.3", "33.6", "-112.2"))
+	// Output:
+	// OK
+	// OK
+	// {"type":"Point","coordinates":[-112.2693,33.5123]}
+	// [0 [truck2]]
+	// 1
+}
+
+func TestCommands(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	square := `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}`
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"SET", "zones", "square", "FIELD", "level", "2", "FIELD", "name", "Square", "OBJECT", square}, "OK"},
+		{[]string{"SET", "pts", "in", "POINT", "5", "5"}, "OK"},
+		{[]string{"SET", "pts", "edge", "POINT", "5", "12"}, "OK"},
+		{[]string{"SET", "pts", "out", "POINT", "20", "20"}, "OK"},
+		{[]string{"SET", "pts", "box", "BOUNDS", "8", "8", "12", "12"}, "OK"},
+		{[]string{"SET", "pts", "in", "NX", "POINT", "1", "1"}, "<nil>"},
+		{[]string{"SET", "pts", "new", "XX", "POINT", "1", "1"}, "<nil>"},
+		{[]string{"GET", "zones", "square", "WITHFIELDS", "BOUNDS"}, "[[[0 0] [10 10]] [level 2 name Square]]"},
+		{[]string{"GET", "pts", "in", "POINT"}, "[5 5]"},
+		{[]string{"GET", "pts", "in", "HASH", "5"}, "s0gs3"},
+		{[]string{"GET", "pts", "missing"}, "<nil>"},
+		{[]string{"WITHIN", "pts", "IDS", "GET", "zones", "square"}, "[0 [in]]"},
+		{[]string{"INTERSECTS", "pts", "IDS", "GET", "zones", "square"}, "[0 [box in]]"},
+		{[]string{"INTERSECTS", "pts", "IDS", "OBJECT", square}, "[0 [box in]]"},
+		{[]string{"WITHIN", "pts", "IDS", "BOUNDS", "0", "0", "12", "12"}, "[0 [box edge in]]"},
+		{[]string{"WITHIN", "pts", "LIMIT", "1", "IDS", "BOUNDS", "0", "0", "12", "12"}, "[0 [box]]"},
+		{[]string{"WITHIN", "pts", "BOUNDS", "BOUNDS", "0", "0", "6", "6"}, "[0 [[in [[5 5] [5 5]]]]]"},
+		{[]string{"INTERSECTS", "pts", "POINTS", "CIRCLE", "5", "5", "1000"}, "[0 [[in [5 5]]]]"},
+		{[]string{"INTERSECTS", "pts", "COUNT", "TILE", "1", "0", "1"}, "4"},
+		{[]string{"INTERSECTS", "pts", "IDS", "HASH", "s0gs3"}, "[0 [in]]"},
+		{[]string{"NEARBY", "pts", "IDS", "POINT", "11", "11"}, "[0 [box edge in out]]"},
+		{[]string{"NEARBY", "pts", "IDS", "POINT", "11", "11", "500000"}, "[0 [box]]"},
+		{[]string{"NEARBY", "pts", "LIMIT", "2", "DISTANCE", "IDS", "POINT", "5", "12"}, "[0 [[edge 0] [box 333958.47237982065]]]"},
+		{[]string{"NEARBY", "pts", "LIMIT", "0", "IDS", "POINT", "5", "12"}, "[0 []]"},
+		{[]string{"KEYS", "*"}, "[pts zones]"},
+		{[]string{"DEL", "pts", "in"}, "1"},
+		{[]string{"DEL", "pts", "in"}, "0"},
+		{[]string{"DROP", "zones"}, "1"},
+		{[]string{"KEYS", "z*"}, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(c.do(tt.args...)); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCommands_errors(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	c.do("SET", "pts", "a", "POINT", "1", "1")
+	for _, args := range [][]string{
+		{"FOO"},
+		{"SET", "pts", "a"},
+		{"SET", "pts", "a", "POINT", "x", "1"},
+		{"SET", "pts", "a", "OBJECT", "{"},
+		{"SET", "pts", "a", "POINT", "1", "1", "extra"},
+		{"WITHIN", "missing", "IDS", "BOUNDS", "0", "0", "1", "1"},
+		{"WITHIN", "pts", "IDS", "GET", "pts", "missing"},
+		{"WITHIN", "pts", "DISTANCE", "BOUNDS", "0", "0", "1", "1"},
+		{"NEARBY", "pts", "CIRCLE", "1", "1", "10"},
+		{"KEYS", "["},
+	} {
+		if _, ok := c.do(args...).(error); !ok {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	// The connection is still usable.
+	if got := c.do("PING", "hello"); got != "hello" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestServeConn_inlineAndPipelining(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	_, err := io.WriteString(c.conn, "SET pts a OBJECT '{\"type\":\"Point\",\"coordinates\":[1,2]}'\r\nGET pts a POINT\r\n\r\nPING\r\nQUIT\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"OK", "[2 1]", "PONG", "OK"} {
+		if got := fmt.Sprint(c.read()); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got := c.read(); got != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v", got)
+	}
+}
+
+func TestServeConn_protocolError(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	if _, err := io.WriteString(c.conn, "*1\r\n+PING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := c.read().(error); !ok || !strings.Contains(err.Error(), "Protocol error") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestServeConn_multibulkLength(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	if _, err := io.WriteString(c.conn, "*-1\r\n*0\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.do("PING"); got != "PONG" {
+		t.Errorf("Expected PONG after empty arrays, got %v", got)
+	}
+	if _, err := io.WriteString(c.conn, "*-5\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := c.read().(error); !ok || !strings.Contains(err.Error(), "Invalid multibulk length") {
+		t.Errorf("Expected a protocol error, got %v", err)
+	}
+}
+
+func TestServeConn_lineLength(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	if _, err := io.WriteString(c.conn, strings.Repeat("a", 65<<10)+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := c.read().(error); !ok || !strings.Contains(err.Error(), "Line too long") {
+		t.Errorf("Expected a protocol error, got %v", err)
+	}
+}
+
+func TestServeConn_bulkLength(t *testing.T) {
+	_, addr := start(t)
+	c := dial(addr)
+	defer c.conn.Close()
+	// The claimed length isn't allocated up front, so a short read just
+	// waits for more data until the client goes away.
+	if _, err := io.WriteString(c.conn, "*1\r\n$536870912\r\nPING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.(*net.TCPConn).CloseWrite()
+	if got := c.read(); got != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", got)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	s, addr := start(t)
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("zone", geometry.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, nil),
+	})
+	s.Attach("zones", resp.NewCollection(idx))
+	c := dial(addr)
+	defer c.conn.Close()
+	if got := fmt.Sprint(c.do("INTERSECTS", "zones", "IDS", "BOUNDS", "0.1", "0.5", "0.2", "0.6")); got != "[0 [zone]]" {
+		t.Errorf("got %v", got)
+	}
+}
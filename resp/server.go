@@ -0,0 +1,256 @@
+// Package resp serves indexes over the Redis serialization protocol (RESP)
+// with a subset of Tile38 commands, so existing Redis clients can query
+// fencer.
+//
+// Objects are stored as geometry.Feature values in named collections, each
+// backed by its own index. Supported commands:
+//
+//	SET key id [FIELD name value ...] [NX|XX] (OBJECT geojson | POINT lat lon | BOUNDS minlat minlon maxlat maxlon | HASH geohash)
+//	GET key id [WITHFIELDS] [OBJECT | POINT | BOUNDS | HASH precision]
+//	DEL key id
+//	DROP key
+//	KEYS pattern
+//	WITHIN key [LIMIT count] [IDS | COUNT | OBJECTS | POINTS | BOUNDS] area
+//	INTERSECTS key [LIMIT count] [IDS | COUNT | OBJECTS | POINTS | BOUNDS] area
+//	NEARBY key [LIMIT count] [DISTANCE] [IDS | COUNT | OBJECTS | POINTS | BOUNDS] POINT lat lon [meters]
+//	PING [message]
+//	QUIT
+//
+// where area is one of:
+//
+//	BOUNDS minlat minlon maxlat maxlon
+//	CIRCLE lat lon meters
+//	OBJECT geojson
+//	GET key id
+//	TILE x y z
+//	HASH geohash
+//
+// As in Tile38, coordinates are given latitude first except in GeoJSON.
+// Search results are returned in a single page, with the cursor always 0.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+)
+
+// Index is the type of indexes backing collections.
+type Index = index.Index[geometry.ID, *geometry.Feature]
+
+// Collection gives access to an index, which may be shared with other
+// servers, e.g. server.Server.
+type Collection interface {
+	// Read calls fn with shared access to the index.
+	Read(fn func(idx *Index) error) error
+	// Update calls fn with exclusive access to the index.
+	Update(fn func(idx *Index) error) error
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("Server closed")
+
+// Options configure a server.
+type Options struct {
+	// Logger reports connection errors; log.Default() if nil.
+	Logger *log.Logger
+}
+
+// Server is a RESP server.
+type Server struct {
+	options Options
+
+	mu          sync.RWMutex
+	collections map[string]Collection
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a server without collections.
+func NewServer(options Options) *Server {
+	if options.Logger == nil {
+		options.Logger = log.Default()
+	}
+	return &Server{
+		options:     options,
+		collections: make(map[string]Collection),
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Attach serves an existing index as a collection, replacing any collection
+// with the same name.
+func (s *Server) Attach(name string, c Collection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections[name] = c
+}
+
+// NewCollection creates a collection for an index not shared with anything
+// else.
+func NewCollection(idx *Index) Collection {
+	return &collection{index: idx}
+}
+
+type collection struct {
+	mu    sync.RWMutex
+	index *Index
+}
+
+func (c *collection) Read(fn func(idx *Index) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return fn(c.index)
+}
+
+func (c *collection) Update(fn func(idx *Index) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fn(c.index)
+}
+
+// collection returns a collection or nil; if create is true, a missing
+// collection is created.
+func (s *Server) collection(name string, create bool) (Collection, error) {
+	s.mu.RLock()
+	c := s.collections[name]
+	s.mu.RUnlock()
+	if c != nil || !create {
+		return c, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c = s.collections[name]; c != nil {
+		return c, nil
+	}
+	idx, err := index.New[geometry.ID]([]*geometry.Feature{})
+	if err != nil {
+		return nil, err
+	}
+	c = NewCollection(idx)
+	s.collections[name] = c
+	return c, nil
+}
+
+// ListenAndServe listens on a TCP address and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections until the listener fails or the server is
+// closed, in which case it returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+	defer func() {
+		s.connMu.Lock()
+		delete(s.listeners, l)
+		s.connMu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.connMu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+			s.connMu.Lock()
+			delete(s.conns, conn)
+			s.connMu.Unlock()
+		}()
+	}
+}
+
+// Close stops listeners, closes connections and waits for their handlers to
+// return.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// ServeConn serves commands from a single connection until it's closed, the
+// client quits or sends a malformed request.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var protocolErr *ProtocolError
+			if errors.As(err, &protocolErr) {
+				_ = writeReply(w, errorReply("ERR "+protocolErr.Error()))
+				_ = w.Flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				s.options.Logger.Printf("RESP connection: %v", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := strings.EqualFold(args[0], "quit")
+		reply := s.do(args)
+		if err := writeReply(w, reply); err != nil {
+			return
+		}
+		// Pipelined commands are answered together.
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
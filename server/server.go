@@ -0,0 +1,463 @@
+// Package server serves an index of geometry.Feature values over HTTP.
+//
+// Query endpoints respond with GeoJSON FeatureCollections:
+//
+//	GET /contains?lon=..&lat=..               features containing a point
+//	GET /intersect?bbox=minLon,minLat,maxLon,maxLat
+//	GET /nearest?lon=..&lat=..&k=..           k nearest features (default 1)
+//	GET /within?lon=..&lat=..&radius=..       features within radius meters
+//
+// Nearest and within measure the geodesic distance to the closest point of
+// features' geometries and add it to their properties as "distance", in
+// meters.
+//
+// Features are managed at /features/{id}: GET returns a FeatureCollection
+// with the feature, PUT creates or replaces it from a GeoJSON Feature and
+// DELETE removes it. POST /features creates a feature, failing if its id is
+// taken. GET /health and GET /stats report the server's status.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+const (
+	// DefaultMaxNearest limits k of the nearest endpoint unless set in
+	// Options.
+	DefaultMaxNearest = 100
+	// DefaultMaxBodySize limits request bodies unless set in Options.
+	DefaultMaxBodySize = 10 << 20
+
+	distanceProperty = "distance"
+)
+
+// Index is the type of indexes served.
+type Index = index.Index[geometry.ID, *geometry.Feature]
+
+// Options configure a server.
+type Options struct {
+	// IDProperty is the property holding ids of features sent by clients; the
+	// feature's "id" member is used if empty.
+	IDProperty string
+	// MaxNearest limits k of the nearest endpoint; DefaultMaxNearest if 0.
+	MaxNearest int
+	// MaxBodySize limits request bodies in bytes; DefaultMaxBodySize if 0.
+	MaxBodySize int64
+}
+
+// Server is an http.Handler serving an index. It serializes mutations and
+// allows concurrent queries.
+type Server struct {
+	options Options
+	started time.Time
+	mux     *http.ServeMux
+
+	mu    sync.RWMutex
+	index *Index
+
+	requests  [numEndpoints]uint64
+	errors    uint64
+	mutations uint64
+}
+
+type endpoint int
+
+const (
+	endpointContains endpoint = iota
+	endpointIntersect
+	endpointNearest
+	endpointWithin
+	endpointFeatures
+	endpointHealth
+	endpointStats
+	numEndpoints
+)
+
+var endpointNames = [numEndpoints]string{"contains", "intersect", "nearest", "within", "features", "health", "stats"}
+
+// New creates a server for an index. The index must not be accessed other
+// than through the server's Read and Update afterwards.
+func New(idx *Index, options Options) *Server {
+	if options.MaxNearest <= 0 {
+		options.MaxNearest = DefaultMaxNearest
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultMaxBodySize
+	}
+	s := &Server{options: options, started: time.Now(), index: idx, mux: http.NewServeMux()}
+	s.handle("/contains", endpointContains, http.MethodGet, s.contains)
+	s.handle("/intersect", endpointIntersect, http.MethodGet, s.intersect)
+	s.handle("/nearest", endpointNearest, http.MethodGet, s.nearest)
+	s.handle("/within", endpointWithin, http.MethodGet, s.within)
+	s.handle("/features", endpointFeatures, http.MethodPost, s.create)
+	s.handle("/features/", endpointFeatures, "", s.feature)
+	s.handle("/health", endpointHealth, http.MethodGet, s.health)
+	s.handle("/stats", endpointStats, http.MethodGet, s.stats)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Read calls fn with shared access to the index.
+func (s *Server) Read(fn func(idx *Index) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.index)
+}
+
+// Update calls fn with exclusive access to the index.
+func (s *Server) Update(fn func(idx *Index) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.index)
+}
+
+// httpError is an error with a status code sent to the client.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (err *httpError) Error() string {
+	return err.msg
+}
+
+func badRequest(format string, args ...any) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle registers a handler counting requests and reporting errors as JSON.
+// An empty method means the handler checks methods itself.
+func (s *Server) handle(pattern string, e endpoint, method string, handler handlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&s.requests[e], 1)
+		var err error
+		if method != "" && r.Method != method {
+			w.Header().Set("Allow", method)
+			err = &httpError{http.StatusMethodNotAllowed, "Method not allowed"}
+		} else {
+			err = handler(w, r)
+		}
+		if err == nil {
+			return
+		}
+		atomic.AddUint64(&s.errors, 1)
+		status := http.StatusInternalServerError
+		var httpErr *httpError
+		if errors.As(err, &httpErr) {
+			status = httpErr.status
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+	})
+}
+
+func (s *Server) contains(w http.ResponseWriter, r *http.Request) error {
+	point, err := pointParam(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	var features []*geometry.Feature
+	err = s.Read(func(idx *Index) error {
+		features, err = idx.FindContaining(point)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeFeatures(w, http.StatusOK, features, nil)
+}
+
+func (s *Server) intersect(w http.ResponseWriter, r *http.Request) error {
+	bounds, err := bboxParam(r.URL.Query().Get("bbox"))
+	if err != nil {
+		return err
+	}
+	var features []*geometry.Feature
+	err = s.Read(func(idx *Index) error {
+		features, err = idx.Intersect(&bounds)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeFeatures(w, http.StatusOK, features, nil)
+}
+
+func (s *Server) nearest(w http.ResponseWriter, r *http.Request) error {
+	params := r.URL.Query()
+	point, err := pointParam(params)
+	if err != nil {
+		return err
+	}
+	k := 1
+	if params.Get("k") != "" {
+		k, err = strconv.Atoi(params.Get("k"))
+		if err != nil || k < 1 || k > s.options.MaxNearest {
+			return badRequest("Expected k between 1 and %d", s.options.MaxNearest)
+		}
+	}
+	return s.nearby(w, point, k, math.Inf(1))
+}
+
+func (s *Server) within(w http.ResponseWriter, r *http.Request) error {
+	params := r.URL.Query()
+	point, err := pointParam(params)
+	if err != nil {
+		return err
+	}
+	radius, err := floatParam(params, "radius")
+	if err != nil {
+		return err
+	}
+	if radius < 0 {
+		return badRequest("Expected a non-negative radius")
+	}
+	return s.nearby(w, point, math.MaxInt, radius)
+}
+
+// nearby writes up to k features nearest to a point first that are at most
+// maxDistance meters away.
+func (s *Server) nearby(w http.ResponseWriter, point primitives.Point, k int, maxDistance float64) error {
+	var neighbours []index.Neighbour[*geometry.Feature]
+	_ = s.Read(func(idx *Index) error {
+		neighbours = idx.Nearest(point, k, maxDistance)
+		return nil
+	})
+	features := make([]*geometry.Feature, len(neighbours))
+	distances := make(map[*geometry.Feature]float64, len(neighbours))
+	for i, neighbour := range neighbours {
+		features[i] = neighbour.Feature
+		distances[neighbour.Feature] = neighbour.Distance
+	}
+	return writeFeatures(w, http.StatusOK, features, distances)
+}
+
+// feature serves GET, PUT and DELETE /features/{id}.
+func (s *Server) feature(w http.ResponseWriter, r *http.Request) error {
+	id := geometry.ID(strings.TrimPrefix(r.URL.Path, "/features/"))
+	if id == "" {
+		return &httpError{http.StatusNotFound, "Missing feature id"}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		var features []*geometry.Feature
+		_ = s.Read(func(idx *Index) error {
+			features, _ = idx.Lookup(id)
+			return nil
+		})
+		if len(features) == 0 {
+			return notFound(id)
+		}
+		return writeFeatures(w, http.StatusOK, features, nil)
+	case http.MethodPut:
+		f, err := s.readFeature(r)
+		if err != nil {
+			return err
+		}
+		if f.Key() != id {
+			return badRequest("Feature id %q doesn't match %q", f.Key(), id)
+		}
+		created := false
+		err = s.Update(func(idx *Index) error {
+			if existing, _ := idx.Lookup(id); len(existing) > 0 {
+				return idx.Update(f)
+			}
+			created = true
+			return idx.Insert(f)
+		})
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&s.mutations, 1)
+		if created {
+			return writeFeatures(w, http.StatusCreated, []*geometry.Feature{f}, nil)
+		}
+		return writeFeatures(w, http.StatusOK, []*geometry.Feature{f}, nil)
+	case http.MethodDelete:
+		err := s.Update(func(idx *Index) error {
+			return idx.Delete(id)
+		})
+		var notFoundErr index.ErrFeatureNotFound[geometry.ID]
+		if errors.As(err, &notFoundErr) {
+			return notFound(id)
+		}
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&s.mutations, 1)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		return &httpError{http.StatusMethodNotAllowed, "Method not allowed"}
+	}
+}
+
+// create serves POST /features.
+func (s *Server) create(w http.ResponseWriter, r *http.Request) error {
+	f, err := s.readFeature(r)
+	if err != nil {
+		return err
+	}
+	err = s.Update(func(idx *Index) error {
+		if existing, _ := idx.Lookup(f.Key()); len(existing) > 0 {
+			return &httpError{http.StatusConflict, fmt.Sprintf("Feature %q already exists", f.Key())}
+		}
+		return idx.Insert(f)
+	})
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.mutations, 1)
+	w.Header().Set("Location", "/features/"+url.PathEscape(f.Key().String()))
+	return writeFeatures(w, http.StatusCreated, []*geometry.Feature{f}, nil)
+}
+
+func (s *Server) readFeature(r *http.Request) (*geometry.Feature, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, s.options.MaxBodySize))
+	if err != nil {
+		return nil, badRequest("Reading request body: %v", err)
+	}
+	f, err := geojson.DecodeFeature(data, geojson.Options{IDProperty: s.options.IDProperty})
+	if err != nil {
+		return nil, badRequest("Invalid feature: %v", err)
+	}
+	return f, nil
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	return nil
+}
+
+// Stats describes a server's state.
+type Stats struct {
+	Features      int               `json:"features"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Requests      map[string]uint64 `json:"requests"`
+	Errors        uint64            `json:"errors"`
+	Mutations     uint64            `json:"mutations"`
+}
+
+// Stats returns the server's current statistics.
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		Requests:      make(map[string]uint64, numEndpoints),
+		Errors:        atomic.LoadUint64(&s.errors),
+		Mutations:     atomic.LoadUint64(&s.mutations),
+	}
+	for e, name := range endpointNames {
+		stats.Requests[name] = atomic.LoadUint64(&s.requests[e])
+	}
+	_ = s.Read(func(idx *Index) error {
+		stats.Features = idx.Size()
+		return nil
+	})
+	return stats
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, s.Stats())
+	return nil
+}
+
+func notFound(id geometry.ID) error {
+	return &httpError{http.StatusNotFound, fmt.Sprintf("Feature %q not found", id)}
+}
+
+func pointParam(params url.Values) (primitives.Point, error) {
+	lon, err := floatParam(params, "lon")
+	if err != nil {
+		return primitives.Point{}, err
+	}
+	lat, err := floatParam(params, "lat")
+	if err != nil {
+		return primitives.Point{}, err
+	}
+	return primitives.Point{lon, lat}, nil
+}
+
+func floatParam(params url.Values, name string) (float64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return 0, badRequest("Missing %s parameter", name)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, badRequest("Invalid %s parameter %q", name, value)
+	}
+	return f, nil
+}
+
+func bboxParam(value string) (primitives.Rect, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return primitives.Rect{}, badRequest("Expected bbox=minLon,minLat,maxLon,maxLat")
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return primitives.Rect{}, badRequest("Invalid bbox coordinate %q", part)
+		}
+		coords[i] = f
+	}
+	if coords[0] > coords[2] || coords[1] > coords[3] {
+		return primitives.Rect{}, badRequest("Expected bbox minimum not to exceed its maximum")
+	}
+	return primitives.Rect{Min: primitives.Point{coords[0], coords[1]}, Max: primitives.Point{coords[2], coords[3]}}, nil
+}
+
+// writeFeatures writes features as a FeatureCollection, adding distances to
+// their properties if set.
+func writeFeatures(w http.ResponseWriter, status int, features []*geometry.Feature, distances map[*geometry.Feature]float64) error {
+	properties := geojson.AllProperties
+	if distances != nil {
+		properties = func(f *geometry.Feature) map[string]any {
+			withDistance := make(map[string]any, len(f.Properties)+1)
+			for name, value := range f.Properties {
+				withDistance[name] = value
+			}
+			withDistance[distanceProperty] = distances[f]
+			return withDistance
+		}
+	}
+	var buf bytes.Buffer
+	err := geojson.ExportFeatures[geometry.ID](&buf, features, geojson.WriteOptions[*geometry.Feature]{Properties: properties})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,241 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/server"
+)
+
+func newServer(t testing.TB) *httptest.Server {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("park", geometry.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}, map[string]any{"name": "Park"}),
+		geometry.NewFeature("cafe", geometry.Point{2, 0.5}, map[string]any{"name": "Cafe"}),
+		geometry.NewFeature("far", geometry.Point{50, 50}, nil),
+	})
+	ts := httptest.NewServer(server.New(idx, server.Options{}))
+	if t != nil {
+		t.Cleanup(ts.Close)
+	}
+	return ts
+}
+
+type collection struct {
+	Features []struct {
+		ID         string         `json:"id"`
+		Properties map[string]any `json:"properties"`
+	} `json:"features"`
+}
+
+func (c collection) ids() []string {
+	ids := make([]string, len(c.Features))
+	for i, f := range c.Features {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+func do(t testing.TB, method, url, body string) (int, []byte) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func query(t testing.TB, url string) collection {
+	status, data := do(t, http.MethodGet, url, "")
+	if status != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", url, status, data)
+	}
+	var c collection
+	if err := json.Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Example() {
+	ts := newServer(nil)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/nearest?lon=1.4&lat=0.5&k=2")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	var c collection
+	_ = json.NewDecoder(resp.Body).Decode(&c)
+	for _, f := range c.Features {
+		fmt.Printf("%s %.0fm\n", f.ID, f.Properties["distance"])
+	}
+	// Output:
+	// park 44526m
+	// cafe 66789m
+}
+
+func TestQueries(t *testing.T) {
+	ts := newServer(t)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/contains?lon=0.5&lat=0.5", "[park]"},
+		{"/contains?lon=5&lat=5", "[]"},
+		{"/intersect?bbox=0.5,0,3,1", "[cafe park]"},
+		{"/nearest?lon=49&lat=49", "[far]"},
+		{"/nearest?lon=2.1&lat=0.5&k=3", "[cafe park far]"},
+		{"/within?lon=2&lat=0.5&radius=1000", "[cafe]"},
+		{"/within?lon=2&lat=0.5&radius=200000", "[cafe park]"},
+		{"/features/cafe", "[cafe]"},
+	}
+	for _, tt := range tests {
+		ids := query(t, ts.URL+tt.path).ids()
+		if tt.path == "/intersect?bbox=0.5,0,3,1" && len(ids) == 2 && ids[0] > ids[1] {
+			ids[0], ids[1] = ids[1], ids[0]
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestQueries_distanceToGeometry(t *testing.T) {
+	// The point is inside the triangle's bounding rectangle but not the
+	// triangle.
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("triangle", geometry.Polygon{{{0, 0}, {1, 0}, {0, 1}, {0, 0}}}, nil),
+	})
+	ts := httptest.NewServer(server.New(idx, server.Options{}))
+	defer ts.Close()
+	c := query(t, ts.URL+"/nearest?lon=0.9&lat=0.9")
+	if len(c.Features) != 1 || c.Features[0].Properties["distance"].(float64) < 1000 {
+		t.Errorf("Expected the distance to the triangle, got %+v", c.Features)
+	}
+	if ids := query(t, ts.URL+"/within?lon=0.9&lat=0.9&radius=1000").ids(); len(ids) != 0 {
+		t.Errorf("Expected no features, got %v", ids)
+	}
+}
+
+func TestQueries_badRequests(t *testing.T) {
+	ts := newServer(t)
+	for _, path := range []string{
+		"/contains?lon=x&lat=1",
+		"/contains?lat=1",
+		"/intersect?bbox=1,2,3",
+		"/intersect?bbox=3,0,1,1",
+		"/nearest?lon=1&lat=1&k=0",
+		"/nearest?lon=1&lat=1&k=1000",
+		"/within?lon=1&lat=1",
+		"/within?lon=1&lat=1&radius=-5",
+	} {
+		status, data := do(t, http.MethodGet, ts.URL+path, "")
+		if status != http.StatusBadRequest || !strings.Contains(string(data), `"error"`) {
+			t.Errorf("%s: got %d %s", path, status, data)
+		}
+	}
+	if status, _ := do(t, http.MethodPost, ts.URL+"/contains?lon=1&lat=1", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("got %d", status)
+	}
+}
+
+func TestCRUD(t *testing.T) {
+	ts := newServer(t)
+	lake := `{"type":"Feature","id":"lake","geometry":{"type":"Polygon","coordinates":[[[3,3],[4,3],[4,4],[3,3]]]},"properties":{"depth":12}}`
+	if status, data := do(t, http.MethodPost, ts.URL+"/features", lake); status != http.StatusCreated {
+		t.Fatalf("create: %d %s", status, data)
+	}
+	if status, _ := do(t, http.MethodPost, ts.URL+"/features", lake); status != http.StatusConflict {
+		t.Errorf("duplicate create: got %d", status)
+	}
+	if got := query(t, ts.URL+"/contains?lon=3.9&lat=3.1").ids(); fmt.Sprint(got) != "[lake]" {
+		t.Errorf("got %v", got)
+	}
+
+	moved := strings.Replace(lake, "[[[3,3],[4,3],[4,4],[3,3]]]", "[[[10,10],[11,10],[11,11],[10,10]]]", 1)
+	if status, data := do(t, http.MethodPut, ts.URL+"/features/lake", moved); status != http.StatusOK {
+		t.Fatalf("update: %d %s", status, data)
+	}
+	if got := query(t, ts.URL+"/contains?lon=3.9&lat=3.1").ids(); len(got) != 0 {
+		t.Errorf("got %v after update", got)
+	}
+	if status, _ := do(t, http.MethodPut, ts.URL+"/features/other", moved); status != http.StatusBadRequest {
+		t.Errorf("mismatched id: got %d", status)
+	}
+	if status, _ := do(t, http.MethodPut, ts.URL+"/features/lake", `{"type":"Feature"}`); status != http.StatusBadRequest {
+		t.Errorf("invalid feature: got %d", status)
+	}
+
+	if status, _ := do(t, http.MethodDelete, ts.URL+"/features/lake", ""); status != http.StatusNoContent {
+		t.Errorf("delete: got %d", status)
+	}
+	if status, _ := do(t, http.MethodDelete, ts.URL+"/features/lake", ""); status != http.StatusNotFound {
+		t.Errorf("second delete: got %d", status)
+	}
+	if status, _ := do(t, http.MethodGet, ts.URL+"/features/lake", ""); status != http.StatusNotFound {
+		t.Errorf("get: got %d", status)
+	}
+	if status, _ := do(t, http.MethodPut, ts.URL+"/features/lake", lake); status != http.StatusCreated {
+		t.Errorf("put: got %d", status)
+	}
+}
+
+func TestHealthAndStats(t *testing.T) {
+	ts := newServer(t)
+	if status, data := do(t, http.MethodGet, ts.URL+"/health", ""); status != http.StatusOK || !strings.Contains(string(data), "ok") {
+		t.Errorf("health: %d %s", status, data)
+	}
+	query(t, ts.URL+"/contains?lon=0.5&lat=0.5")
+	do(t, http.MethodGet, ts.URL+"/contains?lon=x&lat=0.5", "")
+	do(t, http.MethodDelete, ts.URL+"/features/far", "")
+
+	_, data := do(t, http.MethodGet, ts.URL+"/stats", "")
+	var stats server.Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Features != 2 || stats.Requests["contains"] != 2 || stats.Errors != 1 || stats.Mutations != 1 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ts := newServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("p%d", i)
+			body := fmt.Sprintf(`{"type":"Feature","id":%q,"geometry":{"type":"Point","coordinates":[%d,0]},"properties":{}}`, id, i)
+			for j := 0; j < 10; j++ {
+				do(t, http.MethodPut, ts.URL+"/features/"+id, body)
+				query(t, ts.URL+"/intersect?bbox=-1,-1,10,1")
+				do(t, http.MethodDelete, ts.URL+"/features/"+id, "")
+			}
+		}(i)
+	}
+	wg.Wait()
+	var stats server.Stats
+	_, data := do(t, http.MethodGet, ts.URL+"/stats", "")
+	_ = json.Unmarshal(data, &stats)
+	if stats.Features != 3 {
+		t.Errorf("got %d features", stats.Features)
+	}
+}
@@ -0,0 +1,140 @@
+package shapefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type dbfField struct {
+	name     string
+	kind     byte
+	length   int
+	decimals int
+}
+
+// dbfReader reads dBASE III records sequentially.
+type dbfReader struct {
+	r      *bufio.Reader
+	fields []dbfField
+	record []byte
+	count  int // Number of records declared in the header.
+	n      int
+}
+
+func newDBFReader(r io.Reader) (*dbfReader, error) {
+	dbf := &dbfReader{r: bufio.NewReader(r)}
+	var header [32]byte
+	if _, err := io.ReadFull(dbf.r, header[:]); err != nil {
+		return nil, fmt.Errorf("Invalid dBASE header: %w", err)
+	}
+	dbf.count = int(binary.LittleEndian.Uint32(header[4:]))
+	headerLength := int(binary.LittleEndian.Uint16(header[8:]))
+	recordLength := int(binary.LittleEndian.Uint16(header[10:]))
+	if headerLength < 33 || recordLength < 1 {
+		return nil, errors.New("Invalid dBASE header")
+	}
+	descriptors := make([]byte, headerLength-32)
+	if _, err := io.ReadFull(dbf.r, descriptors); err != nil {
+		return nil, fmt.Errorf("Invalid dBASE field descriptors: %w", err)
+	}
+	size := 1 // Deletion flag.
+	for offset := 0; offset+32 <= len(descriptors) && descriptors[offset] != 0x0d; offset += 32 {
+		d := descriptors[offset : offset+32]
+		name := d[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		field := dbfField{
+			name:     strings.TrimSpace(string(name)),
+			kind:     d[11],
+			length:   int(d[16]),
+			decimals: int(d[17]),
+		}
+		dbf.fields = append(dbf.fields, field)
+		size += field.length
+	}
+	if size > recordLength {
+		return nil, errors.New("dBASE fields don't fit the record length")
+	}
+	dbf.record = make([]byte, recordLength)
+	return dbf, nil
+}
+
+func (dbf *dbfReader) hasField(name string) bool {
+	for _, field := range dbf.fields {
+		if field.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the attributes of the next record and whether it's marked as
+// deleted.
+func (dbf *dbfReader) next() (map[string]any, bool, error) {
+	if dbf.n >= dbf.count {
+		return nil, false, errors.New("Missing dBASE record")
+	}
+	if _, err := io.ReadFull(dbf.r, dbf.record); err != nil {
+		return nil, false, fmt.Errorf("Truncated dBASE record: %w", err)
+	}
+	dbf.n++
+	if dbf.record[0] == '*' {
+		return nil, true, nil
+	}
+	properties := make(map[string]any, len(dbf.fields))
+	offset := 1
+	for _, field := range dbf.fields {
+		value, err := field.parse(dbf.record[offset : offset+field.length])
+		if err != nil {
+			return nil, false, fmt.Errorf("Field %q: %w", field.name, err)
+		}
+		properties[field.name] = value
+		offset += field.length
+	}
+	return properties, false, nil
+}
+
+// parse converts a raw value; blank numbers, dates and logicals become nil.
+func (field dbfField) parse(raw []byte) (any, error) {
+	text := strings.TrimSpace(string(bytes.TrimRight(raw, "\x00")))
+	switch field.kind {
+	case 'N', 'F':
+		if text == "" || strings.Trim(text, "*") == "" {
+			return nil, nil
+		}
+		if field.decimals == 0 {
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				return n, nil
+			}
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q", text)
+		}
+		return f, nil
+	case 'L':
+		switch text {
+		case "Y", "y", "T", "t":
+			return true, nil
+		case "N", "n", "F", "f":
+			return false, nil
+		default:
+			return nil, nil
+		}
+	case 'D':
+		// YYYYMMDD, kept as text.
+		if text == "" {
+			return nil, nil
+		}
+		return text, nil
+	default:
+		return text, nil
+	}
+}
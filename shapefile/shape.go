@@ -0,0 +1,170 @@
+package shapefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+var errShortRecord = errors.New("Record content too short")
+
+// decodeShape decodes record content; it returns nil for null shapes.
+func decodeShape(content []byte) (geometry.Geometry, error) {
+	if len(content) < 4 {
+		return nil, errShortRecord
+	}
+	shapeType := ShapeType(binary.LittleEndian.Uint32(content))
+	body := content[4:]
+	switch shapeType.base() {
+	case NullShape:
+		return nil, nil
+	case Point:
+		if len(body) < 16 {
+			return nil, errShortRecord
+		}
+		return geometry.Point{float64At(body, 0), float64At(body, 8)}, nil
+	case MultiPoint:
+		// Bounding box (32 bytes), number of points, points.
+		if len(body) < 36 {
+			return nil, errShortRecord
+		}
+		points, err := readPoints(body[36:], int(binary.LittleEndian.Uint32(body[32:])))
+		if err != nil {
+			return nil, err
+		}
+		if len(points) == 0 {
+			return nil, errors.New("MultiPoint without points")
+		}
+		return geometry.MultiPoint(points), nil
+	case PolyLine, Polygon:
+		parts, err := readParts(body)
+		if err != nil {
+			return nil, err
+		}
+		if shapeType.base() == PolyLine {
+			return polyLine(parts), nil
+		}
+		return polygon(parts)
+	default:
+		return nil, &UnsupportedShapeTypeError{Type: shapeType}
+	}
+}
+
+// readParts reads the parts of a PolyLine or Polygon: bounding box (32 bytes),
+// number of parts, number of points, part start indexes, points.
+func readParts(body []byte) ([][]primitives.Point, error) {
+	if len(body) < 40 {
+		return nil, errShortRecord
+	}
+	numParts := int(binary.LittleEndian.Uint32(body[32:]))
+	numPoints := int(binary.LittleEndian.Uint32(body[36:]))
+	if numParts <= 0 {
+		return nil, errors.New("Shape without parts")
+	}
+	if numParts > (len(body)-40)/4 {
+		return nil, errShortRecord
+	}
+	starts := make([]int, numParts+1)
+	for i := 0; i < numParts; i++ {
+		starts[i] = int(binary.LittleEndian.Uint32(body[40+4*i:]))
+	}
+	starts[numParts] = numPoints
+	points, err := readPoints(body[40+4*numParts:], numPoints)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]primitives.Point, numParts)
+	for i := range parts {
+		if starts[i] < 0 || starts[i] >= starts[i+1] || starts[i+1] > numPoints {
+			return nil, fmt.Errorf("Invalid start index of part %d", i)
+		}
+		parts[i] = points[starts[i]:starts[i+1]:starts[i+1]]
+	}
+	return parts, nil
+}
+
+func readPoints(data []byte, n int) ([]primitives.Point, error) {
+	if n < 0 || n > len(data)/16 {
+		return nil, errShortRecord
+	}
+	points := make([]primitives.Point, n)
+	for i := range points {
+		points[i] = primitives.Point{float64At(data, 16*i), float64At(data, 16*i+8)}
+	}
+	return points, nil
+}
+
+func polyLine(parts [][]primitives.Point) geometry.Geometry {
+	if len(parts) == 1 {
+		return geometry.LineString(parts[0])
+	}
+	multi := make(geometry.MultiLineString, len(parts))
+	for i, part := range parts {
+		multi[i] = part
+	}
+	return multi
+}
+
+// polygon groups rings into polygons. Exterior rings are clockwise, holes are
+// counter-clockwise; each hole belongs to the smallest exterior containing it.
+// Holes outside all exteriors, typical of files with wrong ring orientation,
+// are treated as exteriors.
+func polygon(parts [][]primitives.Point) (geometry.Geometry, error) {
+	type exterior struct {
+		polygon geometry.Polygon
+		area    float64
+	}
+	var exteriors []*exterior
+	var holes []geometry.Ring
+	for i, part := range parts {
+		if len(part) < 4 {
+			return nil, fmt.Errorf("Ring %d has fewer than 4 points", i)
+		}
+		ring := geometry.Ring(part)
+		if area := signedArea(ring); area <= 0 {
+			exteriors = append(exteriors, &exterior{polygon: geometry.Polygon{ring}, area: -area})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	// Smallest first so the first exterior containing a hole is the tightest.
+	bySize := make([]*exterior, len(exteriors))
+	copy(bySize, exteriors)
+	sort.SliceStable(bySize, func(i, j int) bool { return bySize[i].area < bySize[j].area })
+	for _, hole := range holes {
+		var owner *exterior
+		for _, e := range bySize {
+			if e.polygon[0].Contains(hole[0]) {
+				owner = e
+				break
+			}
+		}
+		if owner == nil {
+			exteriors = append(exteriors, &exterior{polygon: geometry.Polygon{hole}})
+			continue
+		}
+		owner.polygon = append(owner.polygon, hole)
+	}
+	if len(exteriors) == 1 {
+		return exteriors[0].polygon, nil
+	}
+	multi := make(geometry.MultiPolygon, len(exteriors))
+	for i, e := range exteriors {
+		multi[i] = e.polygon
+	}
+	return multi, nil
+}
+
+// signedArea is positive for counter-clockwise rings.
+func signedArea(ring geometry.Ring) float64 {
+	var sum float64
+	for i := 0; i < len(ring); i++ {
+		j := (i + 1) % len(ring)
+		sum += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return sum / 2
+}
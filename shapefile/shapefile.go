@@ -0,0 +1,302 @@
+// Package shapefile streams ESRI Shapefile records and their dBASE attributes
+// into geometry.Feature values.
+//
+// Only the .shp and .dbf files are read; the .shx index isn't needed for
+// sequential reading. Point, MultiPoint, PolyLine and Polygon shapes, including
+// their Z and M variants, are supported; Z and M values are discarded. Null
+// shapes are skipped. Attribute text is returned as is, so it should be ASCII
+// or UTF-8.
+package shapefile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+// ShapeType is the type of shapes in a shapefile.
+type ShapeType int32
+
+const (
+	NullShape   ShapeType = 0
+	Point       ShapeType = 1
+	PolyLine    ShapeType = 3
+	Polygon     ShapeType = 5
+	MultiPoint  ShapeType = 8
+	PointZ      ShapeType = 11
+	PolyLineZ   ShapeType = 13
+	PolygonZ    ShapeType = 15
+	MultiPointZ ShapeType = 18
+	PointM      ShapeType = 21
+	PolyLineM   ShapeType = 23
+	PolygonM    ShapeType = 25
+	MultiPointM ShapeType = 28
+	MultiPatch  ShapeType = 31
+)
+
+var shapeTypeNames = map[ShapeType]string{
+	NullShape:   "Null",
+	Point:       "Point",
+	PolyLine:    "PolyLine",
+	Polygon:     "Polygon",
+	MultiPoint:  "MultiPoint",
+	PointZ:      "PointZ",
+	PolyLineZ:   "PolyLineZ",
+	PolygonZ:    "PolygonZ",
+	MultiPointZ: "MultiPointZ",
+	PointM:      "PointM",
+	PolyLineM:   "PolyLineM",
+	PolygonM:    "PolygonM",
+	MultiPointM: "MultiPointM",
+	MultiPatch:  "MultiPatch",
+}
+
+func (t ShapeType) String() string {
+	if name, ok := shapeTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ShapeType(%d)", int32(t))
+}
+
+// base returns the 2D shape type a Z or M variant extends.
+func (t ShapeType) base() ShapeType {
+	switch t {
+	case PointZ, PointM:
+		return Point
+	case PolyLineZ, PolyLineM:
+		return PolyLine
+	case PolygonZ, PolygonM:
+		return Polygon
+	case MultiPointZ, MultiPointM:
+		return MultiPoint
+	}
+	return t
+}
+
+func (t ShapeType) supported() bool {
+	switch t.base() {
+	case NullShape, Point, PolyLine, Polygon, MultiPoint:
+		return true
+	}
+	return false
+}
+
+// UnsupportedShapeTypeError is returned for shapes fencer can't represent.
+type UnsupportedShapeTypeError struct {
+	Record int // Record number, starting at 1, or 0 for the file header.
+	Type   ShapeType
+}
+
+func (err *UnsupportedShapeTypeError) Error() string {
+	if err.Record == 0 {
+		return fmt.Sprintf("Unsupported shape type %v (%d)", err.Type, int32(err.Type))
+	}
+	return fmt.Sprintf("Record %d: unsupported shape type %v (%d)", err.Record, err.Type, int32(err.Type))
+}
+
+// Options configure reading features.
+type Options struct {
+	// IDField is the name of the attribute holding feature keys. If empty,
+	// record numbers, starting at 1, are used.
+	IDField string
+}
+
+const (
+	fileCode   = 9994
+	headerSize = 100
+)
+
+// Reader reads features one record at a time.
+type Reader struct {
+	shp     *bufio.Reader
+	dbf     *dbfReader
+	options Options
+	// ShapeType is the type declared in the file header.
+	ShapeType ShapeType
+	// Bounds is the bounding rectangle declared in the file header.
+	Bounds    primitives.Rect
+	remaining int64 // Bytes of records left according to the file header.
+	closers   []io.Closer
+}
+
+// NewReader creates a reader of a .shp stream and, optionally, the matching
+// .dbf stream; dbf may be nil, in which case features have no properties.
+func NewReader(shp io.Reader, dbf io.Reader, options Options) (*Reader, error) {
+	r := &Reader{shp: bufio.NewReader(shp), options: options}
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.shp, header[:]); err != nil {
+		return nil, fmt.Errorf("Invalid shapefile header: %w", err)
+	}
+	if binary.BigEndian.Uint32(header[0:]) != fileCode {
+		return nil, errors.New("Not a shapefile")
+	}
+	r.remaining = 2*int64(binary.BigEndian.Uint32(header[24:])) - headerSize
+	if r.remaining < 0 {
+		return nil, errors.New("Invalid shapefile length")
+	}
+	r.ShapeType = ShapeType(binary.LittleEndian.Uint32(header[32:]))
+	if !r.ShapeType.supported() {
+		return nil, &UnsupportedShapeTypeError{Type: r.ShapeType}
+	}
+	r.Bounds = primitives.Rect{
+		Min: primitives.Point{float64At(header[:], 36), float64At(header[:], 44)},
+		Max: primitives.Point{float64At(header[:], 52), float64At(header[:], 60)},
+	}
+	if dbf != nil {
+		var err error
+		if r.dbf, err = newDBFReader(dbf); err != nil {
+			return nil, err
+		}
+	}
+	if options.IDField != "" && (r.dbf == nil || !r.dbf.hasField(options.IDField)) {
+		return nil, fmt.Errorf("No attribute %q", options.IDField)
+	}
+	return r, nil
+}
+
+// Open opens a shapefile given the path to its .shp file. The .dbf file next
+// to it is read if it exists.
+func Open(path string, options Options) (*Reader, error) {
+	shp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	closers := []io.Closer{shp}
+	var dbf io.Reader
+	dbfFile, err := os.Open(strings.TrimSuffix(path, ".shp") + ".dbf")
+	switch {
+	case err == nil:
+		dbf = dbfFile
+		closers = append(closers, dbfFile)
+	case !errors.Is(err, os.ErrNotExist):
+		shp.Close()
+		return nil, err
+	}
+	r, err := NewReader(shp, dbf, options)
+	if err != nil {
+		for _, c := range closers {
+			c.Close()
+		}
+		return nil, err
+	}
+	r.closers = closers
+	return r, nil
+}
+
+// Close closes files opened by Open.
+func (r *Reader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	r.closers = nil
+	return err
+}
+
+// Next returns the next feature or io.EOF after the last one. An
+// *UnsupportedShapeTypeError doesn't stop reading; other errors do.
+func (r *Reader) Next() (*geometry.Feature, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r.shp, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("Truncated record header")
+			}
+			return nil, err
+		}
+		number := int(binary.BigEndian.Uint32(header[0:]))
+		length := 2 * int64(binary.BigEndian.Uint32(header[4:]))
+		r.remaining -= int64(len(header)) + length
+		if r.remaining < 0 {
+			return nil, fmt.Errorf("Record %d: length %d exceeds the file length", number, length)
+		}
+		// The header may lie too, so memory is allocated as data arrives.
+		content, err := io.ReadAll(io.LimitReader(r.shp, length))
+		if err != nil || int64(len(content)) < length {
+			return nil, fmt.Errorf("Record %d: truncated", number)
+		}
+		var properties map[string]any
+		if r.dbf != nil {
+			var deleted bool
+			var err error
+			if properties, deleted, err = r.dbf.next(); err != nil {
+				return nil, fmt.Errorf("Record %d attributes: %w", number, err)
+			}
+			if deleted {
+				continue
+			}
+		}
+		g, err := decodeShape(content)
+		var unsupported *UnsupportedShapeTypeError
+		if errors.As(err, &unsupported) {
+			unsupported.Record = number
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Record %d: %w", number, err)
+		}
+		if g == nil {
+			continue
+		}
+		id := geometry.ID(strconv.Itoa(number))
+		if r.options.IDField != "" {
+			value, ok := properties[r.options.IDField]
+			if !ok || value == nil {
+				return nil, fmt.Errorf("Record %d: empty %q attribute", number, r.options.IDField)
+			}
+			id = geometry.ID(fmt.Sprint(value))
+		}
+		return geometry.NewFeature(id, g, properties), nil
+	}
+}
+
+// Load reads all features into a new index.
+func Load(shp io.Reader, dbf io.Reader, options Options) (*index.Index[geometry.ID, *geometry.Feature], error) {
+	r, err := NewReader(shp, dbf, options)
+	if err != nil {
+		return nil, err
+	}
+	return load(r)
+}
+
+// LoadFile reads all features of a shapefile, given the path to its .shp
+// file, into a new index.
+func LoadFile(path string, options Options) (*index.Index[geometry.ID, *geometry.Feature], error) {
+	r, err := Open(path, options)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return load(r)
+}
+
+func load(r *Reader) (*index.Index[geometry.ID, *geometry.Feature], error) {
+	var features []*geometry.Feature
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, f)
+	}
+	return index.New[geometry.ID](features)
+}
+
+func float64At(data []byte, offset int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(data[offset:]))
+}
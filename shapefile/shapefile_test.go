@@ -0,0 +1,238 @@
+package shapefile_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/shapefile"
+)
+
+// Clockwise exterior with a counter-clockwise hole.
+var (
+	square = []primitives.Point{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	hole   = []primitives.Point{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+	island = []primitives.Point{{20, 0}, {20, 5}, {25, 5}, {25, 0}, {20, 0}}
+)
+
+func buildShp(shapeType shapefile.ShapeType, records ...[]byte) []byte {
+	var body bytes.Buffer
+	for i, content := range records {
+		binary.Write(&body, binary.BigEndian, int32(i+1))
+		binary.Write(&body, binary.BigEndian, int32(len(content)/2))
+		body.Write(content)
+	}
+	header := make([]byte, 100)
+	binary.BigEndian.PutUint32(header[0:], 9994)
+	binary.BigEndian.PutUint32(header[24:], uint32((100+body.Len())/2))
+	binary.LittleEndian.PutUint32(header[28:], 1000)
+	binary.LittleEndian.PutUint32(header[32:], uint32(shapeType))
+	return append(header, body.Bytes()...)
+}
+
+func shapeRecord(shapeType shapefile.ShapeType, parts ...[]primitives.Point) []byte {
+	var points []primitives.Point
+	starts := make([]int32, len(parts))
+	for i, part := range parts {
+		starts[i] = int32(len(points))
+		points = append(points, part...)
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, int32(shapeType))
+	binary.Write(&b, binary.LittleEndian, [4]float64{}) // Bounding box, unused.
+	binary.Write(&b, binary.LittleEndian, int32(len(parts)))
+	binary.Write(&b, binary.LittleEndian, int32(len(points)))
+	binary.Write(&b, binary.LittleEndian, starts)
+	binary.Write(&b, binary.LittleEndian, points)
+	return b.Bytes()
+}
+
+func pointRecord(x, y float64) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, int32(shapefile.Point))
+	binary.Write(&b, binary.LittleEndian, [2]float64{x, y})
+	return b.Bytes()
+}
+
+type field struct {
+	name     string
+	kind     byte
+	length   int
+	decimals int
+}
+
+// buildDBF builds a dBASE III file; rows starting with '*' are deleted.
+func buildDBF(fields []field, rows ...[]string) []byte {
+	recordLength := 1
+	for _, f := range fields {
+		recordLength += f.length
+	}
+	headerLength := 32 + 32*len(fields) + 1
+	var b bytes.Buffer
+	header := make([]byte, 32)
+	header[0] = 3
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(rows)))
+	binary.LittleEndian.PutUint16(header[8:], uint16(headerLength))
+	binary.LittleEndian.PutUint16(header[10:], uint16(recordLength))
+	b.Write(header)
+	for _, f := range fields {
+		d := make([]byte, 32)
+		copy(d, f.name)
+		d[11] = f.kind
+		d[16] = byte(f.length)
+		d[17] = byte(f.decimals)
+		b.Write(d)
+	}
+	b.WriteByte(0x0d)
+	for _, row := range rows {
+		flag := " "
+		if row[0] == "*" {
+			flag, row = "*", row[1:]
+		}
+		b.WriteString(flag)
+		for i, f := range fields {
+			fmt.Fprintf(&b, "%-*s", f.length, row[i])
+		}
+	}
+	b.WriteByte(0x1a)
+	return b.Bytes()
+}
+
+var fields = []field{
+	{"CODE", 'C', 8, 0},
+	{"NAME", 'C', 20, 0},
+	{"POP", 'N', 10, 0},
+	{"AREA", 'N', 12, 3},
+	{"COASTAL", 'L', 1, 0},
+}
+
+func Example() {
+	shp := buildShp(shapefile.Polygon,
+		shapeRecord(shapefile.Polygon, square, hole),
+		shapeRecord(shapefile.Polygon, island),
+	)
+	dbf := buildDBF(fields,
+		[]string{"MAIN", "Mainland", "120000", "99.5", "N"},
+		[]string{"ISL", "Island", "", "25.0", "Y"},
+	)
+	idx, err := shapefile.Load(bytes.NewReader(shp), bytes.NewReader(dbf), shapefile.Options{IDField: "CODE"})
+	if err != nil {
+		panic(err)
+	}
+	for _, point := range []primitives.Point{{2, 2}, {5, 5}, {22, 2}} {
+		matches, _ := idx.FindContaining(point)
+		for _, f := range matches {
+			fmt.Println(point, f.Key(), f.Properties["NAME"], f.Properties["POP"], f.Properties["COASTAL"])
+		}
+	}
+	// Output:
+	// [2 2] MAIN Mainland 120000 false
+	// [22 2] ISL Island <nil> true
+}
+
+func TestReader_multiPolygon(t *testing.T) {
+	shp := buildShp(shapefile.Polygon, shapeRecord(shapefile.Polygon, island, square, hole))
+	r, err := shapefile.NewReader(bytes.NewReader(shp), nil, shapefile.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := geometry.MultiPolygon{{island}, {square, hole}}
+	if !reflect.DeepEqual(f.Geometry(), want) {
+		t.Errorf("got %v, want %v", f.Geometry(), want)
+	}
+	if f.Key() != "1" {
+		t.Errorf("got key %v", f.Key())
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReader_skipsNullAndDeleted(t *testing.T) {
+	null := make([]byte, 4)
+	shp := buildShp(shapefile.Point, pointRecord(1, 1), null, pointRecord(2, 2), pointRecord(3, 3))
+	dbf := buildDBF(fields[:1],
+		[]string{"A"},
+		[]string{"B"},
+		[]string{"*", "C"},
+		[]string{"D"},
+	)
+	idx, err := shapefile.Load(bytes.NewReader(shp), bytes.NewReader(dbf), shapefile.Options{IDField: "CODE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := idx.Keys()
+	if keys.Size() != 2 || !keys.Has("A") || !keys.Has("D") {
+		t.Errorf("got %d keys", keys.Size())
+	}
+}
+
+func TestNewReader_unsupportedShapeType(t *testing.T) {
+	shp := buildShp(shapefile.MultiPatch)
+	_, err := shapefile.NewReader(bytes.NewReader(shp), nil, shapefile.Options{})
+	var unsupported *shapefile.UnsupportedShapeTypeError
+	if !errors.As(err, &unsupported) || unsupported.Type != shapefile.MultiPatch {
+		t.Fatalf("got %v", err)
+	}
+	if err.Error() != "Unsupported shape type MultiPatch (31)" {
+		t.Errorf("got %q", err.Error())
+	}
+}
+
+func TestReader_recordLength(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		fileLength   uint32 // In 16-bit words; 0 to keep the right one.
+		recordLength uint32
+		want         string
+	}{
+		{"exceeding the file", 0, 0xffffffff, "Record 1: length 8589934590 exceeds the file length"},
+		{"with a lying file header", 0xffffffff, 0xffffff00, "Record 1: truncated"},
+	} {
+		shp := buildShp(shapefile.Point, pointRecord(1, 1))
+		binary.BigEndian.PutUint32(shp[104:], tt.recordLength)
+		if tt.fileLength > 0 {
+			binary.BigEndian.PutUint32(shp[24:], tt.fileLength)
+		}
+		r, err := shapefile.NewReader(bytes.NewReader(shp), nil, shapefile.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Next(); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: Expected %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zones.shp")
+	shp := buildShp(shapefile.PolygonZ, append(shapeRecord(shapefile.PolygonZ, square), make([]byte, 16+16*5)...))
+	dbf := buildDBF(fields[:1], []string{"Z1"})
+	if err := os.WriteFile(path, shp, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zones.dbf"), dbf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := shapefile.LoadFile(path, shapefile.Options{IDField: "CODE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := idx.FindContaining(primitives.Point{5, 5})
+	if len(matches) != 1 || matches[0].Key() != "Z1" {
+		t.Errorf("got %v", matches)
+	}
+}
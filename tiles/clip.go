@@ -0,0 +1,339 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/primitives"
+)
+
+type geomType uint32
+
+const (
+	geomPoint      geomType = 1
+	geomLineString geomType = 2
+	geomPolygon    geomType = 3
+)
+
+// tileGeometry is a geometry in integer tile coordinates: a single part
+// listing points, a part per line, or rings in polygon order with exterior
+// rings wound clockwise and holes counter-clockwise.
+type tileGeometry struct {
+	kind  geomType
+	parts [][][2]int64
+}
+
+// clipRect is a square clipping window in tile coordinates.
+type clipRect struct {
+	min, max float64
+}
+
+func (c clipRect) contains(p [2]float64) bool {
+	return p[0] >= c.min && p[0] <= c.max && p[1] >= c.min && p[1] <= c.max
+}
+
+// render projects, clips and simplifies a geometry. It returns nil if nothing
+// is left.
+func render(g geometry.Geometry, p projection, clip clipRect, tolerance float64) (*tileGeometry, error) {
+	var geom *tileGeometry
+	switch g := g.(type) {
+	case geometry.Point:
+		geom = renderPoints([]primitives.Point{primitives.Point(g)}, p, clip)
+	case geometry.MultiPoint:
+		geom = renderPoints(g, p, clip)
+	case geometry.LineString:
+		geom = renderLines([][]primitives.Point{g}, p, clip, tolerance)
+	case geometry.MultiLineString:
+		lines := make([][]primitives.Point, len(g))
+		for i, line := range g {
+			lines[i] = line
+		}
+		geom = renderLines(lines, p, clip, tolerance)
+	case geometry.Ring:
+		geom = renderPolygons([]geometry.Polygon{{g}}, p, clip, tolerance)
+	case geometry.Polygon:
+		geom = renderPolygons([]geometry.Polygon{g}, p, clip, tolerance)
+	case geometry.MultiPolygon:
+		geom = renderPolygons(g, p, clip, tolerance)
+	default:
+		return nil, fmt.Errorf("Unsupported geometry %T", g)
+	}
+	if len(geom.parts) == 0 {
+		return nil, nil
+	}
+	return geom, nil
+}
+
+func renderPoints(points []primitives.Point, p projection, clip clipRect) *tileGeometry {
+	var part [][2]int64
+	for _, point := range p.projectAll(points) {
+		if clip.contains(point) {
+			part = append(part, round(point))
+		}
+	}
+	geom := &tileGeometry{kind: geomPoint}
+	if len(part) > 0 {
+		geom.parts = [][][2]int64{part}
+	}
+	return geom
+}
+
+func renderLines(lines [][]primitives.Point, p projection, clip clipRect, tolerance float64) *tileGeometry {
+	geom := &tileGeometry{kind: geomLineString}
+	for _, line := range lines {
+		for _, piece := range clipLine(p.projectAll(line), clip) {
+			part := roundAll(simplify(piece, tolerance))
+			if len(part) >= 2 {
+				geom.parts = append(geom.parts, part)
+			}
+		}
+	}
+	return geom
+}
+
+func renderPolygons(polygons []geometry.Polygon, p projection, clip clipRect, tolerance float64) *tileGeometry {
+	geom := &tileGeometry{kind: geomPolygon}
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			part := renderRing(ring, p, clip, tolerance)
+			if part == nil {
+				if i == 0 {
+					// Holes of a vanished exterior are meaningless.
+					break
+				}
+				continue
+			}
+			// Exterior rings have a positive area in tile coordinates, whose y
+			// axis points down.
+			if exterior := i == 0; (area(part) > 0) != exterior {
+				reverse(part)
+			}
+			geom.parts = append(geom.parts, part)
+		}
+	}
+	return geom
+}
+
+// renderRing returns an open ring with at least 3 distinct points or nil.
+func renderRing(ring geometry.Ring, p projection, clip clipRect, tolerance float64) [][2]int64 {
+	points := p.projectAll(ring)
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	points = clipRing(points, clip)
+	if len(points) < 3 {
+		return nil
+	}
+	part := roundAll(simplifyRing(points, tolerance))
+	if len(part) > 1 && part[0] == part[len(part)-1] {
+		part = part[:len(part)-1]
+	}
+	if len(part) < 3 || area(part) == 0 {
+		return nil
+	}
+	return part
+}
+
+// clipLine clips a polyline, returning the pieces inside the window.
+func clipLine(points [][2]float64, clip clipRect) [][][2]float64 {
+	if len(points) == 1 {
+		if clip.contains(points[0]) {
+			return [][][2]float64{points}
+		}
+		return nil
+	}
+	var pieces [][][2]float64
+	var current [][2]float64
+	for i := 1; i < len(points); i++ {
+		a, b, ok := clipSegment(points[i-1], points[i], clip)
+		if !ok {
+			if current != nil {
+				pieces, current = append(pieces, current), nil
+			}
+			continue
+		}
+		if current == nil {
+			current = [][2]float64{a}
+		}
+		current = append(current, b)
+		// The segment left the window; start a new piece.
+		if b != points[i] {
+			pieces, current = append(pieces, current), nil
+		}
+	}
+	if current != nil {
+		pieces = append(pieces, current)
+	}
+	return pieces
+}
+
+// clipSegment clips segment ab to the window (Liang-Barsky).
+func clipSegment(a, b [2]float64, clip clipRect) ([2]float64, [2]float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, edge := range [4][2]float64{
+		{-dx, a[0] - clip.min},
+		{dx, clip.max - a[0]},
+		{-dy, a[1] - clip.min},
+		{dy, clip.max - a[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return a, b, false
+			}
+			t0 = math.Max(t0, t)
+		} else {
+			if t < t0 {
+				return a, b, false
+			}
+			t1 = math.Min(t1, t)
+		}
+	}
+	clipped := func(t float64) [2]float64 {
+		switch t {
+		case 0:
+			return a
+		case 1:
+			return b
+		}
+		return [2]float64{a[0] + t*dx, a[1] + t*dy}
+	}
+	return clipped(t0), clipped(t1), true
+}
+
+// clipRing clips an open ring (Sutherland-Hodgman).
+func clipRing(points [][2]float64, clip clipRect) [][2]float64 {
+	edges := []struct {
+		inside func(p [2]float64) bool
+		axis   int
+		value  float64
+	}{
+		{func(p [2]float64) bool { return p[0] >= clip.min }, 0, clip.min},
+		{func(p [2]float64) bool { return p[0] <= clip.max }, 0, clip.max},
+		{func(p [2]float64) bool { return p[1] >= clip.min }, 1, clip.min},
+		{func(p [2]float64) bool { return p[1] <= clip.max }, 1, clip.max},
+	}
+	for _, edge := range edges {
+		if len(points) == 0 {
+			return nil
+		}
+		var out [][2]float64
+		prev := points[len(points)-1]
+		for _, cur := range points {
+			curIn, prevIn := edge.inside(cur), edge.inside(prev)
+			if curIn != prevIn {
+				out = append(out, intersection(prev, cur, edge.axis, edge.value))
+			}
+			if curIn {
+				out = append(out, cur)
+			}
+			prev = cur
+		}
+		points = out
+	}
+	return points
+}
+
+// intersection returns the point of segment ab where the coordinate along
+// axis equals value.
+func intersection(a, b [2]float64, axis int, value float64) [2]float64 {
+	t := (value - a[axis]) / (b[axis] - a[axis])
+	p := [2]float64{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}
+	p[axis] = value
+	return p
+}
+
+// simplify applies Douglas-Peucker to a polyline.
+func simplify(points [][2]float64, tolerance float64) [][2]float64 {
+	if tolerance <= 0 || len(points) <= 2 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	douglasPeucker(points, 0, len(points)-1, tolerance*tolerance, keep)
+	simplified := make([][2]float64, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// simplifyRing simplifies an open ring, keeping its first point.
+func simplifyRing(points [][2]float64, tolerance float64) [][2]float64 {
+	closed := append(points[:len(points):len(points)], points[0])
+	simplified := simplify(closed, tolerance)
+	return simplified[:len(simplified)-1]
+}
+
+func douglasPeucker(points [][2]float64, first, last int, sqTolerance float64, keep []bool) {
+	maxDist, index := 0.0, 0
+	for i := first + 1; i < last; i++ {
+		if d := sqSegmentDistance(points[i], points[first], points[last]); d > maxDist {
+			maxDist, index = d, i
+		}
+	}
+	if maxDist > sqTolerance {
+		keep[index] = true
+		douglasPeucker(points, first, index, sqTolerance, keep)
+		douglasPeucker(points, index, last, sqTolerance, keep)
+	}
+}
+
+// sqSegmentDistance returns the squared distance from p to segment ab.
+func sqSegmentDistance(p, a, b [2]float64) float64 {
+	x, y := a[0], a[1]
+	dx, dy := b[0]-x, b[1]-y
+	if dx != 0 || dy != 0 {
+		t := ((p[0]-x)*dx + (p[1]-y)*dy) / (dx*dx + dy*dy)
+		if t > 1 {
+			x, y = b[0], b[1]
+		} else if t > 0 {
+			x, y = x+dx*t, y+dy*t
+		}
+	}
+	dx, dy = p[0]-x, p[1]-y
+	return dx*dx + dy*dy
+}
+
+func round(p [2]float64) [2]int64 {
+	return [2]int64{int64(math.Round(p[0])), int64(math.Round(p[1]))}
+}
+
+// roundAll rounds points, dropping consecutive duplicates.
+func roundAll(points [][2]float64) [][2]int64 {
+	rounded := make([][2]int64, 0, len(points))
+	for _, p := range points {
+		r := round(p)
+		if len(rounded) == 0 || rounded[len(rounded)-1] != r {
+			rounded = append(rounded, r)
+		}
+	}
+	return rounded
+}
+
+// area returns twice the signed area of an open ring.
+func area(ring [][2]int64) int64 {
+	var sum int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		sum += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return sum
+}
+
+func reverse(ring [][2]int64) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
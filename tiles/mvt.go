@@ -0,0 +1,250 @@
+package tiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Field numbers and wire types of the vector tile protobuf schema
+// (https://github.com/mapbox/vector-tile-spec/tree/master/2.1).
+const (
+	wireVarint  = 0
+	wire64      = 1
+	wireBytes   = 2
+	wire32      = 5
+	tileLayers  = 3
+	layerVer    = 15
+	layerName   = 1
+	layerFeats  = 2
+	layerKeys   = 3
+	layerValues = 4
+	layerExtent = 5
+	featTags    = 2
+	featType    = 3
+	featGeom    = 4
+	valString   = 1
+	valFloat    = 2
+	valDouble   = 3
+	valInt      = 4
+	valUint     = 5
+	valBool     = 7
+
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// layer accumulates encoded features, sharing keys and values between them.
+type layer struct {
+	name     string
+	extent   uint32
+	features [][]byte
+	keys     []string
+	keyIndex map[string]uint64
+	values   [][]byte
+	valIndex map[string]uint64
+}
+
+func newLayer(name string, extent uint32) *layer {
+	return &layer{
+		name:     name,
+		extent:   extent,
+		keyIndex: make(map[string]uint64),
+		valIndex: make(map[string]uint64),
+	}
+}
+
+func (l *layer) addFeature(geom *tileGeometry, properties map[string]any) {
+	names := make([]string, 0, len(properties))
+	for name, value := range properties {
+		if value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	tags := make([]uint64, 0, 2*len(names))
+	for _, name := range names {
+		tags = append(tags, l.key(name), l.value(properties[name]))
+	}
+
+	var buf []byte
+	if len(tags) > 0 {
+		buf = appendPacked(buf, featTags, tags)
+	}
+	buf = appendTag(buf, featType, wireVarint)
+	buf = appendVarint(buf, uint64(geom.kind))
+	buf = appendPacked(buf, featGeom, encodeGeometry(geom))
+	l.features = append(l.features, buf)
+}
+
+func (l *layer) key(name string) uint64 {
+	if i, ok := l.keyIndex[name]; ok {
+		return i
+	}
+	i := uint64(len(l.keys))
+	l.keys = append(l.keys, name)
+	l.keyIndex[name] = i
+	return i
+}
+
+func (l *layer) value(value any) uint64 {
+	encoded := encodeValue(value)
+	if i, ok := l.valIndex[string(encoded)]; ok {
+		return i
+	}
+	i := uint64(len(l.values))
+	l.values = append(l.values, encoded)
+	l.valIndex[string(encoded)] = i
+	return i
+}
+
+// encodeTile returns a tile with the layer, or an empty tile if the layer has
+// no features.
+func (l *layer) encodeTile() []byte {
+	if len(l.features) == 0 {
+		return []byte{}
+	}
+	var buf []byte
+	buf = appendTag(buf, layerVer, wireVarint)
+	buf = appendVarint(buf, 2)
+	buf = appendBytes(buf, layerName, []byte(l.name))
+	for _, f := range l.features {
+		buf = appendBytes(buf, layerFeats, f)
+	}
+	for _, k := range l.keys {
+		buf = appendBytes(buf, layerKeys, []byte(k))
+	}
+	for _, v := range l.values {
+		buf = appendBytes(buf, layerValues, v)
+	}
+	buf = appendTag(buf, layerExtent, wireVarint)
+	buf = appendVarint(buf, uint64(l.extent))
+	return appendBytes(nil, tileLayers, buf)
+}
+
+// encodeValue encodes a property value message. Values other than strings,
+// booleans and numbers are encoded as JSON strings.
+func encodeValue(value any) []byte {
+	var buf []byte
+	switch v := value.(type) {
+	case string:
+		return appendBytes(buf, valString, []byte(v))
+	case bool:
+		buf = appendTag(buf, valBool, wireVarint)
+		if v {
+			return appendVarint(buf, 1)
+		}
+		return appendVarint(buf, 0)
+	case int:
+		return appendInt(buf, int64(v))
+	case int8:
+		return appendInt(buf, int64(v))
+	case int16:
+		return appendInt(buf, int64(v))
+	case int32:
+		return appendInt(buf, int64(v))
+	case int64:
+		return appendInt(buf, v)
+	case uint:
+		return appendUint(buf, uint64(v))
+	case uint8:
+		return appendUint(buf, uint64(v))
+	case uint16:
+		return appendUint(buf, uint64(v))
+	case uint32:
+		return appendUint(buf, uint64(v))
+	case uint64:
+		return appendUint(buf, v)
+	case float32:
+		buf = appendTag(buf, valFloat, wire32)
+		bits := math.Float32bits(v)
+		return append(buf, byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24))
+	case float64:
+		buf = appendTag(buf, valDouble, wire64)
+		bits := math.Float64bits(v)
+		for i := 0; i < 8; i++ {
+			buf = append(buf, byte(bits>>(8*i)))
+		}
+		return buf
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(v))
+		}
+		return appendBytes(buf, valString, encoded)
+	}
+}
+
+func appendInt(buf []byte, v int64) []byte {
+	buf = appendTag(buf, valInt, wireVarint)
+	return appendVarint(buf, uint64(v))
+}
+
+func appendUint(buf []byte, v uint64) []byte {
+	buf = appendTag(buf, valUint, wireVarint)
+	return appendVarint(buf, v)
+}
+
+// encodeGeometry returns the command stream of a geometry, with coordinates
+// delta- and zigzag-encoded.
+func encodeGeometry(geom *tileGeometry) []uint64 {
+	var commands []uint64
+	var cursor [2]int64
+	moveTo := func(points [][2]int64, cmd uint64) {
+		commands = append(commands, command(cmd, len(points)))
+		for _, p := range points {
+			commands = append(commands, zigzag(p[0]-cursor[0]), zigzag(p[1]-cursor[1]))
+			cursor = p
+		}
+	}
+	for _, part := range geom.parts {
+		switch geom.kind {
+		case geomPoint:
+			moveTo(part, cmdMoveTo)
+		case geomLineString:
+			moveTo(part[:1], cmdMoveTo)
+			moveTo(part[1:], cmdLineTo)
+		case geomPolygon:
+			moveTo(part[:1], cmdMoveTo)
+			moveTo(part[1:], cmdLineTo)
+			commands = append(commands, command(cmdClosePath, 1))
+		}
+	}
+	return commands
+}
+
+func command(id uint64, count int) uint64 {
+	return id&0x7 | uint64(count)<<3
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendTag(buf []byte, field, wire int) []byte {
+	return appendVarint(buf, uint64(field)<<3|uint64(wire))
+}
+
+func appendBytes(buf []byte, field int, data []byte) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = appendVarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendPacked(buf []byte, field int, values []uint64) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = appendVarint(packed, v)
+	}
+	return appendBytes(buf, field, packed)
+}
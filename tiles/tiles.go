@@ -0,0 +1,161 @@
+// Package tiles renders index features as Mapbox Vector Tiles.
+//
+// Tiles are addressed by zoom level and x/y in the XYZ scheme on the Web
+// Mercator projection. Feature geometries, in longitude/latitude, are projected
+// to tile coordinates, clipped to the tile and its buffer, simplified and
+// encoded as a single layer.
+package tiles
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+)
+
+const (
+	// DefaultLayer is the name of the layer unless set in Options.
+	DefaultLayer = "features"
+	// DefaultExtent is the size of a tile in tile coordinates.
+	DefaultExtent = 4096
+	// DefaultBuffer is the width of the area around a tile geometries are
+	// clipped to, in tile coordinates.
+	DefaultBuffer = 64
+
+	maxZoom     = 30
+	maxLatitude = 85.05112877980659
+)
+
+// Options configure rendering tiles.
+type Options[F any] struct {
+	// Layer is the layer name; DefaultLayer if empty.
+	Layer string
+	// Extent is the tile size in tile coordinates; DefaultExtent if 0.
+	Extent uint32
+	// Buffer is the clipping buffer in tile coordinates; DefaultBuffer if 0.
+	Buffer uint32
+	// Tolerance is the Douglas-Peucker simplification tolerance in tile
+	// coordinates; 0 disables simplification.
+	Tolerance float64
+	// IDProperty, if set, is the name of a property feature keys are written
+	// to.
+	IDProperty string
+	// Properties returns the properties to encode for a feature; optional.
+	// geojson.SelectProperties can be used for geometry.Feature.
+	Properties func(f F) map[string]any
+}
+
+// Bounds returns the longitude/latitude bounds of a tile.
+func Bounds(z, x, y int) (primitives.Rect, error) {
+	if err := validate(z, x, y); err != nil {
+		return primitives.Rect{}, err
+	}
+	return tileBounds(z, float64(x), float64(y), float64(x+1), float64(y+1)), nil
+}
+
+func validate(z, x, y int) error {
+	if z < 0 || z > maxZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return fmt.Errorf("Invalid tile %d/%d/%d", z, x, y)
+	}
+	return nil
+}
+
+// tileBounds converts fractional tile coordinates to longitude/latitude.
+func tileBounds(z int, minX, minY, maxX, maxY float64) primitives.Rect {
+	n := float64(uint64(1) << z)
+	lon := func(x float64) float64 { return x/n*360 - 180 }
+	lat := func(y float64) float64 {
+		y = math.Max(0, math.Min(n, y))
+		return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	}
+	return primitives.Rect{
+		Min: primitives.Point{math.Max(-180, lon(minX)), lat(maxY)},
+		Max: primitives.Point{math.Min(180, lon(maxX)), lat(minY)},
+	}
+}
+
+// projection maps longitude/latitude to tile coordinates.
+type projection struct {
+	n, x, y, extent float64
+}
+
+func (p projection) project(point primitives.Point) [2]float64 {
+	lat := math.Max(-maxLatitude, math.Min(maxLatitude, point[1])) * math.Pi / 180
+	worldX := (point[0] + 180) / 360 * p.n
+	worldY := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * p.n
+	return [2]float64{(worldX - p.x) * p.extent, (worldY - p.y) * p.extent}
+}
+
+func (p projection) projectAll(points []primitives.Point) [][2]float64 {
+	projected := make([][2]float64, len(points))
+	for i, point := range points {
+		projected[i] = p.project(point)
+	}
+	return projected
+}
+
+// Encode renders features intersecting a tile, including its buffer, as a
+// vector tile with a single layer. Features implementing geometry.Geometer are
+// rendered with their geometries, others as their bounding rectangles.
+// Features are encoded in key order.
+func Encode[K feature.Key, F feature.Feature[K]](idx *index.Index[K, F], z, x, y int, options Options[F]) ([]byte, error) {
+	if err := validate(z, x, y); err != nil {
+		return nil, err
+	}
+	if options.Layer == "" {
+		options.Layer = DefaultLayer
+	}
+	if options.Extent == 0 {
+		options.Extent = DefaultExtent
+	}
+	if options.Buffer == 0 {
+		options.Buffer = DefaultBuffer
+	}
+	buffer := float64(options.Buffer) / float64(options.Extent)
+	bounds := tileBounds(z, float64(x)-buffer, float64(y)-buffer, float64(x+1)+buffer, float64(y+1)+buffer)
+	features, err := idx.Intersect(&bounds)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i].Key().String() < features[j].Key().String() })
+
+	p := projection{n: float64(uint64(1) << z), x: float64(x), y: float64(y), extent: float64(options.Extent)}
+	clip := clipRect{
+		min: -float64(options.Buffer),
+		max: float64(options.Extent + options.Buffer),
+	}
+	layer := newLayer(options.Layer, options.Extent)
+	for _, f := range features {
+		var g geometry.Geometry
+		if geometer, ok := any(f).(geometry.Geometer); ok {
+			g = geometer.Geometry()
+		} else {
+			g = geometry.FromRect(*f.Bounds())
+		}
+		geom, err := render(g, p, clip, options.Tolerance)
+		if err != nil {
+			return nil, fmt.Errorf("Feature %q: %w", f.Key().String(), err)
+		}
+		if geom == nil {
+			continue
+		}
+		var properties map[string]any
+		if options.Properties != nil {
+			properties = options.Properties(f)
+		}
+		if options.IDProperty != "" {
+			withID := make(map[string]any, len(properties)+1)
+			for name, value := range properties {
+				withID[name] = value
+			}
+			withID[options.IDProperty] = f.Key().String()
+			properties = withID
+		}
+		layer.addFeature(geom, properties)
+	}
+	return layer.encodeTile(), nil
+}
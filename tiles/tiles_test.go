@@ -0,0 +1,327 @@
+package tiles_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bilus/fencer/geojson"
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/tiles"
+)
+
+func Example() {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("zone", geometry.Polygon{{{0, 0}, {90, 0}, {90, 45}, {0, 45}, {0, 0}}},
+			map[string]any{"name": "Zone", "level": 3}),
+		geometry.NewFeature("far", geometry.Point{-100, -40}, map[string]any{"name": "Far"}),
+	})
+	data, err := tiles.Encode(idx, 1, 1, 0, tiles.Options[*geometry.Feature]{
+		Extent:     256,
+		IDProperty: "id",
+		Properties: geojson.SelectProperties("name"),
+	})
+	if err != nil {
+		panic(err)
+	}
+	layer := decode(data)[0]
+	fmt.Println(layer.name, layer.extent, len(layer.features))
+	f := layer.features[0]
+	fmt.Println(f.kind, f.properties, f.geometry)
+	// Output:
+	// features 256 1
+	// 3 map[id:zone name:Zone] [[[0 184] [128 184] [128 256] [0 256]]]
+}
+
+func TestBounds(t *testing.T) {
+	bounds, err := tiles.Bounds(1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds.Min != (primitives.Point{0, 0}) || bounds.Max[0] != 180 || math.Abs(bounds.Max[1]-85.0511) > 1e-4 {
+		t.Errorf("got %v", bounds)
+	}
+	for _, tile := range [][3]int{{-1, 0, 0}, {0, 1, 0}, {2, 0, 4}, {31, 0, 0}} {
+		if _, err := tiles.Bounds(tile[0], tile[1], tile[2]); err == nil {
+			t.Errorf("%v: expected an error", tile)
+		}
+	}
+}
+
+func TestEncode_clipping(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		// Crosses the tile from west to east, well beyond its buffer.
+		geometry.NewFeature("line", geometry.LineString{{-170, 10}, {170, 10}}, nil),
+		// Covers the whole tile.
+		geometry.NewFeature("big", geometry.Polygon{{{-170, -80}, {170, -80}, {170, 80}, {-170, 80}, {-170, -80}}}, nil),
+	})
+	data, err := tiles.Encode(idx, 2, 1, 1, tiles.Options[*geometry.Feature]{Extent: 256, Buffer: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := decode(data)[0].features
+	if len(features) != 2 {
+		t.Fatalf("got %d features", len(features))
+	}
+	for _, f := range features {
+		for _, part := range f.geometry {
+			for _, p := range part {
+				if p[0] < -8 || p[0] > 264 || p[1] < -8 || p[1] > 264 {
+					t.Errorf("point %v outside the buffer", p)
+				}
+			}
+		}
+	}
+	// Features are sorted by key: "big" first.
+	if rings := features[0].geometry; len(rings) != 1 || len(rings[0]) != 4 || area(rings[0]) != 2*272*272 {
+		t.Errorf("expected the buffered tile, got %v", rings)
+	}
+	if line := features[1].geometry; len(line) != 1 || line[0][0][0] != -8 || line[0][1][0] != 264 {
+		t.Errorf("got %v", line)
+	}
+}
+
+func TestEncode_winding(t *testing.T) {
+	// Counter-clockwise exterior and clockwise hole in longitude/latitude.
+	polygon := geometry.Polygon{
+		{{10, 10}, {80, 10}, {80, 60}, {10, 60}, {10, 10}},
+		{{20, 20}, {20, 40}, {40, 40}, {40, 20}, {20, 20}},
+	}
+	for name, g := range map[string]geometry.Polygon{
+		"as is":    polygon,
+		"reversed": {reversed(polygon[0]), reversed(polygon[1])},
+	} {
+		idx, _ := index.New[geometry.ID]([]*geometry.Feature{geometry.NewFeature("p", g, nil)})
+		data, err := tiles.Encode(idx, 1, 1, 0, tiles.Options[*geometry.Feature]{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rings := decode(data)[0].features[0].geometry
+		if len(rings) != 2 {
+			t.Fatalf("%s: got %d rings", name, len(rings))
+		}
+		if area(rings[0]) <= 0 || area(rings[1]) >= 0 {
+			t.Errorf("%s: wrong winding %v", name, rings)
+		}
+	}
+}
+
+func TestEncode_simplification(t *testing.T) {
+	line := geometry.LineString{}
+	for i := 0; i <= 100; i++ {
+		x := float64(i) * 1.5
+		line = append(line, primitives.Point{x, 20 + 0.01*math.Sin(x)})
+	}
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{geometry.NewFeature("l", line, nil)})
+	data, err := tiles.Encode(idx, 1, 1, 0, tiles.Options[*geometry.Feature]{Tolerance: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decode(data)[0].features[0].geometry[0]; len(got) != 2 {
+		t.Errorf("got %d points", len(got))
+	}
+}
+
+func TestEncode_properties(t *testing.T) {
+	props := map[string]any{"s": "x", "i": -5, "u": uint8(7), "f": 1.5, "b": true, "list": []int{1, 2}}
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("a", geometry.Point{10, 10}, props),
+		geometry.NewFeature("b", geometry.Point{20, 10}, map[string]any{"s": "x"}),
+	})
+	data, err := tiles.Encode(idx, 0, 0, 0, tiles.Options[*geometry.Feature]{
+		Layer:      "points",
+		Properties: geojson.AllProperties,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := decode(data)[0]
+	want := map[string]any{"s": "x", "i": int64(-5), "u": uint64(7), "f": 1.5, "b": true, "list": "[1,2]"}
+	if got := layer.features[0].properties; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if layer.name != "points" || len(layer.values) != len(want) {
+		t.Errorf("expected shared values, got %v", layer.values)
+	}
+}
+
+func TestEncode_empty(t *testing.T) {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{geometry.NewFeature("a", geometry.Point{10, 10}, nil)})
+	data, err := tiles.Encode(idx, 2, 0, 0, tiles.Options[*geometry.Feature]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("expected an empty tile, got %d bytes", len(data))
+	}
+	if _, err := tiles.Encode(idx, 2, 4, 0, tiles.Options[*geometry.Feature]{}); err == nil {
+		t.Error("expected an error for an invalid tile")
+	}
+}
+
+func reversed(ring geometry.Ring) geometry.Ring {
+	r := make(geometry.Ring, len(ring))
+	for i, p := range ring {
+		r[len(ring)-1-i] = p
+	}
+	return r
+}
+
+func area(ring [][2]int64) int64 {
+	var sum int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		sum += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return sum
+}
+
+// A minimal vector tile decoder.
+
+type decodedLayer struct {
+	name     string
+	extent   uint64
+	keys     []string
+	values   []any
+	features []decodedFeature
+}
+
+type decodedFeature struct {
+	kind       uint64
+	properties map[string]any
+	geometry   [][][2]int64
+}
+
+type field struct {
+	num   int
+	value uint64
+	data  []byte
+}
+
+func fields(data []byte) []field {
+	var out []field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		f := field{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.value, n = binary.Uvarint(data)
+			data = data[n:]
+		case 1:
+			f.value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			f.value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			panic(fmt.Sprintf("unexpected wire type %d", key&7))
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func packed(data []byte) []uint64 {
+	var out []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		out, data = append(out, v), data[n:]
+	}
+	return out
+}
+
+func decode(data []byte) []decodedLayer {
+	var layers []decodedLayer
+	for _, t := range fields(data) {
+		if t.num != 3 {
+			continue
+		}
+		var l decodedLayer
+		var raw [][]byte
+		for _, f := range fields(t.data) {
+			switch f.num {
+			case 1:
+				l.name = string(f.data)
+			case 2:
+				raw = append(raw, f.data)
+			case 3:
+				l.keys = append(l.keys, string(f.data))
+			case 4:
+				l.values = append(l.values, decodeValue(f.data))
+			case 5:
+				l.extent = f.value
+			}
+		}
+		for _, data := range raw {
+			l.features = append(l.features, decodeFeature(data, l.keys, l.values))
+		}
+		layers = append(layers, l)
+	}
+	return layers
+}
+
+func decodeValue(data []byte) any {
+	f := fields(data)[0]
+	switch f.num {
+	case 1:
+		return string(f.data)
+	case 2:
+		return float64(math.Float32frombits(uint32(f.value)))
+	case 3:
+		return math.Float64frombits(f.value)
+	case 4:
+		return int64(f.value)
+	case 5:
+		return f.value
+	case 6:
+		return int64(f.value>>1) ^ -int64(f.value&1)
+	default:
+		return f.value == 1
+	}
+}
+
+func decodeFeature(data []byte, keys []string, values []any) decodedFeature {
+	feature := decodedFeature{properties: map[string]any{}}
+	for _, f := range fields(data) {
+		switch f.num {
+		case 2:
+			tags := packed(f.data)
+			for i := 0; i < len(tags); i += 2 {
+				feature.properties[keys[tags[i]]] = values[tags[i+1]]
+			}
+		case 3:
+			feature.kind = f.value
+		case 4:
+			feature.geometry = decodeGeometry(packed(f.data))
+		}
+	}
+	return feature
+}
+
+func decodeGeometry(commands []uint64) [][][2]int64 {
+	var parts [][][2]int64
+	var x, y int64
+	unzig := func(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+	for i := 0; i < len(commands); {
+		id, count := commands[i]&7, int(commands[i]>>3)
+		i++
+		if id == 7 {
+			continue
+		}
+		if id == 1 {
+			parts = append(parts, nil)
+		}
+		for j := 0; j < count; j++ {
+			x, y = x+unzig(commands[i]), y+unzig(commands[i+1])
+			i += 2
+			parts[len(parts)-1] = append(parts[len(parts)-1], [2]int64{x, y})
+		}
+	}
+	return parts
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/bilus/fencer/query"
+)
+
+// StartFunc starts a span in an external tracing system as a child of the
+// span in ctx, returning a context holding the new span.
+type StartFunc func(ctx context.Context, name string) (context.Context, query.Span)
+
+// Adapter forwards spans to a context-based tracing system, nesting them
+// under the span in a base context, e.g. that of an HTTP request. With
+// OpenTelemetry, wrap a trace.Tracer:
+//
+//	tracer := otel.Tracer("fencer")
+//	start := func(ctx context.Context, name string) (context.Context, query.Span) {
+//		ctx, span := tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//	q := query.Build[K, F]().Trace(tracing.NewAdapter(r.Context(), start))...
+//
+// where otelSpan implements query.Span by calling SetAttributes, RecordError
+// with SetStatus, and End.
+type Adapter struct {
+	ctx   context.Context
+	start StartFunc
+}
+
+var _ query.Tracer = (*Adapter)(nil)
+
+// NewAdapter creates an adapter starting root spans in ctx.
+func NewAdapter(ctx context.Context, start StartFunc) *Adapter {
+	return &Adapter{ctx: ctx, start: start}
+}
+
+// Start implements query.Tracer.
+func (adapter *Adapter) Start(parent query.Span, name string) query.Span {
+	ctx := adapter.ctx
+	if p, ok := parent.(*adaptedSpan); ok {
+		ctx = p.ctx
+	}
+	ctx, span := adapter.start(ctx, name)
+	return &adaptedSpan{Span: span, ctx: ctx}
+}
+
+// adaptedSpan carries the context of an external span to its children.
+type adaptedSpan struct {
+	query.Span
+	ctx context.Context
+}
+
+// ContextOf returns the context of a span started by an Adapter, e.g. to
+// nest other spans under it, or nil for other spans.
+func ContextOf(span query.Span) context.Context {
+	if s, ok := span.(*adaptedSpan); ok {
+		return s.ctx
+	}
+	return nil
+}
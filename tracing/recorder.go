@@ -0,0 +1,133 @@
+// Package tracing implements query.Tracer: Recorder keeps spans in memory for
+// tests and debugging, and Adapter forwards them to context-based tracing
+// systems such as OpenTelemetry.
+package tracing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilus/fencer/query"
+)
+
+// RecordedSpan is a span kept by a Recorder.
+type RecordedSpan struct {
+	ID         int
+	ParentID   int // 0 for root spans.
+	Name       string
+	Attributes map[string]any
+	Start, End time.Time
+	Err        error
+	Ended      bool
+}
+
+// Duration returns how long the span took.
+func (span *RecordedSpan) Duration() time.Duration {
+	return span.End.Sub(span.Start)
+}
+
+// Recorder keeps spans in memory. It's safe for concurrent use. The zero
+// value is ready to use.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+var _ query.Tracer = (*Recorder)(nil)
+
+// Start implements query.Tracer.
+func (recorder *Recorder) Start(parent query.Span, name string) query.Span {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	span := &RecordedSpan{
+		ID:         len(recorder.spans) + 1,
+		Name:       name,
+		Attributes: make(map[string]any),
+		Start:      time.Now(),
+	}
+	if p, ok := parent.(*recordedSpan); ok && p.recorder == recorder {
+		span.ParentID = p.span.ID
+	}
+	recorder.spans = append(recorder.spans, span)
+	return &recordedSpan{recorder: recorder, span: span}
+}
+
+// Spans returns copies of recorded spans in the order they were started.
+func (recorder *Recorder) Spans() []RecordedSpan {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	spans := make([]RecordedSpan, len(recorder.spans))
+	for i, span := range recorder.spans {
+		spans[i] = *span
+		spans[i].Attributes = make(map[string]any, len(span.Attributes))
+		for k, v := range span.Attributes {
+			spans[i].Attributes[k] = v
+		}
+	}
+	return spans
+}
+
+// Reset discards recorded spans.
+func (recorder *Recorder) Reset() {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = nil
+}
+
+// Tree returns span names with attributes, indented by depth, one per line.
+// Unended spans are marked with an asterisk and failed ones with their
+// errors.
+func (recorder *Recorder) Tree() string {
+	spans := recorder.Spans()
+	depth := make(map[int]int, len(spans))
+	var b strings.Builder
+	for _, span := range spans {
+		if span.ParentID != 0 {
+			depth[span.ID] = depth[span.ParentID] + 1
+		}
+		b.WriteString(strings.Repeat("  ", depth[span.ID]))
+		b.WriteString(span.Name)
+		keys := make([]string, 0, len(span.Attributes))
+		for k := range span.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, span.Attributes[k])
+		}
+		if !span.Ended {
+			b.WriteString(" *")
+		}
+		if span.Err != nil {
+			fmt.Fprintf(&b, " error=%q", span.Err.Error())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// recordedSpan updates a RecordedSpan under the recorder's lock; after
+// Reset, it updates a span no longer recorded.
+type recordedSpan struct {
+	recorder *Recorder
+	span     *RecordedSpan
+}
+
+func (span *recordedSpan) SetAttribute(key string, value any) {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	span.span.Attributes[key] = value
+}
+
+func (span *recordedSpan) End(err error) {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	if !span.span.Ended {
+		span.span.End = time.Now()
+		span.span.Err = err
+		span.span.Ended = true
+	}
+}
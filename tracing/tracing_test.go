@@ -0,0 +1,160 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bilus/fencer/geometry"
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/query"
+	"github.com/bilus/fencer/tracing"
+)
+
+type named string
+
+func (name named) Map(match *query.Match[geometry.ID, *geometry.Feature]) (*query.Match[geometry.ID, *geometry.Feature], error) {
+	match.AddKey(string(name))
+	return match, nil
+}
+
+func (name named) Reduce(result *query.Result[geometry.ID, *geometry.Feature], match *query.Match[geometry.ID, *geometry.Feature]) error {
+	for _, key := range match.ResultKeys {
+		err := result.Update(key, func(entry *query.ResultEntry[geometry.ID, *geometry.Feature]) error {
+			entry.Features = append(entry.Features, match.Feature)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (name named) String() string {
+	return string(name)
+}
+
+func Example() {
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{
+		geometry.NewFeature("a", geometry.Point{1, 1}, nil),
+		geometry.NewFeature("b", geometry.Point{5, 5}, nil),
+	})
+	recorder := &tracing.Recorder{}
+	idx.SetTracer(recorder)
+	_, _ = idx.FindContaining(primitives.Point{1, 1})
+	fmt.Print(recorder.Tree())
+	// Output:
+	// index.Query bounds=[1 1 1 1] matches=1
+	//   rtree.Search candidates=1
+	//   query.Scan feature=a
+	//     condition query.Contains match=true
+	//     map query.defaultAggregator
+	//     reduce query.defaultAggregator
+}
+
+func TestRecorder_streams(t *testing.T) {
+	recorder := &tracing.Recorder{}
+	builder := query.Build[geometry.ID, *geometry.Feature]().Trace(recorder)
+	builder.StreamTo(named("all")).Map(named("first")).Map(named("second"))
+	q := builder.Query()
+	if err := q.Scan(geometry.NewFeature("a", geometry.Point{1, 1}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	want := `query.Scan feature=a
+  condition query.defaultFilter match=true
+  map first
+  map second
+  reduce all
+`
+	if got := recorder.Tree(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	spans := recorder.Spans()
+	for _, span := range spans {
+		if !span.Ended || span.Duration() < 0 {
+			t.Errorf("unexpected span %+v", span)
+		}
+	}
+	recorder.Reset()
+	if spans := recorder.Spans(); len(spans) != 0 {
+		t.Errorf("got %v", spans)
+	}
+}
+
+func TestRecorder_errors(t *testing.T) {
+	recorder := &tracing.Recorder{}
+	failing := query.Pred[geometry.ID, *geometry.Feature](func(f *geometry.Feature) (bool, error) {
+		return false, errors.New("Boom")
+	})
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{geometry.NewFeature("a", geometry.Point{1, 1}, nil)})
+	bounds := primitives.Rect{Max: primitives.Point{2, 2}}
+	_, err := idx.Query(&bounds, query.Build[geometry.ID, *geometry.Feature]().Where(failing).Trace(recorder).Query())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	want := `index.Query bounds=[0 0 2 2] matches=0 error="Boom"
+  rtree.Search candidates=1
+  query.Scan feature=a error="Boom"
+    condition query.Pred match=false error="Boom"
+`
+	if got := recorder.Tree(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+type contextKey struct{}
+
+// fakeSpan is an external span identified by its path from the root.
+type fakeSpan struct {
+	path  string
+	ended *[]string
+}
+
+func (span fakeSpan) SetAttribute(key string, value any) {}
+
+func (span fakeSpan) End(err error) {
+	*span.ended = append(*span.ended, span.path)
+}
+
+func TestAdapter(t *testing.T) {
+	var ended []string
+	start := func(ctx context.Context, name string) (context.Context, query.Span) {
+		path := name
+		if parent, ok := ctx.Value(contextKey{}).(string); ok {
+			path = parent + " > " + name
+		}
+		return context.WithValue(ctx, contextKey{}, path), fakeSpan{path, &ended}
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	idx, _ := index.New[geometry.ID]([]*geometry.Feature{geometry.NewFeature("a", geometry.Point{1, 1}, nil)})
+	adapter := tracing.NewAdapter(ctx, start)
+	idx.SetTracer(adapter)
+	if _, err := idx.FindContaining(primitives.Point{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ended)
+	want := []string{
+		"request > index.Query",
+		"request > index.Query > query.Scan",
+		"request > index.Query > query.Scan > condition query.Contains",
+		"request > index.Query > query.Scan > map query.defaultAggregator",
+		"request > index.Query > query.Scan > reduce query.defaultAggregator",
+		"request > index.Query > rtree.Search",
+	}
+	if got := strings.Join(ended, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s", got)
+	}
+
+	span := adapter.Start(nil, "root")
+	if got := tracing.ContextOf(span).Value(contextKey{}); got != "request > root" {
+		t.Errorf("got %v", got)
+	}
+	if tracing.ContextOf(fakeSpan{}) != nil {
+		t.Error("expected no context")
+	}
+}
@@ -0,0 +1,38 @@
+package wal
+
+import (
+	"encoding/json"
+
+	"github.com/bilus/fencer/feature"
+)
+
+// Codec serializes feature keys and features.
+type Codec[K feature.Key, F feature.Feature[K]] interface {
+	EncodeKey(key K) ([]byte, error)
+	DecodeKey(data []byte) (K, error)
+	EncodeFeature(f F) ([]byte, error)
+	DecodeFeature(data []byte) (F, error)
+}
+
+// JSONCodec is a codec using encoding/json.
+type JSONCodec[K feature.Key, F feature.Feature[K]] struct{}
+
+func (JSONCodec[K, F]) EncodeKey(key K) ([]byte, error) {
+	return json.Marshal(key)
+}
+
+func (JSONCodec[K, F]) DecodeKey(data []byte) (K, error) {
+	var key K
+	err := json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (JSONCodec[K, F]) EncodeFeature(f F) ([]byte, error) {
+	return json.Marshal(f)
+}
+
+func (JSONCodec[K, F]) DecodeFeature(data []byte) (F, error) {
+	var f F
+	err := json.Unmarshal(data, &f)
+	return f, err
+}
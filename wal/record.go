@@ -0,0 +1,228 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+)
+
+// Records are framed as: payload length (uint32), CRC-32C of the payload
+// (uint32), payload. Both integers are little-endian.
+const headerSize = 8
+
+// maxPayloadSize limits the payload length read from a record header, so
+// that a corrupted length doesn't allocate gigabytes.
+const maxPayloadSize = 256 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn is returned when a record is cut short by the end of file or is
+// empty. It's only the torn tail of the log if no valid record follows; see
+// validFrameAfter.
+var errTorn = errors.New("torn record")
+
+// ErrCorrupted is returned by Recover when a record other than the last one
+// is invalid.
+type ErrCorrupted struct {
+	Path   string
+	Offset int64
+}
+
+func (err ErrCorrupted) Error() string {
+	return fmt.Sprintf("Corrupted record in %v at offset %d", err.Path, err.Offset)
+}
+
+func appendFrame(buf []byte, payload []byte) []byte {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// errBadLength is returned when a record header has an impossible length.
+var errBadLength = errors.New("invalid record length")
+
+// readFrame reads a single record, given the number of bytes remaining in
+// the file. It returns io.EOF at a clean end of file, errTorn if the record
+// is incomplete or empty, e.g. in a zero-filled tail, errBadLength if its
+// length exceeds maxPayloadSize and ok = false if the checksum doesn't match.
+func readFrame(r io.Reader, remaining int64) (payload []byte, ok bool, err error) {
+	var header [headerSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return nil, false, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF || (err == nil && n < headerSize) {
+		return nil, false, errTorn
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length == 0 || int64(length) > remaining-headerSize {
+		return nil, false, errTorn
+	}
+	if length > maxPayloadSize {
+		return nil, false, errBadLength
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, false, errTorn
+		}
+		return nil, false, err
+	}
+	return payload, crc32.Checksum(payload, crcTable) == binary.LittleEndian.Uint32(header[4:8]), nil
+}
+
+// validFrameAfter reports whether a record with a matching checksum starts
+// anywhere between offset and size.
+func validFrameAfter(r io.ReaderAt, offset, size int64) (bool, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	var payload []byte
+	for pos := offset; size-pos >= headerSize; pos++ {
+		header, err := br.Peek(headerSize)
+		if err != nil {
+			return false, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		if length > 0 && length <= maxPayloadSize && length <= size-pos-headerSize {
+			if int64(cap(payload)) < length {
+				payload = make([]byte, length)
+			}
+			payload = payload[:length]
+			if _, err := r.ReadAt(payload, pos+headerSize); err != nil {
+				return false, err
+			}
+			if crc32.Checksum(payload, crcTable) == binary.LittleEndian.Uint32(header[4:8]) {
+				return true, nil
+			}
+		}
+		if _, err := br.Discard(1); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func encodeMutation[K feature.Key, F feature.Feature[K]](codec Codec[K, F], lsn uint64, mutation index.Mutation[K, F]) ([]byte, error) {
+	key, err := codec.EncodeKey(mutation.Key)
+	if err != nil {
+		return nil, err
+	}
+	payload := appendUvarint(nil, lsn)
+	payload = append(payload, byte(mutation.Op))
+	payload = appendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	if mutation.Op == index.OpDelete {
+		return payload, nil
+	}
+	f, err := codec.EncodeFeature(mutation.Feature)
+	if err != nil {
+		return nil, err
+	}
+	return append(payload, f...), nil
+}
+
+// opBatch marks records holding a batch of mutations with consecutive
+// sequence numbers, each encoded as by encodeMutation and prefixed with its
+// length.
+const opBatch = 0
+
+func encodeBatch[K feature.Key, F feature.Feature[K]](codec Codec[K, F], lsn uint64, mutations []index.Mutation[K, F]) ([]byte, error) {
+	payload := appendUvarint(nil, lsn)
+	payload = append(payload, opBatch)
+	payload = appendUvarint(payload, uint64(len(mutations)))
+	for i, mutation := range mutations {
+		record, err := encodeMutation(codec, lsn+uint64(i), mutation)
+		if err != nil {
+			return nil, err
+		}
+		payload = appendUvarint(payload, uint64(len(record)))
+		payload = append(payload, record...)
+	}
+	return payload, nil
+}
+
+// loggedMutation is a mutation read from the log.
+type loggedMutation[K feature.Key, F feature.Feature[K]] struct {
+	lsn      uint64
+	mutation index.Mutation[K, F]
+}
+
+// decodeRecord decodes a single mutation or a batch of mutations.
+func decodeRecord[K feature.Key, F feature.Feature[K]](codec Codec[K, F], payload []byte) ([]loggedMutation[K, F], error) {
+	_, n := binary.Uvarint(payload)
+	if n <= 0 || n >= len(payload) {
+		return nil, errors.New("Invalid record header")
+	}
+	if payload[n] != opBatch {
+		lsn, mutation, err := decodeMutation(codec, payload)
+		if err != nil {
+			return nil, err
+		}
+		return []loggedMutation[K, F]{{lsn, mutation}}, nil
+	}
+	payload = payload[n+1:]
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)) {
+		return nil, errors.New("Invalid batch header")
+	}
+	payload = payload[n:]
+	mutations := make([]loggedMutation[K, F], 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < size {
+			return nil, errors.New("Invalid batch record")
+		}
+		lsn, mutation, err := decodeMutation(codec, payload[n:n+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		mutations = append(mutations, loggedMutation[K, F]{lsn, mutation})
+		payload = payload[n+int(size):]
+	}
+	return mutations, nil
+}
+
+func decodeMutation[K feature.Key, F feature.Feature[K]](codec Codec[K, F], payload []byte) (uint64, index.Mutation[K, F], error) {
+	var mutation index.Mutation[K, F]
+	lsn, n := binary.Uvarint(payload)
+	if n <= 0 || n >= len(payload) {
+		return 0, mutation, errors.New("Invalid record header")
+	}
+	payload = payload[n:]
+	mutation.Op = index.Op(payload[0])
+	payload = payload[1:]
+	keyLen, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < keyLen {
+		return 0, mutation, errors.New("Invalid record key")
+	}
+	key, err := codec.DecodeKey(payload[n : n+int(keyLen)])
+	if err != nil {
+		return 0, mutation, err
+	}
+	mutation.Key = key
+	switch mutation.Op {
+	case index.OpDelete:
+		return lsn, mutation, nil
+	case index.OpInsert, index.OpUpdate:
+		mutation.Feature, err = codec.DecodeFeature(payload[n+int(keyLen):])
+		return lsn, mutation, err
+	default:
+		return 0, mutation, fmt.Errorf("Unsupported operation %v", mutation.Op)
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
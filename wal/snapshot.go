@@ -0,0 +1,122 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+)
+
+// A snapshot uses the same framing as the log. The first record holds the
+// sequence number of the last mutation included in the snapshot, each
+// following record holds one feature.
+
+// writeSnapshot atomically replaces the snapshot in dir.
+func writeSnapshot[K feature.Key, F feature.Feature[K]](dir string, codec Codec[K, F], idx *index.Index[K, F], lsn uint64) error {
+	tmp, err := os.CreateTemp(dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	var buf []byte
+	buf = appendFrame(buf[:0], appendUvarint(nil, lsn))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	err = idx.Each(func(f F) error {
+		payload, err := codec.EncodeFeature(f)
+		if err != nil {
+			return err
+		}
+		buf = appendFrame(buf[:0], payload)
+		_, err = w.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readSnapshot returns features stored in a snapshot and the sequence number
+// of the last mutation it includes. A missing snapshot is treated as empty.
+func readSnapshot[K feature.Key, F feature.Feature[K]](path string, codec Codec[K, F]) ([]F, uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	r := bufio.NewReader(file)
+	var offset int64
+	next := func() ([]byte, error) {
+		payload, ok, err := readFrame(r, info.Size()-offset)
+		if err == errTorn || err == errBadLength || (err == nil && !ok) {
+			return nil, ErrCorrupted{Path: path, Offset: offset}
+		}
+		offset += headerSize + int64(len(payload))
+		return payload, err
+	}
+	header, err := next()
+	if err == io.EOF {
+		return nil, 0, ErrCorrupted{Path: path}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	lsn, n := binary.Uvarint(header)
+	if n <= 0 {
+		return nil, 0, errors.New("Invalid snapshot header")
+	}
+	var features []F
+	for {
+		payload, err := next()
+		if err == io.EOF {
+			return features, lsn, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		f, err := codec.DecodeFeature(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		features = append(features, f)
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
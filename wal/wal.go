@@ -0,0 +1,311 @@
+// Package wal contains a write-ahead log making index mutations durable
+// between snapshots.
+//
+// A log directory holds a snapshot of the index taken by the last checkpoint
+// and a log of mutations made since then. Recover loads both on startup.
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bilus/fencer/feature"
+	"github.com/bilus/fencer/index"
+)
+
+const (
+	logFile      = "log"
+	snapshotFile = "snapshot"
+)
+
+// ErrClosed is returned when recording mutations to a closed log.
+var ErrClosed = errors.New("Log closed")
+
+// SyncPolicy controls when the log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs after every mutation.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodically syncs every Options.SyncInterval; mutations recorded
+	// since the last sync may be lost on power failure.
+	SyncPeriodically
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// Options configure a log.
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration // Used with SyncPeriodically; defaults to 1s.
+}
+
+// Log is an append-only log of index mutations. It implements
+// index.BatchJournal.
+type Log[K feature.Key, F feature.Feature[K]] struct {
+	dir     string
+	codec   Codec[K, F]
+	options Options
+
+	mu     sync.Mutex
+	file   *os.File
+	lsn    uint64 // Sequence number of the last recorded mutation.
+	dirty  bool
+	closed bool
+	done   chan struct{}
+	buf    []byte
+}
+
+// Recover opens a log directory, creating it if necessary, and returns an
+// index restored from the last snapshot and the mutations logged after it.
+// The returned log is attached to the index as its journal.
+//
+// A torn or corrupted final record, left by a crash in the middle of a write,
+// is discarded. Invalid records followed by valid ones are reported as
+// ErrCorrupted instead, leaving the log as it is.
+func Recover[K feature.Key, F feature.Feature[K]](dir string, codec Codec[K, F], options Options) (*index.Index[K, F], *Log[K, F], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	features, lsn, err := readSnapshot(filepath.Join(dir, snapshotFile), codec)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := index.New[K](features)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(dir, logFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	lsn, end, err := replay(file, codec, idx, lsn)
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err == nil {
+		_, err = file.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	log := &Log[K, F]{
+		dir:     dir,
+		codec:   codec,
+		options: options,
+		file:    file,
+		lsn:     lsn,
+		done:    make(chan struct{}),
+	}
+	if options.Sync == SyncPeriodically {
+		interval := options.SyncInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		go log.syncEvery(interval)
+	}
+	idx.SetJournal(log)
+	return idx, log, nil
+}
+
+// Record appends a mutation to the log.
+func (log *Log[K, F]) Record(mutation index.Mutation[K, F]) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return ErrClosed
+	}
+	payload, err := encodeMutation(log.codec, log.lsn+1, mutation)
+	if err != nil {
+		return err
+	}
+	return log.write(payload, 1)
+}
+
+// RecordBatch appends mutations to the log as a single record, so that
+// either all or none of them are recovered.
+func (log *Log[K, F]) RecordBatch(mutations []index.Mutation[K, F]) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return ErrClosed
+	}
+	if len(mutations) == 0 {
+		return nil
+	}
+	payload, err := encodeBatch(log.codec, log.lsn+1, mutations)
+	if err != nil {
+		return err
+	}
+	return log.write(payload, len(mutations))
+}
+
+// write appends a record holding n mutations. A record that fails to be
+// written in full is truncated so that it doesn't precede later ones.
+func (log *Log[K, F]) write(payload []byte, n int) error {
+	log.buf = appendFrame(log.buf[:0], payload)
+	offset, err := log.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := log.file.Write(log.buf); err != nil {
+		if truncateErr := log.file.Truncate(offset); truncateErr != nil {
+			return fmt.Errorf("%w; truncating the log failed: %v", err, truncateErr)
+		}
+		if _, seekErr := log.file.Seek(offset, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("%w; seeking in the log failed: %v", err, seekErr)
+		}
+		return err
+	}
+	log.lsn += uint64(n)
+	log.dirty = true
+	if log.options.Sync == SyncAlways {
+		return log.sync()
+	}
+	return nil
+}
+
+// Sync flushes the log to stable storage.
+func (log *Log[K, F]) Sync() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return ErrClosed
+	}
+	return log.sync()
+}
+
+// Checkpoint writes a snapshot of the index and truncates the log. The index
+// must be the one the log is attached to and must not be modified until
+// Checkpoint returns.
+func (log *Log[K, F]) Checkpoint(idx *index.Index[K, F]) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return ErrClosed
+	}
+	if err := writeSnapshot(log.dir, log.codec, idx, log.lsn); err != nil {
+		return err
+	}
+	if err := log.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := log.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return log.sync()
+}
+
+// Close syncs and closes the log.
+func (log *Log[K, F]) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.closed {
+		return nil
+	}
+	log.closed = true
+	close(log.done)
+	err := log.sync()
+	if closeErr := log.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (log *Log[K, F]) sync() error {
+	if !log.dirty {
+		return nil
+	}
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	log.dirty = false
+	return nil
+}
+
+func (log *Log[K, F]) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.mu.Lock()
+			if !log.closed {
+				// There's no one to report the error to; the next sync
+				// will retry.
+				_ = log.sync()
+			}
+			log.mu.Unlock()
+		case <-log.done:
+			return
+		}
+	}
+}
+
+// replay applies mutations newer than lsn to the index. It returns the
+// sequence number of the last mutation and the offset the log should be
+// truncated to. A final record failing its checksum or decoding is treated
+// as torn, as is an incomplete or empty record not followed by a valid one.
+func replay[K feature.Key, F feature.Feature[K]](file *os.File, codec Codec[K, F], idx *index.Index[K, F], lsn uint64) (uint64, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		payload, ok, err := readFrame(r, info.Size()-offset)
+		if err == errTorn {
+			follows, scanErr := validFrameAfter(file, offset+1, info.Size())
+			if scanErr != nil {
+				return 0, 0, scanErr
+			}
+			if follows {
+				return 0, 0, ErrCorrupted{Path: file.Name(), Offset: offset}
+			}
+			return lsn, offset, nil
+		}
+		if err == io.EOF {
+			return lsn, offset, nil
+		}
+		if err == errBadLength {
+			return 0, 0, ErrCorrupted{Path: file.Name(), Offset: offset}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		end := offset + headerSize + int64(len(payload))
+		if !ok {
+			if end == info.Size() {
+				return lsn, offset, nil
+			}
+			return 0, 0, ErrCorrupted{Path: file.Name(), Offset: offset}
+		}
+		mutations, err := decodeRecord(codec, payload)
+		if err != nil {
+			if end == info.Size() {
+				return lsn, offset, nil
+			}
+			return 0, 0, fmt.Errorf("%v at offset %d: %w", file.Name(), offset, err)
+		}
+		for _, m := range mutations {
+			if m.lsn <= lsn {
+				continue
+			}
+			if err := idx.Replay(m.mutation); err != nil {
+				return 0, 0, fmt.Errorf("%v at offset %d: %w", file.Name(), offset, err)
+			}
+			lsn = m.lsn
+		}
+		offset = end
+	}
+}
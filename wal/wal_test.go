@@ -0,0 +1,284 @@
+package wal_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bilus/fencer/index"
+	"github.com/bilus/fencer/primitives"
+	"github.com/bilus/fencer/testutil"
+	"github.com/bilus/fencer/wal"
+)
+
+// ZoneID uniquely identifies a zone.
+type ZoneID string
+
+func (id ZoneID) String() string {
+	return string(id)
+}
+
+// Zone is an example feature serialized using wal.JSONCodec.
+type Zone struct {
+	ID   ZoneID
+	Name string
+	Area primitives.Rect
+}
+
+func (z *Zone) Bounds() *primitives.Rect {
+	return &z.Area
+}
+
+func (z *Zone) Contains(point primitives.Point) (bool, error) {
+	return testutil.Contains(z.Area, point), nil
+}
+
+func (z *Zone) Key() ZoneID {
+	return z.ID
+}
+
+func newZone(id ZoneID, name string, x, y float64) *Zone {
+	return &Zone{ID: id, Name: name, Area: primitives.Rect{Min: primitives.Point{x, y}, Max: primitives.Point{x + 1, y + 1}}}
+}
+
+var codec = wal.JSONCodec[ZoneID, *Zone]{}
+
+func Example() {
+	dir, _ := os.MkdirTemp("", "wal")
+	defer os.RemoveAll(dir)
+
+	idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncAlways})
+	_ = idx.Insert(newZone("a", "A", 0, 0))
+	_ = idx.Insert(newZone("b", "B", 2, 2))
+	_ = idx.Update(newZone("b", "B2", 2, 2))
+	_ = idx.Delete("a")
+	_ = log.Close() // Or crash.
+
+	idx, log, _ = wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncAlways})
+	defer log.Close()
+	zones, _ := idx.Lookup("b")
+	fmt.Println(idx.Size(), "zone:", zones[0].Name)
+	// Output: 1 zone: B2
+}
+
+func TestRecover_tornFinalRecord(t *testing.T) {
+	dir := t.TempDir()
+	idx, log, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := idx.Insert(newZone(ZoneID(fmt.Sprint(i)), "", float64(i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	path := filepath.Join(dir, "log")
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, log, err = wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Size() != 2 {
+		t.Fatalf("Expected 2 zones, got %d", idx.Size())
+	}
+	// Log remains usable after the torn record is discarded.
+	if err := idx.Insert(newZone("3", "", 3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	idx, log, err = wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if idx.Size() != 3 {
+		t.Fatalf("Expected 3 zones, got %d", idx.Size())
+	}
+}
+
+func TestRecover_corruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	_ = idx.Insert(newZone("a", "", 0, 0))
+	_ = idx.Insert(newZone("b", "", 0, 0))
+	log.Close()
+
+	path := filepath.Join(dir, "log")
+	data, _ := os.ReadFile(path)
+	data[10] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	_, _, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if _, ok := err.(wal.ErrCorrupted); !ok {
+		t.Fatalf("Expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestRecover_invalidFinalRecord(t *testing.T) {
+	for name, tail := range map[string][]byte{
+		// E.g. space allocated by the file system but never written.
+		"zero-filled": make([]byte, 64),
+		// A record with a valid checksum but not a mutation.
+		"undecodable": {1, 0, 0, 0, 0, 0, 0, 0xff, 0xff},
+	} {
+		dir := t.TempDir()
+		idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+		_ = idx.Insert(newZone("a", "", 0, 0))
+		log.Close()
+
+		path := filepath.Join(dir, "log")
+		data, _ := os.ReadFile(path)
+		_ = os.WriteFile(path, append(data, tail...), 0o644)
+		idx, log, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		log.Close()
+		if info, _ := os.Stat(path); idx.Size() != 1 || info.Size() != int64(len(data)) {
+			t.Errorf("%s: Expected the final record to be discarded", name)
+		}
+	}
+}
+
+func TestRecover_corruptedLength(t *testing.T) {
+	dir := t.TempDir()
+	idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	_ = idx.Insert(newZone("a", "", 0, 0))
+	_ = idx.Insert(newZone("b", "", 0, 0))
+	log.Close()
+
+	path := filepath.Join(dir, "log")
+	info, _ := os.Stat(path)
+	// A length over the maximum record size, within the file size.
+	_ = os.Truncate(path, info.Size()+512<<20)
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0o644)
+	_, _ = f.WriteAt([]byte{0, 0, 0, 0x20}, 0)
+	f.Close()
+
+	_, _, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if _, ok := err.(wal.ErrCorrupted); !ok {
+		t.Fatalf("Expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestRecover_invalidLengthBeforeValidRecords(t *testing.T) {
+	for name, length := range map[string][]byte{
+		"zero":         {0, 0, 0, 0},
+		"past the end": {0, 0, 1, 0},
+	} {
+		dir := t.TempDir()
+		idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+		for _, id := range []ZoneID{"a", "b", "c"} {
+			_ = idx.Insert(newZone(id, "", 0, 0))
+		}
+		log.Close()
+
+		path := filepath.Join(dir, "log")
+		data, _ := os.ReadFile(path)
+		copy(data, length)
+		_ = os.WriteFile(path, data, 0o644)
+
+		_, _, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+		if _, ok := err.(wal.ErrCorrupted); !ok {
+			t.Errorf("%s: Expected ErrCorrupted, got %v", name, err)
+		}
+		if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+			t.Errorf("%s: Expected the log not to be truncated, got %d bytes", name, info.Size())
+		}
+	}
+}
+
+func TestLog_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{Sync: wal.SyncPeriodically})
+	_ = idx.Insert(newZone("a", "", 0, 0))
+	_ = idx.Insert(newZone("b", "", 1, 1))
+	logPath := filepath.Join(dir, "log")
+	beforeCheckpoint, _ := os.ReadFile(logPath)
+
+	if err := log.Checkpoint(idx); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(logPath); info.Size() != 0 {
+		t.Fatalf("Expected the log to be truncated, got %d bytes", info.Size())
+	}
+	_ = idx.Delete("a")
+	log.Close()
+
+	idx, log, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zones, _ := idx.Lookup("a"); len(zones) != 0 || idx.Size() != 1 {
+		t.Fatalf("Expected only zone b, got %d zones", idx.Size())
+	}
+	log.Close()
+
+	// A crash after writing the snapshot but before truncating the log must
+	// not apply the same mutations twice.
+	_ = os.WriteFile(logPath, beforeCheckpoint, 0o644)
+	idx, log, err = wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if zones, _ := idx.Lookup("b"); len(zones) != 1 {
+		t.Fatalf("Expected 1 feature for key b, got %d", len(zones))
+	}
+}
+
+func TestLog_Record_closed(t *testing.T) {
+	idx, log, _ := wal.Recover[ZoneID, *Zone](t.TempDir(), codec, wal.Options{})
+	log.Close()
+	if err := idx.Insert(newZone("a", "", 0, 0)); err != wal.ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+	if _, err := idx.Lookup("a"); err != nil || idx.Size() != 0 {
+		t.Fatal("Expected the mutation not to be applied")
+	}
+	var _ index.Journal[ZoneID, *Zone] = log
+}
+
+func TestLog_RecordBatch(t *testing.T) {
+	dir := t.TempDir()
+	idx, log, _ := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	_ = idx.Insert(newZone("a", "A", 0, 0))
+	updated, _ := index.New[ZoneID]([]*Zone{newZone("a", "A2", 0, 0), newZone("b", "B", 1, 1)})
+	changes, _ := index.Diff(idx, updated, func(old, new *Zone) bool { return *old == *new })
+	if err := idx.Apply(changes); err != nil {
+		t.Fatal(err)
+	}
+	_ = idx.Insert(newZone("c", "C", 2, 2))
+	log.Close()
+
+	idx, log, err := wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, _ := idx.Lookup("a")
+	if idx.Size() != 3 || zones[0].Name != "A2" {
+		t.Fatalf("Expected 3 zones including A2, got %d", idx.Size())
+	}
+	log.Close()
+
+	// A torn batch is discarded as a whole.
+	path := filepath.Join(dir, "log")
+	data, _ := os.ReadFile(path)
+	_ = os.WriteFile(path, data[:len(data)-len(data)/3], 0o644)
+	idx, log, err = wal.Recover[ZoneID, *Zone](dir, codec, wal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	zones, _ = idx.Lookup("a")
+	if idx.Size() != 1 || zones[0].Name != "A" {
+		t.Fatalf("Expected only zone A, got %d zones", idx.Size())
+	}
+}